@@ -19,12 +19,16 @@ package cmd
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/chef/go-libs/config"
 	"github.com/chef/go-libs/credentials"
 	"github.com/go-chef/chef"
 	"github.com/pkg/errors"
@@ -32,19 +36,116 @@ import (
 )
 
 var (
-	captureOpts reporting.CaptureOpts
+	captureOpts  reporting.CaptureOpts
+	captureFlags struct {
+		repoDir        string
+		cookbookPaths  []string
+		nonInteractive bool
+		query          string
+		nodesFile      string
+		workers        int
+		downloads      int
+		refresh        bool
+		generator      string
+		kitchenDriver  string
+		kitchenImages  string
+		attributes     []string
+		habOrigin      string
+		archive        string
+		importArchive  string
+		noRedaction    bool
+		secretFile     string
+		withDataBags   string
+		events         string
+	}
 
-	// Possible options (future)
-	// --node-repo-dir -> dir to create for the the special node repository.
-	//     Default: ./node-NAME-repo
+	// where the messages of capture are printed, stderr when the
+	// events are written to stdout for wrappers
+	captureOut io.Writer = os.Stdout
 
 	captureCmd = &cobra.Command{
-		Use:   "capture NODE-NAME",
+		Use:   "capture [NODE-NAME...]",
 		Short: "Capture a node's state into a local chef-repo",
-		Args:  cobra.ExactArgs(1),
 		Long: `Captures a node's state as a local chef-repo, which
-can then be used to converge locally.`,
+can then be used to converge locally.
+
+Several nodes can be captured into the same repository by providing more
+than one node name, a search query with --query or a file with one node
+name per line with --nodes-file. Cookbooks are sourced from --cookbook-path,
+or the cookbook_repo_paths of the config.toml, and values that look like
+secrets are masked and listed in the redaction-log.json of the repository.`,
+		Example: `  # capture a node and converge it locally with Test Kitchen
+  chef capture node1
+
+  # capture the web nodes of production without prompting for cookbooks
+  chef capture --query "role:web AND chef_environment:prod" --non-interactive
+
+  # pack a capture into an archive and rebuild it on another workstation
+  chef capture node1 --archive node1.tar.gz
+  chef capture --import node1.tar.gz`,
+		Args: func(_ *cobra.Command, args []string) error {
+			if captureFlags.importArchive != "" {
+				if len(args) != 0 {
+					return errors.New("--import does not capture nodes, it can't be used with node names")
+				}
+				return nil
+			}
+			if len(args) == 0 && captureFlags.query == "" && captureFlags.nodesFile == "" &&
+				!(captureFlags.refresh && captureFlags.repoDir != "") {
+				return errors.New("requires a node name, a --query, a --nodes-file or --refresh with a --repo-dir")
+			}
+			return nil
+		},
 		RunE: func(_ *cobra.Command, args []string) error {
+			if !isOneOf(captureFlags.events, captureEventFormats) {
+				return errors.Errorf("invalid --events '%s', valid values are %s",
+					captureFlags.events, strings.Join(captureEventFormats, ", "))
+			}
+			if captureFlags.events == captureEventsJSON {
+				captureOut = os.Stderr
+			}
+			if captureFlags.importArchive != "" {
+				return importCapture(captureFlags.importArchive)
+			}
+			if !isOneOf(captureFlags.generator, reporting.RepositoryGenerators) {
+				return errors.Errorf("invalid generator '%s', valid generators are %s",
+					captureFlags.generator, strings.Join(reporting.RepositoryGenerators, ", "))
+			}
+			if !isOneOf(captureFlags.kitchenDriver, reporting.KitchenDrivers) {
+				return errors.Errorf("invalid kitchen driver '%s', valid drivers are %s",
+					captureFlags.kitchenDriver, strings.Join(reporting.KitchenDrivers, ", "))
+			}
+			if captureFlags.withDataBags != "" && !isOneOf(captureFlags.withDataBags, reporting.DataBagModes) {
+				return errors.Errorf("invalid --with-data-bags '%s', valid values are %s",
+					captureFlags.withDataBags, strings.Join(reporting.DataBagModes, ", "))
+			}
+			captureOpts.DownloadDataBags = captureFlags.withDataBags == reporting.AllDataBags
+			captureOpts.ReferencedDataBags = captureFlags.withDataBags == reporting.ReferencedDataBags
+			for _, level := range captureFlags.attributes {
+				if level != reporting.AttributeLevelDefault && level != reporting.AttributeLevelOverride {
+					return errors.Errorf("invalid attribute level '%s', valid levels are %s, %s",
+						level, reporting.AttributeLevelDefault, reporting.AttributeLevelOverride)
+				}
+			}
+			kitchenImages, err := reporting.LoadKitchenImages(captureFlags.kitchenImages)
+			if err != nil {
+				return err
+			}
+			var dataBagSecret []byte
+			if captureFlags.secretFile != "" {
+				dataBagSecret, err = reporting.ReadDataBagSecret(captureFlags.secretFile)
+				if err != nil {
+					return err
+				}
+			}
+			var redactor *reporting.Redactor
+			if !captureFlags.noRedaction {
+				redactor, err = loadRedactor()
+				if err != nil {
+					return err
+				}
+			}
+
 			creds, err := credentials.FromViper(
 				infraFlags.profile,
 				overrideCredentials(),
@@ -53,7 +154,6 @@ can then be used to converge locally.`,
 				return err
 			}
 
-			nodeName := args[0]
 			err = createOutputDirectories()
 			if err != nil {
 				return err
@@ -69,37 +169,95 @@ can then be used to converge locally.`,
 				return err
 			}
 
-			repoName := fmt.Sprintf("node-%s-repo", nodeName)
-			// TODO - future iteration - give option to set the destination path.
-			repoDirName := fmt.Sprintf("./%s", repoName)
-
-			// abort if it exists, have them remove it first.
-			_, err = os.Stat(repoDirName)
-			if err == nil {
-				fmt.Printf(RepositoryAlreadyExistsE002, repoDirName, nodeName)
-				return nil
-			} else {
-				if !os.IsNotExist(err) {
-					return err
-				}
+			cookbookPaths, err := cookbookSourcePaths(captureFlags.cookbookPaths)
+			if err != nil {
+				return err
 			}
 
-			fmt.Println(" - Setting up local repository")
-			cmd := exec.Command("chef", "generate", "repo", repoName)
-			_, err = cmd.Output()
+			nodeNames, err := captureNodeNames(args, reporting.NewChefAnalyzeClient(chefClient))
 			if err != nil {
 				return err
 			}
 
-			// Some files are created that we don't need in our repo, let's remove them
-			err = os.RemoveAll(fmt.Sprintf("%s/cookbooks/example", repoDirName))
-			if err != nil {
-				return errors.Wrap(err, "Could not remove pre-created repo content: example cookbook")
+			repoDirName := captureFlags.repoDir
+			if repoDirName == "" && captureFlags.archive != "" && len(nodeNames) > 0 {
+				// the archive is staged in a temporary repository
+				stagingDir, err := ioutil.TempDir("", "chef-analyze-capture")
+				if err != nil {
+					return errors.Wrap(err, "unable to create staging directory")
+				}
+				defer os.RemoveAll(stagingDir)
+				repoDirName = filepath.Join(stagingDir, "repo")
+			}
+			if repoDirName == "" {
+				switch len(nodeNames) {
+				case 0:
+				case 1:
+					repoDirName = fmt.Sprintf("./node-%s-repo", nodeNames[0])
+				default:
+					repoDirName = "./nodes-repo"
+				}
 			}
 
-			err = os.RemoveAll(fmt.Sprintf("%s/data_bags/example", repoDirName))
-			if err != nil {
-				return errors.Wrap(err, "Could not remove pre-created repo content: example data bag")
+			// refreshing an existing repository recaptures the nodes of the
+			// previous capture, and retries the ones it failed to capture, unless
+			// other nodes are provided
+			var previous *reporting.CaptureManifest
+			if _, err := os.Stat(repoDirName); err == nil && captureFlags.refresh {
+				previous, err = reporting.LoadCaptureManifest(repoDirName)
+				if err != nil {
+					return errors.Wrapf(err, "unable to refresh '%s', it was not created by capture", repoDirName)
+				}
+				if len(nodeNames) == 0 {
+					nodeNames = append(previous.Nodes, previous.FailedNodes...)
+					if len(previous.FailedNodes) > 0 {
+						fmt.Fprintf(captureOut, " - Retrying node(s) that could not be captured previously: %s\n",
+							strings.Join(previous.FailedNodes, ", "))
+					}
+				}
+			}
+
+			if len(nodeNames) == 0 {
+				return errors.New("no nodes to capture, the query and the nodes file did not return any node")
+			}
+
+			var (
+				writer        = &reporting.ObjectWriter{RootDir: repoDirName, Redactor: redactor}
+				archiveWriter *reporting.ArchiveWriter
+				objectWriter  reporting.ObjectWriterInterface = writer
+			)
+			if captureFlags.archive != "" {
+				archiveWriter = &reporting.ArchiveWriter{ObjectWriter: writer, ArchivePath: captureFlags.archive}
+				objectWriter = archiveWriter
+			}
+			if previous == nil {
+				info := reporting.RepositoryInfo{Nodes: nodeNames, CapturedAt: time.Now()}
+				// archives are meant to be shared, their README records neither
+				// the Chef Infra Server nor the command line (and its flags)
+				if captureFlags.archive == "" {
+					info.ChefServerURL = creds.ChefServerUrl
+					info.Command = strings.Join(os.Args, " ")
+				}
+				created, err := setupRepository(repoDirName, info)
+				if err != nil || !created {
+					return err
+				}
+			} else {
+				fmt.Fprintf(captureOut, " - Refreshing local repository (last captured %s)\n",
+					previous.CapturedAt.Local().Format(time.RFC1123))
+				// generated files (kitchen.yml, ChefSpec, Policyfiles) and
+				// objects edited after the last capture are kept as they are
+				writer.Protected = make(map[string]bool)
+				for _, file := range previous.ModifiedFiles(repoDirName) {
+					fmt.Fprintf(captureOut, " - Keeping %s, it was modified after the last capture\n", file)
+					writer.Protected[file] = true
+				}
+			}
+			if dataBagSecret != nil {
+				err = reporting.WriteDataBagSecret(repoDirName, dataBagSecret)
+				if err != nil {
+					return err
+				}
 			}
 
 			capturer := reporting.NewNodeCapturer(
@@ -109,72 +267,388 @@ can then be used to converge locally.`,
 				chefClient.PolicyGroups,
 				chefClient.Policies,
 				chefClient.CookbookArtifacts,
-				&reporting.ObjectWriter{RootDir: repoDirName},
+				objectWriter,
+			)
+			capturer.SetPreviousCapture(previous)
+			capturer.SetKitchenOpts(reporting.KitchenOpts{
+				Driver: captureFlags.kitchenDriver,
+				Images: kitchenImages,
+			})
+			capturer.SetAttributeOpts(reporting.AttributeOpts{Levels: captureFlags.attributes})
+			capturer.SetEffortlessOpts(reporting.EffortlessOpts{Origin: captureFlags.habOrigin})
+			capturer.SetDataBagSecret(dataBagSecret)
+			capturer.SetDownloadWorkers(captureFlags.downloads)
+
+			var (
+				cookbooks, cookbookLocks []reporting.NodeCookbook
+				failedNodes              []string
 			)
-			nc := reporting.NewNodeCapture(nodeName, repoDirName, captureOpts, capturer)
-			go nc.Run()
-			for progress := range nc.Progress {
-				switch progress {
-				case reporting.FetchingNode:
-					fmt.Printf(" - Capturing node object '%s'\n", nodeName)
-				case reporting.FetchingCookbooks:
-					fmt.Println(" - Capturing cookbooks...")
-				case reporting.FetchingRoles:
-					fmt.Println(" - Capturing roles...")
-				case reporting.FetchingDataBags:
-					fmt.Println(" - Capturing data bag items...")
-				case reporting.FetchingEnvironment:
-					fmt.Println(" - Capturing environment...")
-				case reporting.WritingKitchenConfig:
-					fmt.Println(" - Writing kitchen configuration...")
-				case reporting.FetchingCookbookArtifacts:
-					fmt.Println(" - Capturing cookbook artifacts...")
-				case reporting.FetchingPolicyData:
-					fmt.Println(" - Capturing policy data...")
-
-				case reporting.CaptureComplete:
-					break
+			if len(nodeNames) > 1 {
+				cookbooks, cookbookLocks, failedNodes, err = captureNodes(nodeNames, repoDirName, capturer)
+			} else {
+				cookbooks, cookbookLocks, err = captureNode(nodeNames[0], repoDirName, capturer)
+			}
+			if err != nil {
+				return err
+			}
+
+			if missing := capturer.MissingDataBags(); len(missing) > 0 {
+				fmt.Fprintf(captureOut, " - Skipped data bag(s) not found on the Chef Infra Server: %s\n", strings.Join(missing, ", "))
+			}
+			if encrypted := capturer.EncryptedDataBagItems(); len(encrypted) > 0 {
+				if dataBagSecret != nil {
+					fmt.Fprintf(captureOut, " - Verified the data bag secret with %d encrypted data bag item(s)\n", len(encrypted))
+				} else {
+					fmt.Fprintf(captureOut, EncryptedDataBagsWithoutSecretTxt, len(encrypted), strings.Join(encrypted, "\n  "))
 				}
 			}
-			if nc.Error != nil {
-				return nc.Error
+
+			err = writer.WriteRedactionLog()
+			if err != nil {
+				return err
+			}
+			if redactions := redactor.Redactions(); len(redactions) > 0 {
+				fmt.Fprintf(captureOut, " - Redacted %d value(s), see %s\n", len(redactions), reporting.RedactionLogFile)
 			}
 
-			var remainingCBs []reporting.NodeCookbook
-			var cookbookDirName string
-			if nc.Policy == nil {
-				remainingCBs = nc.Cookbooks
-				cookbookDirName = "cookbooks"
-			} else {
-				remainingCBs = locksToCookbooks(nc.Policy.CookbookLocks)
-				cookbookDirName = "cookbook_artifacts"
+			objects := writer.Written()
+			for file := range writer.Protected {
+				if sum, ok := previous.Objects[file]; ok {
+					objects[file] = sum
+				}
+			}
+			// only the captured nodes are in the repository, the failed
+			// ones are recorded separately so a refresh can retry them
+			manifest := reporting.NewCaptureManifest(capturedNodes(nodeNames, failedNodes), objects, cookbooks, cookbookLocks)
+			manifest.FailedNodes = failedNodes
+			manifest.SetChefServerURL(creds.ChefServerUrl)
+			err = manifest.Save(repoDirName)
+			if err != nil {
+				return err
+			}
+			if previous != nil {
+				printCaptureChanges(manifest.Changes(previous), previous.CapturedAt)
 			}
 
-			// Try to gather sources for all cookbooks; stop when we've found
-			// them all or the operator provides a blank input when asked for a new
-			// path.
-			requestGatherSources(repoDirName)
-			baseUserPath := requestCookbookPath(remainingCBs)
-			for len(remainingCBs) > 0 && baseUserPath != "" {
-				remainingCBs, err = resolveCookbooks(cookbookDirName, remainingCBs, repoDirName, baseUserPath)
+			for _, dir := range []struct {
+				name      string
+				cookbooks []reporting.NodeCookbook
+			}{
+				{"cookbooks", cookbooks},
+				{"cookbook_artifacts", cookbookLocks},
+			} {
+				remainingCBs := unsourcedCookbooks(dir.name, dir.cookbooks, repoDirName)
+				if len(remainingCBs) == 0 {
+					continue
+				}
+				remainingCBs, err = sourceCookbooks(dir.name, remainingCBs, repoDirName, cookbookPaths)
 				if err != nil {
 					return err
 				}
-				baseUserPath = requestCookbookPath(remainingCBs)
+				if len(remainingCBs) > 0 {
+					fmt.Fprintf(captureOut, CookbooksNotSourcedTxt, fmt.Sprintf("%s/%s", repoDirName, dir.name),
+						formatCookbooks(remainingCBs))
+				}
 			}
 
-			if len(remainingCBs) > 0 {
-				fmt.Printf(CookbooksNotSourcedTxt, fmt.Sprintf("%s/%s", repoDirName, cookbookDirName),
-					formatCookbooks(remainingCBs))
+			if archiveWriter != nil {
+				fmt.Fprintf(captureOut, " - Writing archive %s\n", captureFlags.archive)
+				err = archiveWriter.Close(manifest)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(captureOut, CaptureArchiveCompleteTxt, captureFlags.archive, captureFlags.archive)
+				return nil
 			}
 
-			fmt.Printf(CookbookCaptureCompleteTxt, repoDirName)
+			fmt.Fprintf(captureOut, CookbookCaptureCompleteTxt, repoDirName)
 			return nil
 
 		},
 	}
 )
 
+// rebuilds a repository captured with --archive, in the directory provided with
+// --repo-dir or in a directory named after the archive, the captured objects are
+// verified against the checksums recorded in the archive
+func importCapture(archivePath string) error {
+	repoDirName := captureFlags.repoDir
+	if repoDirName == "" {
+		repoDirName = archiveRepositoryDir(archivePath)
+	}
+
+	fmt.Fprintf(captureOut, " - Importing %s into %s\n", archivePath, repoDirName)
+	manifest, modified, err := reporting.ImportArchive(archivePath, repoDirName)
+	if err != nil {
+		return err
+	}
+	if len(modified) > 0 {
+		fmt.Fprintf(captureOut, " - The following files differ from the checksums of the archive:\n")
+		for _, file := range modified {
+			fmt.Fprintf(captureOut, "   - %s\n", file)
+		}
+	}
+	fmt.Fprintf(captureOut, " - Nodes: %s (captured %s)\n", strings.Join(manifest.Nodes, ", "),
+		manifest.CapturedAt.Local().Format(time.RFC1123))
+
+	fmt.Fprintf(captureOut, CookbookCaptureCompleteTxt, repoDirName)
+	return nil
+}
+
+// returns the directory to import an archive into, named after the archive
+// without its extension (e.g. ./web-repo for web-repo.tar.gz)
+func archiveRepositoryDir(archivePath string) string {
+	name := filepath.Base(archivePath)
+	for _, ext := range []string{".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return "./" + strings.TrimSuffix(name, ext)
+		}
+	}
+	return "./" + name + "-repo"
+}
+
+// returns the names of the nodes to capture from the arguments, the nodes file
+// and the search query, in that order and without duplicates
+func captureNodeNames(args []string, client *reporting.ChefAnalyzeClient) ([]string, error) {
+	var (
+		nodeNames = make([]string, 0, len(args))
+		seen      = make(map[string]bool)
+		add       = func(names []string) {
+			for _, name := range names {
+				if !seen[name] {
+					seen[name] = true
+					nodeNames = append(nodeNames, name)
+				}
+			}
+		}
+	)
+	add(args)
+
+	if captureFlags.nodesFile != "" {
+		names, err := reporting.ReadNodesFile(captureFlags.nodesFile)
+		if err != nil {
+			return nil, err
+		}
+		add(names)
+	}
+
+	if captureFlags.query != "" {
+		fmt.Fprintf(captureOut, " - Searching nodes with query '%s'\n", captureFlags.query)
+		names, err := reporting.SearchNodeNames(client.Search, captureFlags.query)
+		if err != nil {
+			return nil, err
+		}
+		add(names)
+	}
+
+	return nodeNames, nil
+}
+
+// creates the local repository, returns false if the repository already exists
+func setupRepository(repoDirName string, info reporting.RepositoryInfo) (bool, error) {
+	// abort if it exists, have them remove it first.
+	_, err := os.Stat(repoDirName)
+	if err == nil {
+		fmt.Fprintf(captureOut, RepositoryAlreadyExistsE002, repoDirName, strings.Join(info.Nodes, ", "))
+		return false, nil
+	} else {
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+
+	fmt.Fprintln(captureOut, " - Setting up local repository")
+	if captureFlags.generator == reporting.NativeRepositoryGenerator {
+		return true, reporting.GenerateRepository(repoDirName, info)
+	}
+
+	cmd := exec.Command("chef", "generate", "repo", repoDirName)
+	_, err = cmd.Output()
+	if err != nil {
+		return false, errors.Wrap(err, "unable to generate the repository with 'chef generate repo'")
+	}
+
+	// Some files are created that we don't need in our repo, let's remove them
+	err = os.RemoveAll(fmt.Sprintf("%s/cookbooks/example", repoDirName))
+	if err != nil {
+		return false, errors.Wrap(err, "Could not remove pre-created repo content: example cookbook")
+	}
+
+	err = os.RemoveAll(fmt.Sprintf("%s/data_bags/example", repoDirName))
+	if err != nil {
+		return false, errors.Wrap(err, "Could not remove pre-created repo content: example data bag")
+	}
+	return true, nil
+}
+
+// returns true if the value is one of the valid values of a flag
+func isOneOf(value string, valid []string) bool {
+	for _, v := range valid {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// captures a single node, returns the captured cookbooks and cookbook artifacts
+func captureNode(nodeName string, repoDirName string, capturer *reporting.NodeCapturer) ([]reporting.NodeCookbook, []reporting.NodeCookbook, error) {
+	nc := reporting.NewNodeCapture(nodeName, repoDirName, captureOpts, capturer)
+	go nc.Run()
+	renderer := newCaptureEventRenderer(1)
+	for event := range nc.Progress {
+		renderer.Render(event)
+	}
+	renderer.Finish()
+	if nc.Error != nil {
+		return nil, nil, nc.Error
+	}
+
+	if nc.Policy == nil {
+		return nc.Cookbooks, []reporting.NodeCookbook{}, nil
+	}
+	return []reporting.NodeCookbook{}, locksToCookbooks(nc.Policy.CookbookLocks), nil
+}
+
+// captures several nodes in parallel into the same repository, returns
+// the cookbooks and cookbook artifacts captured for all nodes and the
+// names of the nodes that could not be captured
+func captureNodes(nodeNames []string, repoDirName string, capturer *reporting.NodeCapturer) ([]reporting.NodeCookbook, []reporting.NodeCookbook, []string, error) {
+	fmt.Fprintf(captureOut, " - Capturing %d nodes\n", len(nodeNames))
+	mnc := reporting.NewMultiNodeCapture(nodeNames, repoDirName, captureOpts, capturer, captureFlags.workers)
+	go mnc.Run()
+	renderer := newCaptureEventRenderer(len(nodeNames))
+	for event := range mnc.Progress {
+		renderer.Render(event)
+	}
+	renderer.Finish()
+
+	failed := mnc.Failed()
+	if len(failed) > 0 {
+		fmt.Fprintf(captureOut, "\nUnable to capture %d of %d nodes:\n", len(failed), len(nodeNames))
+		for _, nc := range failed {
+			fmt.Fprintf(captureOut, "  - %v\n", nc.Error)
+		}
+	}
+	if mnc.Error != nil {
+		return nil, nil, nil, mnc.Error
+	}
+
+	if len(mnc.Conflicts) > 0 {
+		fmt.Fprintln(captureOut, "\nThe following cookbooks are used by the nodes in different versions, only one version was captured:")
+		for _, conflict := range mnc.Conflicts {
+			fmt.Fprintf(captureOut, "  - %s (v%s captured, v%s requested)\n", conflict.Name, conflict.Captured, conflict.Requested)
+		}
+	}
+
+	return mnc.Cookbooks(), mnc.CookbookLocks(), mnc.FailedNodes(), nil
+}
+
+// returns the provided nodes without the ones that failed to be captured
+func capturedNodes(nodeNames, failedNodes []string) []string {
+	failed := make(map[string]bool, len(failedNodes))
+	for _, name := range failedNodes {
+		failed[name] = true
+	}
+	captured := make([]string, 0, len(nodeNames))
+	for _, name := range nodeNames {
+		if !failed[name] {
+			captured = append(captured, name)
+		}
+	}
+	return captured
+}
+
+// prints what changed in the repository since the previous capture
+func printCaptureChanges(changes reporting.CaptureChanges, previouslyCapturedAt time.Time) {
+	if !changes.HasChanges() {
+		fmt.Fprintf(captureOut, "\nNo changes since the last capture (%s)\n", previouslyCapturedAt.Local().Format(time.RFC1123))
+		return
+	}
+
+	fmt.Fprintf(captureOut, "\nChanges since the last capture (%s):\n", previouslyCapturedAt.Local().Format(time.RFC1123))
+	for _, section := range []struct {
+		title string
+		items []string
+	}{
+		{"Added", changes.Added},
+		{"Updated", changes.Updated},
+		{"No longer captured", changes.Removed},
+	} {
+		if len(section.items) == 0 {
+			continue
+		}
+		fmt.Fprintf(captureOut, "  %s:\n", section.title)
+		for _, item := range section.items {
+			fmt.Fprintf(captureOut, "    - %s\n", item)
+		}
+	}
+	fmt.Fprintf(captureOut, "  Unchanged: %d\n", changes.Unchanged)
+}
+
+// returns the text to display for a capture progress event
+func captureProgressText(stage reporting.CaptureStage, nodeName string) string {
+	switch stage {
+	case reporting.FetchingNode:
+		return fmt.Sprintf("Capturing node object '%s'", nodeName)
+	case reporting.FetchingCookbooks:
+		return "Capturing cookbooks..."
+	case reporting.FetchingRoles:
+		return "Capturing roles..."
+	case reporting.FetchingDataBags:
+		return "Capturing data bag items..."
+	case reporting.FetchingEnvironment:
+		return "Capturing environment..."
+	case reporting.WritingKitchenConfig:
+		return "Writing kitchen configuration..."
+	case reporting.WritingChefSpec:
+		return "Writing ChefSpec scaffolds..."
+	case reporting.WritingPolicyfile:
+		return "Writing Policyfile..."
+	case reporting.WritingEffortlessPackage:
+		return "Writing Effortless package..."
+	case reporting.FetchingCookbookArtifacts:
+		return "Capturing cookbook artifacts..."
+	case reporting.FetchingPolicyData:
+		return "Capturing policy data..."
+	}
+	return ""
+}
+
+// sources the cookbooks from the provided cookbook paths and, unless running
+// non-interactive, from the paths the operator provides, returns the cookbooks
+// that could not be sourced
+func sourceCookbooks(cookbookDirName string, remainingCBs []reporting.NodeCookbook, repoDirName string, cookbookPaths []string) ([]reporting.NodeCookbook, error) {
+	var err error
+
+	// Gather sources from the provided cookbook paths first
+	for _, cookbookPath := range cookbookPaths {
+		if len(remainingCBs) == 0 {
+			break
+		}
+		remainingCBs, err = resolveCookbooks(cookbookDirName, remainingCBs, repoDirName, cookbookPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Try to gather sources for the remaining cookbooks; stop when we've found
+	// them all or the operator provides a blank input when asked for a new
+	// path.
+	if len(remainingCBs) > 0 && !captureFlags.nonInteractive {
+		requestGatherSources(repoDirName)
+		baseUserPath := requestCookbookPath(remainingCBs)
+		for len(remainingCBs) > 0 && baseUserPath != "" {
+			remainingCBs, err = resolveCookbooks(cookbookDirName, remainingCBs, repoDirName, baseUserPath)
+			if err != nil {
+				return nil, err
+			}
+			baseUserPath = requestCookbookPath(remainingCBs)
+		}
+	}
+	return remainingCBs, nil
+}
+
 func locksToCookbooks(locks map[string]chef.CookbookLock) []reporting.NodeCookbook {
 	var cbs = make([]reporting.NodeCookbook, 0)
 
@@ -185,15 +659,155 @@ func locksToCookbooks(locks map[string]chef.CookbookLock) []reporting.NodeCookbo
 }
 
 func init() {
-	captureCmd.PersistentFlags().BoolVarP(
-		&captureOpts.DownloadDataBags,
+	captureCmd.PersistentFlags().StringVarP(
+		&captureFlags.withDataBags,
 		"with-data-bags",
-		"d", false,
-		"download all data bags as part of node capture",
+		"d", "",
+		fmt.Sprintf("download data bags as part of node capture (%s)", strings.Join(reporting.DataBagModes, ", ")),
+	)
+	// --with-data-bags alone downloads all data bags
+	captureCmd.PersistentFlags().Lookup("with-data-bags").NoOptDefVal = reporting.AllDataBags
+	captureCmd.PersistentFlags().StringSliceVar(
+		&captureOpts.DataBags,
+		"data-bag", []string{},
+		"data bag to download in addition to the referenced ones, i.e. one referenced dynamically",
+	)
+	captureCmd.PersistentFlags().BoolVar(
+		&captureOpts.ChefSpec,
+		"chefspec", false,
+		"write a fauxhai fixture and ChefSpec scaffolds for the recipes of every node",
+	)
+	captureCmd.PersistentFlags().BoolVar(
+		&captureOpts.ToPolicyfile,
+		"to-policyfile", false,
+		"translate the run list, roles and environment of every node into a Policyfile",
+	)
+	captureCmd.PersistentFlags().BoolVar(
+		&captureOpts.Effortless,
+		"effortless", false,
+		"write an Effortless package of the policy of every node managed by a Policyfile",
+	)
+	captureCmd.PersistentFlags().StringVar(
+		&captureFlags.habOrigin,
+		"hab-origin", reporting.DefaultHabitatOrigin,
+		"Habitat origin of the Effortless packages",
+	)
+	captureCmd.PersistentFlags().BoolVar(
+		&captureFlags.noRedaction,
+		"no-redaction", false,
+		"write the captured objects without masking the values that look like secrets",
+	)
+	captureCmd.PersistentFlags().StringVar(
+		&captureFlags.events,
+		"events", captureEventsText,
+		fmt.Sprintf("format of the progress events, one of %s", strings.Join(captureEventFormats, ", ")),
+	)
+	captureCmd.PersistentFlags().StringVar(
+		&captureFlags.secretFile,
+		"secret-file", "",
+		"secret of the encrypted data bag items, verified and used by the local converges",
+	)
+	captureCmd.PersistentFlags().StringVar(
+		&captureFlags.archive,
+		"archive", "",
+		"pack the captured repository into this portable .tar.gz archive",
+	)
+	captureCmd.PersistentFlags().StringSliceVar(
+		&captureFlags.attributes,
+		"with-attributes", []string{},
+		"attribute levels to capture besides the normal attributes, any of default, override",
+	)
+	captureCmd.PersistentFlags().StringVarP(
+		&captureFlags.repoDir,
+		"repo-dir", "r", "",
+		"directory to create the node repository in (default ./node-NODE-NAME-repo, or ./nodes-repo when capturing several nodes)",
+	)
+	captureCmd.PersistentFlags().StringArrayVar(
+		&captureFlags.cookbookPaths,
+		"cookbook-path", []string{},
+		"base path of cookbook checkouts to source cookbooks from, can be specified multiple times (default chef.cookbook_repo_paths from config.toml)",
+	)
+	captureCmd.PersistentFlags().BoolVar(
+		&captureFlags.nonInteractive,
+		"non-interactive", false,
+		"do not prompt for cookbook locations, cookbooks not found in the cookbook paths are left unsourced",
+	)
+	captureCmd.PersistentFlags().StringVarP(
+		&captureFlags.query,
+		"query", "q", "",
+		"capture all nodes that match this search query (e.g. \"role:web AND chef_environment:prod\")",
+	)
+	captureCmd.PersistentFlags().StringVar(
+		&captureFlags.nodesFile,
+		"nodes-file", "",
+		"capture the nodes listed in this file, one node name per line",
+	)
+	captureCmd.PersistentFlags().BoolVar(
+		&captureFlags.refresh,
+		"refresh", false,
+		"refresh an existing repository instead of aborting, locally sourced cookbooks and files modified since the last capture are left untouched",
+	)
+	captureCmd.PersistentFlags().StringVar(
+		&captureFlags.generator,
+		"generator", reporting.NativeRepositoryGenerator,
+		fmt.Sprintf("generator of the repository skeleton, one of %s", strings.Join(reporting.RepositoryGenerators, ", ")),
+	)
+	captureCmd.PersistentFlags().StringVar(
+		&captureFlags.kitchenDriver,
+		"kitchen-driver", reporting.KitchenDriverVagrant,
+		fmt.Sprintf("Test Kitchen driver of the kitchen.yml, one of %s", strings.Join(reporting.KitchenDrivers, ", ")),
+	)
+	captureCmd.PersistentFlags().StringVar(
+		&captureFlags.kitchenImages,
+		"kitchen-images", "",
+		"TOML file with platform images that extend or override the built-in ones",
+	)
+	captureCmd.PersistentFlags().IntVarP(
+		&captureFlags.workers,
+		"workers", "w", 10,
+		"maximum number of nodes to capture in parallel",
+	)
+	captureCmd.PersistentFlags().IntVar(
+		&captureFlags.downloads,
+		"download-workers", reporting.DefaultDownloadWorkers,
+		"maximum number of cookbooks to download in parallel",
+	)
+	captureCmd.PersistentFlags().StringVar(
+		&captureFlags.importArchive,
+		"import", "",
+		"rebuild the repository captured in this archive instead of capturing nodes",
 	)
 	addInfraFlagsToCommand(captureCmd)
 }
 
+// returns the paths to source cookbooks from, the paths provided from the command
+// line must exist, when none are provided the cookbook_repo_paths of the config.toml
+// are used instead and the ones that don't exist are ignored
+func cookbookSourcePaths(flagPaths []string) ([]string, error) {
+	if len(flagPaths) > 0 {
+		for _, cookbookPath := range flagPaths {
+			if _, err := os.Stat(cookbookPath); err != nil {
+				return nil, errors.Wrapf(err, "invalid cookbook path '%s'", cookbookPath)
+			}
+		}
+		return flagPaths, nil
+	}
+
+	// the config.toml is optional
+	cfg, err := config.New()
+	if err != nil {
+		return []string{}, nil
+	}
+
+	cookbookPaths := make([]string, 0, len(cfg.Chef.CookbookRepoPaths))
+	for _, cookbookPath := range cfg.Chef.CookbookRepoPaths {
+		if _, err := os.Stat(cookbookPath); err == nil {
+			cookbookPaths = append(cookbookPaths, cookbookPath)
+		}
+	}
+	return cookbookPaths, nil
+}
+
 func requestCookbookPath(cookbooks []reporting.NodeCookbook) string {
 
 	userPath := promptUser(fmt.Sprintf(CookbookCaptureRequestCookbookPathTxt, formatCookbooks(cookbooks)))
@@ -208,15 +822,15 @@ func requestCookbookPath(cookbooks []reporting.NodeCookbook) string {
 }
 
 func requestGatherSources(repoPath string) {
-	fmt.Printf(CookbookCaptureGatherSourcesTxt, repoPath)
+	fmt.Fprintf(captureOut, CookbookCaptureGatherSourcesTxt, repoPath)
 	promptUser(PressEnterToContinueTxt)
 }
 
 func promptUser(msg string) string {
-	fmt.Print(msg)
+	fmt.Fprint(captureOut, msg)
 	var answer string
 	fmt.Scanf("%s\n", &answer)
-	fmt.Println("") // Blank linke after taking input separates any response we may write
+	fmt.Fprintln(captureOut, "") // Blank linke after taking input separates any response we may write
 	return answer
 }
 
@@ -247,11 +861,7 @@ func resolveCookbooks(cookbookDirName string, cookbooks []reporting.NodeCookbook
 	foundCount := 0
 	for _, cb := range cookbooks {
 		fullSourcePath, _ := filepath.Abs(fmt.Sprintf("%s/%s", sourcePath, cb.Name))
-		if cookbookDirName == "cookbooks" {
-			targetPath, _ = filepath.Abs(fmt.Sprintf("%s/cookbooks/%s", repoDir, cb.Name))
-		} else { // cookbook_artifacts
-			targetPath, _ = filepath.Abs(fmt.Sprintf("%s/cookbook_artifacts/%s-%s", repoDir, cb.Name, cb.Version))
-		}
+		targetPath = cookbookTargetPath(cookbookDirName, cb, repoDir)
 
 		if _, err := os.Stat(fullSourcePath); err == nil {
 
@@ -274,13 +884,37 @@ func resolveCookbooks(cookbookDirName string, cookbooks []reporting.NodeCookbook
 
 	if len(unresolved) > 0 {
 		if foundCount > 0 {
-			fmt.Printf("  Found %d/%d cookbooks in %s\n", foundCount, len(cookbooks), sourcePath)
+			fmt.Fprintf(captureOut, "  Found %d/%d cookbooks in %s\n", foundCount, len(cookbooks), sourcePath)
 		} else {
-			fmt.Printf("  No cookbooks found. Please check the path you provided: %s\n", sourcePath)
+			fmt.Fprintf(captureOut, "  No cookbooks found. Please check the path you provided: %s\n", sourcePath)
 		}
 	} else {
-		fmt.Printf("  Found all remaining cookbooks in %s\n", sourcePath)
+		fmt.Fprintf(captureOut, "  Found all remaining cookbooks in %s\n", sourcePath)
 	}
 
 	return unresolved, nil
 }
+
+// returns the path of a cookbook inside the repository
+func cookbookTargetPath(cookbookDirName string, cb reporting.NodeCookbook, repoDir string) string {
+	var targetPath string
+	if cookbookDirName == "cookbooks" {
+		targetPath, _ = filepath.Abs(fmt.Sprintf("%s/cookbooks/%s", repoDir, cb.Name))
+	} else { // cookbook_artifacts
+		targetPath, _ = filepath.Abs(fmt.Sprintf("%s/cookbook_artifacts/%s-%s", repoDir, cb.Name, cb.Version))
+	}
+	return targetPath
+}
+
+// filters out the cookbooks that were already sourced (symlinked) by a previous capture
+func unsourcedCookbooks(cookbookDirName string, cookbooks []reporting.NodeCookbook, repoDir string) []reporting.NodeCookbook {
+	unsourced := make([]reporting.NodeCookbook, 0, len(cookbooks))
+	for _, cb := range cookbooks {
+		info, err := os.Lstat(cookbookTargetPath(cookbookDirName, cb, repoDir))
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		unsourced = append(unsourced, cb)
+	}
+	return unsourced
+}
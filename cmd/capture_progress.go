@@ -0,0 +1,143 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/cheggaaa/pb/v3"
+
+	"github.com/chef/chef-analyze/pkg/reporting"
+)
+
+// formats the capture events are rendered in, see --events
+const (
+	// progress messages and bars for humans
+	captureEventsText = "text"
+	// one JSON object per event on stdout, for wrappers
+	captureEventsJSON = "json"
+)
+
+var captureEventFormats = []string{captureEventsText, captureEventsJSON}
+
+// captureEventRenderer renders the events of a capture as they happen
+type captureEventRenderer interface {
+	Render(event reporting.CaptureEvent)
+	// called once the capture is complete
+	Finish()
+}
+
+// returns the renderer of the --events format for the capture of nodeCount nodes
+func newCaptureEventRenderer(nodeCount int) captureEventRenderer {
+	switch {
+	case captureFlags.events == captureEventsJSON:
+		return &jsonEventRenderer{encoder: json.NewEncoder(os.Stdout)}
+	case nodeCount > 1:
+		return &multiNodeEventRenderer{nodeCount: nodeCount}
+	default:
+		return &nodeEventRenderer{}
+	}
+}
+
+// writes every event as a JSON object on its own line
+type jsonEventRenderer struct {
+	encoder *json.Encoder
+}
+
+func (r *jsonEventRenderer) Render(event reporting.CaptureEvent) {
+	// stdout is the only channel to the wrapper, there is nowhere else to report this
+	_ = r.encoder.Encode(event)
+}
+
+func (r *jsonEventRenderer) Finish() {}
+
+// prints every stage of the capture of a node and a progress
+// bar of the objects of the stages that capture several objects
+type nodeEventRenderer struct {
+	bar *pb.ProgressBar
+}
+
+func (r *nodeEventRenderer) Render(event reporting.CaptureEvent) {
+	switch {
+	case event.Error != nil:
+		r.Finish()
+	case event.IsObject():
+		if r.bar == nil {
+			r.bar = pb.Simple.New(event.Total).Set("prefix", "  ").Start()
+		}
+		r.bar.SetCurrent(int64(event.Index - 1))
+		r.bar.Set("suffix", event.Object)
+	default:
+		r.Finish()
+		if msg := captureProgressText(event.Stage, event.Node); msg != "" {
+			fmt.Fprintf(captureOut, " - %s\n", msg)
+		}
+	}
+}
+
+// completes the bar of the current stage, every object was captured
+func (r *nodeEventRenderer) Finish() {
+	if r.bar == nil {
+		return
+	}
+	r.bar.SetCurrent(r.bar.Total())
+	r.bar.Set("suffix", "")
+	r.bar.Finish()
+	r.bar = nil
+}
+
+// renders the capture of several nodes, which run in parallel, as a single
+// progress bar of the captured nodes that shows the object each node is at,
+// the events of the repository as a whole are printed once the nodes are done
+type multiNodeEventRenderer struct {
+	nodeCount int
+	bar       *pb.ProgressBar
+}
+
+func (r *multiNodeEventRenderer) Render(event reporting.CaptureEvent) {
+	if event.Node == "" {
+		r.Finish()
+		if msg := captureProgressText(event.Stage, ""); msg != "" && event.Error == nil {
+			fmt.Fprintf(captureOut, " - %s\n", msg)
+		}
+		return
+	}
+
+	if r.bar == nil {
+		r.bar = pb.Simple.New(r.nodeCount).Set("prefix", "  ").Start()
+	}
+	switch {
+	case event.Error != nil, event.Stage == reporting.CaptureComplete:
+		r.bar.Increment()
+		r.bar.Set("suffix", "")
+	case event.IsObject():
+		r.bar.Set("suffix", fmt.Sprintf("[%s] %s", event.Node, event.Object))
+	default:
+		r.bar.Set("suffix", fmt.Sprintf("[%s] %s", event.Node, captureProgressText(event.Stage, event.Node)))
+	}
+}
+
+func (r *multiNodeEventRenderer) Finish() {
+	if r.bar == nil {
+		return
+	}
+	r.bar.Set("suffix", "")
+	r.bar.Finish()
+	r.bar = nil
+}
@@ -42,7 +42,8 @@ const (
 
   The repository already exists in %s.
 
-  To re-run capture for node %s, delete this directory.
+  To re-run capture for node %s, delete this directory
+  or use --refresh to update it.
 
 `
 )
@@ -40,9 +40,14 @@ const (
 	analyzeErrorsDir  = "errors"  // Used for $HOME/.chef-workstation/errors
 	repNameCookbooks  = "cookbooks"
 	repNameNodes      = "nodes"
+	repNameDrift      = "cookbook-drift"
+	repNameGraph      = "cookbooks-graph"
+	repNameSprawl     = "cookbooks-sprawl"
 	ErrExt            = "err"
 	TxtExt            = "txt"
 	CsvExt            = "csv"
+	JSONExt           = "json"
+	DotExt            = "dot"
 )
 
 var (
@@ -58,46 +63,60 @@ var (
 		Long: `Generates a cookbook-oriented report containing details about the
 upgrade compatibility errors and node cookbook usage.
 
+Use --local to analyze cookbooks that never reached the Chef Infra Server: a
+chef-repo, a directory of cookbooks or a single cookbook, and the cookbooks
+locked by the Policyfile.lock.json files found under it. The cookbooks are
+analyzed in place and their node usage is left empty, unless --nodes-snapshot
+provides a directory of node JSON objects, such as the nodes of a captured
+repository, to match it from.
+
+Use --dependencies to parse the dependencies of the cookbooks from their metadata,
+or from the locks of their policy, and report for every cookbook the cookbooks that
+depend on it, directly or transitively, and its blast radius: the nodes that run
+the cookbook or any of its dependents, computed for all the versions of a cookbook
+that is not locked by a policy. The dependency graph of the cookbooks of the
+report is also written as Graphviz DOT and JSON files.
+
+Use --sprawl to aggregate the cookbooks by name instead: the number of versions in
+use, the oldest and newest of them and, for every version, the number of nodes that
+use it and the environments, policy groups or --local policies that pin it.
+
 The result is written to file.
 `,
 		RunE: func(_ *cobra.Command, _ []string) error {
-			creds, err := credentials.FromViper(
-				infraFlags.profile,
-				overrideCredentials(),
-			)
-
-			if err != nil {
-				return err
+			if cookbooksFlags.local == "" && cookbooksFlags.nodesSnapshot != "" {
+				return errors.New("--nodes-snapshot requires --local")
 			}
-
-			err = createOutputDirectories()
-			if err != nil {
-				return err
+			if cookbooksFlags.local != "" && reportsFlags.nodeFilter != "" {
+				return errors.New("--node-filter is a search of the Chef Infra Server, it can't be used with --local")
 			}
-
-			cfg := &reporting.Reporting{Credentials: creds}
-			if infraFlags.noSSLverify {
-				cfg.NoSSLVerify = true
+			if cookbooksFlags.local != "" && cookbooksFlags.nodesSnapshot == "" && cookbooksFlags.onlyUnused {
+				return errors.New("--only-unused with --local requires a --nodes-snapshot to find the usage of the cookbooks")
 			}
-
-			chefClient, err := reporting.NewChefClient(cfg)
-			if err != nil {
-				return err
+			if cookbooksFlags.dependencies && cookbooksFlags.onlyUnused {
+				return errors.New("--dependencies reports the cookbooks in use and their dependents, it can't be used with --only-unused")
+			}
+			if cookbooksFlags.sprawl && (cookbooksFlags.onlyUnused || cookbooksFlags.runCookstyle || cookbooksFlags.dependencies) {
+				return errors.New("--sprawl reports the versions in use, it can't be used with --only-unused, --verify-upgrade or --dependencies")
+			}
+			if cookbooksFlags.sprawl && cookbooksFlags.local != "" && cookbooksFlags.nodesSnapshot == "" {
+				return errors.New("--sprawl with --local requires a --nodes-snapshot to find the versions in use")
 			}
 
-			fmt.Printf("Finding available cookbooks...")
-			cookbooksState, err := reporting.NewCookbooksReport(
-				reporting.NewChefAnalyzeClient(chefClient),
-				cookbooksFlags.runCookstyle,
-				cookbooksFlags.onlyUnused,
-				cookbooksFlags.workers,
-				reportsFlags.nodeFilter,
-				toAnonymize(),
+			var (
+				cookbooksState *reporting.CookbooksReport
+				err            error
 			)
-
+			if cookbooksFlags.local != "" {
+				cookbooksState, err = newLocalCookbooksReport()
+			} else {
+				cookbooksState, err = newServerCookbooksReport()
+			}
 			if err != nil {
 				return err
 			}
+			cookbooksState.WithDependencies = cookbooksFlags.dependencies
+			cookbooksState.WithSprawl = cookbooksFlags.sprawl
 
 			if cookbooksState.TotalCookbooks == 0 {
 				fmt.Printf(" (0 found)\n\nNo cookbooks available for analysis.\n")
@@ -113,6 +132,11 @@ The result is written to file.
 				progressBar.Increment()
 			}
 			progressBar.Finish()
+
+			if cookbooksState.WithSprawl {
+				return saveCookbookSprawlReport(cookbooksState)
+			}
+
 			var (
 				formattedSummary = formatter.CookbooksReportSummary(cookbooksState)
 				results          *formatter.FormattedResult
@@ -139,6 +163,9 @@ The result is written to file.
 				return err
 			}
 
+			if cookbooksState.WithDependencies {
+				return saveCookbookGraph(cookbooksState)
+			}
 			return nil
 		},
 	}
@@ -146,14 +173,32 @@ The result is written to file.
 		Use:   "nodes",
 		Short: "Generates a nodes-oriented report",
 		Long: `Generates a nodes-oriented report containing basic information about the node,
-any applied policies, and the cookbooks used during the most recent chef-client run`,
+any applied policies, and the cookbooks used during the most recent chef-client run.
+
+Nodes that have never completed a chef-client run are called out, use --stale-after
+to also flag nodes that haven't checked in recently (e.g. 7d, 2w or 36h)
+
+Additional node attributes can be added to the report with --attribute or
+with the [reports.nodes] section of the config.toml:
+
+  [reports.nodes]
+  attributes = ["environment=chef_environment", "provider=cloud.provider"]
+
+Use --redact to mask the attribute values that look like secrets with the
+redaction rules of capture, including the ones of the [redaction] section of
+the config.toml.
+
+Use --group-by to aggregate large fleets into counts and percentages instead of
+listing every node, nested groups are created by passing more than one field
+(e.g. --group-by platform,chef_version)
+
+Every node is annotated with the end-of-life of its Chef Infra Client release,
+whether its platform is still supported and the recommended client to upgrade to.
+The support data shipped with this tool can be overridden with --support-data or
+with the support_data setting of the [reports.nodes] section of the config.toml`,
 		Args: cobra.NoArgs,
 		RunE: func(_ *cobra.Command, _ []string) error {
-			creds, err := credentials.FromViper(
-				infraFlags.profile,
-				overrideCredentials(),
-			)
-
+			client, err := newChefAnalyzeClient()
 			if err != nil {
 				return err
 			}
@@ -163,22 +208,73 @@ any applied policies, and the cookbooks used during the most recent chef-client
 				return err
 			}
 
-			cfg := &reporting.Reporting{Credentials: creds}
-			if infraFlags.noSSLverify {
-				cfg.NoSSLVerify = true
+			var staleAfter time.Duration
+			if nodesFlags.staleAfter != "" {
+				staleAfter, err = reporting.ParseAge(nodesFlags.staleAfter)
+				if err != nil {
+					return err
+				}
 			}
 
-			chefClient, err := reporting.NewChefClient(cfg)
+			nodesCfg, err := reporting.LoadNodesReportConfig()
+			if err != nil {
+				return err
+			}
+			// attributes from the command line override the ones from the config.toml
+			attributes, err := reporting.ParseNodeAttributes(
+				append(nodesCfg.Attributes, nodesFlags.attributes...),
+			)
 			if err != nil {
 				return err
 			}
 
+			// the support data from the command line overrides the one from the config.toml
+			supportDataFile := nodesCfg.SupportData
+			if nodesFlags.supportData != "" {
+				supportDataFile = nodesFlags.supportData
+			}
+			supportData, err := reporting.LoadSupportData(supportDataFile)
+			if err != nil {
+				return err
+			}
+
+			var redactor *reporting.Redactor
+			if nodesFlags.redact {
+				redactor, err = loadRedactor()
+				if err != nil {
+					return err
+				}
+			}
+
 			fmt.Println("Analyzing nodes...")
-			report, err := reporting.GenerateNodesReport(reporting.NewChefAnalyzeClient(chefClient), reportsFlags.nodeFilter, toAnonymize())
+			report, err := reporting.GenerateNodesReport(
+				client,
+				reportsFlags.nodeFilter,
+				staleAfter,
+				attributes,
+				toAnonymize(),
+				redactor,
+			)
 			if err != nil {
 				return err
 			}
 
+			if redactions := redactor.Redactions(); len(redactions) > 0 {
+				fmt.Printf("Redacted %d attribute value(s)\n", len(redactions))
+			}
+			reporting.AnnotateNodesSupport(report, supportData)
+
+			if nodesFlags.onlyStale {
+				report = reporting.StaleNodes(report)
+			}
+
+			if nodesFlags.groupBy != "" {
+				return saveNodesGroupsReport(report, reporting.ParseNodeGroupFields(nodesFlags.groupBy))
+			}
+			if reportsFlags.format == "json" {
+				return errors.New("the json format is only available for grouped nodes reports (--group-by)")
+			}
+
 			var (
 				formattedSummary = formatter.NodesReportSummary(report, reportsFlags.nodeFilter)
 				results          *formatter.FormattedResult
@@ -208,10 +304,114 @@ any applied policies, and the cookbooks used during the most recent chef-client
 			return nil
 		},
 	}
+	reportCookbookDriftCmd = &cobra.Command{
+		Use:   "cookbook-drift",
+		Short: "Compares the server cookbooks with their local checkouts",
+		Args:  cobra.NoArgs,
+		Long: `Compares every cookbook version of the Chef Infra Server with the local
+checkout with the same cookbook name in its metadata, found in the --cookbook-path
+paths or the cookbook_repo_paths setting of the [chef] section of the config.toml.
+
+The version is looked up in the git history of the checkout, by a tag named
+after the version (i.e. NAME-1.2.0, NAME-v1.2.0, 1.2.0 or v1.2.0, in that order)
+or by the newest commit with the files of the server among the commits made while
+the metadata.rb held it, otherwise the cookbook is compared with the working tree.
+The files listed in the chefignore are not compared.
+
+Every cookbook version is reported as in sync, uncommitted when it was uploaded
+from changes that are not committed, modified when it differs from both the
+commit and the working tree, or unknown source when the version is not in git
+and differs from the working tree, along with the files that differ.
+
+The result is written to file.
+`,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if reportsFlags.nodeFilter != "" {
+				return errors.New("--node-filter can't be used with cookbook-drift, it doesn't report nodes")
+			}
+			cookbookPaths, err := cookbookSourcePaths(cookbookDriftFlags.cookbookPaths)
+			if err != nil {
+				return err
+			}
+			if len(cookbookPaths) == 0 {
+				return errors.New("requires a --cookbook-path or the cookbook_repo_paths of the config.toml")
+			}
+
+			client, err := newChefAnalyzeClient()
+			if err != nil {
+				return err
+			}
+
+			err = createOutputDirectories()
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Finding cookbooks with a local checkout...")
+			driftReport, err := reporting.NewCookbookDriftReport(
+				client.Cookbooks,
+				cookbookPaths,
+				cookbookDriftFlags.workers,
+				toAnonymize(),
+			)
+			if err != nil {
+				return err
+			}
+			fmt.Printf(" (%d found)\n", driftReport.TotalCookbooks)
+
+			if driftReport.TotalCookbooks != 0 {
+				fmt.Println("Comparing cookbooks...")
+				progressBar := pb.New(driftReport.TotalCookbooks)
+				progressBar.Start()
+				go driftReport.Generate()
+				for range driftReport.Progress {
+					progressBar.Increment()
+				}
+				progressBar.Finish()
+			}
+
+			fmt.Println(formatter.CookbookDriftReportSummary(driftReport.Records).Report)
+
+			var (
+				results *formatter.FormattedResult
+				ext     string
+			)
+			switch reportsFlags.format {
+			case "csv":
+				ext = CsvExt
+				results = formatter.MakeCookbookDriftReportCSV(driftReport.Records)
+			default:
+				ext = TxtExt
+				results = formatter.MakeCookbookDriftReportTXT(driftReport.Records)
+			}
+
+			err = saveReport(repNameDrift, ext, "", results.Report)
+			if err != nil {
+				return err
+			}
+			return saveErrorReport(repNameDrift, results.Errors)
+		},
+	}
+	cookbookDriftFlags struct {
+		cookbookPaths []string
+		workers       int
+	}
 	cookbooksFlags struct {
-		onlyUnused   bool
-		runCookstyle bool
-		workers      int
+		onlyUnused    bool
+		runCookstyle  bool
+		workers       int
+		local         string
+		nodesSnapshot string
+		dependencies  bool
+		sprawl        bool
+	}
+	nodesFlags struct {
+		staleAfter  string
+		onlyStale   bool
+		attributes  []string
+		groupBy     string
+		supportData string
+		redact      bool
 	}
 	reportsFlags struct {
 		format     string
@@ -228,7 +428,7 @@ func init() {
 	reportCmd.PersistentFlags().StringVarP(
 		&reportsFlags.format,
 		"format", "f", "txt",
-		"output format: txt is human readable, csv is machine readable",
+		"output format: txt is human readable, csv is machine readable, json is available for grouped nodes reports",
 	)
 	reportCmd.PersistentFlags().StringVarP(
 		&reportsFlags.nodeFilter,
@@ -252,14 +452,83 @@ func init() {
 		"verify-upgrade", "V", false,
 		"verify the upgrade compatibility of every cookbook",
 	)
+	reportCookbooksCmd.PersistentFlags().StringVar(
+		&cookbooksFlags.local,
+		"local", "",
+		"analyze the cookbooks of a local chef-repo, cookbooks directory or cookbook and its Policyfile.lock.json files instead of the Chef Infra Server",
+	)
+	reportCookbooksCmd.PersistentFlags().StringVar(
+		&cookbooksFlags.nodesSnapshot,
+		"nodes-snapshot", "",
+		"directory of node JSON objects (e.g. the nodes of a captured repository) to find the nodes that use the --local cookbooks",
+	)
+	reportCookbooksCmd.PersistentFlags().BoolVar(
+		&cookbooksFlags.dependencies,
+		"dependencies", false,
+		"report the dependents and blast radius of every cookbook and save its dependency graph as DOT and JSON",
+	)
+	reportCookbooksCmd.PersistentFlags().BoolVar(
+		&cookbooksFlags.sprawl,
+		"sprawl", false,
+		"aggregate the cookbooks by name with the versions in use and the environments or policy groups that pin them",
+	)
 	reportCmd.PersistentFlags().BoolVarP(
 		&reportsFlags.anonymize,
 		"anonymize", "a", false,
 		"replace cookbook and node names with hash values",
 	)
+
+	// cookbook-drift cmd flags
+	reportCookbookDriftCmd.PersistentFlags().StringArrayVar(
+		&cookbookDriftFlags.cookbookPaths,
+		"cookbook-path", []string{},
+		"base path of cookbook checkouts to compare the cookbooks with, can be specified multiple times (default chef.cookbook_repo_paths from config.toml)",
+	)
+	reportCookbookDriftCmd.PersistentFlags().IntVarP(
+		&cookbookDriftFlags.workers,
+		"workers", "w", 10,
+		"maximum number of parallel workers at once",
+	)
+
+	// nodes cmd flags
+	reportNodesCmd.PersistentFlags().StringVar(
+		&nodesFlags.staleAfter,
+		"stale-after", "",
+		"flag nodes that have not checked in within this age (e.g. 7d, 2w or 36h)",
+	)
+	reportNodesCmd.PersistentFlags().BoolVar(
+		&nodesFlags.redact,
+		"redact", false,
+		"mask the attribute values that look like secrets, with the same rules as capture",
+	)
+	reportNodesCmd.PersistentFlags().BoolVar(
+		&nodesFlags.onlyStale,
+		"only-stale", false,
+		"generate a report with only stale and never-converged nodes",
+	)
+	reportNodesCmd.PersistentFlags().StringArrayVar(
+		&nodesFlags.attributes,
+		"attribute", []string{},
+		"add a node attribute column to the report in the form NAME=PATH (e.g. provider=cloud.provider), can be specified multiple times",
+	)
+	reportNodesCmd.PersistentFlags().StringVar(
+		&nodesFlags.groupBy,
+		"group-by", "",
+		fmt.Sprintf("aggregate nodes by a comma separated list of fields (%s)",
+			strings.Join(reporting.NodeGroupFields, ", ")),
+	)
+	reportNodesCmd.PersistentFlags().StringVar(
+		&nodesFlags.supportData,
+		"support-data", "",
+		"file with Chef Infra Client releases and platforms support data that overrides the built-in data",
+	)
+
 	// adds the cookbooks command as a sub-command of the report command
 	reportCmd.AddCommand(reportCookbooksCmd)
 
+	// adds the cookbook-drift command as a sub-command of the report command
+	reportCmd.AddCommand(reportCookbookDriftCmd)
+
 	// adds the nodes command as a sub-command of the report command
 	reportCmd.AddCommand(reportNodesCmd)
 
@@ -270,6 +539,154 @@ func init() {
 	reportCmd.AddCommand(sessionCmd)
 }
 
+// returns a client of the Chef Infra Server from the credentials of the profile
+// and the flags that override them
+func newChefAnalyzeClient() (*reporting.ChefAnalyzeClient, error) {
+	creds, err := credentials.FromViper(
+		infraFlags.profile,
+		overrideCredentials(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &reporting.Reporting{Credentials: creds}
+	if infraFlags.noSSLverify {
+		cfg.NoSSLVerify = true
+	}
+
+	chefClient, err := reporting.NewChefClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return reporting.NewChefAnalyzeClient(chefClient), nil
+}
+
+// creates the report of the cookbooks of the Chef Infra Server
+func newServerCookbooksReport() (*reporting.CookbooksReport, error) {
+	client, err := newChefAnalyzeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createOutputDirectories()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Finding available cookbooks...")
+	return reporting.NewCookbooksReport(
+		client,
+		cookbooksFlags.runCookstyle,
+		cookbooksFlags.onlyUnused,
+		cookbooksFlags.workers,
+		reportsFlags.nodeFilter,
+		toAnonymize(),
+	)
+}
+
+// creates the report of the cookbooks of the --local path
+func newLocalCookbooksReport() (*reporting.CookbooksReport, error) {
+	err := createOutputDirectories()
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot *reporting.NodeSnapshot
+	if cookbooksFlags.nodesSnapshot != "" {
+		snapshot, err = reporting.LoadNodeSnapshot(cookbooksFlags.nodesSnapshot)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Printf("Finding available cookbooks...")
+	return reporting.NewLocalCookbooksReport(
+		cookbooksFlags.local,
+		snapshot,
+		cookbooksFlags.runCookstyle,
+		cookbooksFlags.onlyUnused,
+		cookbooksFlags.workers,
+		toAnonymize(),
+	)
+}
+
+// saves the dependency graph of the cookbooks of the report as DOT and JSON
+func saveCookbookGraph(cookbooksState *reporting.CookbooksReport) error {
+	dotGraph := formatter.MakeCookbookGraphDOT(cookbooksState.Graph)
+	err := saveReport(repNameGraph, DotExt, cookbooksState.NodeFilter, dotGraph.Report)
+	if err != nil {
+		return err
+	}
+
+	jsonGraph := formatter.MakeCookbookGraphJSON(cookbooksState.Graph)
+	err = saveReport(repNameGraph, JSONExt, cookbooksState.NodeFilter, jsonGraph.Report)
+	if err != nil {
+		return err
+	}
+	return saveErrorReport(repNameGraph, jsonGraph.Errors)
+}
+
+// prints and saves the versions in use of every cookbook of the report
+func saveCookbookSprawlReport(cookbooksState *reporting.CookbooksReport) error {
+	var (
+		formattedSummary = formatter.CookbookSprawlReportSummary(cookbooksState.Sprawl)
+		results          *formatter.FormattedResult
+		ext              string
+	)
+
+	fmt.Println(formattedSummary.Report)
+
+	switch reportsFlags.format {
+	case "csv":
+		ext = CsvExt
+		results = formatter.MakeCookbookSprawlReportCSV(cookbooksState.Sprawl)
+	default:
+		ext = TxtExt
+		results = formatter.MakeCookbookSprawlReportTXT(cookbooksState.Sprawl)
+	}
+
+	err := saveReport(repNameSprawl, ext, cookbooksState.NodeFilter, results.Report)
+	if err != nil {
+		return err
+	}
+	return saveErrorReport(repNameSprawl, formatter.MakeCookbookRecordsErrors(cookbooksState.Records))
+}
+
+// prints and saves the nodes of the report aggregated by the provided fields
+func saveNodesGroupsReport(report []*reporting.NodeReportItem, fields []string) error {
+	groups, err := reporting.GroupNodes(report, fields)
+	if err != nil {
+		return err
+	}
+
+	var (
+		formattedSummary = formatter.NodesGroupsReportSummary(groups, fields, reportsFlags.nodeFilter, toAnonymize())
+		results          *formatter.FormattedResult
+		ext              string
+	)
+
+	fmt.Println(formattedSummary.Report)
+
+	switch reportsFlags.format {
+	case "csv":
+		ext = CsvExt
+		results = formatter.MakeNodesGroupsReportCSV(groups, fields)
+	case "json":
+		ext = JSONExt
+		results = formatter.MakeNodesGroupsReportJSON(groups, fields, reportsFlags.nodeFilter)
+	default:
+		ext = TxtExt
+		results = &formatter.FormattedResult{Report: formattedSummary.Report}
+	}
+
+	err = saveReport(repNameNodes, ext, reportsFlags.nodeFilter, results.Report)
+	if err != nil {
+		return err
+	}
+	return saveErrorReport(repNameNodes, results.Errors)
+}
+
 func createOutputDirectories() error {
 	wsDir, err := config.ChefWorkstationDir()
 	if err != nil {
@@ -350,6 +767,16 @@ func saveReport(baseName string, ext string, nodeFilter string, content string)
 	return nil
 }
 
+// returns a redactor with the default redaction rules
+// extended with the ones of the config.toml
+func loadRedactor() (*reporting.Redactor, error) {
+	rules, err := reporting.LoadRedactionRules()
+	if err != nil {
+		return nil, err
+	}
+	return reporting.NewRedactor(rules)
+}
+
 func toAnonymize() bool {
 
 	c, error := reporting.LoadConfig()
@@ -87,7 +87,8 @@ func initConfig() {
 			viper.SetConfigFile(credsFile)
 		} else {
 
-			if !hasMinimumParams() && isReportCommand() {
+			// local cookbook reports don't talk to the Chef Infra Server
+			if !hasMinimumParams() && isReportCommand() && cookbooksFlags.local == "" {
 				fmt.Printf("Error: %s\n", MissingMinimumParametersErr)
 				rootCmd.Usage()
 				os.Exit(-1)
@@ -42,6 +42,24 @@ As you identify issues, you can modify cookbooks in their
 original checkout locations or in the repository's cookbooks
 directory and they will be picked up on subsequent runs
 of 'kitchen converge'.
+`
+	// Param 1: number of encrypted data bag items
+	// Param 2: newline-separated list of encrypted data bag items
+	EncryptedDataBagsWithoutSecretTxt = `
+------------------------ WARNING ---------------------------
+%d encrypted data bag item(s) were captured without a secret,
+local converges that decrypt them will fail:
+
+  %s
+
+Capture again with --secret-file to use them.
+-----------------------------------------------------------
+`
+	// Param 1, 2: archive path
+	CaptureArchiveCompleteTxt = `
+The capture was archived to %s.
+
+Rebuild the repository anywhere with 'chef capture --import %s'.
 `
 	// Param 1: repository directory
 	CookbookCaptureGatherSourcesTxt = `
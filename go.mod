@@ -3,6 +3,7 @@ module github.com/chef/chef-analyze
 go 1.26
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/aws/aws-sdk-go v1.55.8
 	github.com/chef/go-libs v0.4.3
 	github.com/cheggaaa/pb/v3 v3.1.7
@@ -25,7 +26,6 @@ require (
 	cloud.google.com/go/pubsub v1.3.1 // indirect
 	cloud.google.com/go/storage v1.14.0 // indirect
 	dmitri.shuralyov.com/gpu/mtl v0.0.0-20190408044501-666a987793e9 // indirect
-	github.com/BurntSushi/toml v1.6.0 // indirect
 	github.com/BurntSushi/xgb v0.0.0-20160522181843-27f122750802 // indirect
 	github.com/DataDog/datadog-go v3.2.0+incompatible // indirect
 	github.com/OneOfOne/xxhash v1.2.2 // indirect
@@ -56,18 +56,57 @@ func TestHelpCommandDisplayHelpForCapture(t *testing.T) {
 	var expected = `Captures a node's state as a local chef-repo, which
 can then be used to converge locally.
 
+Several nodes can be captured into the same repository by providing more
+than one node name, a search query with --query or a file with one node
+name per line with --nodes-file. Cookbooks are sourced from --cookbook-path,
+or the cookbook_repo_paths of the config.toml, and values that look like
+secrets are masked and listed in the redaction-log.json of the repository.
+
 Usage:
-  chef capture NODE-NAME [flags]
+  chef capture [NODE-NAME...] [flags]
+
+Examples:
+  # capture a node and converge it locally with Test Kitchen
+  chef capture node1
+
+  # capture the web nodes of production without prompting for cookbooks
+  chef capture --query "role:web AND chef_environment:prod" --non-interactive
+
+  # pack a capture into an archive and rebuild it on another workstation
+  chef capture node1 --archive node1.tar.gz
+  chef capture --import node1.tar.gz
 
 Flags:
-  -s, --chef-server-url string   Chef Infra Server URL
-  -k, --client-key string        Chef Infra Server API client key
-  -n, --client-name string       Chef Infra Server API client name
-  -c, --credentials string       credentials file (default $HOME/.chef/credentials)
-  -h, --help                     help for capture
-  -p, --profile string           profile to use from credentials file (default "default")
-  -o, --ssl-no-verify            Do not verify SSL when connecting to Chef Infra Server (default: verify)
-  -d, --with-data-bags           download all data bags as part of node capture
+      --archive string                  pack the captured repository into this portable .tar.gz archive
+  -s, --chef-server-url string          Chef Infra Server URL
+      --chefspec                        write a fauxhai fixture and ChefSpec scaffolds for the recipes of every node
+  -k, --client-key string               Chef Infra Server API client key
+  -n, --client-name string              Chef Infra Server API client name
+      --cookbook-path stringArray       base path of cookbook checkouts to source cookbooks from, can be specified multiple times (default chef.cookbook_repo_paths from config.toml)
+  -c, --credentials string              credentials file (default $HOME/.chef/credentials)
+      --data-bag strings                data bag to download in addition to the referenced ones, i.e. one referenced dynamically
+      --download-workers int            maximum number of cookbooks to download in parallel (default 10)
+      --effortless                      write an Effortless package of the policy of every node managed by a Policyfile
+      --events string                   format of the progress events, one of text, json (default "text")
+      --generator string                generator of the repository skeleton, one of native, chef (default "native")
+      --hab-origin string               Habitat origin of the Effortless packages (default "chef-analyze")
+  -h, --help                            help for capture
+      --import string                   rebuild the repository captured in this archive instead of capturing nodes
+      --kitchen-driver string           Test Kitchen driver of the kitchen.yml, one of vagrant, dokken, docker, ec2 (default "vagrant")
+      --kitchen-images string           TOML file with platform images that extend or override the built-in ones
+      --no-redaction                    write the captured objects without masking the values that look like secrets
+      --nodes-file string               capture the nodes listed in this file, one node name per line
+      --non-interactive                 do not prompt for cookbook locations, cookbooks not found in the cookbook paths are left unsourced
+  -p, --profile string                  profile to use from credentials file (default "default")
+  -q, --query string                    capture all nodes that match this search query (e.g. "role:web AND chef_environment:prod")
+      --refresh                         refresh an existing repository instead of aborting, locally sourced cookbooks and files modified since the last capture are left untouched
+  -r, --repo-dir string                 directory to create the node repository in (default ./node-NODE-NAME-repo, or ./nodes-repo when capturing several nodes)
+      --secret-file string              secret of the encrypted data bag items, verified and used by the local converges
+  -o, --ssl-no-verify                   Do not verify SSL when connecting to Chef Infra Server (default: verify)
+      --to-policyfile                   translate the run list, roles and environment of every node into a Policyfile
+      --with-attributes strings         attribute levels to capture besides the normal attributes, any of default, override
+  -d, --with-data-bags string[="all"]   download data bags as part of node capture (all, referenced)
+  -w, --workers int                     maximum number of nodes to capture in parallel (default 10)
 `
 	assert.Equal(t, expected, out.String())
 	assert.Empty(t, err.String(), "STDERR should be empty")
@@ -82,8 +121,9 @@ Usage:
   chef report [command]
 
 Available Commands:
-  cookbooks   Generates a cookbook-oriented report
-  nodes       Generates a nodes-oriented report
+  cookbook-drift Compares the server cookbooks with their local checkouts
+  cookbooks      Generates a cookbook-oriented report
+  nodes          Generates a nodes-oriented report
 
 Flags:
   -a, --anonymize                replace cookbook and node names with hash values
@@ -91,7 +131,7 @@ Flags:
   -k, --client-key string        Chef Infra Server API client key
   -n, --client-name string       Chef Infra Server API client name
   -c, --credentials string       credentials file (default $HOME/.chef/credentials)
-  -f, --format string            output format: txt is human readable, csv is machine readable (default "txt")
+  -f, --format string            output format: txt is human readable, csv is machine readable, json is available for grouped nodes reports (default "txt")
   -h, --help                     help for report
   -F, --node-filter string       Search filter to apply to nodes
   -p, --profile string           profile to use from credentials file (default "default")
@@ -109,16 +149,38 @@ func TestHelpCommandDisplayHelpForReportCookbooks(t *testing.T) {
 	expected := `Generates a cookbook-oriented report containing details about the
 upgrade compatibility errors and node cookbook usage.
 
+Use --local to analyze cookbooks that never reached the Chef Infra Server: a
+chef-repo, a directory of cookbooks or a single cookbook, and the cookbooks
+locked by the Policyfile.lock.json files found under it. The cookbooks are
+analyzed in place and their node usage is left empty, unless --nodes-snapshot
+provides a directory of node JSON objects, such as the nodes of a captured
+repository, to match it from.
+
+Use --dependencies to parse the dependencies of the cookbooks from their metadata,
+or from the locks of their policy, and report for every cookbook the cookbooks that
+depend on it, directly or transitively, and its blast radius: the nodes that run
+the cookbook or any of its dependents, computed for all the versions of a cookbook
+that is not locked by a policy. The dependency graph of the cookbooks of the
+report is also written as Graphviz DOT and JSON files.
+
+Use --sprawl to aggregate the cookbooks by name instead: the number of versions in
+use, the oldest and newest of them and, for every version, the number of nodes that
+use it and the environments, policy groups or --local policies that pin it.
+
 The result is written to file.
 
 Usage:
   chef report cookbooks [flags]
 
 Flags:
-  -h, --help             help for cookbooks
-  -u, --only-unused      generate a report with only cookbooks that are not included in any node's runlist
-  -V, --verify-upgrade   verify the upgrade compatibility of every cookbook
-  -w, --workers int      maximum number of parallel workers at once (default 50)
+      --dependencies            report the dependents and blast radius of every cookbook and save its dependency graph as DOT and JSON
+  -h, --help                    help for cookbooks
+      --local string            analyze the cookbooks of a local chef-repo, cookbooks directory or cookbook and its Policyfile.lock.json files instead of the Chef Infra Server
+      --nodes-snapshot string   directory of node JSON objects (e.g. the nodes of a captured repository) to find the nodes that use the --local cookbooks
+  -u, --only-unused             generate a report with only cookbooks that are not included in any node's runlist
+      --sprawl                  aggregate the cookbooks by name with the versions in use and the environments or policy groups that pin them
+  -V, --verify-upgrade          verify the upgrade compatibility of every cookbook
+  -w, --workers int             maximum number of parallel workers at once (default 50)
 
 Global Flags:
   -a, --anonymize                replace cookbook and node names with hash values
@@ -126,7 +188,7 @@ Global Flags:
   -k, --client-key string        Chef Infra Server API client key
   -n, --client-name string       Chef Infra Server API client name
   -c, --credentials string       credentials file (default $HOME/.chef/credentials)
-  -f, --format string            output format: txt is human readable, csv is machine readable (default "txt")
+  -f, --format string            output format: txt is human readable, csv is machine readable, json is available for grouped nodes reports (default "txt")
   -F, --node-filter string       Search filter to apply to nodes
   -p, --profile string           profile to use from credentials file (default "default")
   -o, --ssl-no-verify            Do not verify SSL when connecting to Chef Infra Server (default: verify)
@@ -139,13 +201,41 @@ Global Flags:
 func TestHelpCommandDisplayHelpForReportNodes(t *testing.T) {
 	out, err, exitcode := ChefAnalyze("help", "report", "nodes")
 	var expected = `Generates a nodes-oriented report containing basic information about the node,
-any applied policies, and the cookbooks used during the most recent chef-client run
+any applied policies, and the cookbooks used during the most recent chef-client run.
+
+Nodes that have never completed a chef-client run are called out, use --stale-after
+to also flag nodes that haven't checked in recently (e.g. 7d, 2w or 36h)
+
+Additional node attributes can be added to the report with --attribute or
+with the [reports.nodes] section of the config.toml:
+
+  [reports.nodes]
+  attributes = ["environment=chef_environment", "provider=cloud.provider"]
+
+Use --redact to mask the attribute values that look like secrets with the
+redaction rules of capture, including the ones of the [redaction] section of
+the config.toml.
+
+Use --group-by to aggregate large fleets into counts and percentages instead of
+listing every node, nested groups are created by passing more than one field
+(e.g. --group-by platform,chef_version)
+
+Every node is annotated with the end-of-life of its Chef Infra Client release,
+whether its platform is still supported and the recommended client to upgrade to.
+The support data shipped with this tool can be overridden with --support-data or
+with the support_data setting of the [reports.nodes] section of the config.toml
 
 Usage:
   chef report nodes [flags]
 
 Flags:
-  -h, --help   help for nodes
+      --attribute stringArray   add a node attribute column to the report in the form NAME=PATH (e.g. provider=cloud.provider), can be specified multiple times
+      --group-by string         aggregate nodes by a comma separated list of fields (chef_version, platform, platform_version, policy_group, policy, environment)
+  -h, --help                    help for nodes
+      --only-stale              generate a report with only stale and never-converged nodes
+      --redact                  mask the attribute values that look like secrets, with the same rules as capture
+      --stale-after string      flag nodes that have not checked in within this age (e.g. 7d, 2w or 36h)
+      --support-data string     file with Chef Infra Client releases and platforms support data that overrides the built-in data
 
 Global Flags:
   -a, --anonymize                replace cookbook and node names with hash values
@@ -153,7 +243,7 @@ Global Flags:
   -k, --client-key string        Chef Infra Server API client key
   -n, --client-name string       Chef Infra Server API client name
   -c, --credentials string       credentials file (default $HOME/.chef/credentials)
-  -f, --format string            output format: txt is human readable, csv is machine readable (default "txt")
+  -f, --format string            output format: txt is human readable, csv is machine readable, json is available for grouped nodes reports (default "txt")
   -F, --node-filter string       Search filter to apply to nodes
   -p, --profile string           profile to use from credentials file (default "default")
   -o, --ssl-no-verify            Do not verify SSL when connecting to Chef Infra Server (default: verify)
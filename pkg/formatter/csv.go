@@ -20,7 +20,9 @@ package formatter
 import (
 	"encoding/csv"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/chef/chef-analyze/pkg/reporting"
 )
@@ -51,6 +53,9 @@ func MakeCookbooksReportCSV(state *reporting.CookbooksReport) *FormattedResult {
 	} else {
 		tableHeaders = append(tableHeaders, fmt.Sprintf("Nodes (filtered: %s)", state.NodeFilter))
 	}
+	if state.WithDependencies {
+		tableHeaders = append(tableHeaders, "Dependents", "Blast Radius")
+	}
 	csvWriter.Write(tableHeaders)
 
 	sortCookbookRecords(state.Records)
@@ -60,6 +65,14 @@ func MakeCookbooksReportCSV(state *reporting.CookbooksReport) *FormattedResult {
 		if record.NumNodesAffected() != 0 {
 			nodesString = strings.Join(record.Nodes, " ")
 		}
+		// only written when the report has the dependencies of the cookbooks
+		dependencies := []string{}
+		if state.WithDependencies {
+			dependencies = []string{
+				strings.Join(record.Dependents, " "),
+				strings.Join(record.BlastRadius, " "),
+			}
+		}
 
 		if state.RunCookstyle {
 			for _, file := range record.Files {
@@ -83,21 +96,15 @@ func MakeCookbooksReportCSV(state *reporting.CookbooksReport) *FormattedResult {
 					if offense.Correctable {
 						row[7] = "Y"
 					}
-					csvWriter.Write(row)
+					csvWriter.Write(append(row, dependencies...))
 				}
 			}
 		} else {
 			row := []string{record.Name, record.Version, record.PolicyGroup, record.Policy, record.PolicyVer, nodesString}
-			csvWriter.Write(row)
+			csvWriter.Write(append(row, dependencies...))
 		}
 
-		for _, e := range record.Errors() {
-			if record.PolicyGroup != "" {
-				errBuilder.WriteString(fmt.Sprintf(" - %s (PolicyGroup %s, Policy %s, PolicyRevision %s): %v\n", record.Name, record.PolicyGroup, record.Policy, record.PolicyVer, e))
-			} else {
-				errBuilder.WriteString(fmt.Sprintf(" - %s (%s): %v\n", record.Name, record.Version, e))
-			}
-		}
+		writeCookbookRecordErrors(&errBuilder, record)
 	}
 
 	csvWriter.Flush()
@@ -116,7 +123,9 @@ func MakeNodesReportCSV(records []*reporting.NodeReportItem, nodeFilter string)
 		return &FormattedResult{"", ""}
 	}
 
-	tableHeaders := []string{"Node Name", "Chef Version", "Operating System", "Policy Group", "Policy", "Cookbooks (alphanumeric order)"}
+	tableHeaders := []string{"Node Name", "Chef Version", "Operating System", "Policy Group", "Policy", "Cookbooks (alphanumeric order)",
+		"Run List", "Last Check-in", "Days Since Last Run", "Stale", "Client EOL", "Platform Supported", "Recommended Client"}
+	tableHeaders = append(tableHeaders, reporting.NodeAttributeNames(records)...)
 	if len(nodeFilter) > 0 {
 		tableHeaders[0] = fmt.Sprintf("Node Name (node filter: %s)", nodeFilter)
 	}
@@ -128,6 +137,9 @@ func MakeNodesReportCSV(records []*reporting.NodeReportItem, nodeFilter string)
 		var (
 			cookbooksString = "None"
 			cookbooksList   = record.CookbooksList()
+			lastCheckIn     = "never converged"
+			daysSinceRun    = ""
+			stale           = "N"
 		)
 		if len(cookbooksList) != 0 {
 			cookbooksString = strings.Join(cookbooksList, " ")
@@ -136,16 +148,141 @@ func MakeNodesReportCSV(records []*reporting.NodeReportItem, nodeFilter string)
 			}
 		}
 
-		csvWriter.Write([]string{
+		if !record.NeverConverged() {
+			lastCheckIn = record.LastCheckIn.UTC().Format(time.RFC3339)
+			daysSinceRun = strconv.Itoa(record.DaysSinceLastRun)
+		}
+		if record.Stale {
+			stale = "Y"
+		}
+
+		row := []string{
 			record.Name,
 			record.ChefVersion,
 			record.OSVersionPretty(),
 			record.GetPolicyGroup(),
 			record.GetPolicyWithRev(),
 			cookbooksString,
-		})
+			strings.Join(record.RunList, " "),
+			lastCheckIn,
+			daysSinceRun,
+			stale,
+			record.Support.ClientEOLPretty(),
+			record.Support.PlatformSupportedPretty(),
+			record.Support.RecommendedClient,
+		}
+		for _, attr := range record.Attributes {
+			row = append(row, attr.Value)
+		}
+		csvWriter.Write(row)
+
+		if record.ExpandError != nil {
+			errBuilder.WriteString(fmt.Sprintf(" - %s: %v\n", record.Name, record.ExpandError))
+		}
+	}
+
+	csvWriter.Flush()
+	return &FormattedResult{strBuilder.String(), errBuilder.String()}
+}
+
+// MakeNodesGroupsReportCSV generates a CSV formatted report of nodes aggregated by the
+// provided fields, every row contains the values of the group and all its parent groups
+func MakeNodesGroupsReportCSV(groups []*reporting.NodeGroup, fields []string) *FormattedResult {
+	var (
+		strBuilder strings.Builder
+		csvWriter  = csv.NewWriter(&strBuilder)
+	)
+
+	if len(groups) == 0 {
+		return &FormattedResult{"", ""}
+	}
+
+	tableHeaders := append([]string{}, fields...)
+	tableHeaders = append(tableHeaders, "Nodes", "Percentage")
+	csvWriter.Write(tableHeaders)
+
+	for _, fg := range flattenNodeGroups(groups, len(fields)) {
+		row := append([]string{}, fg.values...)
+		row = append(row,
+			strconv.Itoa(fg.group.Count),
+			strconv.FormatFloat(fg.group.Percentage, 'f', 2, 64),
+		)
+		csvWriter.Write(row)
+	}
+
+	csvWriter.Flush()
+	return &FormattedResult{strBuilder.String(), ""}
+}
+
+// MakeCookbookDriftReportCSV generates a CSV formatted cookbook drift report,
+// a row per file that differs from the source or per cookbook when none does
+func MakeCookbookDriftReportCSV(records []*reporting.CookbookDriftRecord) *FormattedResult {
+	var (
+		strBuilder strings.Builder
+		errBuilder strings.Builder
+		csvWriter  = csv.NewWriter(&strBuilder)
+	)
+
+	if len(records) == 0 {
+		return &FormattedResult{"", ""}
+	}
+
+	csvWriter.Write([]string{"Cookbook Name", "Version", "Source", "Revision", "Status", "File", "Change"})
+
+	sortCookbookDriftRecords(records)
+
+	for _, record := range records {
+		if record.Error != nil {
+			errBuilder.WriteString(fmt.Sprintf(" - %s (%s): %v\n", record.Name, record.Version, record.Error))
+			continue
+		}
+
+		row := []string{record.Name, record.Version, record.SourcePath, record.Revision, record.Status}
+		if len(record.Files) == 0 {
+			csvWriter.Write(append(row, "", ""))
+		}
+		for _, file := range record.Files {
+			csvWriter.Write(append(row, file.Path, file.Change))
+		}
 	}
 
 	csvWriter.Flush()
 	return &FormattedResult{strBuilder.String(), errBuilder.String()}
 }
+
+// MakeCookbookSprawlReportCSV generates a CSV formatted cookbook sprawl report,
+// a row per version in use of every cookbook
+func MakeCookbookSprawlReportCSV(sprawl []*reporting.CookbookSprawl) *FormattedResult {
+	var (
+		strBuilder strings.Builder
+		csvWriter  = csv.NewWriter(&strBuilder)
+	)
+
+	if len(sprawl) == 0 {
+		return &FormattedResult{"", ""}
+	}
+
+	csvWriter.Write([]string{
+		"Cookbook Name", "Versions In Use", "Oldest", "Newest",
+		"Version", "Node Count", "Environments", "Policy Groups", "Nodes",
+	})
+
+	for _, cookbook := range sprawl {
+		for _, version := range cookbook.Versions {
+			csvWriter.Write([]string{
+				cookbook.Name,
+				strconv.Itoa(cookbook.NumVersions()),
+				cookbook.Oldest(),
+				cookbook.Newest(),
+				version.Version,
+				strconv.Itoa(len(version.Nodes)),
+				strings.Join(version.EnvironmentPins, "; "),
+				strings.Join(version.PolicyGroups, "; "),
+				strings.Join(version.Nodes, " "),
+			})
+		}
+	}
+
+	csvWriter.Flush()
+	return &FormattedResult{strBuilder.String(), ""}
+}
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -63,6 +64,21 @@ func TestMakeCookbooksReportCSV_WithUnverifiedRecords(t *testing.T) {
 	assert.Equal(t, "", lines[2])
 }
 
+func TestMakeCookbooksReportCSV_WithDependencies(t *testing.T) {
+	cbStatus := reporting.CookbooksReport{
+		WithDependencies: true,
+		Records: []*reporting.CookbookRecord{
+			&reporting.CookbookRecord{Name: "base", Version: "1.0", Nodes: []string{"node-1"},
+				Dependents: []string{"web@1.0"}, BlastRadius: []string{"node-1", "node-2"}},
+		},
+	}
+
+	lines := strings.Split(subject.MakeCookbooksReportCSV(&cbStatus).Report, "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.Equal(t, "Cookbook Name,Version,Policy Group,Policy,Policy Revision,Nodes,Dependents,Blast Radius", lines[0])
+	assert.Equal(t, "base,1.0,,,,node-1,web@1.0,node-1 node-2", lines[1])
+}
+
 func TestMakeCookbooksReportCSV_WithUnverifiedPolicyRecords(t *testing.T) {
 	cbStatus := reporting.CookbooksReport{
 		RunCookstyle: false,
@@ -244,15 +260,34 @@ func TestMakeNodesReportCSV_WithRecords(t *testing.T) {
 	}
 	lines := strings.Split(subject.MakeNodesReportCSV(nodesReport, "").Report, "\n")
 	if assert.Equal(t, 6, len(lines)) {
-		assert.Equal(t, "Node Name,Chef Version,Operating System,Policy Group,Policy,Cookbooks (alphanumeric order)", lines[0])
-		assert.Contains(t, lines, "node1,12.22,windows v10.1,preprod,grafana (rev xyz1234567890),mycookbook")
-		assert.Contains(t, lines, "node2,13.11,,preprod,grafana (rev xyz1234567890),mycookbook test")
-		assert.Contains(t, lines, "node3,15.00,ubuntu v16.04,no group,no policy,None")
-		assert.Contains(t, lines, "node4,16.00,ubuntu v18.04,no group,no policy,mycookbook(1.0) test(9.9)")
+		assert.Equal(t, "Node Name,Chef Version,Operating System,Policy Group,Policy,Cookbooks (alphanumeric order),Run List,Last Check-in,Days Since Last Run,Stale,Client EOL,Platform Supported,Recommended Client", lines[0])
+		assert.Contains(t, lines, "node1,12.22,windows v10.1,preprod,grafana (rev xyz1234567890),mycookbook,,never converged,,N,unknown,unknown,")
+		assert.Contains(t, lines, "node2,13.11,,preprod,grafana (rev xyz1234567890),mycookbook test,,never converged,,N,unknown,unknown,")
+		assert.Contains(t, lines, "node3,15.00,ubuntu v16.04,no group,no policy,None,,never converged,,N,unknown,unknown,")
+		assert.Contains(t, lines, "node4,16.00,ubuntu v18.04,no group,no policy,mycookbook(1.0) test(9.9),,never converged,,N,unknown,unknown,")
 		assert.Equal(t, "", lines[5])
 	}
 }
 
+func TestMakeNodesReportCSV_ExpandError(t *testing.T) {
+	nodesReport := []*reporting.NodeReportItem{
+		&reporting.NodeReportItem{Name: "node1", ChefVersion: "", OS: "ubuntu", OSVersion: "18.04",
+			RunList: []string{"recipe[web]"}, CookbooksExpanded: true,
+			CookbookVersions: []reporting.CookbookVersion{
+				reporting.CookbookVersion{Name: "web", Version: "0.2.0"}},
+		},
+		&reporting.NodeReportItem{Name: "node2", ChefVersion: "", OS: "ubuntu", OSVersion: "18.04",
+			RunList: []string{"role[missing]"}, ExpandError: errors.New("404 role missing not found")},
+	}
+	actual := subject.MakeNodesReportCSV(nodesReport, "")
+	lines := strings.Split(actual.Report, "\n")
+	if assert.Equal(t, 4, len(lines)) {
+		assert.Contains(t, lines, "node1,,ubuntu v18.04,no group,no policy,web(0.2.0),recipe[web],never converged,,N,unknown,unknown,")
+		assert.Contains(t, lines, "node2,,ubuntu v18.04,no group,no policy,None,role[missing],never converged,,N,unknown,unknown,")
+	}
+	assert.Equal(t, " - node2: 404 role missing not found\n", actual.Errors)
+}
+
 func TestMakeNodesReportCSV_WithRecordsSorted(t *testing.T) {
 	nodesReport := []*reporting.NodeReportItem{
 		&reporting.NodeReportItem{Name: "zzz", ChefVersion: "13.11", OS: "", OSVersion: "", PolicyGroup: "prod", Policy: "grafana", PolicyRev: "xyz1234567890",
@@ -282,11 +317,11 @@ func TestMakeNodesReportCSV_WithRecordsSorted(t *testing.T) {
 
 	lines := strings.Split(subject.MakeNodesReportCSV(nodesReport, "").Report, "\n")
 	if assert.Equal(t, 6, len(lines)) {
-		assert.Equal(t, "Node Name,Chef Version,Operating System,Policy Group,Policy,Cookbooks (alphanumeric order)", lines[0])
-		assert.Equal(t, "123,13.11,,no group,no policy,None", lines[1])
-		assert.Equal(t, "Aaa,13.11,,no group,no policy,yyy(1.0) yyy(10.0) YYY(9.9) YYY(99.9)", lines[2])
-		assert.Equal(t, "aaa,13.11,,no group,no policy,ccc(9.9) ddd(1.0)", lines[3])
-		assert.Equal(t, "zzz,13.11,,prod,grafana (rev xyz1234567890),ccc yyy", lines[4])
+		assert.Equal(t, "Node Name,Chef Version,Operating System,Policy Group,Policy,Cookbooks (alphanumeric order),Run List,Last Check-in,Days Since Last Run,Stale,Client EOL,Platform Supported,Recommended Client", lines[0])
+		assert.Equal(t, "123,13.11,,no group,no policy,None,,never converged,,N,unknown,unknown,", lines[1])
+		assert.Equal(t, "Aaa,13.11,,no group,no policy,yyy(1.0) yyy(10.0) YYY(9.9) YYY(99.9),,never converged,,N,unknown,unknown,", lines[2])
+		assert.Equal(t, "aaa,13.11,,no group,no policy,ccc(9.9) ddd(1.0),,never converged,,N,unknown,unknown,", lines[3])
+		assert.Equal(t, "zzz,13.11,,prod,grafana (rev xyz1234567890),ccc yyy,,never converged,,N,unknown,unknown,", lines[4])
 		assert.Equal(t, "", lines[5])
 	}
 }
@@ -309,10 +344,10 @@ func TestMakeNodesReportCSV_WithRecordsAndFilter(t *testing.T) {
 
 	lines := strings.Split(subject.MakeNodesReportCSV(nodesReport, "name:node*").Report, "\n")
 	if assert.Equal(t, 5, len(lines)) {
-		assert.Equal(t, "Node Name (node filter: name:node*),Chef Version,Operating System,Policy Group,Policy,Cookbooks (alphanumeric order)", lines[0])
-		assert.Contains(t, lines, "node1,12.22,windows v10.1,no group,no policy,mycookbook(1.0)")
-		assert.Contains(t, lines, "node2,13.11,,prod,grafana (rev xyz1234567890),mycookbook test")
-		assert.Contains(t, lines, "node3,15.00,ubuntu v16.04,no group,no policy,None")
+		assert.Equal(t, "Node Name (node filter: name:node*),Chef Version,Operating System,Policy Group,Policy,Cookbooks (alphanumeric order),Run List,Last Check-in,Days Since Last Run,Stale,Client EOL,Platform Supported,Recommended Client", lines[0])
+		assert.Contains(t, lines, "node1,12.22,windows v10.1,no group,no policy,mycookbook(1.0),,never converged,,N,unknown,unknown,")
+		assert.Contains(t, lines, "node2,13.11,,prod,grafana (rev xyz1234567890),mycookbook test,,never converged,,N,unknown,unknown,")
+		assert.Contains(t, lines, "node3,15.00,ubuntu v16.04,no group,no policy,None,,never converged,,N,unknown,unknown,")
 		assert.Equal(t, "", lines[4])
 	}
 }
@@ -346,12 +381,97 @@ func TestMakeNodesReportCSV_WithMultipleNodesAndCookbooks(t *testing.T) {
 
 	lines := strings.Split(subject.MakeNodesReportCSV(nodesReport, "").Report, "\n")
 	if assert.Equal(t, 7, len(lines)) {
-		assert.Equal(t, "Node Name,Chef Version,Operating System,Policy Group,Policy,Cookbooks (alphanumeric order)", lines[0])
-		assert.Contains(t, lines, "node1,12.22,windows v10.1,no group,no policy,mycookbook1(1.0) mycookbook1(2.0)")
-		assert.Contains(t, lines, "node1,13.10,windows v10.1,no group,no policy,mycookbook1(1.0) mycookbook1(2.0)")
-		assert.Contains(t, lines, "node1,15.2,windows v10.1,no group,no policy,None")
-		assert.Contains(t, lines, "node2,13.11,macos v15,prod,grafana (rev xyz1234567890),mycookbook test")
-		assert.Contains(t, lines, "node3,15.00,ubuntu v16.04,no group,no policy,None")
+		assert.Equal(t, "Node Name,Chef Version,Operating System,Policy Group,Policy,Cookbooks (alphanumeric order),Run List,Last Check-in,Days Since Last Run,Stale,Client EOL,Platform Supported,Recommended Client", lines[0])
+		assert.Contains(t, lines, "node1,12.22,windows v10.1,no group,no policy,mycookbook1(1.0) mycookbook1(2.0),,never converged,,N,unknown,unknown,")
+		assert.Contains(t, lines, "node1,13.10,windows v10.1,no group,no policy,mycookbook1(1.0) mycookbook1(2.0),,never converged,,N,unknown,unknown,")
+		assert.Contains(t, lines, "node1,15.2,windows v10.1,no group,no policy,None,,never converged,,N,unknown,unknown,")
+		assert.Contains(t, lines, "node2,13.11,macos v15,prod,grafana (rev xyz1234567890),mycookbook test,,never converged,,N,unknown,unknown,")
+		assert.Contains(t, lines, "node3,15.00,ubuntu v16.04,no group,no policy,None,,never converged,,N,unknown,unknown,")
 		assert.Equal(t, "", lines[6])
 	}
 }
+
+func TestMakeNodesReportCSV_WithLastCheckIn(t *testing.T) {
+	lastRun := time.Date(2020, 4, 1, 10, 30, 0, 0, time.UTC)
+	nodesReport := []*reporting.NodeReportItem{
+		&reporting.NodeReportItem{Name: "node1", ChefVersion: "15.8", OS: "ubuntu", OSVersion: "18.04",
+			RunList: []string{"role[base]", "recipe[web]"}, LastCheckIn: lastRun, DaysSinceLastRun: 2},
+		&reporting.NodeReportItem{Name: "node2", ChefVersion: "12.22", OS: "ubuntu", OSVersion: "14.04",
+			RunList: []string{"recipe[web]"}, LastCheckIn: lastRun, DaysSinceLastRun: 45, Stale: true},
+	}
+
+	lines := strings.Split(subject.MakeNodesReportCSV(nodesReport, "").Report, "\n")
+	if assert.Equal(t, 4, len(lines)) {
+		assert.Equal(t, "node1,15.8,ubuntu v18.04,no group,no policy,None,role[base] recipe[web],2020-04-01T10:30:00Z,2,N,unknown,unknown,", lines[1])
+		assert.Equal(t, "node2,12.22,ubuntu v14.04,no group,no policy,None,recipe[web],2020-04-01T10:30:00Z,45,Y,unknown,unknown,", lines[2])
+	}
+}
+
+func TestMakeNodesReportCSV_WithAttributes(t *testing.T) {
+	nodesReport := []*reporting.NodeReportItem{
+		&reporting.NodeReportItem{Name: "node1", ChefVersion: "15.8", OS: "ubuntu", OSVersion: "18.04",
+			Attributes: []reporting.NodeAttributeValue{
+				reporting.NodeAttributeValue{Name: "env", Value: "production"},
+				reporting.NodeAttributeValue{Name: "tags", Value: "web, frontend"},
+			},
+		},
+	}
+
+	lines := strings.Split(subject.MakeNodesReportCSV(nodesReport, "").Report, "\n")
+	if assert.Equal(t, 3, len(lines)) {
+		assert.Equal(t, "Node Name,Chef Version,Operating System,Policy Group,Policy,Cookbooks (alphanumeric order),Run List,Last Check-in,Days Since Last Run,Stale,Client EOL,Platform Supported,Recommended Client,env,tags", lines[0])
+		assert.Equal(t, `node1,15.8,ubuntu v18.04,no group,no policy,None,,never converged,,N,unknown,unknown,,production,"web, frontend"`, lines[1])
+	}
+}
+
+func TestMakeNodesGroupsReportCSV(t *testing.T) {
+	assert.Equal(t,
+		&subject.FormattedResult{Report: "", Errors: ""},
+		subject.MakeNodesGroupsReportCSV(nil, []string{"platform"}))
+
+	lines := strings.Split(subject.MakeNodesGroupsReportCSV(mockedNodeGroups(), []string{"platform", "chef_version"}).Report, "\n")
+	assert.Equal(t, []string{
+		"platform,chef_version,Nodes,Percentage",
+		"ubuntu,,3,75.00",
+		"ubuntu,15.8,2,66.67",
+		"ubuntu,12.22,1,33.33",
+		",,1,25.00",
+		",12.22,1,100.00",
+		"",
+	}, lines)
+}
+
+func TestMakeCookbookDriftReportCSV(t *testing.T) {
+	records := []*reporting.CookbookDriftRecord{
+		{Name: "foo", Version: "1.0.0", SourcePath: "/src/foo", Revision: "0123456789ab", Status: reporting.DriftModified,
+			Files: []reporting.CookbookDriftFile{
+				{Path: "recipes/default.rb", Change: reporting.DriftFileModified},
+				{Path: "recipes/hotfix.rb", Change: reporting.DriftFileServerOnly},
+			}},
+		{Name: "bar", Version: "0.1.0", SourcePath: "/src/bar", Status: reporting.DriftUncommitted},
+	}
+	expected := `Cookbook Name,Version,Source,Revision,Status,File,Change
+bar,0.1.0,/src/bar,,uncommitted,,
+foo,1.0.0,/src/foo,0123456789ab,modified,recipes/default.rb,modified
+foo,1.0.0,/src/foo,0123456789ab,modified,recipes/hotfix.rb,server only
+`
+	result := subject.MakeCookbookDriftReportCSV(records)
+	assert.Equal(t, expected, result.Report)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, &subject.FormattedResult{Report: "", Errors: ""}, subject.MakeCookbookDriftReportCSV(nil))
+}
+
+func TestMakeCookbookSprawlReportCSV(t *testing.T) {
+	sprawl := []*reporting.CookbookSprawl{
+		{Name: "base", Versions: []*reporting.CookbookSprawlVersion{
+			{Version: "1.2.0", Nodes: []string{"node1"}, EnvironmentPins: []string{"prod (~> 1.2)", "staging (= 1.2.0)"}},
+			{Version: "2.0.0", Nodes: []string{"node2", "node3"}, PolicyGroups: []string{"dev (web)"}},
+		}},
+	}
+	expected := `Cookbook Name,Versions In Use,Oldest,Newest,Version,Node Count,Environments,Policy Groups,Nodes
+base,2,1.2.0,2.0.0,1.2.0,1,prod (~> 1.2); staging (= 1.2.0),,node1
+base,2,1.2.0,2.0.0,2.0.0,2,,dev (web),node2 node3
+`
+	assert.Equal(t, &subject.FormattedResult{Report: expected, Errors: ""}, subject.MakeCookbookSprawlReportCSV(sprawl))
+	assert.Equal(t, &subject.FormattedResult{Report: "", Errors: ""}, subject.MakeCookbookSprawlReportCSV(nil))
+}
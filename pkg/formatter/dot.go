@@ -0,0 +1,55 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package formatter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chef/chef-analyze/pkg/reporting"
+)
+
+// MakeCookbookGraphDOT generates a Graphviz DOT formatted dependency graph of cookbooks,
+// every cookbook points to the cookbooks it depends on and is labeled with its blast radius
+func MakeCookbookGraphDOT(graph *reporting.CookbookGraph) *FormattedResult {
+	var strBuilder strings.Builder
+
+	strBuilder.WriteString("digraph cookbooks {\n")
+	strBuilder.WriteString("  rankdir=LR;\n")
+	strBuilder.WriteString("  node [shape=box];\n")
+	if graph != nil {
+		for _, cookbook := range graph.Cookbooks {
+			strBuilder.WriteString(fmt.Sprintf("  %s [label=%s];\n",
+				dotQuote(cookbook.ID),
+				dotQuote(fmt.Sprintf("%s\\nblast radius: %d node(s)", cookbook.ID, len(cookbook.BlastRadius))),
+			))
+		}
+		for _, cookbook := range graph.Cookbooks {
+			for _, dependency := range cookbook.Depends {
+				strBuilder.WriteString(fmt.Sprintf("  %s -> %s;\n", dotQuote(cookbook.ID), dotQuote(dependency)))
+			}
+		}
+	}
+	strBuilder.WriteString("}\n")
+
+	return &FormattedResult{strBuilder.String(), ""}
+}
+
+// quotes a DOT identifier, the escape sequences of labels (i.e. \n) are kept
+func dotQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
@@ -0,0 +1,51 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package formatter_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	subject "github.com/chef/chef-analyze/pkg/formatter"
+	"github.com/chef/chef-analyze/pkg/reporting"
+)
+
+func TestMakeCookbookGraphDOT_Nil(t *testing.T) {
+	assert.Equal(t,
+		&subject.FormattedResult{Report: "digraph cookbooks {\n  rankdir=LR;\n  node [shape=box];\n}\n", Errors: ""},
+		subject.MakeCookbookGraphDOT(nil))
+}
+
+func TestMakeCookbookGraphDOT(t *testing.T) {
+	graph := &reporting.CookbookGraph{
+		Cookbooks: []*reporting.CookbookGraphNode{
+			&reporting.CookbookGraphNode{ID: "base@1.0.0", BlastRadius: []string{"node-1", "node-2"}},
+			&reporting.CookbookGraphNode{ID: "web@1.0.0", Depends: []string{"base@1.0.0"}, BlastRadius: []string{"node-2"}},
+		},
+	}
+
+	expected := `digraph cookbooks {
+  rankdir=LR;
+  node [shape=box];
+  "base@1.0.0" [label="base@1.0.0\nblast radius: 2 node(s)"];
+  "web@1.0.0" [label="web@1.0.0\nblast radius: 1 node(s)"];
+  "web@1.0.0" -> "base@1.0.0";
+}
+`
+	assert.Equal(t, &subject.FormattedResult{Report: expected, Errors: ""}, subject.MakeCookbookGraphDOT(graph))
+}
@@ -18,8 +18,10 @@
 package formatter
 
 import (
+	"fmt"
 	"regexp"
 	"sort"
+	"strings"
 
 	"github.com/chef/chef-analyze/pkg/reporting"
 )
@@ -59,6 +61,36 @@ func sortCookbookRecords(records []*reporting.CookbookRecord) {
 	}
 }
 
+// MakeCookbookRecordsErrors returns the errors of the cookbook records, one per line
+func MakeCookbookRecordsErrors(records []*reporting.CookbookRecord) string {
+	var errorBuilder strings.Builder
+	sortCookbookRecords(records)
+	for _, record := range records {
+		writeCookbookRecordErrors(&errorBuilder, record)
+	}
+	return errorBuilder.String()
+}
+
+func writeCookbookRecordErrors(errorBuilder *strings.Builder, record *reporting.CookbookRecord) {
+	for _, e := range record.Errors() {
+		if record.PolicyGroup != "" {
+			errorBuilder.WriteString(fmt.Sprintf(" - %s (PolicyGroup %s, Policy %s, PolicyRevision %s): %v\n", record.Name, record.PolicyGroup, record.Policy, record.PolicyVer, e))
+		} else {
+			errorBuilder.WriteString(fmt.Sprintf(" - %s (%s): %v\n", record.Name, record.Version, e))
+		}
+	}
+}
+
+// sorts the records by cookbook name and version
+func sortCookbookDriftRecords(records []*reporting.CookbookDriftRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return reporting.CompareVersions(records[i].Version, records[j].Version) < 0
+	})
+}
+
 func sortNodeRecords(records []*reporting.NodeReportItem) {
 	sort.Sort(reporting.NodeRecordsByName(records))
 
@@ -68,6 +100,40 @@ func sortNodeRecords(records []*reporting.NodeReportItem) {
 	}
 }
 
+// a node group along with the values of all its parent groups
+type flatNodeGroup struct {
+	group  *reporting.NodeGroup
+	depth  int
+	values []string
+}
+
+// flattens nested node groups depth-first, every parent group is followed by its children
+func flattenNodeGroups(groups []*reporting.NodeGroup, numFields int) []flatNodeGroup {
+	flat := make([]flatNodeGroup, 0)
+	var walk func([]*reporting.NodeGroup, []string)
+	walk = func(groups []*reporting.NodeGroup, parents []string) {
+		for _, group := range groups {
+			values := make([]string, numFields)
+			copy(values, parents)
+			values[len(parents)] = group.Value
+			flat = append(flat, flatNodeGroup{group: group, depth: len(parents), values: values})
+			walk(group.Groups, values[0:len(parents)+1])
+		}
+	}
+	walk(groups, []string{})
+	return flat
+}
+
+// values of these fields are hashed when the report is anonymized, as well
+// as the values of any custom attribute
+func isHashedNodeGroupField(field string) bool {
+	switch field {
+	case "chef_version", "platform", "platform_version":
+		return false
+	}
+	return true
+}
+
 func stringReplace(regex string, input string, replace string) string {
 	re := regexp.MustCompile(regex)
 	return re.ReplaceAllString(input, replace)
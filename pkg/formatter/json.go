@@ -0,0 +1,67 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package formatter
+
+import (
+	"encoding/json"
+
+	"github.com/chef/chef-analyze/pkg/reporting"
+)
+
+type nodesGroupsReportJSON struct {
+	NodeFilter string                 `json:"node_filter,omitempty"`
+	GroupBy    []string               `json:"group_by"`
+	TotalNodes int                    `json:"total_nodes"`
+	Groups     []*reporting.NodeGroup `json:"groups"`
+}
+
+// MakeNodesGroupsReportJSON generates a JSON formatted report of nodes aggregated by the provided fields
+func MakeNodesGroupsReportJSON(groups []*reporting.NodeGroup, fields []string, nodeFilter string) *FormattedResult {
+	report := nodesGroupsReportJSON{
+		NodeFilter: nodeFilter,
+		GroupBy:    fields,
+		Groups:     groups,
+	}
+	if report.Groups == nil {
+		report.Groups = []*reporting.NodeGroup{}
+	}
+	for _, group := range groups {
+		report.TotalNodes += group.Count
+	}
+
+	bytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return &FormattedResult{"", err.Error()}
+	}
+	return &FormattedResult{string(bytes) + "\n", ""}
+}
+
+// MakeCookbookGraphJSON generates a JSON formatted dependency graph of cookbooks
+func MakeCookbookGraphJSON(graph *reporting.CookbookGraph) *FormattedResult {
+	if graph == nil {
+		graph = &reporting.CookbookGraph{}
+	}
+	if graph.Cookbooks == nil {
+		graph.Cookbooks = []*reporting.CookbookGraphNode{}
+	}
+
+	bytes, err := json.MarshalIndent(graph, "", "  ")
+	if err != nil {
+		return &FormattedResult{"", err.Error()}
+	}
+	return &FormattedResult{string(bytes) + "\n", ""}
+}
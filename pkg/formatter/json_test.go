@@ -0,0 +1,140 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package formatter_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	subject "github.com/chef/chef-analyze/pkg/formatter"
+	"github.com/chef/chef-analyze/pkg/reporting"
+)
+
+func TestMakeNodesGroupsReportJSON_Empty(t *testing.T) {
+	assert.Equal(t,
+		&subject.FormattedResult{Report: `{
+  "group_by": [
+    "platform"
+  ],
+  "total_nodes": 0,
+  "groups": []
+}
+`, Errors: ""},
+		subject.MakeNodesGroupsReportJSON(nil, []string{"platform"}, ""))
+}
+
+func TestMakeNodesGroupsReportJSON(t *testing.T) {
+	groups := []*reporting.NodeGroup{
+		&reporting.NodeGroup{Field: "platform", Value: "ubuntu", Count: 3, Percentage: 75,
+			Groups: []*reporting.NodeGroup{
+				&reporting.NodeGroup{Field: "chef_version", Value: "15.8", Count: 2, Percentage: 66.67},
+				&reporting.NodeGroup{Field: "chef_version", Value: "12.22", Count: 1, Percentage: 33.33},
+			},
+		},
+		&reporting.NodeGroup{Field: "platform", Value: "windows", Count: 1, Percentage: 25,
+			Groups: []*reporting.NodeGroup{
+				&reporting.NodeGroup{Field: "chef_version", Value: "12.22", Count: 1, Percentage: 100},
+			},
+		},
+	}
+
+	expected := `{
+  "node_filter": "name:n*",
+  "group_by": [
+    "platform",
+    "chef_version"
+  ],
+  "total_nodes": 4,
+  "groups": [
+    {
+      "field": "platform",
+      "value": "ubuntu",
+      "count": 3,
+      "percentage": 75,
+      "groups": [
+        {
+          "field": "chef_version",
+          "value": "15.8",
+          "count": 2,
+          "percentage": 66.67
+        },
+        {
+          "field": "chef_version",
+          "value": "12.22",
+          "count": 1,
+          "percentage": 33.33
+        }
+      ]
+    },
+    {
+      "field": "platform",
+      "value": "windows",
+      "count": 1,
+      "percentage": 25,
+      "groups": [
+        {
+          "field": "chef_version",
+          "value": "12.22",
+          "count": 1,
+          "percentage": 100
+        }
+      ]
+    }
+  ]
+}
+`
+	actual := subject.MakeNodesGroupsReportJSON(groups, []string{"platform", "chef_version"}, "name:n*")
+	assert.Equal(t, expected, actual.Report)
+	assert.Empty(t, actual.Errors)
+}
+
+func TestMakeCookbookGraphJSON_Nil(t *testing.T) {
+	assert.Equal(t,
+		&subject.FormattedResult{Report: "{\n  \"cookbooks\": []\n}\n", Errors: ""},
+		subject.MakeCookbookGraphJSON(nil))
+}
+
+func TestMakeCookbookGraphJSON(t *testing.T) {
+	graph := &reporting.CookbookGraph{
+		Cookbooks: []*reporting.CookbookGraphNode{
+			&reporting.CookbookGraphNode{ID: "base@1.0.0", Name: "base", Version: "1.0.0",
+				Depends: []string{}, Dependents: []string{"web@1.0.0"},
+				Nodes: []string{}, BlastRadius: []string{"node-1"}},
+		},
+	}
+
+	expected := `{
+  "cookbooks": [
+    {
+      "id": "base@1.0.0",
+      "name": "base",
+      "version": "1.0.0",
+      "depends": [],
+      "dependents": [
+        "web@1.0.0"
+      ],
+      "nodes": [],
+      "blast_radius": [
+        "node-1"
+      ]
+    }
+  ]
+}
+`
+	assert.Equal(t, &subject.FormattedResult{Report: expected, Errors: ""}, subject.MakeCookbookGraphJSON(graph))
+}
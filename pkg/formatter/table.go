@@ -41,7 +41,15 @@ const (
 	filteredEmptyNodeResultFmt = "No nodes found with filter applied: %s"
 	emptyCookbookResultMsg     = "No available cookbooks to generate a report"
 	emptyNodeResultMsg         = "No nodes found to analyze."
+	emptyCookbookDriftMsg      = "No cookbooks with a checkout in the cookbook paths to compare."
+	driftedCookbooksFmt        = "Drifted: %d cookbook version(s) were not uploaded from a committed source\n"
+	emptyCookbookSprawlMsg     = "No cookbooks in use to aggregate."
+	sprawledCookbooksFmt       = "Sprawl: %d cookbook(s) have more than one version in use\n"
 	appliedNodesFilterFmt      = "\n\nNode Filter applied: %s\n"
+	neverConvergedNodesFmt     = "Never converged: %d node(s) have not completed a chef-client run\n"
+	staleNodesFmt              = "Stale: %d node(s) have not checked in recently\n"
+	clientEOLNodesFmt          = "Client EOL: %d node(s) run a Chef Infra Client release that reached its end-of-life\n"
+	unsupportedNodesFmt        = "Unsupported platform: %d node(s) run on a platform that is no longer supported\n"
 )
 
 // CookbooksReportSummary prints smaller, summarized report
@@ -78,39 +86,7 @@ func CookbooksReportSummary(state *reporting.CookbooksReport) FormattedResult {
 
 	// sets max for each col to 30 chars, this is not strictly enforced.
 	// unwrappable content will expand beyond this limit.
-	table := tablewriter.NewTable(buffer,
-		tablewriter.WithHeaderAutoFormat(tw.Off),  // don't make our headers capitalized
-		tablewriter.WithRowAlignment(tw.AlignLeft),
-		tablewriter.WithHeaderAutoWrap(tw.WrapNormal),
-		tablewriter.WithRowAutoWrap(tw.WrapNormal),
-		tablewriter.WithRendition(tw.Rendition{
-			Borders: tw.Border{
-				Left:   tw.On,
-				Right:  tw.On,
-				Top:    tw.Off,
-				Bottom: tw.Off,
-			},
-			Settings: tw.Settings{
-				Separators: tw.Separators{
-					BetweenRows:    tw.Off,
-					BetweenColumns: tw.On,
-				},
-				Lines: tw.Lines{
-					ShowTop:        tw.Off,
-					ShowBottom:     tw.Off,
-					ShowHeaderLine: tw.On,
-					ShowFooterLine: tw.Off,
-				},
-			},
-			Symbols: tw.NewSymbolCustom("legacy").
-				WithColumn(" ").
-				WithRow("-").
-				WithCenter(" ").
-				WithHeaderLeft(" ").
-				WithHeaderMid(" ").
-				WithHeaderRight(" "),
-		}),
-	)
+	table := newReportTable(buffer)
 	table.Header(CookbooksReportHeader)
 
 	sortCookbookRecords(state.Records)
@@ -152,25 +128,79 @@ func CookbooksReportSummary(state *reporting.CookbooksReport) FormattedResult {
 
 	table.Render()
 
-	var (
-		errMsg            strings.Builder
-		bufStr            = buffer.String()
-		lines             = strings.SplitN(bufStr, "\n", 2)
-		width             = twwidth.Width(lines[0])
-		termWidth, _, err = term.GetSize(int(os.Stdout.Fd()))
-	)
-	if err != nil {
-		termWidth = MinTermWidth
+	note := terminalWidthNote(buffer.String())
+	if len(state.NodeFilter) > 0 {
+		fmt.Fprintf(buffer, appliedNodesFilterFmt, state.NodeFilter)
 	}
+	return FormattedResult{buffer.String(), note}
+}
 
-	if termWidth < width {
-		errMsg.WriteString("\nNote:  To view the report with correct formatting, please expand")
-		errMsg.WriteString(fmt.Sprintf("\n       your terminal window to be at least %v characters wide\n", width))
+// CookbookDriftReportSummary prints the drift status of every cookbook version
+func CookbookDriftReportSummary(records []*reporting.CookbookDriftRecord) FormattedResult {
+	if len(records) == 0 {
+		return FormattedResult{emptyCookbookDriftMsg, ""}
 	}
-	if len(state.NodeFilter) > 0 {
-		fmt.Fprintf(buffer, appliedNodesFilterFmt, state.NodeFilter)
+
+	buffer := bytes.NewBufferString("\n-- REPORT SUMMARY --\n\n")
+	table := newReportTable(buffer)
+	table.Header([]string{"Cookbook", "Version", "Revision", "Status", "Files Changed"})
+
+	sortCookbookDriftRecords(records)
+
+	drifted := 0
+	for _, record := range records {
+		status := record.Status
+		if record.Error != nil {
+			status = "error"
+		}
+		if record.Drifted() {
+			drifted++
+		}
+		table.Append([]string{
+			record.Name,
+			record.Version,
+			stringOrPlaceholder(record.Revision, "working tree"),
+			status,
+			strconv.Itoa(len(record.Files)),
+		})
 	}
-	return FormattedResult{buffer.String(), errMsg.String()}
+
+	table.Render()
+	fmt.Fprintf(buffer, "\n"+driftedCookbooksFmt, drifted)
+
+	note := terminalWidthNote(buffer.String())
+	return FormattedResult{buffer.String(), note}
+}
+
+// CookbookSprawlReportSummary prints the versions in use of every cookbook
+func CookbookSprawlReportSummary(sprawl []*reporting.CookbookSprawl) FormattedResult {
+	if len(sprawl) == 0 {
+		return FormattedResult{emptyCookbookSprawlMsg, ""}
+	}
+
+	buffer := bytes.NewBufferString("\n-- REPORT SUMMARY --\n\n")
+	table := newReportTable(buffer)
+	table.Header([]string{"Cookbook", "Versions In Use", "Oldest", "Newest", "Nodes Affected"})
+
+	sprawled := 0
+	for _, cookbook := range sprawl {
+		if cookbook.NumVersions() > 1 {
+			sprawled++
+		}
+		table.Append([]string{
+			cookbook.Name,
+			strconv.Itoa(cookbook.NumVersions()),
+			cookbook.Oldest(),
+			cookbook.Newest(),
+			strconv.Itoa(cookbook.NumNodes()),
+		})
+	}
+
+	table.Render()
+	fmt.Fprintf(buffer, "\n"+sprawledCookbooksFmt, sprawled)
+
+	note := terminalWidthNote(buffer.String())
+	return FormattedResult{buffer.String(), note}
 }
 
 // NodesReportSummary prints smaller, summarized report
@@ -190,7 +220,9 @@ func NodesReportSummary(records []*reporting.NodeReportItem, appliedNodesFilter
 		buffer = bytes.NewBufferString("\n-- REPORT SUMMARY --\n\n")
 	}
 
-	NodeReportHeader := []string{"Node Name", "Chef Version", "Operating System", "Number Cookbooks"}
+	NodeReportHeader := []string{"Node Name", "Chef Version", "Operating System", "Number Cookbooks", "Last Run",
+		"Client EOL", "Platform Supported", "Recommended Client"}
+	NodeReportHeader = append(NodeReportHeader, reporting.NodeAttributeNames(records)...)
 
 	if len(appliedNodesFilter) > 0 {
 		NodeReportHeader[0] = fmt.Sprintf("Node Name (filter applied: %s)", appliedNodesFilter)
@@ -198,8 +230,162 @@ func NodesReportSummary(records []*reporting.NodeReportItem, appliedNodesFilter
 
 	// sets max for each col to 30 chars, this is not strictly enforced
 	// unwrappable content will expand beyond this limit
-	table := tablewriter.NewTable(buffer,
-		tablewriter.WithHeaderAutoFormat(tw.Off),  // don't make our headers capitalized
+	table := newReportTable(buffer)
+	table.Header(NodeReportHeader)
+
+	sortNodeRecords(records)
+
+	var neverConverged, stale, clientEOL, unsupported int
+	for _, record := range records {
+
+		lastRun := ""
+		switch {
+		case record.NeverConverged():
+			lastRun = "never"
+			neverConverged++
+		case record.DaysSinceLastRun == 0:
+			lastRun = "today"
+		default:
+			lastRun = fmt.Sprintf("%dd ago", record.DaysSinceLastRun)
+		}
+		if record.Stale {
+			lastRun = fmt.Sprintf("%s (stale)", lastRun)
+			stale++
+		}
+
+		recordName := ""
+		if record.Anonymize {
+			recordName = ShortFormat(record.Name)
+		} else {
+			recordName = record.Name
+		}
+		row := []string{
+			recordName,
+			stringOrEmptyPlaceholder(record.ChefVersion),
+			stringOrEmptyPlaceholder(record.OSVersionPretty()),
+			strconv.Itoa(len(record.CookbooksList())),
+			lastRun,
+			record.Support.ClientEOLPretty(),
+			record.Support.PlatformSupportedPretty(),
+			stringOrEmptyPlaceholder(record.Support.RecommendedClient),
+		}
+		if record.Support.ClientEOLReached {
+			clientEOL++
+		}
+		if record.Support.Platform == reporting.PlatformUnsupported {
+			unsupported++
+		}
+		for _, attr := range record.Attributes {
+			if record.Anonymize {
+				row = append(row, stringOrEmptyPlaceholder(ShortFormat(attr.Value)))
+			} else {
+				row = append(row, stringOrEmptyPlaceholder(attr.Value))
+			}
+		}
+		table.Append(row)
+	}
+
+	table.Render()
+
+	if neverConverged > 0 || stale > 0 || clientEOL > 0 || unsupported > 0 {
+		buffer.WriteString("\n")
+	}
+	if neverConverged > 0 {
+		fmt.Fprintf(buffer, neverConvergedNodesFmt, neverConverged)
+	}
+	if stale > 0 {
+		fmt.Fprintf(buffer, staleNodesFmt, stale)
+	}
+	if clientEOL > 0 {
+		fmt.Fprintf(buffer, clientEOLNodesFmt, clientEOL)
+	}
+	if unsupported > 0 {
+		fmt.Fprintf(buffer, unsupportedNodesFmt, unsupported)
+	}
+
+	note := terminalWidthNote(buffer.String())
+	if len(appliedNodesFilter) > 0 {
+		fmt.Fprintf(buffer, appliedNodesFilterFmt, appliedNodesFilter)
+	}
+
+	return FormattedResult{buffer.String(), note}
+}
+
+// NodesGroupsReportSummary prints the nodes aggregated by the provided fields,
+// nested groups are printed right under their parent group
+func NodesGroupsReportSummary(groups []*reporting.NodeGroup, fields []string, appliedNodesFilter string, anonymize bool) FormattedResult {
+	if len(groups) == 0 {
+		if len(appliedNodesFilter) > 0 {
+			return FormattedResult{fmt.Sprintf(filteredEmptyNodeResultFmt, appliedNodesFilter), ""}
+		}
+		return FormattedResult{emptyNodeResultMsg, ""}
+	}
+
+	var buffer *bytes.Buffer
+	if anonymize {
+		buffer = bytes.NewBufferString("\n-- REPORT SUMMARY (Anonymized)--\n\n")
+	} else {
+		buffer = bytes.NewBufferString("\n-- REPORT SUMMARY --\n\n")
+	}
+
+	header := append([]string{}, fields...)
+	header = append(header, "Nodes", "Percentage")
+
+	table := newReportTable(buffer)
+	table.Header(header)
+
+	for _, fg := range flattenNodeGroups(groups, len(fields)) {
+		row := make([]string, 0, len(header))
+		for i, value := range fg.values {
+			switch {
+			case i != fg.depth:
+				// only the value of the group itself, parent values are in the rows above
+				row = append(row, "")
+			case anonymize && isHashedNodeGroupField(fg.group.Field):
+				row = append(row, stringOrUnknownPlaceholder(ShortFormat(value)))
+			default:
+				row = append(row, stringOrUnknownPlaceholder(value))
+			}
+		}
+		row = append(row,
+			strconv.Itoa(fg.group.Count),
+			fmt.Sprintf("%.1f%%", fg.group.Percentage),
+		)
+		table.Append(row)
+	}
+
+	table.Render()
+
+	total := 0
+	for _, group := range groups {
+		total += group.Count
+	}
+	fmt.Fprintf(buffer, "\nTotal nodes: %d\n", total)
+
+	note := terminalWidthNote(buffer.String())
+	if len(appliedNodesFilter) > 0 {
+		fmt.Fprintf(buffer, appliedNodesFilterFmt, appliedNodesFilter)
+	}
+
+	return FormattedResult{buffer.String(), note}
+}
+
+func ShortFormat(name string) string {
+
+	// Shorten to 11 spaces (first 8 chars followed by 3 dots)
+	if len(name) > 10 {
+		return name[0:9] + "..."
+	}
+
+	return name
+}
+
+// newReportTable creates the borderless, left aligned table
+// the report summaries are rendered with
+func newReportTable(buffer *bytes.Buffer) *tablewriter.Table {
+	return tablewriter.NewTable(buffer,
+		tablewriter.WithHeaderAutoFormat(tw.Off), // don't make our headers capitalized
+		tablewriter.WithRowAlignment(tw.AlignLeft),
 		tablewriter.WithHeaderAutoWrap(tw.WrapNormal),
 		tablewriter.WithRowAutoWrap(tw.WrapNormal),
 		tablewriter.WithRendition(tw.Rendition{
@@ -230,62 +416,27 @@ func NodesReportSummary(records []*reporting.NodeReportItem, appliedNodesFilter
 				WithHeaderRight(" "),
 		}),
 	)
-	table.Header(NodeReportHeader)
-
-	sortNodeRecords(records)
-
-	for _, record := range records {
-
-		recordName := ""
-		if record.Anonymize {
-			recordName = ShortFormat(record.Name)
-		} else {
-			recordName = record.Name
-		}
-		table.Append(
-			[]string{
-				recordName,
-				stringOrEmptyPlaceholder(record.ChefVersion),
-				stringOrEmptyPlaceholder(record.OSVersionPretty()),
-				strconv.Itoa(len(record.CookbooksList())),
-			},
-		)
-	}
-
-	table.Render()
+}
 
-	// A bit of a hack to find the actual width of the string used to render a line
-	// of the table. We get the first line only  - this is the minimum width needed
-	// to avoid wrapping  the teriminal line and making the table look bad.
-	// multibyte characters accounted for by using DisplayWidth.
+// returns a note asking to expand the terminal when it is narrower than the table
+// of the report, measured on its header line, right below the title of the report.
+// multibyte characters are accounted for by using their display width.
+func terminalWidthNote(report string) string {
 	var (
-		errMsg            strings.Builder
-		bufStr            = buffer.String()
-		lines             = strings.SplitN(bufStr, "\n", 2)
-		width             = twwidth.Width(lines[0])
+		lines             = strings.SplitN(report, "\n", 5)
+		width             = 0
 		termWidth, _, err = term.GetSize(int(os.Stdout.Fd()))
 	)
+	if len(lines) > 3 {
+		width = twwidth.Width(lines[3])
+	}
 	if err != nil {
 		termWidth = MinTermWidth
 	}
 
-	if termWidth < width {
-		errMsg.WriteString("\nNote:  To view the report with correct formatting, please expand")
-		errMsg.WriteString(fmt.Sprintf("\n       your terminal window to be at least %v characters wide\n", width))
-	}
-	if len(appliedNodesFilter) > 0 {
-		fmt.Fprintf(buffer, appliedNodesFilterFmt, appliedNodesFilter)
-	}
-
-	return FormattedResult{buffer.String(), errMsg.String()}
-}
-
-func ShortFormat(name string) string {
-
-	// Shorten to 11 spaces (first 8 chars followed by 3 dots)
-	if len(name) > 10 {
-		return name[0:9] + "..."
+	if termWidth >= width {
+		return ""
 	}
-
-	return name
+	return fmt.Sprintf("\nNote:  To view the report with correct formatting, please expand"+
+		"\n       your terminal window to be at least %v characters wide\n", width)
 }
@@ -17,8 +17,10 @@
 package formatter_test
 
 import (
+	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	subject "github.com/chef/chef-analyze/pkg/formatter"
 	"github.com/chef/chef-analyze/pkg/reporting"
@@ -127,14 +129,15 @@ func TestNodesReportSummary_withRecordsSorted(t *testing.T) {
 	report := subject.NodesReportSummary(nri, "")
 
 	lines := strings.Split(report.Report, "\n")
-	if assert.Equal(t, 10, len(lines)) {
+	if assert.Equal(t, 12, len(lines)) {
 		assert.Equal(t, "-- REPORT SUMMARY --", lines[1])
-		assert.Equal(t, "  Node Name   Chef Version   Operating System   Number Cookbooks  ", lines[3])
-		assert.Equal(t, "  123         13.11          -                  0                 ", lines[5])
-		assert.Equal(t, "  Aaa         13.11          -                  4                 ", lines[6])
-		assert.Equal(t, "  aaa         13.11          -                  2                 ", lines[7])
-		assert.Equal(t, "  zzz         13.11          -                  2                 ", lines[8])
+		assert.Equal(t, "  Node Name   Chef Version   Operating System   Number Cookbooks   Last Run   Client EOL   Platform Supported   Recommended Client  ", lines[3])
+		assert.Equal(t, "  123         13.11          -                  0                  never      unknown      unknown              -                   ", lines[5])
+		assert.Equal(t, "  Aaa         13.11          -                  4                  never      unknown      unknown              -                   ", lines[6])
+		assert.Equal(t, "  aaa         13.11          -                  2                  never      unknown      unknown              -                   ", lines[7])
+		assert.Equal(t, "  zzz         13.11          -                  2                  never      unknown      unknown              -                   ", lines[8])
 		assert.Equal(t, "", lines[9])
+		assert.Equal(t, "Never converged: 4 node(s) have not completed a chef-client run", lines[10])
 	}
 }
 
@@ -167,13 +170,13 @@ func TestNodesReportSummary_AnonWithRecordsSorted(t *testing.T) {
 	report := subject.NodesReportSummary(nri, "")
 
 	lines := strings.Split(report.Report, "\n")
-	if assert.Equal(t, 10, len(lines)) {
+	if assert.Equal(t, 12, len(lines)) {
 		assert.Equal(t, "-- REPORT SUMMARY (Anonymized)--", lines[1])
-		assert.Equal(t, "   Node Name     Chef Version   Operating System   Number Cookbooks  ", lines[3])
-		assert.Equal(t, "  29447b868...   13.11          -                  2                 ", lines[5])
-		assert.Equal(t, "  3d2e5adf7...   13.11          -                  4                 ", lines[6])
-		assert.Equal(t, "  725d5688d...   13.11          -                  0                 ", lines[7])
-		assert.Equal(t, "  8c8b3dc21...   13.11          -                  2                 ", lines[8])
+		assert.Equal(t, "   Node Name     Chef Version   Operating System   Number Cookbooks   Last Run   Client EOL   Platform Supported   Recommended Client  ", lines[3])
+		assert.Equal(t, "  29447b868...   13.11          -                  2                  never      unknown      unknown              -                   ", lines[5])
+		assert.Equal(t, "  3d2e5adf7...   13.11          -                  4                  never      unknown      unknown              -                   ", lines[6])
+		assert.Equal(t, "  725d5688d...   13.11          -                  0                  never      unknown      unknown              -                   ", lines[7])
+		assert.Equal(t, "  8c8b3dc21...   13.11          -                  2                  never      unknown      unknown              -                   ", lines[8])
 		assert.Equal(t, "", lines[9])
 	}
 }
@@ -285,6 +288,80 @@ func TestNodesReportSummary_withRecords_NoCookbooks(t *testing.T) {
 	}
 }
 
+func TestNodesReportSummary_withStaleRecords(t *testing.T) {
+	lastRun := time.Now()
+	nri := []*reporting.NodeReportItem{
+		&reporting.NodeReportItem{Name: "fresh", ChefVersion: "15.8", LastCheckIn: lastRun},
+		&reporting.NodeReportItem{Name: "stale", ChefVersion: "15.8", LastCheckIn: lastRun, DaysSinceLastRun: 12, Stale: true},
+		&reporting.NodeReportItem{Name: "dead", ChefVersion: "15.8"},
+	}
+	report := subject.NodesReportSummary(nri, "")
+
+	lines := strings.Split(report.Report, "\n")
+	if assert.Equal(t, 12, len(lines)) {
+		assert.Equal(t, "  Node Name   Chef Version   Operating System   Number Cookbooks      Last Run       Client EOL   Platform Supported   Recommended Client  ", lines[3])
+		assert.Equal(t, "  dead        15.8           -                  0                  never             unknown      unknown              -                   ", lines[5])
+		assert.Equal(t, "  fresh       15.8           -                  0                  today             unknown      unknown              -                   ", lines[6])
+		assert.Equal(t, "  stale       15.8           -                  0                  12d ago (stale)   unknown      unknown              -                   ", lines[7])
+		assert.Equal(t, "", lines[8])
+		assert.Equal(t, "Never converged: 1 node(s) have not completed a chef-client run", lines[9])
+		assert.Equal(t, "Stale: 1 node(s) have not checked in recently", lines[10])
+		assert.Equal(t, "", lines[11])
+	}
+}
+
+func TestNodesReportSummary_withAttributes(t *testing.T) {
+	nri := []*reporting.NodeReportItem{
+		&reporting.NodeReportItem{Name: "node1", ChefVersion: "15.8", LastCheckIn: time.Now(),
+			Attributes: []reporting.NodeAttributeValue{
+				reporting.NodeAttributeValue{Name: "env", Value: "production"},
+				reporting.NodeAttributeValue{Name: "fqdn", Value: ""},
+			},
+		},
+	}
+	report := subject.NodesReportSummary(nri, "")
+
+	lines := strings.Split(report.Report, "\n")
+	if assert.Equal(t, 7, len(lines)) {
+		assert.Equal(t, "  Node Name   Chef Version   Operating System   Number Cookbooks   Last Run   Client EOL   Platform Supported   Recommended Client      env       fqdn  ", lines[3])
+		assert.Equal(t, "  node1       15.8           -                  0                  today      unknown      unknown              -                    production   -     ", lines[5])
+	}
+}
+
+func TestNodesReportSummary_withSupport(t *testing.T) {
+	nri := []*reporting.NodeReportItem{
+		&reporting.NodeReportItem{Name: "node1", ChefVersion: "12.22", OS: "centos", OSVersion: "6.10", LastCheckIn: time.Now(),
+			Support: reporting.NodeSupport{
+				ClientKnown:       true,
+				ClientEOL:         time.Date(2019, 4, 30, 0, 0, 0, 0, time.UTC),
+				ClientEOLReached:  true,
+				Platform:          reporting.PlatformUnsupported,
+				RecommendedClient: "17",
+			},
+		},
+		&reporting.NodeReportItem{Name: "node2", ChefVersion: "18.1", OS: "ubuntu", OSVersion: "20.04", LastCheckIn: time.Now(),
+			Support: reporting.NodeSupport{
+				ClientKnown:       true,
+				Platform:          reporting.PlatformSupported,
+				RecommendedClient: "18",
+			},
+		},
+	}
+	report := subject.NodesReportSummary(nri, "")
+
+	lines := strings.Split(report.Report, "\n")
+	if assert.Equal(t, 11, len(lines)) {
+		assert.Contains(t, lines[3], "Client EOL")
+		assert.Contains(t, lines[3], "Platform Supported")
+		assert.Contains(t, lines[3], "Recommended Client")
+		assert.Contains(t, lines[5], "2019-04-30 (reached)")
+		assert.Contains(t, lines[6], "not announced")
+		assert.Equal(t, "", lines[7])
+		assert.Equal(t, "Client EOL: 1 node(s) run a Chef Infra Client release that reached its end-of-life", lines[8])
+		assert.Equal(t, "Unsupported platform: 1 node(s) run on a platform that is no longer supported", lines[9])
+	}
+}
+
 func TestCookbooksReportSummary_Nil(t *testing.T) {
 	assert.Equal(t,
 		subject.FormattedResult{
@@ -561,3 +638,115 @@ func Test_shortFormat(t *testing.T) {
 		})
 	}
 }
+
+func mockedNodeGroups() []*reporting.NodeGroup {
+	nodes := []*reporting.NodeReportItem{
+		&reporting.NodeReportItem{Name: "n1", ChefVersion: "15.8", OS: "ubuntu", OSVersion: "18.04"},
+		&reporting.NodeReportItem{Name: "n2", ChefVersion: "15.8", OS: "ubuntu", OSVersion: "16.04"},
+		&reporting.NodeReportItem{Name: "n3", ChefVersion: "12.22", OS: "ubuntu", OSVersion: "16.04"},
+		&reporting.NodeReportItem{Name: "n4", ChefVersion: "12.22", OS: "", OSVersion: ""},
+	}
+	groups, err := reporting.GroupNodes(nodes, []string{"platform", "chef_version"})
+	if err != nil {
+		panic(err)
+	}
+	return groups
+}
+
+func TestNodesGroupsReportSummary_noGroups(t *testing.T) {
+	assert.Equal(t,
+		subject.FormattedResult{"No nodes found to analyze.", ""},
+		subject.NodesGroupsReportSummary([]*reporting.NodeGroup{}, []string{"platform"}, "", false))
+	assert.Equal(t,
+		subject.FormattedResult{"No nodes found with filter applied: name:blah", ""},
+		subject.NodesGroupsReportSummary(nil, []string{"platform"}, "name:blah", false))
+}
+
+func TestNodesGroupsReportSummary_Nested(t *testing.T) {
+	report := subject.NodesGroupsReportSummary(mockedNodeGroups(), []string{"platform", "chef_version"}, "", false)
+
+	lines := strings.Split(report.Report, "\n")
+	if assert.Equal(t, 13, len(lines)) {
+		assert.Equal(t, "-- REPORT SUMMARY --", lines[1])
+		assert.Equal(t, "  platform   chef_version   Nodes   Percentage  ", lines[3])
+		assert.Equal(t, "  ubuntu                    3       75.0%       ", lines[5])
+		assert.Equal(t, "             15.8           2       66.7%       ", lines[6])
+		assert.Equal(t, "             12.22          1       33.3%       ", lines[7])
+		assert.Equal(t, "  unknown                   1       25.0%       ", lines[8])
+		assert.Equal(t, "             12.22          1       100.0%      ", lines[9])
+		assert.Equal(t, "Total nodes: 4", lines[11])
+	}
+}
+
+func TestNodesGroupsReportSummary_Anon(t *testing.T) {
+	groups := []*reporting.NodeGroup{
+		&reporting.NodeGroup{Field: "policy_group", Value: "ca12f31b8cbf5f29e268ea64c20a37f3d50b539d891db0c3ebc7c0f66b1fb98a", Count: 1, Percentage: 100},
+	}
+	report := subject.NodesGroupsReportSummary(groups, []string{"policy_group"}, "name:n*", true)
+
+	lines := strings.Split(report.Report, "\n")
+	if assert.Equal(t, 12, len(lines)) {
+		assert.Equal(t, "-- REPORT SUMMARY (Anonymized)--", lines[1])
+		assert.Equal(t, "  ca12f31b8...   1       100.0%      ", lines[5])
+		assert.Equal(t, "Node Filter applied: name:n*", lines[10])
+	}
+}
+
+func TestCookbookDriftReportSummary(t *testing.T) {
+	assert.Equal(t,
+		subject.FormattedResult{"No cookbooks with a checkout in the cookbook paths to compare.", ""},
+		subject.CookbookDriftReportSummary(nil))
+
+	records := []*reporting.CookbookDriftRecord{
+		{Name: "foo", Version: "1.0.0", Revision: "v1.0.0", Status: reporting.DriftInSync},
+		{Name: "bar", Version: "0.1.0", Status: reporting.DriftUncommitted,
+			Files: []reporting.CookbookDriftFile{{Path: "metadata.rb", Change: reporting.DriftFileModified}}},
+	}
+	result := subject.CookbookDriftReportSummary(records)
+	assert.Contains(t, result.Report, "working tree")
+	assert.Contains(t, result.Report, "uncommitted")
+	assert.Contains(t, result.Report, "Drifted: 1 cookbook version(s) were not uploaded from a committed source")
+	// sorted by cookbook
+	assert.True(t, strings.Index(result.Report, "bar") < strings.Index(result.Report, "foo"))
+}
+
+func TestCookbookSprawlReportSummary(t *testing.T) {
+	assert.Equal(t,
+		subject.FormattedResult{"No cookbooks in use to aggregate.", ""},
+		subject.CookbookSprawlReportSummary(nil))
+
+	sprawl := []*reporting.CookbookSprawl{
+		{Name: "base", Versions: []*reporting.CookbookSprawlVersion{
+			{Version: "1.2.0", Nodes: []string{"node1"}},
+			{Version: "2.0.0", Nodes: []string{"node2", "node3"}},
+		}},
+		{Name: "web", Versions: []*reporting.CookbookSprawlVersion{
+			{Version: "1.0.0", Nodes: []string{"node1"}},
+		}},
+	}
+	result := subject.CookbookSprawlReportSummary(sprawl)
+	assert.Contains(t, result.Report, "Versions In Use")
+	assert.Contains(t, result.Report, "Sprawl: 1 cookbook(s) have more than one version in use")
+	assert.Regexp(t, `base\s+2\s+1\.2\.0\s+2\.0\.0\s+3`, result.Report)
+}
+
+func TestNodesReportSummary_terminalWidthNote(t *testing.T) {
+	nri := []*reporting.NodeReportItem{
+		&reporting.NodeReportItem{Name: "node1", ChefVersion: "15.8"},
+	}
+	// the width of the table is measured on its header line, the tests
+	// don't run in a terminal so the minimum terminal width is used
+	var (
+		summary = subject.NodesReportSummary(nri, "")
+		lines   = strings.Split(summary.Report, "\n")
+	)
+	if assert.Greater(t, len(lines[3]), subject.MinTermWidth) {
+		assert.Equal(t, fmt.Sprintf("\nNote:  To view the report with correct formatting, please expand"+
+			"\n       your terminal window to be at least %d characters wide\n", len(lines[3])), summary.Errors)
+	}
+
+	summary = subject.CookbookSprawlReportSummary([]*reporting.CookbookSprawl{
+		&reporting.CookbookSprawl{Name: "foo", Versions: []*reporting.CookbookSprawlVersion{{Version: "1.0.0"}}},
+	})
+	assert.Equal(t, "", summary.Errors)
+}
@@ -62,6 +62,17 @@ func MakeCookbooksReportTXT(state *reporting.CookbooksReport) *FormattedResult {
 			strBuilder.WriteString("\n")
 		}
 
+		if state.WithDependencies {
+			if len(record.Dependents) == 0 {
+				strBuilder.WriteString("  Dependents: none\n")
+			} else {
+				strBuilder.WriteString("  Dependents: ")
+				strBuilder.WriteString(strings.Join(record.Dependents, ", "))
+				strBuilder.WriteString("\n")
+			}
+			strBuilder.WriteString(fmt.Sprintf("  Blast radius: %d node(s)\n", len(record.BlastRadius)))
+		}
+
 		if state.RunCookstyle {
 			strBuilder.WriteString(fmt.Sprintf("  Violations: %v\n", record.NumOffenses()))
 			strBuilder.WriteString(fmt.Sprintf("  Auto correctable: %v\n", record.NumCorrectable()))
@@ -84,13 +95,7 @@ func MakeCookbooksReportTXT(state *reporting.CookbooksReport) *FormattedResult {
 			}
 		}
 
-		for _, e := range record.Errors() {
-			if record.PolicyGroup != "" {
-				errorBuilder.WriteString(fmt.Sprintf(" - %s (PolicyGroup %s, Policy %s, PolicyRevision %s): %v\n", record.Name, record.PolicyGroup, record.Policy, record.PolicyVer, e))
-			} else {
-				errorBuilder.WriteString(fmt.Sprintf(" - %s (%s): %v\n", record.Name, record.Version, e))
-			}
-		}
+		writeCookbookRecordErrors(&errorBuilder, record)
 
 	}
 	return &FormattedResult{strBuilder.String(), errorBuilder.String()}
@@ -123,6 +128,16 @@ func MakeNodesReportTXT(records []*reporting.NodeReportItem, nodeFilter string)
 			fmt.Sprintf("  Operating System: %s\n",
 				stringOrUnknownPlaceholder(record.OSVersionPretty())),
 		)
+		strBuilder.WriteString(
+			fmt.Sprintf("  Client EOL: %s\n", record.Support.ClientEOLPretty()),
+		)
+		strBuilder.WriteString(
+			fmt.Sprintf("  Platform Supported: %s\n", record.Support.PlatformSupportedPretty()),
+		)
+		strBuilder.WriteString(
+			fmt.Sprintf("  Recommended Client: %s\n",
+				stringOrUnknownPlaceholder(record.Support.RecommendedClient)),
+		)
 
 		strBuilder.WriteString(
 			fmt.Sprintf("  Policy Group: %s\n",
@@ -134,10 +149,32 @@ func MakeNodesReportTXT(records []*reporting.NodeReportItem, nodeFilter string)
 				stringOrUnknownPlaceholder(record.GetPolicyWithRev())),
 		)
 
+		strBuilder.WriteString(
+			fmt.Sprintf("  Last Check-in: %s\n", record.LastCheckInPretty()),
+		)
+
+		if len(record.RunList) == 0 {
+			strBuilder.WriteString("  Run List: none\n")
+		} else {
+			strBuilder.WriteString(
+				fmt.Sprintf("  Run List: %s\n", strings.Join(record.RunList, ", ")),
+			)
+		}
+
+		for _, attr := range record.Attributes {
+			strBuilder.WriteString(
+				fmt.Sprintf("  %s: %s\n", attr.Name, stringOrUnknownPlaceholder(attr.Value)),
+			)
+		}
+
 		if len(record.CookbooksList()) == 0 {
 			strBuilder.WriteString("  Cookbooks Applied: none\n")
 		} else {
-			strBuilder.WriteString("  Cookbooks Applied (alphanumeric order): ")
+			if record.CookbooksExpanded {
+				strBuilder.WriteString("  Cookbooks Expected (resolved from the run list): ")
+			} else {
+				strBuilder.WriteString("  Cookbooks Applied (alphanumeric order): ")
+			}
 			cookbooksString := strings.Join(record.CookbooksList(), ", ")
 			if record.HasPolicyGroup() {
 				cookbooksString = stringReplace(`\([\d,.]+\)`, cookbooksString, "")
@@ -145,7 +182,61 @@ func MakeNodesReportTXT(records []*reporting.NodeReportItem, nodeFilter string)
 			strBuilder.WriteString(cookbooksString)
 			strBuilder.WriteString("\n")
 		}
+
+		if record.ExpandError != nil {
+			errorBuilder.WriteString(fmt.Sprintf(" - %s: %v\n", record.Name, record.ExpandError))
+		}
 	}
 
 	return &FormattedResult{strBuilder.String(), errorBuilder.String()}
 }
+
+// MakeCookbookDriftReportTXT text output of the drift of every cookbook version
+func MakeCookbookDriftReportTXT(records []*reporting.CookbookDriftRecord) *FormattedResult {
+	var (
+		errorBuilder strings.Builder
+		strBuilder   strings.Builder
+	)
+
+	sortCookbookDriftRecords(records)
+
+	for _, record := range records {
+		if record.Error != nil {
+			errorBuilder.WriteString(fmt.Sprintf(" - %s (%s): %v\n", record.Name, record.Version, record.Error))
+			continue
+		}
+
+		strBuilder.WriteString(fmt.Sprintf("> Cookbook: %v (%v)\n", record.Name, record.Version))
+		strBuilder.WriteString(fmt.Sprintf("  Source: %s\n", record.SourcePath))
+		strBuilder.WriteString(fmt.Sprintf("  Revision: %s\n", stringOrPlaceholder(record.Revision, "working tree")))
+		strBuilder.WriteString(fmt.Sprintf("  Status: %s\n", record.Status))
+		if len(record.Files) != 0 {
+			strBuilder.WriteString("  Files:")
+			for _, file := range record.Files {
+				strBuilder.WriteString(fmt.Sprintf("\n   - %s (%s)", file.Path, file.Change))
+			}
+			strBuilder.WriteString("\n")
+		}
+	}
+	return &FormattedResult{strBuilder.String(), errorBuilder.String()}
+}
+
+// MakeCookbookSprawlReportTXT text output of the versions in use of every cookbook
+func MakeCookbookSprawlReportTXT(sprawl []*reporting.CookbookSprawl) *FormattedResult {
+	var strBuilder strings.Builder
+
+	for _, cookbook := range sprawl {
+		strBuilder.WriteString(fmt.Sprintf("> Cookbook: %v\n", cookbook.Name))
+		strBuilder.WriteString(fmt.Sprintf("  Versions in use: %d (oldest %s, newest %s)\n",
+			cookbook.NumVersions(), cookbook.Oldest(), cookbook.Newest()))
+		strBuilder.WriteString(fmt.Sprintf("  Nodes affected: %d\n", cookbook.NumNodes()))
+		for _, version := range cookbook.Versions {
+			strBuilder.WriteString(fmt.Sprintf("   - %s: %d node(s)\n", version.Version, len(version.Nodes)))
+			strBuilder.WriteString(fmt.Sprintf("     Environments: %s\n",
+				stringOrPlaceholder(strings.Join(version.EnvironmentPins, ", "), "none")))
+			strBuilder.WriteString(fmt.Sprintf("     Policy groups: %s\n",
+				stringOrPlaceholder(strings.Join(version.PolicyGroups, ", "), "none")))
+		}
+	}
+	return &FormattedResult{strBuilder.String(), ""}
+}
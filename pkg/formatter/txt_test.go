@@ -21,6 +21,7 @@ import (
 	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -79,6 +80,26 @@ func TestMakeCookbooksReportTXT_WithUnverifiedPolicyRecords(t *testing.T) {
 	assert.Equal(t, "", lines[3])
 }
 
+func TestMakeCookbooksReportTXT_WithDependencies(t *testing.T) {
+	cbStatus := reporting.CookbooksReport{
+		WithDependencies: true,
+		Records: []*reporting.CookbookRecord{
+			&reporting.CookbookRecord{Name: "base", Version: "1.0", Nodes: []string{"node-1"},
+				Dependents: []string{"app@1.0", "web@1.0"}, BlastRadius: []string{"node-1", "node-2", "node-3"}},
+			&reporting.CookbookRecord{Name: "web", Version: "1.0", Nodes: []string{"node-2"},
+				Dependents: []string{}, BlastRadius: []string{"node-2"}},
+		},
+	}
+
+	actual := subject.MakeCookbooksReportTXT(&cbStatus)
+	lines := strings.Split(actual.Report, "\n")
+	assert.Equal(t, 11, len(lines))
+	assert.Equal(t, "  Dependents: app@1.0, web@1.0", lines[3])
+	assert.Equal(t, "  Blast radius: 3 node(s)", lines[4])
+	assert.Equal(t, "  Dependents: none", lines[8])
+	assert.Equal(t, "  Blast radius: 1 node(s)", lines[9])
+}
+
 func TestMakeCookbooksReportTXT_WithVerifiedRecords(t *testing.T) {
 	cbStatus := reporting.CookbooksReport{
 		RunCookstyle: true,
@@ -150,6 +171,17 @@ func TestMakeCookbooksReportTXT_ErrorReport(t *testing.T) {
 	assert.Equal(t, lines[4], "")
 }
 
+func TestMakeCookbookRecordsErrors(t *testing.T) {
+	records := []*reporting.CookbookRecord{
+		&reporting.CookbookRecord{Name: "their-cookbook", Version: "1.1", UsageLookupError: errors.New("could not look up usage")},
+		&reporting.CookbookRecord{Name: "our-cookbook", Version: "1.2"},
+		&reporting.CookbookRecord{Name: "my-cookbook", Version: "1.0", DownloadError: errors.New("could not download")},
+	}
+	assert.Equal(t, " - my-cookbook (1.0): could not download\n - their-cookbook (1.1): could not look up usage\n",
+		subject.MakeCookbookRecordsErrors(records))
+	assert.Equal(t, "", subject.MakeCookbookRecordsErrors([]*reporting.CookbookRecord{}))
+}
+
 func TestMakeNodesReportTXT_Nil(t *testing.T) {
 	assert.Equal(t,
 		&subject.FormattedResult{Report: "", Errors: ""},
@@ -189,24 +221,39 @@ func TestMakeNodesReportTXT_WithRecords(t *testing.T) {
 		expectedReport = `> Node: node1
   Chef Version: 12.22
   Operating System: windows v10.1
+  Client EOL: unknown
+  Platform Supported: unknown
+  Recommended Client: unknown
   Policy Group: no group
   Policy: no policy
+  Last Check-in: never converged
+  Run List: none
   Cookbooks Applied (alphanumeric order): mycookbook(1.0)
 > Node: node2
   Chef Version: 13.11
   Operating System: unknown
+  Client EOL: unknown
+  Platform Supported: unknown
+  Recommended Client: unknown
   Policy Group: prod
   Policy: grafana (rev xyz1234567890)
+  Last Check-in: never converged
+  Run List: none
   Cookbooks Applied (alphanumeric order): mycookbook, test
 > Node: node3
   Chef Version: 15.00
   Operating System: ubuntu v16.04
+  Client EOL: unknown
+  Platform Supported: unknown
+  Recommended Client: unknown
   Policy Group: no group
   Policy: no policy
+  Last Check-in: never converged
+  Run List: none
   Cookbooks Applied: none
 `
 	)
-	if assert.Equal(t, 19, len(lines)) {
+	if assert.Equal(t, 34, len(lines)) {
 		assert.Equal(t, expectedReport, actual.Report)
 	}
 }
@@ -244,30 +291,50 @@ func TestMakeNodesReportTXT_WithRecordsSorted(t *testing.T) {
 		expectedReport = `> Node: 123
   Chef Version: 13.11
   Operating System: unknown
+  Client EOL: unknown
+  Platform Supported: unknown
+  Recommended Client: unknown
   Policy Group: no group
   Policy: no policy
+  Last Check-in: never converged
+  Run List: none
   Cookbooks Applied: none
 > Node: Aaa
   Chef Version: 13.11
   Operating System: unknown
+  Client EOL: unknown
+  Platform Supported: unknown
+  Recommended Client: unknown
   Policy Group: no group
   Policy: no policy
+  Last Check-in: never converged
+  Run List: none
   Cookbooks Applied (alphanumeric order): yyy(1.0), yyy(10.0), YYY(9.9), YYY(99.9)
 > Node: aaa
   Chef Version: 13.11
   Operating System: unknown
+  Client EOL: unknown
+  Platform Supported: unknown
+  Recommended Client: unknown
   Policy Group: prod
   Policy: grafana (rev xyz1234567890)
+  Last Check-in: never converged
+  Run List: none
   Cookbooks Applied (alphanumeric order): ccc, ddd
 > Node: zzz
   Chef Version: 13.11
   Operating System: unknown
+  Client EOL: unknown
+  Platform Supported: unknown
+  Recommended Client: unknown
   Policy Group: no group
   Policy: no policy
+  Last Check-in: never converged
+  Run List: none
   Cookbooks Applied (alphanumeric order): ccc(9.9), yyy(1.0)
 `
 	)
-	if assert.Equal(t, 25, len(lines)) {
+	if assert.Equal(t, 45, len(lines)) {
 		assert.Equal(t, expectedReport, actual.Report)
 	}
 }
@@ -295,24 +362,188 @@ func TestMakeNodesReportTXT_WithRecordsAndFilter(t *testing.T) {
 > Node: node1
   Chef Version: 12.22
   Operating System: windows v10.1
+  Client EOL: unknown
+  Platform Supported: unknown
+  Recommended Client: unknown
   Policy Group: no group
   Policy: no policy
+  Last Check-in: never converged
+  Run List: none
   Cookbooks Applied (alphanumeric order): mycookbook(1.0)
 > Node: node2
   Chef Version: 13.11
   Operating System: unknown
+  Client EOL: unknown
+  Platform Supported: unknown
+  Recommended Client: unknown
   Policy Group: staging
   Policy: seven-zip (rev 99999xxxx99999)
+  Last Check-in: never converged
+  Run List: none
   Cookbooks Applied (alphanumeric order): mycookbook, test
 > Node: node3
   Chef Version: 15.00
   Operating System: ubuntu v16.04
+  Client EOL: unknown
+  Platform Supported: unknown
+  Recommended Client: unknown
   Policy Group: no group
   Policy: no policy
+  Last Check-in: never converged
+  Run List: none
   Cookbooks Applied: none
 `
 	)
-	if assert.Equal(t, 20, len(lines)) {
+	if assert.Equal(t, 35, len(lines)) {
 		assert.Equal(t, expectedReport, actual.Report)
 	}
 }
+
+func TestMakeNodesReportTXT_WithLastCheckIn(t *testing.T) {
+	lastRun := time.Date(2020, 4, 1, 10, 30, 0, 0, time.UTC)
+	nodesReport := []*reporting.NodeReportItem{
+		&reporting.NodeReportItem{Name: "node1", ChefVersion: "15.8", OS: "ubuntu", OSVersion: "18.04",
+			RunList: []string{"role[base]", "recipe[web]"}, LastCheckIn: lastRun, DaysSinceLastRun: 2},
+		&reporting.NodeReportItem{Name: "node2", ChefVersion: "12.22", OS: "ubuntu", OSVersion: "14.04",
+			RunList: []string{"recipe[web]"}, LastCheckIn: lastRun, DaysSinceLastRun: 45, Stale: true},
+	}
+
+	var (
+		actual         = subject.MakeNodesReportTXT(nodesReport, "")
+		expectedReport = `> Node: node1
+  Chef Version: 15.8
+  Operating System: ubuntu v18.04
+  Client EOL: unknown
+  Platform Supported: unknown
+  Recommended Client: unknown
+  Policy Group: no group
+  Policy: no policy
+  Last Check-in: 2020-04-01T10:30:00Z (2 days ago)
+  Run List: role[base], recipe[web]
+  Cookbooks Applied: none
+> Node: node2
+  Chef Version: 12.22
+  Operating System: ubuntu v14.04
+  Client EOL: unknown
+  Platform Supported: unknown
+  Recommended Client: unknown
+  Policy Group: no group
+  Policy: no policy
+  Last Check-in: 2020-04-01T10:30:00Z (45 days ago, stale)
+  Run List: recipe[web]
+  Cookbooks Applied: none
+`
+	)
+	assert.Equal(t, expectedReport, actual.Report)
+}
+
+func TestMakeNodesReportTXT_NeverConverged(t *testing.T) {
+	nodesReport := []*reporting.NodeReportItem{
+		&reporting.NodeReportItem{Name: "node1", ChefVersion: "", OS: "ubuntu", OSVersion: "18.04",
+			RunList: []string{"recipe[web]"}, CookbooksExpanded: true,
+			CookbookVersions: []reporting.CookbookVersion{
+				reporting.CookbookVersion{Name: "web", Version: "0.2.0"}},
+		},
+		&reporting.NodeReportItem{Name: "node2", ChefVersion: "", OS: "ubuntu", OSVersion: "18.04",
+			RunList: []string{"role[missing]"}, ExpandError: errors.New("404 role missing not found")},
+	}
+
+	var (
+		actual         = subject.MakeNodesReportTXT(nodesReport, "")
+		expectedReport = `> Node: node1
+  Chef Version: unknown
+  Operating System: ubuntu v18.04
+  Client EOL: unknown
+  Platform Supported: unknown
+  Recommended Client: unknown
+  Policy Group: no group
+  Policy: no policy
+  Last Check-in: never converged
+  Run List: recipe[web]
+  Cookbooks Expected (resolved from the run list): web(0.2.0)
+> Node: node2
+  Chef Version: unknown
+  Operating System: ubuntu v18.04
+  Client EOL: unknown
+  Platform Supported: unknown
+  Recommended Client: unknown
+  Policy Group: no group
+  Policy: no policy
+  Last Check-in: never converged
+  Run List: role[missing]
+  Cookbooks Applied: none
+`
+	)
+	assert.Equal(t, expectedReport, actual.Report)
+	assert.Equal(t, " - node2: 404 role missing not found\n", actual.Errors)
+}
+
+func TestMakeNodesReportTXT_WithAttributes(t *testing.T) {
+	nodesReport := []*reporting.NodeReportItem{
+		&reporting.NodeReportItem{Name: "node1", ChefVersion: "15.8", OS: "ubuntu", OSVersion: "18.04",
+			Attributes: []reporting.NodeAttributeValue{
+				reporting.NodeAttributeValue{Name: "env", Value: "production"},
+				reporting.NodeAttributeValue{Name: "tags", Value: ""},
+			},
+		},
+	}
+
+	expectedReport := `> Node: node1
+  Chef Version: 15.8
+  Operating System: ubuntu v18.04
+  Client EOL: unknown
+  Platform Supported: unknown
+  Recommended Client: unknown
+  Policy Group: no group
+  Policy: no policy
+  Last Check-in: never converged
+  Run List: none
+  env: production
+  tags: unknown
+  Cookbooks Applied: none
+`
+	assert.Equal(t, expectedReport, subject.MakeNodesReportTXT(nodesReport, "").Report)
+}
+
+func TestMakeCookbookDriftReportTXT(t *testing.T) {
+	records := []*reporting.CookbookDriftRecord{
+		{Name: "foo", Version: "1.10.0", SourcePath: "/src/foo", Revision: "v1.10.0", Status: reporting.DriftInSync},
+		{Name: "foo", Version: "1.2.0", SourcePath: "/src/foo", Status: reporting.DriftUnknownSource,
+			Files: []reporting.CookbookDriftFile{{Path: "recipes/default.rb", Change: reporting.DriftFileModified}}},
+		{Name: "bar", Version: "0.1.0", Error: errors.New("download failed")},
+	}
+	expected := `> Cookbook: foo (1.2.0)
+  Source: /src/foo
+  Revision: working tree
+  Status: unknown source
+  Files:
+   - recipes/default.rb (modified)
+> Cookbook: foo (1.10.0)
+  Source: /src/foo
+  Revision: v1.10.0
+  Status: in sync
+`
+	result := subject.MakeCookbookDriftReportTXT(records)
+	assert.Equal(t, expected, result.Report)
+	assert.Equal(t, " - bar (0.1.0): download failed\n", result.Errors)
+}
+
+func TestMakeCookbookSprawlReportTXT(t *testing.T) {
+	sprawl := []*reporting.CookbookSprawl{
+		{Name: "base", Versions: []*reporting.CookbookSprawlVersion{
+			{Version: "1.2.0", Nodes: []string{"node1"}, EnvironmentPins: []string{"prod (~> 1.2)"}},
+			{Version: "2.0.0", Nodes: []string{"node2", "node3"}, PolicyGroups: []string{"dev (web)", "prod (web)"}},
+		}},
+	}
+	expected := `> Cookbook: base
+  Versions in use: 2 (oldest 1.2.0, newest 2.0.0)
+  Nodes affected: 3
+   - 1.2.0: 1 node(s)
+     Environments: prod (~> 1.2)
+     Policy groups: none
+   - 2.0.0: 2 node(s)
+     Environments: none
+     Policy groups: dev (web), prod (web)
+`
+	assert.Equal(t, &subject.FormattedResult{Report: expected, Errors: ""}, subject.MakeCookbookSprawlReportTXT(sprawl))
+}
@@ -24,9 +24,12 @@ package reporting
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
 
 	"github.com/go-chef/chef"
@@ -34,15 +37,21 @@ import (
 )
 
 type NodeCaptureInterface interface {
-	CaptureCookbooks(string, map[string]interface{}) ([]NodeCookbook, error)
-	CaptureCookbookArtifacts(string, *chef.RevisionDetailsResponse) error
+	CaptureCookbooks(string, map[string]interface{}, ObjectProgressFunc) ([]NodeCookbook, error)
+	CaptureCookbookArtifacts(string, *chef.RevisionDetailsResponse, ObjectProgressFunc) error
 	CaptureNodeObject(node string) (*chef.Node, error)
+	CaptureNodeAttributes(*chef.Node) error
 	CaptureEnvObject(string) error
-	CaptureRoleObjects([]string) error
+	CaptureRoleObjects([]string, ObjectProgressFunc) error
 	CapturePolicyObject(string, string) (*chef.RevisionDetailsResponse, error)
 	CapturePolicyGroupObject(string) (*chef.PolicyGroup, error)
-	CaptureAllDataBagItems() error
+	CaptureAllDataBagItems(ObjectProgressFunc) error
+	CaptureDataBags(names []string, progress ObjectProgressFunc) error
+	ExpandRunList(node *chef.Node) (*ExpandedRunList, error)
 	SaveKitchenYML(node *chef.Node) error
+	SaveChefSpec(node *chef.Node, recipes []string) error
+	SavePolicyfile(node *chef.Node, expanded *ExpandedRunList, cookbooks []NodeCookbook) error
+	SaveEffortlessPackage(group string, policy *chef.RevisionDetailsResponse) error
 }
 
 type NodeCapture struct {
@@ -53,8 +62,10 @@ type NodeCapture struct {
 	node          *chef.Node
 	repositoryDir string
 	opts          CaptureOpts
-	Progress      chan int
-	Error         error
+	// the events of the capture, see CaptureEvent, the channel
+	// is unbuffered and closed once the capture is complete
+	Progress chan CaptureEvent
+	Error    error
 }
 
 type NodeCapturer struct {
@@ -67,6 +78,21 @@ type NodeCapturer struct {
 	policyGroups      PolicyGroupInterface
 	roles             RolesInterface
 	writer            ObjectWriterInterface
+	// the previous capture of the repository when refreshing it
+	previous   *CaptureManifest
+	kitchen    KitchenOpts
+	attributes AttributeOpts
+	effortless EffortlessOpts
+	// secret of the encrypted data bag items
+	dataBagSecret []byte
+	// maximum number of cookbooks downloaded in parallel
+	downloadWorkers int
+
+	// guards what is recorded while capturing, several node
+	// captures can share the capturer
+	mutex           sync.Mutex
+	encryptedItems  []string
+	missingDataBags []string
 }
 
 type NodeCookbook struct {
@@ -88,25 +114,27 @@ type nodePlatformData struct {
 type kitchenYMLArgs struct {
 	ChefVersion string
 	NodeName    string
-	Image       string
-}
-
-// Events that we publish on the Progress channel when
-// performing a node capture
-const (
-	FetchingNode = iota
-	FetchingPolicyData
-	FetchingEnvironment
-	FetchingRoles
-	FetchingDataBags
-	FetchingCookbooks
-	FetchingCookbookArtifacts
-	WritingKitchenConfig
-	CaptureComplete
-)
+	Driver      string
+	Platform    kitchenPlatform
+	// attributes and run list of the node as JSON, see kitchenAttributes
+	Attributes string
+	RunList    string
+	// path of the secret of the encrypted data bag items, if any
+	DataBagSecret string
+}
 
 type CaptureOpts struct {
 	DownloadDataBags bool
+	// capture only the data bags referenced by the cookbooks of the node, see ScanDataBagReferences
+	ReferencedDataBags bool
+	// data bags to capture in addition to the referenced ones, i.e. the ones referenced dynamically
+	DataBags []string
+	// write ChefSpec scaffolds of the node, see SaveChefSpec
+	ChefSpec bool
+	// translate nodes managed by roles and environments into a Policyfile, see SavePolicyfile
+	ToPolicyfile bool
+	// write an Effortless package of the policy of policy-managed nodes, see SaveEffortlessPackage
+	Effortless bool
 }
 
 func NewNodeCapture(name string, repositoryDir string, opts CaptureOpts, capturer NodeCaptureInterface) *NodeCapture {
@@ -115,90 +143,161 @@ func NewNodeCapture(name string, repositoryDir string, opts CaptureOpts, capture
 		capturer:      capturer,
 		repositoryDir: repositoryDir,
 		opts:          opts,
-		// 6 max possible events in a Run - let's not block our activity in case the caller
-		// doesn't pick them up
-		Progress: make(chan int, 7),
+		Progress:      make(chan CaptureEvent),
 	}
 }
 
-// Initialize a NodeCapture. Creates a Progress channel that callers can monitor for updates
+// Run captures the node and publishes the events of the capture on the Progress
+// channel, which callers must consume until it is closed
 func (nc *NodeCapture) Run() {
 	defer func() { close(nc.Progress) }()
 
-	nc.Progress <- FetchingNode
+	nc.startStage(FetchingNode)
 	node, err := nc.capturer.CaptureNodeObject(nc.name)
 	if err != nil {
-		nc.Error = errors.Wrapf(err, "unable to capture node '%s'", nc.name)
+		nc.fail(FetchingNode, errors.Wrapf(err, "unable to capture node '%s'", nc.name))
 		return
 	}
 
 	if nc.opts.DownloadDataBags {
-		nc.Progress <- FetchingDataBags
-		err = nc.capturer.CaptureAllDataBagItems()
+		nc.startStage(FetchingDataBags)
+		err = nc.capturer.CaptureAllDataBagItems(nc.objectProgress(FetchingDataBags))
 		if err != nil {
-			nc.Error = errors.Wrapf(err, "unable to capture data bag items")
+			nc.fail(FetchingDataBags, errors.Wrapf(err, "unable to capture data bag items"))
 			return
 		}
 	}
 
 	if len(node.PolicyName) > 0 {
-		nc.Progress <- FetchingPolicyData
+		nc.startStage(FetchingPolicyData)
 		group, err := nc.capturer.CapturePolicyGroupObject(node.PolicyGroup)
 		if err != nil {
-			nc.Error = errors.Wrapf(err, "unable to capture policy group '%s'", node.PolicyGroup)
+			nc.fail(FetchingPolicyData, errors.Wrapf(err, "unable to capture policy group '%s'", node.PolicyGroup))
 			return
 		}
 
 		policyRevision := group.Policies[node.PolicyName]["revision_id"]
 		policy, err := nc.capturer.CapturePolicyObject(node.PolicyName, policyRevision)
 		if err != nil {
-			nc.Error = errors.Wrapf(err, "unable to capture policy name '%s' revision '%s'", node.PolicyName, policyRevision)
+			nc.fail(FetchingPolicyData, errors.Wrapf(err, "unable to capture policy name '%s' revision '%s'", node.PolicyName, policyRevision))
 			return
 		}
 		nc.Policy = policy
 
-		nc.Progress <- FetchingCookbookArtifacts
-		err = nc.capturer.CaptureCookbookArtifacts(nc.repositoryDir, policy)
+		nc.startStage(FetchingCookbookArtifacts)
+		err = nc.capturer.CaptureCookbookArtifacts(nc.repositoryDir, policy, nc.objectProgress(FetchingCookbookArtifacts))
 		if err != nil {
-			nc.Error = errors.Wrapf(err, "unable to capture cookbook artifacts for policy '%s' revision '%s'", node.PolicyName, policyRevision)
+			nc.fail(FetchingCookbookArtifacts, errors.Wrapf(err, "unable to capture cookbook artifacts for policy '%s' revision '%s'", node.PolicyName, policyRevision))
 			return
 		}
 
 	} else {
 		// Cookbooks/Env/Roles only apply to traditionally managed
 		// nodes, and not policy-managed.
-		nc.Progress <- FetchingCookbooks
-		// If a node has never converged, it will not have this attribute:
+		nc.startStage(FetchingCookbooks)
+		// If a node has never converged, it will not have this attribute,
+		// in that case we resolve the cookbooks from its run list instead:
 		cookbooks := node.AutomaticAttributes["cookbooks"]
+		if cookbooks == nil && len(node.RunList) > 0 {
+			expanded, err := nc.capturer.ExpandRunList(node)
+			if err != nil {
+				nc.fail(FetchingCookbooks, errors.Wrapf(err, "unable to expand run list for node '%s'", nc.name))
+				return
+			}
+			cookbooks = expanded.AsCookbooksAttribute()
+		}
 		if cookbooks != nil {
-			nc.Cookbooks, err = nc.capturer.CaptureCookbooks(nc.repositoryDir, cookbooks.(map[string]interface{}))
+			nc.Cookbooks, err = nc.capturer.CaptureCookbooks(nc.repositoryDir, cookbooks.(map[string]interface{}),
+				nc.objectProgress(FetchingCookbooks))
 			if err != nil {
-				nc.Error = errors.Wrapf(err, "unable to capture node cookbooks for '%s'", nc.name)
+				nc.fail(FetchingCookbooks, errors.Wrapf(err, "unable to capture node cookbooks for '%s'", nc.name))
 				return
 			}
 		}
 
-		nc.Progress <- FetchingEnvironment
+		nc.startStage(FetchingEnvironment)
 		err = nc.capturer.CaptureEnvObject(node.Environment)
 		if err != nil {
-			nc.Error = errors.Wrapf(err, "unable to capture environment")
+			nc.fail(FetchingEnvironment, errors.Wrapf(err, "unable to capture environment"))
+			return
+		}
+
+		nc.startStage(FetchingRoles)
+		err = nc.capturer.CaptureRoleObjects(node.RunList, nc.objectProgress(FetchingRoles))
+		if err != nil {
+			nc.fail(FetchingRoles, errors.Wrapf(err, "unable to capture role(s)"))
 			return
 		}
+	}
 
-		nc.Progress <- FetchingRoles
-		err = nc.capturer.CaptureRoleObjects(node.RunList)
+	// the referenced data bags are found in the cookbooks, they are
+	// captured once the cookbooks are
+	if !nc.opts.DownloadDataBags && (nc.opts.ReferencedDataBags || len(nc.opts.DataBags) > 0) {
+		nc.startStage(FetchingDataBags)
+		err = nc.captureReferencedDataBags()
 		if err != nil {
-			nc.Error = errors.Wrapf(err, "unable to capture role(s)")
+			nc.fail(FetchingDataBags, errors.Wrapf(err, "unable to capture data bag items"))
 			return
 		}
 	}
 
-	nc.Progress <- WritingKitchenConfig
-	err = nc.capturer.SaveKitchenYML(node)
+	// the attributes of the node are written along with the kitchen config
+	nc.startStage(WritingKitchenConfig)
+	err = nc.capturer.CaptureNodeAttributes(node)
+	if err != nil {
+		nc.fail(WritingKitchenConfig, errors.Wrapf(err, "unable to capture node attributes"))
+	} else {
+		err = nc.capturer.SaveKitchenYML(node)
+		if err != nil {
+			nc.fail(WritingKitchenConfig, errors.Wrapf(err, "unable to write Kitchen config"))
+		}
+	}
+
+	// ChefSpec converges the cookbooks of the repository, which policy-managed
+	// nodes don't have, and they don't need to be migrated to a Policyfile
+	if nc.Error == nil && len(node.PolicyName) == 0 && (nc.opts.ChefSpec || nc.opts.ToPolicyfile) {
+		nc.runExpandedRunListSteps(node)
+	}
+	if nc.Error == nil && nc.Policy != nil && nc.opts.Effortless {
+		nc.startStage(WritingEffortlessPackage)
+		err = nc.capturer.SaveEffortlessPackage(node.PolicyGroup, nc.Policy)
+		if err != nil {
+			nc.fail(WritingEffortlessPackage, errors.Wrapf(err, "unable to write Effortless package"))
+		}
+	}
+	if nc.Error == nil {
+		nc.startStage(CaptureComplete)
+	}
+}
+
+// runs the steps that need the expanded run list of the node
+func (nc *NodeCapture) runExpandedRunListSteps(node *chef.Node) {
+	stage := WritingPolicyfile
+	if nc.opts.ChefSpec {
+		stage = WritingChefSpec
+	}
+	expanded, err := nc.capturer.ExpandRunList(node)
 	if err != nil {
-		nc.Error = errors.Wrapf(err, "unable to write Kitchen config")
+		nc.fail(stage, errors.Wrapf(err, "unable to expand run list for node '%s'", nc.name))
+		return
+	}
+
+	if nc.opts.ChefSpec {
+		nc.startStage(WritingChefSpec)
+		err = nc.capturer.SaveChefSpec(node, expanded.Recipes)
+		if err != nil {
+			nc.fail(WritingChefSpec, errors.Wrapf(err, "unable to write ChefSpec scaffolds"))
+			return
+		}
+	}
+
+	if nc.opts.ToPolicyfile {
+		nc.startStage(WritingPolicyfile)
+		err = nc.capturer.SavePolicyfile(node, expanded, nc.Cookbooks)
+		if err != nil {
+			nc.fail(WritingPolicyfile, errors.Wrapf(err, "unable to write Policyfile"))
+		}
 	}
-	nc.Progress <- CaptureComplete
 }
 
 func NewNodeCapturer(
@@ -224,6 +323,13 @@ func NewNodeCapturer(
 
 }
 
+// SetPreviousCapture makes the capturer refresh a repository that was already
+// captured, cookbooks that didn't change since the previous capture and cookbooks
+// that were sourced locally are left untouched
+func (nc *NodeCapturer) SetPreviousCapture(previous *CaptureManifest) {
+	nc.previous = previous
+}
+
 // Capture the the node nc.Name from Chef Server to
 // repositoryDir/nodes/NAME.json
 // Returns the chef.Node object.
@@ -241,52 +347,93 @@ func (nc *NodeCapturer) CaptureNodeObject(name string) (*chef.Node, error) {
 	return &node, nil
 }
 
-// Given a map of cookbook [ name ] : { "version" : version }, download the cookbooks
-// from Chef Server into repositoryDir/cookbooks
-func (nc *NodeCapturer) CaptureCookbookArtifacts(repositoryDir string, policyRevisionDetails *chef.RevisionDetailsResponse) error {
+// Download the cookbook artifacts locked by a policy revision from Chef Server
+// into repositoryDir/cookbook_artifacts, see downloadCookbooks
+func (nc *NodeCapturer) CaptureCookbookArtifacts(repositoryDir string, policyRevisionDetails *chef.RevisionDetailsResponse, progress ObjectProgressFunc) error {
 	artifactDir := fmt.Sprintf("%s/cookbook_artifacts", repositoryDir)
 
-	for ck, cv := range policyRevisionDetails.CookbookLocks {
-		err := nc.cookbookArtifacts.DownloadTo(ck, cv.Identifier, artifactDir)
-		if err != nil {
-			return errors.Wrapf(err, "Failed to download cookbook artifacts %ss v%s", ck, cv.Identifier)
-		}
+	names := make([]string, 0, len(policyRevisionDetails.CookbookLocks))
+	for ck := range policyRevisionDetails.CookbookLocks {
+		names = append(names, ck)
+	}
+	sort.Strings(names)
 
-		// The cookbook directory wil have been created as Name-TruncatedCookbookIdent.  chef-zero requires
-		// it in the form Name-CookbookIdent, so we'll move it here.
-		oldName := fmt.Sprintf("%s/%s-%s", artifactDir, ck, cv.Identifier[0:20])
-		newName := fmt.Sprintf("%s/%s-%s", artifactDir, ck, cv.Identifier)
-		err = os.Rename(oldName, newName)
-		if err != nil {
-			return errors.Wrapf(err, "failed to rename cookbook artifact '%s' to '%s'", oldName, newName)
+	downloads := make([]cookbookDownload, 0, len(names))
+	for _, ck := range names {
+		cv := policyRevisionDetails.CookbookLocks[ck]
+		// artifacts are immutable, one that was already captured (or sourced locally) is up to date
+		if _, err := os.Lstat(fmt.Sprintf("%s/%s-%s", artifactDir, ck, cv.Identifier)); err == nil {
+			continue
 		}
+		downloads = append(downloads, cookbookDownload{
+			Object: fmt.Sprintf("%s-%s", ck, cv.Identifier),
+			// The cookbook directory will be created as Name-TruncatedCookbookIdent.  chef-zero requires
+			// it in the form Name-CookbookIdent, so we'll move it there.
+			Downloaded: fmt.Sprintf("%s-%s", ck, cv.Identifier[0:20]),
+			Target:     fmt.Sprintf("%s-%s", ck, cv.Identifier),
+			download: func(dir string) error {
+				err := nc.cookbookArtifacts.DownloadTo(ck, cv.Identifier, dir)
+				return errors.Wrapf(err, "Failed to download cookbook artifacts %s v%s", ck, cv.Identifier)
+			},
+		})
 	}
-	return nil
+	return nc.downloadCookbooks(artifactDir, downloads, progress)
 }
 
 // Given a map of cookbook [ name ] : { "version" : version }, download the cookbooks
-// from Chef Server into repositoryDir/cookbooks
-func (nc *NodeCapturer) CaptureCookbooks(repositoryDir string, cookbooks map[string]interface{}) ([]NodeCookbook, error) {
+// from Chef Server into repositoryDir/cookbooks, see downloadCookbooks
+func (nc *NodeCapturer) CaptureCookbooks(repositoryDir string, cookbooks map[string]interface{}, progress ObjectProgressFunc) ([]NodeCookbook, error) {
 	var outCookbooks []NodeCookbook
 	cookbookDir := fmt.Sprintf("%s/cookbooks", repositoryDir)
 
-	for name, version_data := range cookbooks {
-		version := safeStringFromMap(version_data.(map[string]interface{}), "version")
-		err := nc.cookbooks.DownloadTo(name, version, cookbookDir)
-		if err != nil {
-			return nil, errors.Wrapf(err, "Failed to download cookbook %s v%s", name, version)
-		}
+	names := make([]string, 0, len(cookbooks))
+	for name := range cookbooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	downloads := make([]cookbookDownload, 0, len(names))
+	for _, name := range names {
+		version := safeStringFromMap(cookbooks[name].(map[string]interface{}), "version")
 		outCookbooks = append(outCookbooks, NodeCookbook{name, version})
-		// The cookbook directory will have been created as 'name-version'.  We need it to be just 'name'
-		// so that it can be picked up by chef-zero when requested from within kitchen.
-		err = os.Rename(fmt.Sprintf("%s/%s-%s", cookbookDir, name, version), fmt.Sprintf("%s/%s", cookbookDir, name))
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to rename cookbook %s-%s to non-versioned name", name, version)
+
+		if !nc.refreshCookbook(cookbookDir, name, version) {
+			continue
 		}
+		downloads = append(downloads, cookbookDownload{
+			Object: fmt.Sprintf("%s-%s", name, version),
+			// The cookbook directory will be created as 'name-version'.  We need it to be just 'name'
+			// so that it can be picked up by chef-zero when requested from within kitchen.
+			Downloaded: fmt.Sprintf("%s-%s", name, version),
+			Target:     name,
+			download: func(dir string) error {
+				err := nc.cookbooks.DownloadTo(name, version, dir)
+				return errors.Wrapf(err, "Failed to download cookbook %s v%s", name, version)
+			},
+		})
+	}
+
+	if err := nc.downloadCookbooks(cookbookDir, downloads, progress); err != nil {
+		return nil, err
 	}
 	return outCookbooks, nil
 }
 
+// returns false if a cookbook that already exists in the repository must be left
+// untouched because it was sourced locally (it is a symlink) or it has the same
+// version that was previously captured, a cookbook that is downloaded again only
+// replaces the previous capture once the download succeeds, see downloadCookbooks
+func (nc *NodeCapturer) refreshCookbook(cookbookDir, name, version string) bool {
+	info, err := os.Lstat(fmt.Sprintf("%s/%s", cookbookDir, name))
+	if err != nil {
+		return true
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return false
+	}
+	return nc.previous == nil || nc.previous.Cookbooks[fmt.Sprintf("cookbooks/%s", name)] != version
+}
+
 func (nc *NodeCapturer) CaptureEnvObject(environment string) error {
 	env, err := nc.env.Get(environment)
 	if err != nil {
@@ -335,9 +482,10 @@ func (nc *NodeCapturer) CapturePolicyGroupObject(groupName string) (*chef.Policy
 	return &group, nil
 }
 
-func (nc *NodeCapturer) CaptureRoleObjects(runList []string) error {
+func (nc *NodeCapturer) CaptureRoleObjects(runList []string, progress ObjectProgressFunc) error {
 	roleNames := filterRoles(runList)
-	for _, roleName := range roleNames {
+	for i, roleName := range roleNames {
+		progress.report(roleName, i+1, len(roleNames))
 		role, err := nc.roles.Get(roleName)
 		if err != nil {
 			return errors.Wrapf(err, "unable to retrieve role: %s", roleName)
@@ -350,71 +498,209 @@ func (nc *NodeCapturer) CaptureRoleObjects(runList []string) error {
 	return nil
 }
 
-func (nc *NodeCapturer) CaptureAllDataBagItems() error {
+// CaptureAllDataBagItems captures every data bag of the Chef Infra Server, the
+// progress of every item is reported as BAG/ITEM, its index is the one of the
+// item in its data bag
+func (nc *NodeCapturer) CaptureAllDataBagItems(progress ObjectProgressFunc) error {
 	bags, err := nc.dataBags.List()
 	if err != nil {
 		return errors.Wrap(err, "unable to retrieve list of data bags")
 	}
 	for name, _ := range *bags {
-		items, err := nc.dataBags.ListItems(name)
+		err = nc.captureDataBag(name, progress)
 		if err != nil {
-			return errors.Wrapf(err, "unable to retrieve data bag items for %s", name)
+			return err
 		}
+	}
+	return nil
+}
 
-		for itemName, _ := range *items {
-			item, err := nc.dataBags.GetItem(name, itemName)
-			if err != nil {
-				return errors.Wrapf(err, "unable to retrieve data bag item %s/%s", name, itemName)
-			}
+// CaptureDataBags captures the items of the provided data bags, the ones that
+// don't exist on the Chef Infra Server are skipped, see MissingDataBags, the progress
+// of the items is reported like the one of CaptureAllDataBagItems
+func (nc *NodeCapturer) CaptureDataBags(names []string, progress ObjectProgressFunc) error {
+	bags, err := nc.dataBags.List()
+	if err != nil {
+		return errors.Wrap(err, "unable to retrieve list of data bags")
+	}
+	for _, name := range names {
+		if _, ok := (*bags)[name]; !ok {
+			nc.recordMissingDataBag(name)
+			continue
+		}
+		err = nc.captureDataBag(name, progress)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			err = nc.writer.WriteDataBagItem(name, itemName, item)
-			if err != nil {
-				return errors.Wrapf(err, "unable to save data bag item %s/%s", name, itemName)
+// MissingDataBags returns the data bags that were requested but
+// don't exist on the Chef Infra Server
+func (nc *NodeCapturer) MissingDataBags() []string {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+	return append([]string{}, nc.missingDataBags...)
+}
+
+func (nc *NodeCapturer) recordMissingDataBag(name string) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+	for _, missing := range nc.missingDataBags {
+		if missing == name {
+			return
+		}
+	}
+	nc.missingDataBags = append(nc.missingDataBags, name)
+}
+
+func (nc *NodeCapturer) captureDataBag(name string, progress ObjectProgressFunc) error {
+	items, err := nc.dataBags.ListItems(name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to retrieve data bag items for %s", name)
+	}
+
+	itemNames := make([]string, 0, len(*items))
+	for itemName := range *items {
+		itemNames = append(itemNames, itemName)
+	}
+	sort.Strings(itemNames)
+	for i, itemName := range itemNames {
+		progress.report(fmt.Sprintf("%s/%s", name, itemName), i+1, len(itemNames))
+		item, err := nc.dataBags.GetItem(name, itemName)
+		if err != nil {
+			return errors.Wrapf(err, "unable to retrieve data bag item %s/%s", name, itemName)
+		}
+
+		// encrypted items are written encrypted, the secret is only used
+		// to verify that local converges will be able to decrypt them
+		if IsEncryptedDataBagItem(item) {
+			nc.recordEncryptedItem(fmt.Sprintf("%s/%s", name, itemName))
+			if nc.dataBagSecret != nil {
+				if err := VerifyDataBagItemSecret(item, nc.dataBagSecret); err != nil {
+					return errors.Wrapf(err, "the data bag secret does not decrypt data bag item %s/%s", name, itemName)
+				}
 			}
+		}
 
+		err = nc.writer.WriteDataBagItem(name, itemName, item)
+		if err != nil {
+			return errors.Wrapf(err, "unable to save data bag item %s/%s", name, itemName)
 		}
+
 	}
 	return nil
 }
 
-func (nc *NodeCapturer) SaveKitchenYML(node *chef.Node) error {
+// Resolves the node's run list into recipes and cookbook versions, used for
+// nodes that never completed a chef-client run.
+func (nc *NodeCapturer) ExpandRunList(node *chef.Node) (*ExpandedRunList, error) {
+	return NewRunListExpander(nc.roles, nc.env, nc.cookbooks).ExpandNode(node)
+}
 
-	packages := node.AutomaticAttributes["chef_packages"]
-	if packages == nil {
-		return errors.New("could not determine chef client version: node missing automatic attribute 'chef_packages'")
+func (nc *NodeCapturer) SaveKitchenYML(node *chef.Node) error {
+	version, err := kitchenChefVersion(node)
+	if err != nil {
+		return err
 	}
 
-	chef := packages.(map[string]interface{})["chef"]
-	if chef == nil {
-		return errors.New("could not determine chef client version: node missing automatic attribute chef_packages['chef']")
+	platform, err := nc.kitchenPlatform(node)
+	if err != nil {
+		return err
 	}
 
-	version := safeStringFromMap(chef.(map[string]interface{}), "version")
-	if version == "" {
-		return errors.New("could not determine chef client version: node missing automatic attribute chef_packages['chef']['version']")
+	args, err := nc.kitchenYMLArgs(node, version, platform)
+	if err != nil {
+		return err
 	}
-
-	args := kitchenYMLArgs{version, node.Name, determineKitchenImage(node)}
 	tmpl_text := `
 ---
-driver:
-  name: vagrant
-
+{{template "driver" .}}
 provisioner:
   name: chef_zero_capture
   product_name: chef
   product_version: {{.ChefVersion}}
-  json_attributes: false
+  json_attributes: {{if .Attributes}}true{{else}}false{{end}}
+{{- if .DataBagSecret}}
+  encrypted_data_bag_secret_key_path: {{.DataBagSecret}}
+{{- end}}
   client_rb:
     node_name: {{.NodeName}}
 
 platforms:
-  - name: {{.Image}}
+  - name: {{.Platform.Name}}
+{{- template "platform_settings" .Platform}}
 
 suites:
   - name: {{.NodeName}}
+{{- if .Attributes}}
+{{- if .RunList}}
+    run_list: {{.RunList}}
+{{- end}}
+    attributes: {{.Attributes}}
+{{- end}}
+{{- if eq .Driver "dokken"}}
+    driver:
+      chef_version: {{.ChefVersion}}
+{{- end}}
 `
+	return nc.writeKitchenYML(tmpl_text, args)
+}
+
+// returns the arguments of the kitchen.yml templates for a node, nodes with
+// attributes pass them with json_attributes, which replaces the run list of
+// the node with the one of the suite, so the suite gets the run list of the node
+// (policyfile nodes get their run list from the policy instead)
+func (nc *NodeCapturer) kitchenYMLArgs(node *chef.Node, version string, platform kitchenPlatform) (kitchenYMLArgs, error) {
+	args := kitchenYMLArgs{
+		ChefVersion:   version,
+		NodeName:      node.Name,
+		Driver:        nc.kitchenDriver(),
+		Platform:      platform,
+		DataBagSecret: nc.kitchenDataBagSecret(),
+	}
+
+	attributes, err := nc.kitchenAttributes(node)
+	if err != nil || attributes == "" {
+		return args, err
+	}
+	args.Attributes = attributes
+
+	if node.PolicyName == "" {
+		runList := node.RunList
+		if runList == nil {
+			runList = []string{}
+		}
+		content, err := json.Marshal(runList)
+		if err != nil {
+			return args, errors.Wrapf(err, "unable to serialize run list of node '%s'", node.Name)
+		}
+		args.RunList = string(content)
+	}
+	return args, nil
+}
+
+// returns the path of the data bag secret in the kitchen.yml, or an empty
+// string when no secret was provided
+func (nc *NodeCapturer) kitchenDataBagSecret() string {
+	if nc.dataBagSecret == nil {
+		return ""
+	}
+	return DataBagSecretFile
+}
+
+// writes the kitchen.yml from the provided template, it can use the templates
+// "driver", the driver section of the kitchen driver, and "platform_settings",
+// the driver, transport and provisioner settings of a kitchenPlatform
+func (nc *NodeCapturer) writeKitchenYML(tmpl_text string, args interface{}) error {
 	tmpl, err := template.New("kitchen.yml").Parse(tmpl_text)
+	if err == nil {
+		_, err = tmpl.New("driver").Parse(kitchenDriverTemplates[nc.kitchenDriver()])
+	}
+	if err == nil {
+		_, err = tmpl.New("platform_settings").Parse(kitchenPlatformSettingsTmpl)
+	}
 	if err != nil {
 		return errors.Wrap(err, "could not create new template")
 	}
@@ -431,7 +717,39 @@ suites:
 		return errors.Wrap(err, "failed to save")
 	}
 	return nil
+}
 
+// returns the version of chef-client to converge the node with, the version the
+// node last ran or, for nodes that never completed a chef-client run and have no
+// chef_packages attribute, the recommended client of the built-in support data
+func kitchenChefVersion(node *chef.Node) (string, error) {
+	if node.AutomaticAttributes["chef_packages"] != nil {
+		return nodeChefVersion(node)
+	}
+	data, err := LoadSupportData("")
+	if err != nil {
+		return "", errors.Wrap(err, "could not determine chef client version")
+	}
+	return data.RecommendedClient, nil
+}
+
+// returns the version of chef-client that the node last ran
+func nodeChefVersion(node *chef.Node) (string, error) {
+	packages := node.AutomaticAttributes["chef_packages"]
+	if packages == nil {
+		return "", errors.New("could not determine chef client version: node missing automatic attribute 'chef_packages'")
+	}
+
+	chef := packages.(map[string]interface{})["chef"]
+	if chef == nil {
+		return "", errors.New("could not determine chef client version: node missing automatic attribute chef_packages['chef']")
+	}
+
+	version := safeStringFromMap(chef.(map[string]interface{}), "version")
+	if version == "" {
+		return "", errors.New("could not determine chef client version: node missing automatic attribute chef_packages['chef']['version']")
+	}
+	return version, nil
 }
 
 func extractPlatformFromNode(node *chef.Node) nodePlatformData {
@@ -463,14 +781,14 @@ func filterRoles(possibleRoles []string) []string {
 	return roles
 }
 
-// In a very limited way takes a guess at the name of the kitchen
-// image to use for the node being captured. We will probably
-// want to look closer at how and where this is invoked -
-// ideally we nee dto provide for user entry of an image name if
-// we can't resolve it, or resolve it incorrectly. This is also an
-// opportunity to be smart about providers - for example if the original
-// node is provided by AWS and we have an AMI we can use, we should do that.
+// Returns the name of the kitchen platform of the node being captured,
+// the box or image of the platform is resolved from the kitchen images
+// table, see kitchenPlatform. Windows nodes are named after their
+// release (e.g. windows-2019) so that drivers recognize them.
 func determineKitchenImage(node *chef.Node) string {
 	platform := extractPlatformFromNode(node)
+	if platform.isWindows() {
+		return fmt.Sprintf("windows-%s", windowsRelease(platform.Version))
+	}
 	return fmt.Sprintf("%s-%s", platform.Name, platform.Version)
 }
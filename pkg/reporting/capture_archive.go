@@ -0,0 +1,268 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// where the cookbooks of a captured repository were sourced from
+const (
+	// downloaded from the Chef Infra Server
+	CookbookSourceChefServer = "chef_server"
+	// linked to a local checkout
+	CookbookSourceLocal = "local"
+)
+
+// directories that are not archived, they are specific to the machine
+// that captured the repository or hold secrets, like the data bag secret
+var archiveSkippedDirs = map[string]bool{".chef": true, ".git": true, ".kitchen": true}
+
+// ArchiveWriter is an ObjectWriter that stages a capture in RootDir and packs it
+// into a portable .tar.gz archive once the capture is complete, cookbooks linked
+// to local checkouts are archived as regular files so the archive can be imported
+// anywhere with ImportArchive
+type ArchiveWriter struct {
+	*ObjectWriter
+	ArchivePath string
+}
+
+// Close records where the cookbooks were sourced from in the manifest, saves it
+// and packs the staged repository into the archive
+func (aw *ArchiveWriter) Close(manifest *CaptureManifest) error {
+	manifest.CookbookSources = make(map[string]string, len(manifest.Cookbooks))
+	for dir := range manifest.Cookbooks {
+		info, err := os.Lstat(filepath.Join(aw.RootDir, dir))
+		switch {
+		case err != nil:
+			// the cookbook could not be captured nor sourced
+		case info.Mode()&os.ModeSymlink != 0:
+			manifest.CookbookSources[dir] = CookbookSourceLocal
+		default:
+			manifest.CookbookSources[dir] = CookbookSourceChefServer
+		}
+	}
+	if err := manifest.Save(aw.RootDir); err != nil {
+		return err
+	}
+
+	f, err := os.Create(aw.ArchivePath)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create archive '%s'", aw.ArchivePath)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	if err := archiveDir(tw, aw.RootDir, ""); err != nil {
+		return errors.Wrapf(err, "unable to write archive '%s'", aw.ArchivePath)
+	}
+	if err := tw.Close(); err != nil {
+		return errors.Wrapf(err, "unable to write archive '%s'", aw.ArchivePath)
+	}
+	if err := gz.Close(); err != nil {
+		return errors.Wrapf(err, "unable to write archive '%s'", aw.ArchivePath)
+	}
+	return f.Close()
+}
+
+// adds the content of dir to the archive under prefix, links are resolved and the
+// content of their target is added in their place, except for links to the directory
+// being archived or one of its parents which would loop forever and are skipped
+func archiveDir(tw *tar.Writer, dir, prefix string) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return errors.Wrapf(err, "unable to resolve '%s'", dir)
+	}
+	return archiveTree(tw, dir, prefix, map[string]bool{root: true})
+}
+
+// archives dir, the real paths of the directories that contain it are in parents
+func archiveTree(tw *tar.Writer, dir, prefix string, parents map[string]bool) error {
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := path.Join(prefix, filepath.ToSlash(rel))
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(file)
+			if err != nil {
+				return errors.Wrapf(err, "unable to resolve link '%s'", file)
+			}
+			info, err = os.Stat(target)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if linksToParent(target, file, parents) {
+					return nil
+				}
+				if err := archiveHeader(tw, name, info); err != nil {
+					return err
+				}
+				parents[target] = true
+				defer delete(parents, target)
+				return archiveTree(tw, target, name, parents)
+			}
+			file = target
+		}
+
+		switch {
+		case info.IsDir() && archiveSkippedDirs[info.Name()]:
+			return filepath.SkipDir
+		case info.IsDir():
+			return archiveHeader(tw, name, info)
+		case !info.Mode().IsRegular():
+			return nil
+		}
+
+		if err := archiveHeader(tw, name, info); err != nil {
+			return err
+		}
+		content, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		_, err = io.Copy(tw, content)
+		return err
+	})
+}
+
+// returns true if the link (to the target directory) is in the target directory,
+// or in a directory walked through a link to one of the parents
+func linksToParent(target, link string, parents map[string]bool) bool {
+	if parents[target] {
+		return true
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(link))
+	if err != nil {
+		return false
+	}
+	return dir == target || strings.HasPrefix(dir, target+string(filepath.Separator))
+}
+
+// writes the header of a file or directory, without the owner of the
+// file since it would not exist on the machines the archive is imported
+func archiveHeader(tw *tar.Writer, name string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	return tw.WriteHeader(header)
+}
+
+// ImportArchive extracts an archive written by an ArchiveWriter into repositoryDir, which
+// must not exist, and verifies the captured objects against the checksums of its manifest,
+// it returns the manifest and the objects whose content differs from the manifest
+func ImportArchive(archivePath, repositoryDir string) (*CaptureManifest, []string, error) {
+	if _, err := os.Stat(repositoryDir); err == nil {
+		return nil, nil, errors.Errorf("unable to import into '%s', it already exists", repositoryDir)
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "unable to open archive '%s'", archivePath)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "unable to read archive '%s'", archivePath)
+	}
+	defer gz.Close()
+
+	if err := extractArchive(tar.NewReader(gz), repositoryDir); err != nil {
+		return nil, nil, errors.Wrapf(err, "unable to extract archive '%s'", archivePath)
+	}
+
+	manifest, err := LoadCaptureManifest(repositoryDir)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "'%s' is not a capture archive", archivePath)
+	}
+
+	modified := make([]string, 0)
+	for file := range manifest.Objects {
+		if _, err := os.Stat(filepath.Join(repositoryDir, file)); err != nil {
+			return nil, nil, errors.Errorf("archive '%s' is incomplete, '%s' is missing", archivePath, file)
+		}
+		if manifest.ModifiedLocally(repositoryDir, file) {
+			modified = append(modified, file)
+		}
+	}
+	sort.Strings(modified)
+	return manifest, modified, nil
+}
+
+func extractArchive(tr *tar.Reader, repositoryDir string) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// entries must stay inside the repository
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return errors.Errorf("invalid entry '%s'", header.Name)
+		}
+		target := filepath.Join(repositoryDir, filepath.FromSlash(name))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode)&0777)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
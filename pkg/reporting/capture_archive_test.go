@@ -0,0 +1,195 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/go-chef/chef"
+	"github.com/stretchr/testify/assert"
+)
+
+// setup errors are bugs of the test itself
+func mustSucceed(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+// captures a node and a cookbook linked to a local checkout into an archive
+func writeTestArchive(t *testing.T, baseDir string) (string, *subject.CaptureManifest) {
+	var (
+		stagingDir  = filepath.Join(baseDir, "staging")
+		checkoutDir = filepath.Join(baseDir, "checkouts", "bar")
+		archivePath = filepath.Join(baseDir, "capture.tar.gz")
+	)
+	mustSucceed(os.MkdirAll(filepath.Join(stagingDir, "cookbooks", "foo", "recipes"), 0755))
+	mustSucceed(ioutil.WriteFile(filepath.Join(stagingDir, "cookbooks", "foo", "recipes", "default.rb"), []byte("log 'foo'\n"), 0644))
+	mustSucceed(os.MkdirAll(filepath.Join(checkoutDir, ".git"), 0755))
+	mustSucceed(ioutil.WriteFile(filepath.Join(checkoutDir, ".git", "HEAD"), []byte("ref: refs/heads/main\n"), 0644))
+	mustSucceed(ioutil.WriteFile(filepath.Join(checkoutDir, "metadata.rb"), []byte("name 'bar'\n"), 0644))
+	mustSucceed(os.Symlink(checkoutDir, filepath.Join(stagingDir, "cookbooks", "bar")))
+	mustSucceed(subject.WriteDataBagSecret(stagingDir, []byte("secret")))
+
+	aw := &subject.ArchiveWriter{ObjectWriter: &subject.ObjectWriter{RootDir: stagingDir}, ArchivePath: archivePath}
+	mustSucceed(aw.WriteNode(&chef.Node{Name: "node1"}))
+	mustSucceed(aw.WriteContent("kitchen.yml", []byte("---\n")))
+
+	manifest := subject.NewCaptureManifest([]string{"node1"}, aw.Written(),
+		[]subject.NodeCookbook{{"foo", "1.0.0"}, {"bar", "2.0.0"}}, nil)
+	manifest.SetChefServerURL("https://chef.example.com/organizations/test")
+	mustSucceed(aw.Close(manifest))
+	return archivePath, manifest
+}
+
+func archiveEntries(t *testing.T, archivePath string) map[string]byte {
+	f, err := os.Open(archivePath)
+	mustSucceed(err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	mustSucceed(err)
+	tr := tar.NewReader(gz)
+
+	entries := make(map[string]byte)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		entries[header.Name] = header.Typeflag
+	}
+	return entries
+}
+
+func TestArchiveWriter_Close(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	mustSucceed(err)
+	defer os.RemoveAll(baseDir)
+
+	archivePath, manifest := writeTestArchive(t, baseDir)
+
+	assert.Equal(t, map[string]string{
+		"cookbooks/foo": subject.CookbookSourceChefServer,
+		"cookbooks/bar": subject.CookbookSourceLocal,
+	}, manifest.CookbookSources)
+	assert.Len(t, manifest.ChefServerURLHash, 64)
+
+	entries := archiveEntries(t, archivePath)
+	// the linked cookbook is archived as regular files, without its git directory
+	assert.Equal(t, byte(tar.TypeDir), entries["cookbooks/bar/"])
+	assert.Equal(t, byte(tar.TypeReg), entries["cookbooks/bar/metadata.rb"])
+	assert.NotContains(t, entries, "cookbooks/bar/.git/")
+	assert.NotContains(t, entries, "cookbooks/bar/.git/HEAD")
+	// the data bag secret is never archived
+	assert.NotContains(t, entries, ".chef/")
+	assert.NotContains(t, entries, subject.DataBagSecretFile)
+	assert.Equal(t, byte(tar.TypeReg), entries["cookbooks/foo/recipes/default.rb"])
+	assert.Equal(t, byte(tar.TypeReg), entries["nodes/node1.json"])
+	assert.Equal(t, byte(tar.TypeReg), entries[subject.CaptureManifestFile])
+}
+
+func TestArchiveWriter_CloseWithLinkLoops(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	mustSucceed(err)
+	defer os.RemoveAll(baseDir)
+
+	var (
+		stagingDir  = filepath.Join(baseDir, "staging")
+		checkoutDir = filepath.Join(baseDir, "checkouts", "bar")
+		archivePath = filepath.Join(baseDir, "capture.tar.gz")
+	)
+	mustSucceed(os.MkdirAll(filepath.Join(stagingDir, "cookbooks"), 0755))
+	mustSucceed(os.MkdirAll(filepath.Join(checkoutDir, "files"), 0755))
+	mustSucceed(ioutil.WriteFile(filepath.Join(checkoutDir, "metadata.rb"), []byte("name 'bar'\n"), 0644))
+	mustSucceed(os.Symlink(checkoutDir, filepath.Join(stagingDir, "cookbooks", "bar")))
+	// links to the checkout itself and to the repository being archived
+	mustSucceed(os.Symlink(checkoutDir, filepath.Join(checkoutDir, "files", "self")))
+	mustSucceed(os.Symlink(stagingDir, filepath.Join(stagingDir, "cookbooks", "repo")))
+
+	aw := &subject.ArchiveWriter{ObjectWriter: &subject.ObjectWriter{RootDir: stagingDir}, ArchivePath: archivePath}
+	mustSucceed(aw.Close(subject.NewCaptureManifest([]string{"node1"}, aw.Written(),
+		[]subject.NodeCookbook{{"bar", "2.0.0"}}, nil)))
+
+	entries := archiveEntries(t, archivePath)
+	assert.Equal(t, byte(tar.TypeReg), entries["cookbooks/bar/metadata.rb"])
+	assert.Equal(t, byte(tar.TypeDir), entries["cookbooks/bar/files/"])
+	assert.NotContains(t, entries, "cookbooks/bar/files/self/")
+	assert.NotContains(t, entries, "cookbooks/repo/")
+}
+
+func TestImportArchive(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	mustSucceed(err)
+	defer os.RemoveAll(baseDir)
+
+	archivePath, captured := writeTestArchive(t, baseDir)
+	repoDir := filepath.Join(baseDir, "imported")
+
+	manifest, modified, err := subject.ImportArchive(archivePath, repoDir)
+	mustSucceed(err)
+	assert.Empty(t, modified)
+	assert.Equal(t, captured.Nodes, manifest.Nodes)
+	assert.Equal(t, captured.Objects, manifest.Objects)
+	assert.Equal(t, captured.CookbookSources, manifest.CookbookSources)
+
+	info, err := os.Lstat(filepath.Join(repoDir, "cookbooks", "bar"))
+	if assert.Nil(t, err) {
+		assert.True(t, info.IsDir())
+	}
+	content, err := ioutil.ReadFile(filepath.Join(repoDir, "cookbooks", "bar", "metadata.rb"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "name 'bar'\n", string(content))
+	}
+
+	// a repository is never imported over an existing directory
+	_, _, err = subject.ImportArchive(archivePath, repoDir)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "already exists")
+	}
+}
+
+func TestImportArchive_InvalidEntry(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	mustSucceed(err)
+	defer os.RemoveAll(baseDir)
+
+	archivePath := filepath.Join(baseDir, "evil.tar.gz")
+	f, err := os.Create(archivePath)
+	mustSucceed(err)
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	content := []byte("gotcha\n")
+	mustSucceed(tw.WriteHeader(&tar.Header{Name: "../outside.txt", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err = tw.Write(content)
+	mustSucceed(err)
+	mustSucceed(tw.Close())
+	mustSucceed(gz.Close())
+	mustSucceed(f.Close())
+
+	_, _, err = subject.ImportArchive(archivePath, filepath.Join(baseDir, "repo"))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "invalid entry '../outside.txt'")
+	}
+	_, err = os.Stat(filepath.Join(baseDir, "outside.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,124 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"encoding/json"
+
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+)
+
+// attribute precedence levels that can be captured besides the normal attributes
+const (
+	AttributeLevelDefault  = "default"
+	AttributeLevelNormal   = "normal"
+	AttributeLevelOverride = "override"
+)
+
+// AttributeLevels are the attribute precedence levels that can be captured,
+// from the lowest to the highest precedence
+var AttributeLevels = []string{AttributeLevelDefault, AttributeLevelNormal, AttributeLevelOverride}
+
+// AttributeOpts are the options used to capture the attributes of a node
+type AttributeOpts struct {
+	// attribute precedence levels to capture, the normal attributes are always captured
+	Levels []string
+}
+
+// SetAttributeOpts sets the options used to capture the attributes of nodes
+func (nc *NodeCapturer) SetAttributeOpts(opts AttributeOpts) {
+	nc.attributes = opts
+}
+
+// CaptureNodeAttributes writes the merged attributes of the captured precedence levels
+// to dna/NODE.json, ready to be used with `chef-client -j`, and the automatic attributes
+// to ohai/NODE.json as an ohai fixture, both are redacted by the writer
+func (nc *NodeCapturer) CaptureNodeAttributes(node *chef.Node) error {
+	err := nc.writer.WriteJSON("dna", node.Name, nc.nodeAttributes(node))
+	if err != nil {
+		return errors.Wrapf(err, "unable to save attributes of node '%s'", node.Name)
+	}
+
+	err = nc.writer.WriteJSON("ohai", node.Name, node.AutomaticAttributes)
+	if err != nil {
+		return errors.Wrapf(err, "unable to save ohai data of node '%s'", node.Name)
+	}
+	return nil
+}
+
+// returns the attributes of the captured precedence levels, merged in precedence order
+func (nc *NodeCapturer) nodeAttributes(node *chef.Node) map[string]interface{} {
+	levels := map[string]map[string]interface{}{
+		AttributeLevelDefault:  node.DefaultAttributes,
+		AttributeLevelNormal:   node.NormalAttributes,
+		AttributeLevelOverride: node.OverrideAttributes,
+	}
+
+	merged := make(map[string]interface{})
+	for _, level := range AttributeLevels {
+		if level != AttributeLevelNormal && !containsString(nc.attributes.Levels, level) {
+			continue
+		}
+		mergeAttributes(merged, levels[level])
+	}
+	return merged
+}
+
+// returns the attributes of the node to add to the kitchen.yml as compact JSON,
+// which is valid YAML, redacted, or an empty string when the node has no attributes
+func (nc *NodeCapturer) kitchenAttributes(node *chef.Node) (string, error) {
+	attributes := nc.redactAttributes("kitchen.yml", nc.nodeAttributes(node))
+	if len(attributes) == 0 {
+		return "", nil
+	}
+	content, err := json.Marshal(attributes)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to serialize attributes of node '%s'", node.Name)
+	}
+	return string(content), nil
+}
+
+// returns the attributes with the values that match the rules of the writer masked,
+// for the attributes written in files that are not JSON, where the writer only
+// applies its value rules (see ObjectWriter.WriteContent)
+func (nc *NodeCapturer) redactAttributes(file string, attributes map[string]interface{}) map[string]interface{} {
+	if redacted, ok := nc.writer.RedactValue(file, []string{}, attributes).(map[string]interface{}); ok {
+		return redacted
+	}
+	return attributes
+}
+
+// deep merges the source attributes into the destination, values
+// of the source replace the ones of the destination except maps,
+// that are merged, the same way the client merges precedence levels
+func mergeAttributes(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		switch {
+		case srcIsMap && dstIsMap:
+			mergeAttributes(dstMap, srcMap)
+		case srcIsMap:
+			copied := make(map[string]interface{}, len(srcMap))
+			mergeAttributes(copied, srcMap)
+			dst[key] = copied
+		default:
+			dst[key] = value
+		}
+	}
+}
@@ -0,0 +1,239 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func nodeWithAttributes() *chef.Node {
+	node := nodeWithChefInstall()
+	node.RunList = []string{"recipe[web]", "role[base]"}
+	node.DefaultAttributes = map[string]interface{}{
+		"web": map[string]interface{}{"port": 80, "workers": 2},
+	}
+	node.NormalAttributes = map[string]interface{}{
+		"web": map[string]interface{}{"port": 8080},
+		"db":  map[string]interface{}{"password": "s3cr3t", "user": "app"},
+	}
+	node.OverrideAttributes = map[string]interface{}{
+		"web": map[string]interface{}{"workers": 8},
+	}
+	node.AutomaticAttributes["ec2"] = map[string]interface{}{
+		"iam": map[string]interface{}{"SecretAccessKey": "abc"},
+	}
+	return node
+}
+
+func readJSONFile(t *testing.T, path string) map[string]interface{} {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(content, &object); err != nil {
+		t.Fatal(err)
+	}
+	return object
+}
+
+func TestCapturer_CaptureNodeAttributes(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	nc := subject.NewNodeCapturer(
+		NodeMock{},
+		RoleMock{},
+		EnvMock{},
+		CookbookMock{},
+		DataBagMock{},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		&subject.ObjectWriter{RootDir: baseDir, Redactor: defaultRedactor()},
+	)
+
+	// only the normal attributes are captured by default
+	err = nc.CaptureNodeAttributes(nodeWithAttributes())
+	if assert.Nil(t, err) {
+		assert.Equal(t, map[string]interface{}{
+			"web": map[string]interface{}{"port": 8080.0},
+			"db":  map[string]interface{}{"password": subject.RedactedValue, "user": "app"},
+		}, readJSONFile(t, filepath.Join(baseDir, "dna", "node1.json")))
+
+		ohai := readJSONFile(t, filepath.Join(baseDir, "ohai", "node1.json"))
+		assert.Equal(t, "ubuntu", ohai["platform"])
+		assert.Equal(t, map[string]interface{}{
+			"iam": map[string]interface{}{"SecretAccessKey": subject.RedactedValue},
+		}, ohai["ec2"])
+	}
+
+	// levels are merged in precedence order
+	nc.SetAttributeOpts(subject.AttributeOpts{
+		Levels: []string{subject.AttributeLevelOverride, subject.AttributeLevelDefault},
+	})
+	err = nc.CaptureNodeAttributes(nodeWithAttributes())
+	if assert.Nil(t, err) {
+		assert.Equal(t, map[string]interface{}{
+			"web": map[string]interface{}{"port": 8080.0, "workers": 8.0},
+			"db":  map[string]interface{}{"password": subject.RedactedValue, "user": "app"},
+		}, readJSONFile(t, filepath.Join(baseDir, "dna", "node1.json")))
+	}
+}
+
+func TestCapturer_CaptureNodeAttributesWithoutRedaction(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	// the writer of a capture with --no-redaction
+	nc := subject.NewNodeCapturer(NodeMock{}, RoleMock{}, EnvMock{}, CookbookMock{}, DataBagMock{},
+		PolicyGroupMock{}, PolicyMock{}, CBAMock{}, &subject.ObjectWriter{RootDir: baseDir})
+	err = nc.CaptureNodeAttributes(nodeWithAttributes())
+	if assert.Nil(t, err) {
+		assert.Equal(t, map[string]interface{}{
+			"web": map[string]interface{}{"port": 8080.0},
+			"db":  map[string]interface{}{"password": "s3cr3t", "user": "app"},
+		}, readJSONFile(t, filepath.Join(baseDir, "dna", "node1.json")))
+	}
+}
+
+func TestCapturer_CaptureNodeAttributesWithFailedWrite(t *testing.T) {
+	nc := newKitchenCapturer(&ObjectWriterMock{Error: errors.New("disk full")}, subject.KitchenOpts{})
+	err := nc.CaptureNodeAttributes(nodeWithAttributes())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to save attributes of node 'node1'")
+		assert.Contains(t, err.Error(), "disk full")
+	}
+}
+
+func TestCapturer_SaveKitchenYMLWithAttributes(t *testing.T) {
+	writerMock := ObjectWriterMock{Redactor: defaultRedactor()}
+	nc := newKitchenCapturer(&writerMock, subject.KitchenOpts{})
+
+	err := nc.SaveKitchenYML(nodeWithAttributes())
+	expectedYML := `
+---
+driver:
+  name: vagrant
+
+provisioner:
+  name: chef_zero_capture
+  product_name: chef
+  product_version: 99.0
+  json_attributes: true
+  client_rb:
+    node_name: node1
+
+platforms:
+  - name: ubuntu-18.04
+    driver:
+      box: bento/ubuntu-18.04
+
+suites:
+  - name: node1
+    run_list: ["recipe[web]","role[base]"]
+    attributes: {"db":{"password":"**REDACTED**","user":"app"},"web":{"port":8080}}
+`
+	if assert.Nil(t, err) {
+		assert.Equal(t, expectedYML, string(writerMock.ReceivedObject.([]byte)))
+	}
+
+	// policyfile nodes get their run list from the policy
+	node := nodeWithAttributes()
+	node.PolicyName = "web"
+	node.PolicyGroup = "prod"
+	err = nc.SaveKitchenYML(node)
+	if assert.Nil(t, err) {
+		assert.NotContains(t, string(writerMock.ReceivedObject.([]byte)), "run_list")
+		assert.Contains(t, string(writerMock.ReceivedObject.([]byte)), "    attributes: {")
+	}
+}
+
+func TestCapturer_SaveMultiNodeKitchenYMLWithAttributes(t *testing.T) {
+	writerMock := ObjectWriterMock{Redactor: defaultRedactor()}
+	nc := newKitchenCapturer(&writerMock, subject.KitchenOpts{})
+
+	node2 := nodeWithChefInstall()
+	node2.Name = "node2"
+	err := nc.SaveMultiNodeKitchenYML([]*chef.Node{nodeWithAttributes(), node2})
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(writerMock.ReceivedObject.([]byte)), `
+suites:
+  - name: node1
+    provisioner:
+      product_version: 99.0
+      json_attributes: true
+      client_rb:
+        node_name: node1
+    run_list: ["recipe[web]","role[base]"]
+    attributes: {"db":{"password":"**REDACTED**","user":"app"},"web":{"port":8080}}
+    includes:
+      - ubuntu-18.04
+  - name: node2
+    provisioner:
+      product_version: 99.0
+      client_rb:
+        node_name: node2
+    includes:
+      - ubuntu-18.04
+`)
+	}
+}
+
+func TestCapturer_SaveKitchenYMLWithConfigKeyRule(t *testing.T) {
+	// a rule of the [redaction] section of the config.toml
+	rules := subject.DefaultRedactionRules
+	rules.Keys = append(append([]string{}, rules.Keys...), "^user$")
+	redactor, err := subject.NewRedactor(rules)
+	mustSucceed(err)
+
+	writerMock := ObjectWriterMock{Redactor: redactor}
+	nc := newKitchenCapturer(&writerMock, subject.KitchenOpts{})
+	err = nc.SaveKitchenYML(nodeWithAttributes())
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(writerMock.ReceivedObject.([]byte)),
+			`attributes: {"db":{"password":"**REDACTED**","user":"**REDACTED**"},"web":{"port":8080}}`)
+	}
+	assert.Equal(t, []subject.Redaction{
+		{File: "kitchen.yml", Path: "db.password", Rule: rules.Keys[0]},
+		{File: "kitchen.yml", Path: "db.user", Rule: "^user$"},
+	}, redactor.Redactions())
+
+	// nothing is masked without a redactor, i.e. with --no-redaction
+	writerMock = ObjectWriterMock{}
+	nc = newKitchenCapturer(&writerMock, subject.KitchenOpts{})
+	err = nc.SaveKitchenYML(nodeWithAttributes())
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(writerMock.ReceivedObject.([]byte)),
+			`attributes: {"db":{"password":"s3cr3t","user":"app"},"web":{"port":8080}}`)
+	}
+}
@@ -0,0 +1,124 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+)
+
+const chefSpecHelper = `# Generated by chef-analyze capture, the specs of every captured
+# node converge the cookbooks of this repository
+require 'chefspec'
+
+RSpec.configure do |config|
+  config.cookbook_path = [File.expand_path('../cookbooks', __dir__)]
+  config.role_path = File.expand_path('../roles', __dir__)
+  config.environment_path = File.expand_path('../environments', __dir__)
+  config.log_level = :error
+end
+`
+
+const chefSpecTmpl = `#
+# ChefSpec scaffold of recipe {{quote .Recipe}} generated by chef-analyze capture
+# from the captured data of node {{quote .NodeName}}:
+#
+#  - spec/fixtures/fauxhai/{{.NodeName}}.json: the ohai data of the node
+#  - dna/{{.NodeName}}.json: the captured attributes of the node
+#
+require_relative '../spec_helper'
+require 'json'
+
+describe {{quote .Recipe}} do
+  let(:chef_run) do
+    fixture = File.expand_path({{quote (print "../fixtures/fauxhai/" .NodeName ".json")}}, __dir__)
+    runner = ChefSpec::SoloRunner.new(path: fixture) do |node|
+      attributes = JSON.parse(File.read(File.expand_path({{quote (print "../../dna/" .NodeName ".json")}}, __dir__)))
+      attributes.each { |key, value| node.normal[key] = value }
+    end
+    runner.converge(described_recipe)
+  end
+
+  it 'converges successfully' do
+    expect { chef_run }.to_not raise_error
+  end
+end
+`
+
+type chefSpecArgs struct {
+	NodeName string
+	Recipe   string
+}
+
+// SaveChefSpec writes a fauxhai fixture with the ohai data of the node to
+// spec/fixtures/fauxhai/NODE.json and a ChefSpec scaffold for every recipe
+// to spec/NODE/COOKBOOK_RECIPE_spec.rb that converges the recipe with that
+// fixture and the captured attributes of the node
+func (nc *NodeCapturer) SaveChefSpec(node *chef.Node, recipes []string) error {
+	err := nc.writer.WriteJSON("spec/fixtures/fauxhai", node.Name, node.AutomaticAttributes)
+	if err != nil {
+		return errors.Wrapf(err, "unable to save fauxhai fixture of node '%s'", node.Name)
+	}
+
+	err = nc.writer.WriteContent("spec/spec_helper.rb", []byte(chefSpecHelper))
+	if err != nil {
+		return errors.Wrap(err, "unable to save ChefSpec helper")
+	}
+
+	tmpl, err := template.New("spec").Funcs(template.FuncMap{"quote": rubyQuote}).Parse(chefSpecTmpl)
+	if err != nil {
+		return errors.Wrap(err, "could not create new template")
+	}
+
+	written := make(map[string]bool)
+	for _, recipe := range recipes {
+		recipe = qualifiedRecipe(recipe)
+		if written[recipe] {
+			continue
+		}
+		written[recipe] = true
+
+		var spec bytes.Buffer
+		if err := tmpl.Execute(&spec, chefSpecArgs{node.Name, recipe}); err != nil {
+			return errors.Wrap(err, "failed to execute template")
+		}
+
+		fileName := fmt.Sprintf("spec/%s/%s_spec.rb", node.Name, strings.Replace(recipe, "::", "_", 1))
+		if err := nc.writer.WriteContent(fileName, spec.Bytes()); err != nil {
+			return errors.Wrapf(err, "unable to save ChefSpec of recipe '%s'", recipe)
+		}
+	}
+	return nil
+}
+
+// returns the recipe with its cookbook, "apache2" => "apache2::default"
+func qualifiedRecipe(recipe string) string {
+	if strings.Contains(recipe, "::") {
+		return recipe
+	}
+	return recipe + "::default"
+}
+
+// returns the string as a single quoted ruby string
+func rubyQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,90 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapturer_SaveChefSpec(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	nc := subject.NewNodeCapturer(
+		NodeMock{},
+		RoleMock{},
+		EnvMock{},
+		CookbookMock{},
+		DataBagMock{},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		&subject.ObjectWriter{RootDir: baseDir, Redactor: defaultRedactor()},
+	)
+	err = nc.SaveChefSpec(nodeWithAttributes(), []string{"web", "web::default", "db::server"})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	fixture := readJSONFile(t, filepath.Join(baseDir, "spec", "fixtures", "fauxhai", "node1.json"))
+	assert.Equal(t, "ubuntu", fixture["platform"])
+	assert.Equal(t, "18.04", fixture["platform_version"])
+	assert.Equal(t, map[string]interface{}{
+		"iam": map[string]interface{}{"SecretAccessKey": subject.RedactedValue},
+	}, fixture["ec2"])
+
+	helper, err := ioutil.ReadFile(filepath.Join(baseDir, "spec", "spec_helper.rb"))
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(helper), "config.cookbook_path = [File.expand_path('../cookbooks', __dir__)]")
+	}
+
+	specs, err := ioutil.ReadDir(filepath.Join(baseDir, "spec", "node1"))
+	if assert.Nil(t, err) {
+		names := make([]string, 0, len(specs))
+		for _, spec := range specs {
+			names = append(names, spec.Name())
+		}
+		assert.Equal(t, []string{"db_server_spec.rb", "web_default_spec.rb"}, names)
+	}
+
+	spec, err := ioutil.ReadFile(filepath.Join(baseDir, "spec", "node1", "web_default_spec.rb"))
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(spec), "describe 'web::default' do")
+		assert.Contains(t, string(spec), "fixture = File.expand_path('../fixtures/fauxhai/node1.json', __dir__)")
+		assert.Contains(t, string(spec), "File.read(File.expand_path('../../dna/node1.json', __dir__))")
+		assert.Contains(t, string(spec), "runner.converge(described_recipe)")
+	}
+}
+
+func TestCapturer_SaveChefSpecWithFailedWrite(t *testing.T) {
+	nc := newKitchenCapturer(&ObjectWriterMock{Error: errors.New("disk full")}, subject.KitchenOpts{})
+	err := nc.SaveChefSpec(nodeWithAttributes(), []string{"web"})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to save fauxhai fixture of node 'node1'")
+		assert.Contains(t, err.Error(), "disk full")
+	}
+}
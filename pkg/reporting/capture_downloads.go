@@ -0,0 +1,148 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultDownloadWorkers is the number of cookbooks a capturer
+// downloads in parallel unless SetDownloadWorkers is called
+const DefaultDownloadWorkers = 10
+
+// a cookbook (or cookbook artifact) to download into the repository
+type cookbookDownload struct {
+	// reported to the ObjectProgressFunc, i.e. name-version
+	Object string
+	// the directory the download creates and the one it is renamed to
+	Downloaded string
+	Target     string
+	// downloads the cookbook into the provided directory, the
+	// error must name the cookbook it failed to download
+	download func(dir string) error
+}
+
+// SetDownloadWorkers sets the maximum number of cookbooks downloaded in parallel
+func (nc *NodeCapturer) SetDownloadWorkers(workers int) {
+	nc.downloadWorkers = workers
+}
+
+// downloads the cookbooks into dir with a pool of workers, every cookbook is
+// downloaded into a staging directory and renamed into place once complete so
+// that a failed download leaves nothing behind (nor removes a previous
+// capture), every download is attempted and the error lists each of the
+// cookbooks that failed
+func (nc *NodeCapturer) downloadCookbooks(dir string, downloads []cookbookDownload, progress ObjectProgressFunc) error {
+	if len(downloads) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "unable to create directory %s", dir)
+	}
+	// the staging directory lives next to (not inside) the cookbooks
+	// directory, on the same filesystem, so that the renames are atomic
+	stagingDir, err := ioutil.TempDir(filepath.Dir(dir), ".download-")
+	if err != nil {
+		return errors.Wrap(err, "unable to create download directory")
+	}
+	defer os.RemoveAll(stagingDir)
+
+	// determine how many workers do we need, by default, the total number of
+	// downloads, but never more than the maximum allowed
+	numWorkers := nc.downloadWorkers
+	if numWorkers <= 0 {
+		numWorkers = DefaultDownloadWorkers
+	}
+	if len(downloads) < numWorkers {
+		numWorkers = len(downloads)
+	}
+
+	var (
+		jobsCh   = make(chan cookbookDownload, len(downloads))
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		started  int
+		failures = make([]string, 0)
+	)
+	for _, job := range downloads {
+		jobsCh <- job
+	}
+	close(jobsCh)
+
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobsCh {
+				// the index is the order the downloads start in
+				mutex.Lock()
+				started++
+				progress.report(job.Object, started, len(downloads))
+				mutex.Unlock()
+
+				if err := downloadCookbook(dir, stagingDir, job); err != nil {
+					mutex.Lock()
+					failures = append(failures, err.Error())
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(failures) != 0 {
+		sort.Strings(failures)
+		return errors.Errorf("unable to download %d cookbook(s):\n  - %s",
+			len(failures), strings.Join(failures, "\n  - "))
+	}
+	return nil
+}
+
+// downloads a cookbook into the staging directory and renames it into dir, a
+// previous download of the cookbook is moved into the staging directory first
+// and restored if the rename fails
+func downloadCookbook(dir, stagingDir string, job cookbookDownload) error {
+	if err := job.download(stagingDir); err != nil {
+		return err
+	}
+
+	var (
+		target   = filepath.Join(dir, job.Target)
+		previous = filepath.Join(stagingDir, ".previous-"+job.Target)
+		replaced = false
+	)
+	if _, err := os.Lstat(target); err == nil {
+		if err := os.Rename(target, previous); err != nil {
+			return errors.Wrapf(err, "failed to replace previous capture of cookbook %s", job.Target)
+		}
+		replaced = true
+	}
+	if err := os.Rename(filepath.Join(stagingDir, job.Downloaded), target); err != nil {
+		if replaced {
+			_ = os.Rename(previous, target)
+		}
+		return errors.Wrapf(err, "failed to rename cookbook %s to '%s'", job.Downloaded, job.Target)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CaptureStage is a stage of a capture
+type CaptureStage int
+
+// Stages of a capture, in the order they run
+const (
+	FetchingNode CaptureStage = iota
+	FetchingPolicyData
+	FetchingEnvironment
+	FetchingRoles
+	FetchingDataBags
+	FetchingCookbooks
+	FetchingCookbookArtifacts
+	WritingKitchenConfig
+	WritingChefSpec
+	WritingPolicyfile
+	WritingEffortlessPackage
+	CaptureComplete
+)
+
+var captureStageNames = map[CaptureStage]string{
+	FetchingNode:              "fetching_node",
+	FetchingPolicyData:        "fetching_policy_data",
+	FetchingEnvironment:       "fetching_environment",
+	FetchingRoles:             "fetching_roles",
+	FetchingDataBags:          "fetching_data_bags",
+	FetchingCookbooks:         "fetching_cookbooks",
+	FetchingCookbookArtifacts: "fetching_cookbook_artifacts",
+	WritingKitchenConfig:      "writing_kitchen_config",
+	WritingChefSpec:           "writing_chefspec",
+	WritingPolicyfile:         "writing_policyfile",
+	WritingEffortlessPackage:  "writing_effortless_package",
+	CaptureComplete:           "capture_complete",
+}
+
+func (s CaptureStage) String() string {
+	if name, ok := captureStageNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("stage_%d", int(s))
+}
+
+// MarshalText encodes the stage by its name, i.e. in JSON events
+func (s CaptureStage) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// CaptureEvent is a progress event of a capture, an event is published when a
+// stage starts, for every object captured by the stage and when a stage fails
+type CaptureEvent struct {
+	Stage CaptureStage
+	// the node the event belongs to, empty for the events of a
+	// MultiNodeCapture that apply to the repository as a whole
+	Node string
+	// the object being captured (e.g. a cookbook), empty when the stage starts
+	Object string
+	// position of the object among the Total objects of the stage, from 1
+	Index int
+	Total int
+	// set when the stage failed, it is the last event of the node
+	Error error
+}
+
+// IsObject returns true if the event is the capture of an object of a stage
+func (e CaptureEvent) IsObject() bool {
+	return e.Object != ""
+}
+
+// MarshalJSON encodes the event as an object with the error as a string
+func (e CaptureEvent) MarshalJSON() ([]byte, error) {
+	event := struct {
+		Stage  CaptureStage `json:"stage"`
+		Node   string       `json:"node,omitempty"`
+		Object string       `json:"object,omitempty"`
+		Index  int          `json:"index,omitempty"`
+		Total  int          `json:"total,omitempty"`
+		Error  string       `json:"error,omitempty"`
+	}{e.Stage, e.Node, e.Object, e.Index, e.Total, ""}
+	if e.Error != nil {
+		event.Error = e.Error.Error()
+	}
+	return json.Marshal(event)
+}
+
+// ObjectProgressFunc is called by the capturer before it captures each of the
+// objects of a stage, index goes from 1 to total, a nil ObjectProgressFunc
+// doesn't report anything
+type ObjectProgressFunc func(object string, index, total int)
+
+func (f ObjectProgressFunc) report(object string, index, total int) {
+	if f != nil {
+		f(object, index, total)
+	}
+}
+
+// publishes the event of the node on the Progress channel
+func (nc *NodeCapture) emit(event CaptureEvent) {
+	event.Node = nc.name
+	nc.Progress <- event
+}
+
+// publishes the start of a stage
+func (nc *NodeCapture) startStage(stage CaptureStage) {
+	nc.emit(CaptureEvent{Stage: stage})
+}
+
+// returns the ObjectProgressFunc that publishes the objects of a stage
+func (nc *NodeCapture) objectProgress(stage CaptureStage) ObjectProgressFunc {
+	return func(object string, index, total int) {
+		nc.emit(CaptureEvent{Stage: stage, Object: object, Index: index, Total: total})
+	}
+}
+
+// fails the capture at the provided stage
+func (nc *NodeCapture) fail(stage CaptureStage, err error) {
+	nc.Error = err
+	nc.emit(CaptureEvent{Stage: stage, Error: err})
+}
@@ -0,0 +1,107 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+)
+
+func TestCaptureStage_String(t *testing.T) {
+	assert.Equal(t, "fetching_cookbooks", subject.FetchingCookbooks.String())
+	assert.Equal(t, "capture_complete", subject.CaptureComplete.String())
+	assert.Equal(t, "stage_99", subject.CaptureStage(99).String())
+}
+
+func TestCaptureEvent_MarshalJSON(t *testing.T) {
+	event, err := json.Marshal(subject.CaptureEvent{
+		Stage: subject.FetchingCookbooks, Node: "node1", Object: "foo-1.0.0", Index: 1, Total: 3,
+	})
+	if assert.Nil(t, err) {
+		assert.JSONEq(t,
+			`{"stage":"fetching_cookbooks","node":"node1","object":"foo-1.0.0","index":1,"total":3}`,
+			string(event))
+	}
+
+	event, err = json.Marshal(subject.CaptureEvent{Stage: subject.FetchingRoles, Error: errors.New("no role")})
+	if assert.Nil(t, err) {
+		assert.JSONEq(t, `{"stage":"fetching_roles","error":"no role"}`, string(event))
+	}
+}
+
+func TestCapture_RunObjectEvents(t *testing.T) {
+	capturer := &CapturerMock{
+		NodeReturn:     defaultNode(),
+		CookbookReturn: []subject.NodeCookbook{{"foo", "1.0.0"}, {"bar", "2.0.0"}},
+	}
+	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{}, capturer)
+
+	objects := make([]subject.CaptureEvent, 0)
+	for _, event := range captureEvents(nc) {
+		assert.Equal(t, "node1", event.Node)
+		if event.IsObject() {
+			objects = append(objects, event)
+		}
+	}
+	assert.Equal(t, []subject.CaptureEvent{
+		{Stage: subject.FetchingCookbooks, Node: "node1", Object: "foo-1.0.0", Index: 1, Total: 2},
+		{Stage: subject.FetchingCookbooks, Node: "node1", Object: "bar-2.0.0", Index: 2, Total: 2},
+	}, objects)
+}
+
+func TestCapture_RunFailureEvent(t *testing.T) {
+	capturer := &CapturerMock{NodeReturn: defaultNode(), RoleErrorReturn: errors.New("no role")}
+	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{}, capturer)
+
+	events := captureEvents(nc)
+	// the failure is the last event, the capture is not completed
+	last := events[len(events)-1]
+	assert.Equal(t, subject.FetchingRoles, last.Stage)
+	if assert.NotNil(t, last.Error) {
+		assert.Equal(t, nc.Error, last.Error)
+		assert.Contains(t, last.Error.Error(), "unable to capture role(s)")
+	}
+}
+
+func TestCapturer_CaptureRoleObjectsProgress(t *testing.T) {
+	nc := subject.NewNodeCapturer(
+		NodeMock{},
+		RoleMock{Role: &chef.Role{Name: "base"}},
+		EnvMock{},
+		CookbookMock{},
+		DataBagMock{},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		&ObjectWriterMock{},
+	)
+
+	reported := make([]string, 0)
+	err := nc.CaptureRoleObjects([]string{"role[base]", "recipe[foo]", "role[web]"}, func(object string, index, total int) {
+		reported = append(reported, fmt.Sprintf("%s %d/%d", object, index, total))
+	})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"base 1/2", "web 2/2"}, reported)
+	}
+}
@@ -0,0 +1,186 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CaptureManifestFile is the name of the file, at the root of a captured
+// repository, that records what was captured
+const CaptureManifestFile = "capture-manifest.json"
+
+// CaptureManifest records the objects and cookbooks captured into a
+// repository, it is used to refresh the repository and report what changed
+type CaptureManifest struct {
+	CapturedAt time.Time `json:"captured_at"`
+	// the nodes captured into the repository
+	Nodes []string `json:"nodes"`
+	// the nodes that could not be captured, they are retried on refresh
+	FailedNodes []string `json:"failed_nodes,omitempty"`
+	// files relative to the repository and the sha256 of their content
+	Objects map[string]string `json:"objects"`
+	// cookbook directories relative to the repository and their version
+	// (or identifier for cookbook artifacts)
+	Cookbooks map[string]string `json:"cookbooks"`
+	// sha256 of the URL of the Chef Infra Server the nodes were captured from,
+	// the URL itself is not recorded so archives can be shared
+	ChefServerURLHash string `json:"chef_server_url_sha256,omitempty"`
+	// cookbook directories relative to the repository and where they were sourced
+	// from, one of CookbookSourceChefServer or CookbookSourceLocal, recorded by archives
+	CookbookSources map[string]string `json:"cookbook_sources,omitempty"`
+}
+
+// CaptureChanges are the differences between two captures of a repository
+type CaptureChanges struct {
+	Added     []string
+	Updated   []string
+	Removed   []string
+	Unchanged int
+}
+
+// NewCaptureManifest creates a manifest of the provided nodes, written
+// objects, cookbooks and cookbook artifacts
+func NewCaptureManifest(nodes []string, objects map[string]string, cookbooks, cookbookLocks []NodeCookbook) *CaptureManifest {
+	manifest := &CaptureManifest{
+		CapturedAt: time.Now().UTC(),
+		Nodes:      nodes,
+		Objects:    objects,
+		Cookbooks:  make(map[string]string, len(cookbooks)+len(cookbookLocks)),
+	}
+	for _, cb := range cookbooks {
+		manifest.Cookbooks[fmt.Sprintf("cookbooks/%s", cb.Name)] = cb.Version
+	}
+	for _, cb := range cookbookLocks {
+		manifest.Cookbooks[fmt.Sprintf("cookbook_artifacts/%s-%s", cb.Name, cb.Version)] = cb.Version
+	}
+	return manifest
+}
+
+// SetChefServerURL records the hash of the URL of the Chef Infra Server
+func (cm *CaptureManifest) SetChefServerURL(url string) {
+	cm.ChefServerURLHash = fmt.Sprintf("%x", sha256.Sum256([]byte(url)))
+}
+
+// LoadCaptureManifest loads the manifest of a captured repository
+func LoadCaptureManifest(repositoryDir string) (*CaptureManifest, error) {
+	path := filepath.Join(repositoryDir, CaptureManifestFile)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read capture manifest '%s'", path)
+	}
+
+	var manifest CaptureManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse capture manifest '%s'", path)
+	}
+	return &manifest, nil
+}
+
+// Save writes the manifest into the provided repository
+func (cm *CaptureManifest) Save(repositoryDir string) error {
+	content, err := json.MarshalIndent(cm, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "unable to generate capture manifest")
+	}
+
+	path := filepath.Join(repositoryDir, CaptureManifestFile)
+	if err := ioutil.WriteFile(path, append(content, '\n'), 0600); err != nil {
+		return errors.Wrapf(err, "unable to write capture manifest '%s'", path)
+	}
+	return nil
+}
+
+// ModifiedLocally returns true if the file, relative to the repository, was
+// modified after it was captured
+func (cm *CaptureManifest) ModifiedLocally(repositoryDir, file string) bool {
+	captured, ok := cm.Objects[file]
+	if !ok {
+		return false
+	}
+	content, err := ioutil.ReadFile(filepath.Join(repositoryDir, file))
+	if err != nil {
+		return false
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(content)) != captured
+}
+
+// ModifiedFiles returns the files of the capture, relative to the repository,
+// that were modified after it was captured
+func (cm *CaptureManifest) ModifiedFiles(repositoryDir string) []string {
+	modified := make([]string, 0)
+	for file := range cm.Objects {
+		if cm.ModifiedLocally(repositoryDir, file) {
+			modified = append(modified, file)
+		}
+	}
+	sort.Strings(modified)
+	return modified
+}
+
+// Changes returns what changed in this manifest since the previous one
+func (cm *CaptureManifest) Changes(previous *CaptureManifest) CaptureChanges {
+	changes := CaptureChanges{
+		Added:   make([]string, 0),
+		Updated: make([]string, 0),
+		Removed: make([]string, 0),
+	}
+
+	compare := func(current, before map[string]string, describe func(string, string, string) string) {
+		for name, value := range current {
+			previousValue, ok := before[name]
+			switch {
+			case !ok:
+				changes.Added = append(changes.Added, name)
+			case previousValue != value:
+				changes.Updated = append(changes.Updated, describe(name, previousValue, value))
+			default:
+				changes.Unchanged++
+			}
+		}
+		for name := range before {
+			if _, ok := current[name]; !ok {
+				changes.Removed = append(changes.Removed, name)
+			}
+		}
+	}
+
+	compare(cm.Objects, previous.Objects, func(name, _, _ string) string {
+		return name
+	})
+	compare(cm.Cookbooks, previous.Cookbooks, func(name, before, after string) string {
+		return fmt.Sprintf("%s (%s -> %s)", name, before, after)
+	})
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Updated)
+	sort.Strings(changes.Removed)
+	return changes
+}
+
+// HasChanges returns true if anything was added, updated or removed
+func (cc CaptureChanges) HasChanges() bool {
+	return len(cc.Added)+len(cc.Updated)+len(cc.Removed) > 0
+}
@@ -0,0 +1,155 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureManifest_SaveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := subject.NewCaptureManifest(
+		[]string{"node1"},
+		map[string]string{"nodes/node1.json": "abc"},
+		[]subject.NodeCookbook{{Name: "foo", Version: "1.0.0"}},
+		[]subject.NodeCookbook{{Name: "bar", Version: "0123456789"}},
+	)
+	assert.Equal(t, map[string]string{
+		"cookbooks/foo":                     "1.0.0",
+		"cookbook_artifacts/bar-0123456789": "0123456789",
+	}, manifest.Cookbooks)
+	manifest.FailedNodes = []string{"node2"}
+
+	if assert.Nil(t, manifest.Save(dir)) {
+		loaded, err := subject.LoadCaptureManifest(dir)
+		if assert.Nil(t, err) {
+			assert.Equal(t, manifest.Nodes, loaded.Nodes)
+			assert.Equal(t, []string{"node2"}, loaded.FailedNodes)
+			assert.Equal(t, manifest.Objects, loaded.Objects)
+			assert.Equal(t, manifest.Cookbooks, loaded.Cookbooks)
+			assert.True(t, manifest.CapturedAt.Equal(loaded.CapturedAt))
+		}
+	}
+
+	_, err = subject.LoadCaptureManifest(filepath.Join(dir, "missing"))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to read capture manifest")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, subject.CaptureManifestFile), []byte("{"), 0600)
+	if err != nil {
+		panic(err)
+	}
+	_, err = subject.LoadCaptureManifest(dir)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to parse capture manifest")
+	}
+}
+
+func TestCaptureManifest_ModifiedLocally(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer := &subject.ObjectWriter{RootDir: dir}
+	if err := writer.WriteContent("kitchen.yml", []byte("driver: vagrant\n")); err != nil {
+		panic(err)
+	}
+	manifest := subject.NewCaptureManifest([]string{"node1"}, writer.Written(), nil, nil)
+	assert.False(t, manifest.ModifiedLocally(dir, "kitchen.yml"))
+	assert.False(t, manifest.ModifiedLocally(dir, "not-captured.yml"))
+
+	err = ioutil.WriteFile(filepath.Join(dir, "kitchen.yml"), []byte("driver: ec2\n"), 0600)
+	if err != nil {
+		panic(err)
+	}
+	assert.True(t, manifest.ModifiedLocally(dir, "kitchen.yml"))
+}
+
+func TestCaptureManifest_ModifiedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer := &subject.ObjectWriter{RootDir: dir}
+	for _, file := range []string{"kitchen.yml", "spec/unit/node1_spec.rb", "policyfiles/node1.rb"} {
+		if err := writer.WriteContent(file, []byte("captured\n")); err != nil {
+			panic(err)
+		}
+	}
+	manifest := subject.NewCaptureManifest([]string{"node1"}, writer.Written(), nil, nil)
+	assert.Empty(t, manifest.ModifiedFiles(dir))
+
+	for _, file := range []string{"spec/unit/node1_spec.rb", "kitchen.yml"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte("edited\n"), 0600); err != nil {
+			panic(err)
+		}
+	}
+	assert.Equal(t, []string{"kitchen.yml", "spec/unit/node1_spec.rb"}, manifest.ModifiedFiles(dir))
+}
+
+func TestCaptureManifest_Changes(t *testing.T) {
+	previous := &subject.CaptureManifest{
+		Objects: map[string]string{
+			"nodes/node1.json":       "a",
+			"roles/base.json":        "b",
+			"roles/old.json":         "c",
+			"environments/prod.json": "d",
+		},
+		Cookbooks: map[string]string{
+			"cookbooks/foo": "1.0.0",
+			"cookbooks/bar": "2.0.0",
+		},
+	}
+	current := &subject.CaptureManifest{
+		Objects: map[string]string{
+			"nodes/node1.json":       "a2",
+			"roles/base.json":        "b",
+			"roles/new.json":         "e",
+			"environments/prod.json": "d",
+		},
+		Cookbooks: map[string]string{
+			"cookbooks/foo": "1.1.0",
+			"cookbooks/bar": "2.0.0",
+			"cookbooks/baz": "0.1.0",
+		},
+	}
+
+	changes := current.Changes(previous)
+	assert.True(t, changes.HasChanges())
+	assert.Equal(t, []string{"cookbooks/baz", "roles/new.json"}, changes.Added)
+	assert.Equal(t, []string{"cookbooks/foo (1.0.0 -> 1.1.0)", "nodes/node1.json"}, changes.Updated)
+	assert.Equal(t, []string{"roles/old.json"}, changes.Removed)
+	assert.Equal(t, 3, changes.Unchanged)
+
+	assert.False(t, previous.Changes(previous).HasChanges())
+}
@@ -0,0 +1,628 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+)
+
+// MultiNodeCaptureInterface is a NodeCaptureInterface that can also write
+// a kitchen config with one suite per captured node
+type MultiNodeCaptureInterface interface {
+	NodeCaptureInterface
+	SaveMultiNodeKitchenYML(nodes []*chef.Node) error
+}
+
+// CookbookConflict is a cookbook that nodes use in different versions, only
+// one version of a cookbook fits in the cookbooks directory of a repository
+type CookbookConflict struct {
+	Name      string
+	Captured  string
+	Requested string
+}
+
+// MultiNodeCapture captures several nodes into a single repository, the objects
+// the nodes share (cookbooks, roles, environments, data bags, policies) are
+// captured only once
+type MultiNodeCapture struct {
+	// one capture per node, in the order the node names were provided
+	Captures  []*NodeCapture
+	Conflicts []CookbookConflict
+	// the events of all nodes, see CaptureEvent, the channel is
+	// unbuffered and closed once the capture is complete
+	Progress chan CaptureEvent
+	// set when the repository could not be completed, failures of
+	// individual nodes are set in the Error of their capture
+	Error    error
+	capturer MultiNodeCaptureInterface
+	shared   *sharedCapturer
+	workers  int
+}
+
+// NewMultiNodeCapture creates a capture of the provided nodes into repositoryDir
+// that captures up to `workers` nodes in parallel
+func NewMultiNodeCapture(
+	names []string,
+	repositoryDir string,
+	opts CaptureOpts,
+	capturer MultiNodeCaptureInterface,
+	workers int,
+) *MultiNodeCapture {
+	if workers < 1 {
+		workers = 1
+	}
+	shared := newSharedCapturer(capturer)
+	mnc := &MultiNodeCapture{
+		Captures: make([]*NodeCapture, 0, len(names)),
+		Progress: make(chan CaptureEvent),
+		capturer: capturer,
+		shared:   shared,
+		workers:  workers,
+	}
+	for _, name := range names {
+		mnc.Captures = append(mnc.Captures, NewNodeCapture(name, repositoryDir, opts, shared))
+	}
+	return mnc
+}
+
+// Run captures all nodes and publishes the progress of every node on the Progress
+// channel, which is closed once the capture is complete
+func (mnc *MultiNodeCapture) Run() {
+	defer func() { close(mnc.Progress) }()
+
+	var (
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, mnc.workers)
+	)
+	for _, nc := range mnc.Captures {
+		wg.Add(1)
+		go func(nc *NodeCapture) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			go nc.Run()
+			for event := range nc.Progress {
+				mnc.Progress <- event
+			}
+		}(nc)
+	}
+	wg.Wait()
+
+	mnc.Conflicts = mnc.shared.cookbookConflicts()
+
+	nodes := mnc.shared.kitchenNodes()
+	if len(nodes) == 0 {
+		mnc.Error = errors.New("unable to capture any of the nodes")
+		return
+	}
+
+	mnc.Progress <- CaptureEvent{Stage: WritingKitchenConfig}
+	err := mnc.capturer.SaveMultiNodeKitchenYML(nodes)
+	if err != nil {
+		mnc.Error = errors.Wrap(err, "unable to write Kitchen config")
+		mnc.Progress <- CaptureEvent{Stage: WritingKitchenConfig, Error: mnc.Error}
+		return
+	}
+	mnc.Progress <- CaptureEvent{Stage: CaptureComplete}
+}
+
+// Failed returns the captures that failed
+func (mnc *MultiNodeCapture) Failed() []*NodeCapture {
+	failed := make([]*NodeCapture, 0)
+	for _, nc := range mnc.Captures {
+		if nc.Error != nil {
+			failed = append(failed, nc)
+		}
+	}
+	return failed
+}
+
+// FailedNodes returns the names of the nodes that could not be captured
+func (mnc *MultiNodeCapture) FailedNodes() []string {
+	names := make([]string, 0)
+	for _, nc := range mnc.Failed() {
+		names = append(names, nc.name)
+	}
+	return names
+}
+
+// Cookbooks returns the cookbooks captured for all nodes, without duplicates
+func (mnc *MultiNodeCapture) Cookbooks() []NodeCookbook {
+	var (
+		cookbooks = make([]NodeCookbook, 0)
+		seen      = make(map[NodeCookbook]bool)
+	)
+	for _, nc := range mnc.Captures {
+		for _, cb := range nc.Cookbooks {
+			if !seen[cb] {
+				seen[cb] = true
+				cookbooks = append(cookbooks, cb)
+			}
+		}
+	}
+	return cookbooks
+}
+
+// CookbookLocks returns the cookbook artifacts captured for all the policy
+// managed nodes, without duplicates
+func (mnc *MultiNodeCapture) CookbookLocks() []NodeCookbook {
+	var (
+		cookbooks = make([]NodeCookbook, 0)
+		seen      = make(map[NodeCookbook]bool)
+	)
+	for _, nc := range mnc.Captures {
+		if nc.Policy == nil {
+			continue
+		}
+		for name, lock := range nc.Policy.CookbookLocks {
+			cb := NodeCookbook{Name: name, Version: lock.Identifier}
+			if !seen[cb] {
+				seen[cb] = true
+				cookbooks = append(cookbooks, cb)
+			}
+		}
+	}
+	return cookbooks
+}
+
+// the result of an object that is captured once and shared by all nodes,
+// done is closed once the value and the error are set
+type sharedCapture struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// sharedCapturer wraps a capturer to capture every shared object only once,
+// it is safe to use from several node captures at the same time
+type sharedCapturer struct {
+	capturer  NodeCaptureInterface
+	mutex     sync.Mutex
+	captured  map[string]*sharedCapture
+	conflicts map[CookbookConflict]bool
+	nodes     []*chef.Node
+}
+
+func newSharedCapturer(capturer NodeCaptureInterface) *sharedCapturer {
+	return &sharedCapturer{
+		capturer:  capturer,
+		captured:  make(map[string]*sharedCapture),
+		conflicts: make(map[CookbookConflict]bool),
+		nodes:     make([]*chef.Node, 0),
+	}
+}
+
+// runs fn once per key, concurrent callers of the same key wait for the
+// first call to finish and all of them get its result
+func (sc *sharedCapturer) once(key string, fn func() (interface{}, error)) (interface{}, error) {
+	values, err := sc.onceAll([]string{key}, func([]string) (map[string]interface{}, error) {
+		value, err := fn()
+		return map[string]interface{}{key: value}, err
+	})
+	return values[key], err
+}
+
+// like once for a batch of keys, fn is called a single time with the keys that
+// no other caller captured (or is capturing) yet and returns their values, the
+// values of every key are returned once all of them are captured
+func (sc *sharedCapturer) onceAll(keys []string, fn func(keys []string) (map[string]interface{}, error)) (map[string]interface{}, error) {
+	var (
+		captures = make([]*sharedCapture, len(keys))
+		claimed  = make([]string, 0)
+		owned    = make(map[string]*sharedCapture)
+	)
+	sc.mutex.Lock()
+	for i, key := range keys {
+		capture, ok := sc.captured[key]
+		if !ok {
+			capture = &sharedCapture{done: make(chan struct{})}
+			sc.captured[key] = capture
+			claimed = append(claimed, key)
+			owned[key] = capture
+		}
+		captures[i] = capture
+	}
+	sc.mutex.Unlock()
+
+	if len(claimed) != 0 {
+		values, err := fn(claimed)
+		for key, capture := range owned {
+			capture.value, capture.err = values[key], err
+			close(capture.done)
+		}
+	}
+
+	values := make(map[string]interface{}, len(keys))
+	for i, key := range keys {
+		<-captures[i].done
+		if captures[i].err != nil {
+			return nil, captures[i].err
+		}
+		values[key] = captures[i].value
+	}
+	return values, nil
+}
+
+func (sc *sharedCapturer) CaptureNodeObject(name string) (*chef.Node, error) {
+	return sc.capturer.CaptureNodeObject(name)
+}
+
+func (sc *sharedCapturer) ExpandRunList(node *chef.Node) (*ExpandedRunList, error) {
+	return sc.capturer.ExpandRunList(node)
+}
+
+// the cookbooks that no other node captured yet are downloaded in a single batch,
+// the progress only reports those downloads
+func (sc *sharedCapturer) CaptureCookbooks(repositoryDir string, cookbooks map[string]interface{}, progress ObjectProgressFunc) ([]NodeCookbook, error) {
+	names := make([]string, 0, len(cookbooks))
+	keys := make([]string, 0, len(cookbooks))
+	for name := range cookbooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		keys = append(keys, "cookbook:"+name)
+	}
+
+	captured, err := sc.onceAll(keys, func(claimed []string) (map[string]interface{}, error) {
+		var (
+			requested = make(map[string]interface{}, len(claimed))
+			versions  = make(map[string]interface{}, len(claimed))
+		)
+		for _, key := range claimed {
+			name := strings.TrimPrefix(key, "cookbook:")
+			requested[name] = cookbooks[name]
+			versions[key] = safeStringFromMap(cookbooks[name].(map[string]interface{}), "version")
+		}
+		if _, err := sc.capturer.CaptureCookbooks(repositoryDir, requested, progress); err != nil {
+			return nil, err
+		}
+		return versions, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	outCookbooks := make([]NodeCookbook, 0, len(names))
+	for i, name := range names {
+		var (
+			version         = safeStringFromMap(cookbooks[name].(map[string]interface{}), "version")
+			capturedVersion = captured[keys[i]].(string)
+		)
+		if capturedVersion != version {
+			sc.mutex.Lock()
+			sc.conflicts[CookbookConflict{Name: name, Captured: capturedVersion, Requested: version}] = true
+			sc.mutex.Unlock()
+		}
+		outCookbooks = append(outCookbooks, NodeCookbook{Name: name, Version: capturedVersion})
+	}
+	return outCookbooks, nil
+}
+
+// like CaptureCookbooks, the artifacts not captured yet are downloaded in a single batch
+func (sc *sharedCapturer) CaptureCookbookArtifacts(repositoryDir string, policy *chef.RevisionDetailsResponse, progress ObjectProgressFunc) error {
+	var (
+		keys  = make([]string, 0, len(policy.CookbookLocks))
+		names = make(map[string]string, len(policy.CookbookLocks))
+	)
+	for name, lock := range policy.CookbookLocks {
+		key := fmt.Sprintf("cookbook_artifact:%s-%s", name, lock.Identifier)
+		keys = append(keys, key)
+		names[key] = name
+	}
+	sort.Strings(keys)
+
+	_, err := sc.onceAll(keys, func(claimed []string) (map[string]interface{}, error) {
+		requested := &chef.RevisionDetailsResponse{
+			RevisionID:    policy.RevisionID,
+			Name:          policy.Name,
+			CookbookLocks: make(map[string]chef.CookbookLock, len(claimed)),
+		}
+		for _, key := range claimed {
+			requested.CookbookLocks[names[key]] = policy.CookbookLocks[names[key]]
+		}
+		return nil, sc.capturer.CaptureCookbookArtifacts(repositoryDir, requested, progress)
+	})
+	return err
+}
+
+func (sc *sharedCapturer) CaptureEnvObject(environment string) error {
+	_, err := sc.once("environment:"+environment, func() (interface{}, error) {
+		return nil, sc.capturer.CaptureEnvObject(environment)
+	})
+	return err
+}
+
+func (sc *sharedCapturer) CaptureRoleObjects(runList []string, progress ObjectProgressFunc) error {
+	roleNames := filterRoles(runList)
+	for i, roleName := range roleNames {
+		progress.report(roleName, i+1, len(roleNames))
+		roleItem := fmt.Sprintf("role[%s]", roleName)
+		_, err := sc.once("role:"+roleName, func() (interface{}, error) {
+			return nil, sc.capturer.CaptureRoleObjects([]string{roleItem}, nil)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sc *sharedCapturer) CapturePolicyObject(policyName string, revision string) (*chef.RevisionDetailsResponse, error) {
+	policy, err := sc.once(fmt.Sprintf("policy:%s:%s", policyName, revision), func() (interface{}, error) {
+		return sc.capturer.CapturePolicyObject(policyName, revision)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return policy.(*chef.RevisionDetailsResponse), nil
+}
+
+func (sc *sharedCapturer) CapturePolicyGroupObject(groupName string) (*chef.PolicyGroup, error) {
+	group, err := sc.once("policy_group:"+groupName, func() (interface{}, error) {
+		return sc.capturer.CapturePolicyGroupObject(groupName)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return group.(*chef.PolicyGroup), nil
+}
+
+// the items of the data bags are only reported to the node that captures them
+func (sc *sharedCapturer) CaptureAllDataBagItems(progress ObjectProgressFunc) error {
+	_, err := sc.once("data_bags", func() (interface{}, error) {
+		return nil, sc.capturer.CaptureAllDataBagItems(progress)
+	})
+	return err
+}
+
+func (sc *sharedCapturer) CaptureDataBags(names []string, progress ObjectProgressFunc) error {
+	for _, name := range names {
+		_, err := sc.once("data_bag:"+name, func() (interface{}, error) {
+			return nil, sc.capturer.CaptureDataBags([]string{name}, progress)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sc *sharedCapturer) SavePolicyfile(node *chef.Node, expanded *ExpandedRunList, cookbooks []NodeCookbook) error {
+	return sc.capturer.SavePolicyfile(node, expanded, cookbooks)
+}
+
+func (sc *sharedCapturer) SaveEffortlessPackage(group string, policy *chef.RevisionDetailsResponse) error {
+	_, err := sc.once(fmt.Sprintf("effortless:%s:%s", policy.Name, group), func() (interface{}, error) {
+		return nil, sc.capturer.SaveEffortlessPackage(group, policy)
+	})
+	return err
+}
+
+func (sc *sharedCapturer) SaveChefSpec(node *chef.Node, recipes []string) error {
+	return sc.capturer.SaveChefSpec(node, recipes)
+}
+
+func (sc *sharedCapturer) CaptureNodeAttributes(node *chef.Node) error {
+	return sc.capturer.CaptureNodeAttributes(node)
+}
+
+// the kitchen config is written once all nodes are captured, here we
+// only verify that we will be able to add the node to it
+func (sc *sharedCapturer) SaveKitchenYML(node *chef.Node) error {
+	if _, err := kitchenChefVersion(node); err != nil {
+		return err
+	}
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.nodes = append(sc.nodes, node)
+	return nil
+}
+
+// returns the nodes to add to the kitchen config sorted by name
+func (sc *sharedCapturer) kitchenNodes() []*chef.Node {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	nodes := make([]*chef.Node, len(sc.nodes))
+	copy(nodes, sc.nodes)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
+func (sc *sharedCapturer) cookbookConflicts() []CookbookConflict {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	conflicts := make([]CookbookConflict, 0, len(sc.conflicts))
+	for conflict := range sc.conflicts {
+		conflicts = append(conflicts, conflict)
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Name != conflicts[j].Name {
+			return conflicts[i].Name < conflicts[j].Name
+		}
+		return conflicts[i].Requested < conflicts[j].Requested
+	})
+	return conflicts
+}
+
+// Struct for providing arguments to the
+// multi-node kitchen.yml template
+type multiNodeKitchenYMLArgs struct {
+	Driver        string
+	Platforms     []kitchenPlatform
+	Nodes         []kitchenYMLArgs
+	DataBagSecret string
+}
+
+// SaveMultiNodeKitchenYML writes a kitchen config with one suite per node,
+// every suite only runs on the platform of its node
+func (nc *NodeCapturer) SaveMultiNodeKitchenYML(nodes []*chef.Node) error {
+	var (
+		args = multiNodeKitchenYMLArgs{Driver: nc.kitchenDriver(), DataBagSecret: nc.kitchenDataBagSecret()}
+		// platform names and the settings of the platforms with that name,
+		// nodes with the same platform but different settings (e.g. the AMI
+		// of EC2 instances) get a numbered platform each
+		platforms = make(map[string][]string)
+	)
+	for _, node := range nodes {
+		version, err := kitchenChefVersion(node)
+		if err != nil {
+			return errors.Wrapf(err, "node '%s'", node.Name)
+		}
+
+		platform, err := nc.kitchenPlatform(node)
+		if err != nil {
+			return errors.Wrapf(err, "node '%s'", node.Name)
+		}
+
+		var (
+			key      = platform.settingsKey()
+			variants = platforms[platform.Name]
+			index    = indexOfString(variants, key)
+		)
+		if index < 0 {
+			index = len(variants)
+			platforms[platform.Name] = append(variants, key)
+		}
+		if index > 0 {
+			platform.Name = fmt.Sprintf("%s-%d", platform.Name, index+1)
+		}
+		if index == len(variants) {
+			args.Platforms = append(args.Platforms, platform)
+		}
+		nodeArgs, err := nc.kitchenYMLArgs(node, version, platform)
+		if err != nil {
+			return err
+		}
+		args.Nodes = append(args.Nodes, nodeArgs)
+	}
+	sort.Slice(args.Platforms, func(i, j int) bool {
+		return args.Platforms[i].Name < args.Platforms[j].Name
+	})
+
+	tmpl_text := `
+---
+{{template "driver" .}}
+provisioner:
+  name: chef_zero_capture
+  product_name: chef
+  json_attributes: false
+{{- if .DataBagSecret}}
+  encrypted_data_bag_secret_key_path: {{.DataBagSecret}}
+{{- end}}
+
+platforms:
+{{- range .Platforms}}
+  - name: {{.Name}}
+{{- template "platform_settings" .}}
+{{- end}}
+
+suites:
+{{- range .Nodes}}
+  - name: {{.NodeName}}
+    provisioner:
+      product_version: {{.ChefVersion}}
+{{- if .Attributes}}
+      json_attributes: true
+{{- end}}
+      client_rb:
+        node_name: {{.NodeName}}
+{{- if .Attributes}}
+{{- if .RunList}}
+    run_list: {{.RunList}}
+{{- end}}
+    attributes: {{.Attributes}}
+{{- end}}
+{{- if eq .Driver "dokken"}}
+    driver:
+      chef_version: {{.ChefVersion}}
+{{- end}}
+    includes:
+      - {{.Platform.Name}}
+{{- end}}
+`
+	return nc.writeKitchenYML(tmpl_text, args)
+}
+
+// SearchNodeNames returns the names of the nodes that match the provided search query
+func SearchNodeNames(search SearchInterface, query string) ([]string, error) {
+	pres, err := search.PartialExec("node", query, map[string]interface{}{
+		"name": []string{"name"},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to search nodes with query '%s'", query)
+	}
+
+	names := make([]string, 0, len(pres.Rows))
+	for _, element := range pres.Rows {
+		v, ok := element.(map[string]interface{})["data"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if name := safeStringFromMap(v, "name"); name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ReadNodesFile reads a file with one node name per line, empty
+// lines and lines starting with '#' are ignored
+func ReadNodesFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read nodes file '%s'", path)
+	}
+	defer file.Close()
+
+	names := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		names = append(names, name)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "unable to read nodes file '%s'", path)
+	}
+	return names, nil
+}
+
+// returns the index of the string in the list, or -1 if it isn't in the list
+func indexOfString(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,432 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// a thread safe capturer that records every call it receives
+type MultiCapturerMock struct {
+	mutex        sync.Mutex
+	Nodes        map[string]*chef.Node
+	Calls        []string
+	KitchenNodes []string
+}
+
+func (cm *MultiCapturerMock) record(call string) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.Calls = append(cm.Calls, call)
+}
+
+func (cm *MultiCapturerMock) calls(prefix string) []string {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	calls := make([]string, 0)
+	for _, call := range cm.Calls {
+		if strings.HasPrefix(call, prefix) {
+			calls = append(calls, call)
+		}
+	}
+	sort.Strings(calls)
+	return calls
+}
+
+func (cm *MultiCapturerMock) CaptureNodeObject(name string) (*chef.Node, error) {
+	cm.record("node:" + name)
+	node, ok := cm.Nodes[name]
+	if !ok {
+		return nil, errors.New("node not found")
+	}
+	return node, nil
+}
+
+func (cm *MultiCapturerMock) CaptureCookbooks(_ string, cookbooks map[string]interface{}, progress subject.ObjectProgressFunc) ([]subject.NodeCookbook, error) {
+	names := make([]string, 0)
+	for name := range cookbooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cm.record("cookbooks:" + strings.Join(names, ","))
+
+	result := make([]subject.NodeCookbook, 0)
+	for i, name := range names {
+		version := cookbooks[name].(map[string]interface{})["version"].(string)
+		cm.record("cookbook:" + name + "-" + version)
+		if progress != nil {
+			progress(name+"-"+version, i+1, len(names))
+		}
+		result = append(result, subject.NodeCookbook{Name: name, Version: version})
+	}
+	return result, nil
+}
+
+func (cm *MultiCapturerMock) CaptureCookbookArtifacts(_ string, policy *chef.RevisionDetailsResponse, _ subject.ObjectProgressFunc) error {
+	for name, lock := range policy.CookbookLocks {
+		cm.record("artifact:" + name + "-" + lock.Identifier)
+	}
+	return nil
+}
+
+func (cm *MultiCapturerMock) CaptureEnvObject(env string) error {
+	cm.record("environment:" + env)
+	return nil
+}
+
+func (cm *MultiCapturerMock) CaptureRoleObjects(runList []string, _ subject.ObjectProgressFunc) error {
+	cm.record("roles:" + strings.Join(runList, ","))
+	return nil
+}
+
+func (cm *MultiCapturerMock) CapturePolicyObject(name string, revision string) (*chef.RevisionDetailsResponse, error) {
+	cm.record("policy:" + name + ":" + revision)
+	return &chef.RevisionDetailsResponse{
+		Name:       name,
+		RevisionID: revision,
+		CookbookLocks: map[string]chef.CookbookLock{
+			"base": chef.CookbookLock{Identifier: "abcdef0123456789abcdef0123456789"},
+		},
+	}, nil
+}
+
+func (cm *MultiCapturerMock) CapturePolicyGroupObject(name string) (*chef.PolicyGroup, error) {
+	cm.record("policy_group:" + name)
+	return &chef.PolicyGroup{
+		Policies: map[string]chef.Revision{"web": chef.Revision{"revision_id": "1234"}},
+	}, nil
+}
+
+func (cm *MultiCapturerMock) CaptureAllDataBagItems(_ subject.ObjectProgressFunc) error {
+	cm.record("data_bags")
+	return nil
+}
+
+func (cm *MultiCapturerMock) CaptureDataBags(names []string, _ subject.ObjectProgressFunc) error {
+	for _, name := range names {
+		cm.record("data_bag:" + name)
+	}
+	return nil
+}
+
+func (cm *MultiCapturerMock) ExpandRunList(*chef.Node) (*subject.ExpandedRunList, error) {
+	return &subject.ExpandedRunList{}, nil
+}
+
+func (cm *MultiCapturerMock) CaptureNodeAttributes(node *chef.Node) error {
+	cm.record("attributes:" + node.Name)
+	return nil
+}
+
+func (cm *MultiCapturerMock) SaveChefSpec(node *chef.Node, _ []string) error {
+	cm.record("chefspec:" + node.Name)
+	return nil
+}
+
+func (cm *MultiCapturerMock) SavePolicyfile(node *chef.Node, _ *subject.ExpandedRunList, _ []subject.NodeCookbook) error {
+	cm.record("policyfile:" + node.Name)
+	return nil
+}
+
+func (cm *MultiCapturerMock) SaveEffortlessPackage(group string, policy *chef.RevisionDetailsResponse) error {
+	cm.record("effortless:" + policy.Name + ":" + group)
+	return nil
+}
+
+func (cm *MultiCapturerMock) SaveKitchenYML(*chef.Node) error {
+	cm.record("kitchen")
+	return nil
+}
+
+func (cm *MultiCapturerMock) SaveMultiNodeKitchenYML(nodes []*chef.Node) error {
+	for _, node := range nodes {
+		cm.KitchenNodes = append(cm.KitchenNodes, node.Name)
+	}
+	return nil
+}
+
+func fleetNode(name, env string, runList []string, cookbooks map[string]string) *chef.Node {
+	node := nodeWithChefInstall()
+	node.Name = name
+	node.Environment = env
+	node.RunList = runList
+	cbs := make(map[string]interface{})
+	for cb, version := range cookbooks {
+		cbs[cb] = map[string]interface{}{"version": version}
+	}
+	node.AutomaticAttributes["cookbooks"] = cbs
+	return node
+}
+
+func TestMultiNodeCapture_RunWithDataBagAllowList(t *testing.T) {
+	capturer := &MultiCapturerMock{Nodes: map[string]*chef.Node{
+		"web1": fleetNode("web1", "prod", []string{"role[web]"}, map[string]string{"nginx": "2.0.0"}),
+		"web2": fleetNode("web2", "prod", []string{"role[web]"}, map[string]string{"nginx": "2.0.0"}),
+	}}
+
+	mnc := subject.NewMultiNodeCapture([]string{"web1", "web2"}, "repo",
+		subject.CaptureOpts{DataBags: []string{"users", "certificates"}}, capturer, 2)
+	go mnc.Run()
+	for range mnc.Progress {
+	}
+
+	assert.Nil(t, mnc.Error)
+	// every data bag is captured once for all the nodes
+	assert.Equal(t, []string{"data_bag:certificates", "data_bag:users"}, capturer.calls("data_bag:"))
+	assert.Empty(t, capturer.calls("data_bags"))
+}
+
+func TestMultiNodeCapture_Run(t *testing.T) {
+	capturer := &MultiCapturerMock{Nodes: map[string]*chef.Node{
+		"web1": fleetNode("web1", "prod", []string{"role[base]", "role[web]"}, map[string]string{"base": "1.0.0", "nginx": "2.0.0"}),
+		"web2": fleetNode("web2", "prod", []string{"role[base]", "role[web]"}, map[string]string{"base": "1.0.0", "nginx": "2.0.0"}),
+		"db1":  fleetNode("db1", "dev", []string{"role[base]", "recipe[mysql]"}, map[string]string{"base": "1.1.0", "mysql": "8.0.0"}),
+	}}
+
+	mnc := subject.NewMultiNodeCapture([]string{"web1", "web2", "db1", "missing"}, "repo",
+		subject.CaptureOpts{DownloadDataBags: true}, capturer, 2)
+	go mnc.Run()
+
+	var (
+		progress = make(map[string][]subject.CaptureStage)
+		objects  = make(map[string][]string)
+		failures = make(map[string]error)
+	)
+	for p := range mnc.Progress {
+		switch {
+		case p.Error != nil:
+			failures[p.Node] = p.Error
+		case p.IsObject():
+			objects[p.Node] = append(objects[p.Node], fmt.Sprintf("%s %d/%d", p.Object, p.Index, p.Total))
+		default:
+			progress[p.Node] = append(progress[p.Node], p.Stage)
+		}
+	}
+
+	assert.Nil(t, mnc.Error)
+	assert.Equal(t, []subject.CaptureStage{subject.WritingKitchenConfig, subject.CaptureComplete}, progress[""])
+	assert.Contains(t, progress["web1"], subject.CaptureComplete)
+	assert.Contains(t, progress["db1"], subject.CaptureComplete)
+	assert.Equal(t, []subject.CaptureStage{subject.FetchingNode}, progress["missing"])
+	if assert.NotNil(t, failures["missing"]) {
+		assert.Contains(t, failures["missing"].Error(), "unable to capture node 'missing'")
+	}
+	// roles are reported to every node that uses them, a cookbook download
+	// only to the node that downloaded it
+	assert.Subset(t, objects["web1"], []string{"base 1/2", "web 2/2"})
+	assert.Subset(t, objects["web2"], []string{"base 1/2", "web 2/2"})
+	downloads := 0
+	for _, node := range []string{"web1", "web2", "db1"} {
+		for _, object := range objects[node] {
+			if strings.Contains(object, "-") {
+				downloads++
+			}
+		}
+	}
+	assert.Equal(t, 3, downloads)
+
+	failed := mnc.Failed()
+	if assert.Equal(t, 1, len(failed)) {
+		assert.Contains(t, failed[0].Error.Error(), "unable to capture node 'missing'")
+	}
+	assert.Equal(t, []string{"missing"}, mnc.FailedNodes())
+
+	// shared objects are captured once
+	assert.Equal(t, []string{"data_bags"}, capturer.calls("data_bags"))
+	assert.Equal(t, []string{"environment:dev", "environment:prod"}, capturer.calls("environment:"))
+	assert.Equal(t, []string{"roles:role[base]", "roles:role[web]"}, capturer.calls("roles:"))
+	assert.Equal(t, 3, len(capturer.calls("cookbook:")))
+	// the cookbooks of a node are downloaded in a single batch, if any
+	assert.LessOrEqual(t, len(capturer.calls("cookbooks:")), 3)
+	assert.NotContains(t, capturer.calls("cookbooks:"), "cookbooks:")
+	assert.Empty(t, capturer.calls("kitchen"))
+
+	assert.Equal(t, []string{"db1", "web1", "web2"}, capturer.KitchenNodes)
+
+	// base is used in two versions, whichever node got there first wins
+	if assert.Equal(t, 1, len(mnc.Conflicts)) {
+		assert.Equal(t, "base", mnc.Conflicts[0].Name)
+		assert.NotEqual(t, mnc.Conflicts[0].Captured, mnc.Conflicts[0].Requested)
+	}
+	assert.Equal(t, 3, len(mnc.Cookbooks()))
+	assert.Empty(t, mnc.CookbookLocks())
+}
+
+func TestMultiNodeCapture_RunWithPolicyManagedNodes(t *testing.T) {
+	web1, web2 := policyManagedNode(), policyManagedNode()
+	for i, node := range []*chef.Node{web1, web2} {
+		node.Name = []string{"web1", "web2"}[i]
+		node.PolicyName = "web"
+		node.PolicyGroup = "prod"
+		node.AutomaticAttributes = nodeWithChefInstall().AutomaticAttributes
+	}
+	capturer := &MultiCapturerMock{Nodes: map[string]*chef.Node{"web1": web1, "web2": web2}}
+
+	mnc := subject.NewMultiNodeCapture([]string{"web1", "web2"}, "repo", subject.CaptureOpts{Effortless: true}, capturer, 10)
+	go mnc.Run()
+	for range mnc.Progress {
+	}
+
+	assert.Nil(t, mnc.Error)
+	assert.Empty(t, mnc.Failed())
+	assert.Empty(t, mnc.FailedNodes())
+	assert.Equal(t, []string{"policy_group:prod"}, capturer.calls("policy_group:"))
+	assert.Equal(t, []string{"policy:web:1234"}, capturer.calls("policy:"))
+	assert.Equal(t, []string{"effortless:web:prod"}, capturer.calls("effortless:"))
+	assert.Equal(t, []string{"artifact:base-abcdef0123456789abcdef0123456789"}, capturer.calls("artifact:"))
+	assert.Equal(t, []subject.NodeCookbook{
+		subject.NodeCookbook{Name: "base", Version: "abcdef0123456789abcdef0123456789"},
+	}, mnc.CookbookLocks())
+}
+
+func TestMultiNodeCapture_RunWithAllNodesFailing(t *testing.T) {
+	capturer := &MultiCapturerMock{Nodes: map[string]*chef.Node{}}
+
+	mnc := subject.NewMultiNodeCapture([]string{"a", "b"}, "repo", subject.CaptureOpts{}, capturer, 0)
+	go mnc.Run()
+	for range mnc.Progress {
+	}
+
+	assert.Equal(t, 2, len(mnc.Failed()))
+	if assert.NotNil(t, mnc.Error) {
+		assert.Equal(t, "unable to capture any of the nodes", mnc.Error.Error())
+	}
+	assert.Empty(t, capturer.KitchenNodes)
+}
+
+func TestCapturer_SaveMultiNodeKitchenYML(t *testing.T) {
+	writerMock := ObjectWriterMock{}
+	nc := subject.NewNodeCapturer(
+		NodeMock{},
+		RoleMock{},
+		EnvMock{},
+		CookbookMock{},
+		DataBagMock{},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		&writerMock,
+	)
+	node1 := nodeWithChefInstall()
+	node2 := nodeWithChefInstall()
+	node2.Name = "node2"
+	node2.AutomaticAttributes["platform"] = "centos"
+	node2.AutomaticAttributes["platform_version"] = "7.8"
+	node2.AutomaticAttributes["chef_packages"] = map[string]interface{}{
+		"chef": map[string]interface{}{"version": "15.8.23"},
+	}
+
+	err := nc.SaveMultiNodeKitchenYML([]*chef.Node{node1, node2})
+	expectedYML := `
+---
+driver:
+  name: vagrant
+
+provisioner:
+  name: chef_zero_capture
+  product_name: chef
+  json_attributes: false
+
+platforms:
+  - name: centos-7.8
+    driver:
+      box: bento/centos-7
+  - name: ubuntu-18.04
+    driver:
+      box: bento/ubuntu-18.04
+
+suites:
+  - name: node1
+    provisioner:
+      product_version: 99.0
+      client_rb:
+        node_name: node1
+    includes:
+      - ubuntu-18.04
+  - name: node2
+    provisioner:
+      product_version: 15.8.23
+      client_rb:
+        node_name: node2
+    includes:
+      - centos-7.8
+`
+	if assert.Nil(t, err) {
+		assert.Equal(t, expectedYML, string(writerMock.ReceivedObject.([]byte)))
+	}
+
+	node := nodeWithChefInstall()
+	delete(node.AutomaticAttributes["chef_packages"].(map[string]interface{}), "chef")
+	err = nc.SaveMultiNodeKitchenYML([]*chef.Node{node})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "node 'node1'")
+		assert.Contains(t, err.Error(), "missing automatic attribute chef_packages['chef']")
+	}
+}
+
+func TestSearchNodeNames(t *testing.T) {
+	names, err := subject.SearchNodeNames(makeMockSearch(`[
+  { "data": { "name": "web2" } },
+  { "data": { "name": "web1" } },
+  { "data": { } }
+]`, nil), "role:web")
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"web1", "web2"}, names)
+	}
+
+	_, err = subject.SearchNodeNames(makeMockSearch("", errors.New("lost connection")), "role:web")
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to search nodes with query 'role:web'")
+		assert.Contains(t, err.Error(), "lost connection")
+	}
+}
+
+func TestReadNodesFile(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	nodesFile := filepath.Join(dir, "nodes.txt")
+	err = ioutil.WriteFile(nodesFile, []byte("# web nodes\nweb1\n  web2  \n\ndb1\n"), 0644)
+	if err != nil {
+		panic(err)
+	}
+
+	names, err := subject.ReadNodesFile(nodesFile)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"web1", "web2", "db1"}, names)
+	}
+
+	_, err = subject.ReadNodesFile(filepath.Join(dir, "missing.txt"))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to read nodes file")
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	subject "github.com/chef/chef-analyze/pkg/reporting"
@@ -42,10 +43,23 @@ type CapturerMock struct {
 	RoleErrorReturn             error
 	CookbookErrorReturn         error
 	KitchenErrorReturn          error
+	AttributesErrorReturn       error
+	ChefSpecErrorReturn         error
+	ChefSpecRecipes             []string
+	PolicyfileErrorReturn       error
+	PolicyfileCookbooks         []subject.NodeCookbook
+	EffortlessErrorReturn       error
+	EffortlessGroup             string
 	DataBagErrorReturn          error
+	DataBagsCaptured            []string
+	ExpandReturn                *subject.ExpandedRunList
+	ExpandErrorReturn           error
 }
 
-func (cm *CapturerMock) CaptureCookbooks(string, map[string]interface{}) ([]subject.NodeCookbook, error) {
+func (cm *CapturerMock) CaptureCookbooks(_ string, _ map[string]interface{}, progress subject.ObjectProgressFunc) ([]subject.NodeCookbook, error) {
+	for i, cookbook := range cm.CookbookReturn {
+		progress(cookbook.Name+"-"+cookbook.Version, i+1, len(cm.CookbookReturn))
+	}
 	return cm.CookbookReturn, cm.CookbookErrorReturn
 }
 
@@ -57,15 +71,34 @@ func (cm *CapturerMock) CaptureEnvObject(string) error {
 	return cm.EnvErrorReturn
 }
 
-func (cm *CapturerMock) CaptureRoleObjects([]string) error {
+func (cm *CapturerMock) CaptureRoleObjects([]string, subject.ObjectProgressFunc) error {
 	return cm.RoleErrorReturn
 }
 
+func (cm *CapturerMock) CaptureNodeAttributes(*chef.Node) error {
+	return cm.AttributesErrorReturn
+}
+
+func (cm *CapturerMock) SaveChefSpec(node *chef.Node, recipes []string) error {
+	cm.ChefSpecRecipes = recipes
+	return cm.ChefSpecErrorReturn
+}
+
+func (cm *CapturerMock) SavePolicyfile(node *chef.Node, expanded *subject.ExpandedRunList, cookbooks []subject.NodeCookbook) error {
+	cm.PolicyfileCookbooks = cookbooks
+	return cm.PolicyfileErrorReturn
+}
+
+func (cm *CapturerMock) SaveEffortlessPackage(group string, _ *chef.RevisionDetailsResponse) error {
+	cm.EffortlessGroup = group
+	return cm.EffortlessErrorReturn
+}
+
 func (cm *CapturerMock) SaveKitchenYML(node *chef.Node) error {
 	return cm.KitchenErrorReturn
 }
 
-func (cm *CapturerMock) CaptureCookbookArtifacts(string, *chef.RevisionDetailsResponse) error {
+func (cm *CapturerMock) CaptureCookbookArtifacts(string, *chef.RevisionDetailsResponse, subject.ObjectProgressFunc) error {
 	return cm.CBAErrorReturn
 }
 
@@ -77,10 +110,22 @@ func (cm *CapturerMock) CapturePolicyGroupObject(string) (*chef.PolicyGroup, err
 	return cm.PolicyGroupReturn, cm.PolicyGroupError
 }
 
-func (cm *CapturerMock) CaptureAllDataBagItems() error {
+func (cm *CapturerMock) CaptureAllDataBagItems(subject.ObjectProgressFunc) error {
 	return cm.DataBagErrorReturn
 }
 
+func (cm *CapturerMock) CaptureDataBags(names []string, _ subject.ObjectProgressFunc) error {
+	cm.DataBagsCaptured = append(cm.DataBagsCaptured, names...)
+	return cm.DataBagErrorReturn
+}
+
+func (cm *CapturerMock) ExpandRunList(*chef.Node) (*subject.ExpandedRunList, error) {
+	if cm.ExpandReturn == nil {
+		return &subject.ExpandedRunList{}, cm.ExpandErrorReturn
+	}
+	return cm.ExpandReturn, cm.ExpandErrorReturn
+}
+
 func nodeWithChefInstall() *chef.Node {
 	return &chef.Node{
 		Name:        "node1",
@@ -124,6 +169,28 @@ func policyManagedNode() *chef.Node {
 	}
 }
 
+// runs the capture and returns all its events
+func captureEvents(nc *subject.NodeCapture) []subject.CaptureEvent {
+	go nc.Run()
+	events := make([]subject.CaptureEvent, 0)
+	for event := range nc.Progress {
+		events = append(events, event)
+	}
+	return events
+}
+
+// runs the capture and returns the stages it started, without
+// the events of the objects of the stages and of failures
+func captureStages(nc *subject.NodeCapture) []subject.CaptureStage {
+	stages := make([]subject.CaptureStage, 0)
+	for _, event := range captureEvents(nc) {
+		if !event.IsObject() && event.Error == nil {
+			stages = append(stages, event.Stage)
+		}
+	}
+	return stages
+}
+
 func defaultNode() *chef.Node {
 	return &chef.Node{
 		Name:        "node1",
@@ -148,19 +215,14 @@ func TestCapture_Run(t *testing.T) {
 	}
 	defer os.RemoveAll(baseDir)
 	nc := subject.NewNodeCapture("node1", baseDir, subject.CaptureOpts{}, &CapturerMock{NodeReturn: defaultNode()})
-	go nc.Run()
-	val := <-nc.Progress
-	assert.Equal(t, subject.FetchingNode, val)
-	val = <-nc.Progress
-	assert.Equal(t, subject.FetchingCookbooks, val)
-	val = <-nc.Progress
-	assert.Equal(t, subject.FetchingEnvironment, val)
-	val = <-nc.Progress
-	assert.Equal(t, subject.FetchingRoles, val)
-	val = <-nc.Progress
-	assert.Equal(t, subject.WritingKitchenConfig, val)
-	val = <-nc.Progress
-	assert.Equal(t, subject.CaptureComplete, val)
+	assert.Equal(t, []subject.CaptureStage{
+		subject.FetchingNode,
+		subject.FetchingCookbooks,
+		subject.FetchingEnvironment,
+		subject.FetchingRoles,
+		subject.WritingKitchenConfig,
+		subject.CaptureComplete,
+	}, captureStages(nc))
 
 	assert.Nil(t, nc.Error)
 
@@ -174,21 +236,15 @@ func TestCapture_RunWithDataBags(t *testing.T) {
 	defer os.RemoveAll(baseDir)
 	nc := subject.NewNodeCapture("node1", baseDir,
 		subject.CaptureOpts{DownloadDataBags: true}, &CapturerMock{NodeReturn: defaultNode()})
-	go nc.Run()
-	val := <-nc.Progress
-	assert.Equal(t, subject.FetchingNode, val)
-	val = <-nc.Progress
-	assert.Equal(t, subject.FetchingDataBags, val)
-	val = <-nc.Progress
-	assert.Equal(t, subject.FetchingCookbooks, val)
-	val = <-nc.Progress
-	assert.Equal(t, subject.FetchingEnvironment, val)
-	val = <-nc.Progress
-	assert.Equal(t, subject.FetchingRoles, val)
-	val = <-nc.Progress
-	assert.Equal(t, subject.WritingKitchenConfig, val)
-	val = <-nc.Progress
-	assert.Equal(t, subject.CaptureComplete, val)
+	assert.Equal(t, []subject.CaptureStage{
+		subject.FetchingNode,
+		subject.FetchingDataBags,
+		subject.FetchingCookbooks,
+		subject.FetchingEnvironment,
+		subject.FetchingRoles,
+		subject.WritingKitchenConfig,
+		subject.CaptureComplete,
+	}, captureStages(nc))
 
 	assert.Nil(t, nc.Error)
 
@@ -229,17 +285,13 @@ func TestCapture_RunWithPolicyManagedNode(t *testing.T) {
 			PolicyGroupReturn:  &policyGroup,
 			PolicyObjectReturn: &policyDetail,
 		})
-	go nc.Run()
-	val := <-nc.Progress
-	assert.Equal(t, subject.FetchingNode, val)
-	val = <-nc.Progress
-	assert.Equal(t, subject.FetchingPolicyData, val)
-	val = <-nc.Progress
-	assert.Equal(t, subject.FetchingCookbookArtifacts, val)
-	val = <-nc.Progress
-	assert.Equal(t, subject.WritingKitchenConfig, val)
-	val = <-nc.Progress
-	assert.Equal(t, subject.CaptureComplete, val)
+	assert.Equal(t, []subject.CaptureStage{
+		subject.FetchingNode,
+		subject.FetchingPolicyData,
+		subject.FetchingCookbookArtifacts,
+		subject.WritingKitchenConfig,
+		subject.CaptureComplete,
+	}, captureStages(nc))
 
 	assert.Nil(t, nc.Error)
 }
@@ -256,7 +308,7 @@ func TestCapture_RunWithPolicyManagedNode_FailPolicyGroupCapture(t *testing.T) {
 			NodeReturn:       policyManagedNode(),
 			PolicyGroupError: errors.New("403 on group fetch"),
 		})
-	nc.Run()
+	captureStages(nc)
 
 	if assert.NotNil(t, nc.Error) {
 		assert.Contains(t, nc.Error.Error(), "unable to capture policy group 'policy': 403 on group fetch")
@@ -276,7 +328,7 @@ func TestCapture_RunWithPolicyManagedNode_FailPolicyObjectCapture(t *testing.T)
 			PolicyGroupReturn:       &chef.PolicyGroup{},
 			PolicyObjectErrorReturn: errors.New("sorry fresh out of policy objects"),
 		})
-	nc.Run()
+	captureStages(nc)
 
 	if assert.NotNil(t, nc.Error) {
 		assert.Contains(t, nc.Error.Error(), "unable to capture policy name 'pgroup' revision ''")
@@ -296,7 +348,7 @@ func TestCapture_RunWithPolicyManagedNode_FailCookbookArtifacts(t *testing.T) {
 			PolicyGroupReturn: &chef.PolicyGroup{},
 			NodeReturn:        policyManagedNode(), CBAErrorReturn: errors.New("sorry all out of artifacts"),
 		})
-	nc.Run()
+	captureStages(nc)
 
 	if assert.NotNil(t, nc.Error) {
 		assert.Contains(t, nc.Error.Error(), "unable to capture cookbook artifacts for policy 'pgroup' revision ''")
@@ -307,7 +359,7 @@ func TestCapture_RunWithPolicyManagedNode_FailCookbookArtifacts(t *testing.T) {
 func TestCapture_RunWithNodeFailure(t *testing.T) {
 	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{},
 		&CapturerMock{NodeErrorReturn: errors.New("no node")})
-	nc.Run()
+	captureStages(nc)
 	if assert.NotNil(t, nc.Error) {
 		assert.Contains(t, nc.Error.Error(), "no node")
 		assert.Contains(t, nc.Error.Error(), "unable to capture node 'node1'")
@@ -317,15 +369,86 @@ func TestCapture_RunWithNodeFailure(t *testing.T) {
 func TestCapture_RunWithNoCookbooksAvailable(t *testing.T) {
 	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{},
 		&CapturerMock{NodeReturn: &chef.Node{}})
-	nc.Run()
+	captureStages(nc)
 	assert.Nil(t, nc.Error)
 }
 
+func TestCapture_RunWithNeverConvergedNode(t *testing.T) {
+	node := defaultNode()
+	delete(node.AutomaticAttributes, "cookbooks")
+	capturer := &CapturerMock{NodeReturn: node,
+		ExpandReturn: &subject.ExpandedRunList{
+			Cookbooks: []subject.NodeCookbook{{Name: "cookbook1", Version: "1.2.0"}},
+		},
+		CookbookReturn: []subject.NodeCookbook{{Name: "cookbook1", Version: "1.2.0"}},
+	}
+	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{}, capturer)
+	captureStages(nc)
+	if assert.Nil(t, nc.Error) {
+		assert.Equal(t, []subject.NodeCookbook{{Name: "cookbook1", Version: "1.2.0"}}, nc.Cookbooks)
+	}
+}
+
+func TestCapture_RunWithNeverConvergedNodeExpandFailure(t *testing.T) {
+	node := defaultNode()
+	delete(node.AutomaticAttributes, "cookbooks")
+	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{},
+		&CapturerMock{NodeReturn: node,
+			ExpandErrorReturn: errors.New("role went missing")})
+	captureStages(nc)
+	if assert.NotNil(t, nc.Error) {
+		assert.Contains(t, nc.Error.Error(), "unable to expand run list for node 'node1'")
+		assert.Contains(t, nc.Error.Error(), "role went missing")
+	}
+}
+
+func TestCapture_RunWithNeverConvergedNodeCapturer(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	var (
+		cookbooks = mockedExpanderCookbooks()
+		writer    = ObjectWriterMock{}
+		// a node that was registered but never ran chef-client
+		node = chef.Node{Name: "node1", Environment: "_default", RunList: []string{"recipe[web]"}}
+	)
+	cookbooks.createDirOnDownload = true
+	capturer := subject.NewNodeCapturer(
+		NodeMock{Node: node},
+		mockedExpanderRoles(),
+		&EnvMock{Env: &chef.Environment{Name: "_default"}},
+		cookbooks,
+		DataBagMock{},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		&writer,
+	)
+	nc := subject.NewNodeCapture("node1", baseDir, subject.CaptureOpts{}, capturer)
+	assert.Contains(t, captureStages(nc), subject.CaptureComplete)
+	if assert.Nil(t, nc.Error) {
+		assert.ElementsMatch(t, []subject.NodeCookbook{
+			{Name: "apache2", Version: "4.1.0"},
+			{Name: "base", Version: "1.1.0"},
+			{Name: "web", Version: "0.2.0"},
+		}, nc.Cookbooks)
+
+		// the kitchen config converges it with the recommended client
+		support, err := subject.LoadSupportData("")
+		mustSucceed(err)
+		assert.Contains(t, string(writer.ReceivedObject.([]byte)),
+			fmt.Sprintf("  product_version: %s\n", support.RecommendedClient))
+	}
+}
+
 func TestCapture_RunWithCookbookFailure(t *testing.T) {
 	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{},
 		&CapturerMock{NodeReturn: defaultNode(),
 			CookbookErrorReturn: errors.New("no cookbook")})
-	nc.Run()
+	captureStages(nc)
 	if assert.NotNil(t, nc.Error) {
 		assert.Contains(t, nc.Error.Error(), "no cookbook")
 		assert.Contains(t, nc.Error.Error(), "unable to capture node cookbooks")
@@ -336,7 +459,7 @@ func TestCapture_RunWithEnvironmentFailure(t *testing.T) {
 	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{},
 		&CapturerMock{NodeReturn: defaultNode(),
 			EnvErrorReturn: errors.New("no env")})
-	nc.Run()
+	captureStages(nc)
 	if assert.NotNil(t, nc.Error) {
 		assert.Contains(t, nc.Error.Error(), "no env")
 		assert.Contains(t, nc.Error.Error(), "unable to capture environment")
@@ -348,7 +471,7 @@ func TestCapture_RunWithRoleFailure(t *testing.T) {
 	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{},
 		&CapturerMock{NodeReturn: defaultNode(),
 			RoleErrorReturn: errors.New("no role")})
-	nc.Run()
+	captureStages(nc)
 	if assert.NotNil(t, nc.Error) {
 		assert.Contains(t, nc.Error.Error(), "no role")
 		assert.Contains(t, nc.Error.Error(), "unable to capture role")
@@ -359,7 +482,7 @@ func TestCapture_RunWithCookbookDataBagFailure(t *testing.T) {
 	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{DownloadDataBags: true},
 		&CapturerMock{NodeReturn: defaultNode(),
 			DataBagErrorReturn: errors.New("spilled all over the carpet")})
-	nc.Run()
+	captureStages(nc)
 	if assert.NotNil(t, nc.Error) {
 		assert.Contains(t, nc.Error.Error(), "spilled all over the carpet")
 		assert.Contains(t, nc.Error.Error(), "unable to capture data bag")
@@ -369,13 +492,137 @@ func TestCapture_RunWithKitchenYMLFailure(t *testing.T) {
 	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{},
 		&CapturerMock{NodeReturn: defaultNode(),
 			KitchenErrorReturn: errors.New("failure here")})
-	nc.Run()
+	captureStages(nc)
 	if assert.NotNil(t, nc.Error) {
 		assert.Contains(t, nc.Error.Error(), "failure here")
 		assert.Contains(t, nc.Error.Error(), "unable to write Kitchen config")
 	}
 }
 
+func TestCapture_RunWithChefSpec(t *testing.T) {
+	capturer := &CapturerMock{
+		NodeReturn:   defaultNode(),
+		ExpandReturn: &subject.ExpandedRunList{Recipes: []string{"cookbook1::recipe1", "base"}},
+	}
+	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{ChefSpec: true}, capturer)
+	events := captureStages(nc)
+	assert.Nil(t, nc.Error)
+	assert.Equal(t, []subject.CaptureStage{
+		subject.FetchingNode,
+		subject.FetchingCookbooks,
+		subject.FetchingEnvironment,
+		subject.FetchingRoles,
+		subject.WritingKitchenConfig,
+		subject.WritingChefSpec,
+		subject.CaptureComplete,
+	}, events)
+	assert.Equal(t, []string{"cookbook1::recipe1", "base"}, capturer.ChefSpecRecipes)
+
+	capturer = &CapturerMock{NodeReturn: defaultNode(), ChefSpecErrorReturn: errors.New("failure here")}
+	nc = subject.NewNodeCapture("node1", "", subject.CaptureOpts{ChefSpec: true}, capturer)
+	captureStages(nc)
+	if assert.NotNil(t, nc.Error) {
+		assert.Contains(t, nc.Error.Error(), "unable to write ChefSpec scaffolds")
+		assert.Contains(t, nc.Error.Error(), "failure here")
+	}
+
+	// policy-managed nodes have no cookbooks to converge with ChefSpec
+	capturer = &CapturerMock{
+		NodeReturn:         policyManagedNode(),
+		PolicyGroupReturn:  &chef.PolicyGroup{Policies: map[string]chef.Revision{}},
+		PolicyObjectReturn: &chef.RevisionDetailsResponse{},
+	}
+	nc = subject.NewNodeCapture("node1", "", subject.CaptureOpts{ChefSpec: true}, capturer)
+	captureStages(nc)
+	assert.Nil(t, nc.Error)
+	assert.Nil(t, capturer.ChefSpecRecipes)
+}
+
+func TestCapture_RunWithPolicyfile(t *testing.T) {
+	capturer := &CapturerMock{
+		NodeReturn:     defaultNode(),
+		CookbookReturn: []subject.NodeCookbook{{"foo", "0.1.0"}},
+	}
+	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{ChefSpec: true, ToPolicyfile: true}, capturer)
+	events := captureStages(nc)
+	assert.Nil(t, nc.Error)
+	assert.Equal(t, []subject.CaptureStage{
+		subject.FetchingNode,
+		subject.FetchingCookbooks,
+		subject.FetchingEnvironment,
+		subject.FetchingRoles,
+		subject.WritingKitchenConfig,
+		subject.WritingChefSpec,
+		subject.WritingPolicyfile,
+		subject.CaptureComplete,
+	}, events)
+	assert.Equal(t, []subject.NodeCookbook{{"foo", "0.1.0"}}, capturer.PolicyfileCookbooks)
+
+	capturer = &CapturerMock{NodeReturn: defaultNode(), PolicyfileErrorReturn: errors.New("failure here")}
+	nc = subject.NewNodeCapture("node1", "", subject.CaptureOpts{ToPolicyfile: true}, capturer)
+	captureStages(nc)
+	if assert.NotNil(t, nc.Error) {
+		assert.Contains(t, nc.Error.Error(), "unable to write Policyfile")
+		assert.Contains(t, nc.Error.Error(), "failure here")
+	}
+}
+
+func TestCapture_RunWithEffortless(t *testing.T) {
+	policyGroup := chef.PolicyGroup{
+		Policies: map[string]chef.Revision{"pgroup": chef.Revision{"revision_id": "123xyz"}},
+	}
+	policyDetail := chef.RevisionDetailsResponse{Name: "pgroup", RevisionID: "123xyz"}
+	capturer := &CapturerMock{
+		NodeReturn:         policyManagedNode(),
+		PolicyGroupReturn:  &policyGroup,
+		PolicyObjectReturn: &policyDetail,
+	}
+	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{Effortless: true, ChefSpec: true}, capturer)
+	events := captureStages(nc)
+	assert.Nil(t, nc.Error)
+	// ChefSpec scaffolds are not written for policy-managed nodes
+	assert.Equal(t, []subject.CaptureStage{
+		subject.FetchingNode,
+		subject.FetchingPolicyData,
+		subject.FetchingCookbookArtifacts,
+		subject.WritingKitchenConfig,
+		subject.WritingEffortlessPackage,
+		subject.CaptureComplete,
+	}, events)
+	assert.Equal(t, "policy", capturer.EffortlessGroup)
+
+	// nodes that are not policy-managed have no policy to package
+	capturer = &CapturerMock{NodeReturn: defaultNode()}
+	nc = subject.NewNodeCapture("node1", "", subject.CaptureOpts{Effortless: true}, capturer)
+	captureStages(nc)
+	assert.Nil(t, nc.Error)
+	assert.Equal(t, "", capturer.EffortlessGroup)
+
+	capturer = &CapturerMock{
+		NodeReturn:            policyManagedNode(),
+		PolicyGroupReturn:     &policyGroup,
+		PolicyObjectReturn:    &policyDetail,
+		EffortlessErrorReturn: errors.New("failure here"),
+	}
+	nc = subject.NewNodeCapture("node1", "", subject.CaptureOpts{Effortless: true}, capturer)
+	captureStages(nc)
+	if assert.NotNil(t, nc.Error) {
+		assert.Contains(t, nc.Error.Error(), "unable to write Effortless package")
+		assert.Contains(t, nc.Error.Error(), "failure here")
+	}
+}
+
+func TestCapture_RunWithNodeAttributesFailure(t *testing.T) {
+	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{},
+		&CapturerMock{NodeReturn: defaultNode(),
+			AttributesErrorReturn: errors.New("failure here")})
+	captureStages(nc)
+	if assert.NotNil(t, nc.Error) {
+		assert.Contains(t, nc.Error.Error(), "failure here")
+		assert.Contains(t, nc.Error.Error(), "unable to capture node attributes")
+	}
+}
+
 func TestCapturer_CaptureNodeObject(t *testing.T) {
 	expectedNode := chef.Node{
 		Name:        "node1",
@@ -456,7 +703,7 @@ func TestCapturer_CaptureRoleObjects(t *testing.T) {
 		CBAMock{},
 		&writer,
 	)
-	err := nc.CaptureRoleObjects([]string{"role[role1]"})
+	err := nc.CaptureRoleObjects([]string{"role[role1]"}, nil)
 	assert.Equal(t, &expectedRole, writer.ReceivedObject)
 	assert.Nil(t, err)
 }
@@ -474,7 +721,7 @@ func TestCapturer_CaptureRoleObjectsWithErrorOnFetch(t *testing.T) {
 		CBAMock{},
 		&writer,
 	)
-	err := nc.CaptureRoleObjects([]string{"role[role1]"})
+	err := nc.CaptureRoleObjects([]string{"role[role1]"}, nil)
 	if assert.NotNil(t, err) {
 		assert.Contains(t, err.Error(), "unable to retrieve")
 		assert.Contains(t, err.Error(), "failed to fetch")
@@ -494,7 +741,7 @@ func TestCapturer_CaptureRoleObjectsWithErrorOnSave(t *testing.T) {
 		CBAMock{},
 		&writer,
 	)
-	err := nc.CaptureRoleObjects([]string{"role[role1]"})
+	err := nc.CaptureRoleObjects([]string{"role[role1]"}, nil)
 	if assert.NotNil(t, err) {
 		assert.Contains(t, err.Error(), "failed to save role")
 		assert.Contains(t, err.Error(), "failed to write")
@@ -615,7 +862,7 @@ func TestCapturer_CaptureCookbookArtifacts(t *testing.T) {
 		&writer,
 	)
 
-	err = nc.CaptureCookbookArtifacts(baseDir, &policyDetail)
+	err = nc.CaptureCookbookArtifacts(baseDir, &policyDetail, nil)
 	assert.Nil(t, err)
 }
 
@@ -661,7 +908,7 @@ func TestCapturer_CaptureCookbookArtifacts_WithDownloadError(t *testing.T) {
 		&writer,
 	)
 
-	err = nc.CaptureCookbookArtifacts(baseDir, &policyDetail)
+	err = nc.CaptureCookbookArtifacts(baseDir, &policyDetail, nil)
 	if assert.NotNil(t, err) {
 		assert.Contains(t, err.Error(), "alpha")
 		assert.Contains(t, err.Error(), "Download Error")
@@ -892,7 +1139,7 @@ func TestCapturer_CaptureCookbooks(t *testing.T) {
 		},
 	}
 
-	cbs, err := nc.CaptureCookbooks(baseDir, cbmap)
+	cbs, err := nc.CaptureCookbooks(baseDir, cbmap, nil)
 	if assert.Nil(t, err) {
 		assert.Contains(t, cbs, subject.NodeCookbook{"foo", "0.1.0"})
 	}
@@ -941,7 +1188,7 @@ func TestCapturer_CaptureCookbooksWithDownloadError(t *testing.T) {
 		},
 	}
 
-	_, err = nc.CaptureCookbooks(baseDir, cbmap)
+	_, err = nc.CaptureCookbooks(baseDir, cbmap, nil)
 	if assert.NotNil(t, err) {
 		assert.Contains(t, err.Error(), "download error in test1")
 		assert.Contains(t, err.Error(), "Failed to download cookbook")
@@ -980,12 +1227,92 @@ func TestCapturer_CaptureCookbooksWithRenameError(t *testing.T) {
 		},
 	}
 
-	_, err := nc.CaptureCookbooks("/mocked/anyway", cbmap)
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	mustSucceed(err)
+	defer os.RemoveAll(baseDir)
+
+	_, err = nc.CaptureCookbooks(baseDir, cbmap, nil)
 	if assert.NotNil(t, err) {
 		assert.Contains(t, err.Error(), "failed to rename cookbook")
 	}
 }
 
+func TestCapturer_CaptureCookbooksInParallel(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	mustSucceed(err)
+	defer os.RemoveAll(baseDir)
+
+	nc := subject.NewNodeCapturer(
+		NodeMock{},
+		RoleMock{},
+		EnvMock{},
+		newMockCookbook(chef.CookbookListResult{}, nil, nil),
+		DataBagMock{},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		&ObjectWriterMock{},
+	)
+	nc.SetDownloadWorkers(2)
+
+	cbmap := map[string]interface{}{}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		cbmap[name] = map[string]interface{}{"version": "1.0.0"}
+	}
+	reported := make([]int, 0)
+	cookbooks, err := nc.CaptureCookbooks(baseDir, cbmap, func(object string, index, total int) {
+		assert.Equal(t, 5, total)
+		reported = append(reported, index)
+	})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []int{1, 2, 3, 4, 5}, reported)
+		assert.Len(t, cookbooks, 5)
+		// every cookbook was moved into place and the staging directory is gone
+		entries, err := ioutil.ReadDir(filepath.Join(baseDir, "cookbooks"))
+		mustSucceed(err)
+		names := make([]string, 0)
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		assert.Equal(t, []string{"a", "b", "c", "d", "e"}, names)
+	}
+}
+
+func TestCapturer_CaptureCookbooksAggregatesDownloadErrors(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	mustSucceed(err)
+	defer os.RemoveAll(baseDir)
+
+	cbMock := newMockCookbook(chef.CookbookListResult{}, nil, errors.New("connection reset"))
+	nc := subject.NewNodeCapturer(
+		NodeMock{},
+		RoleMock{},
+		EnvMock{},
+		cbMock,
+		DataBagMock{},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		&ObjectWriterMock{},
+	)
+
+	cbmap := map[string]interface{}{
+		"foo": map[string]interface{}{"version": "0.1.0"},
+		"bar": map[string]interface{}{"version": "0.2.0"},
+	}
+	_, err = nc.CaptureCookbooks(baseDir, cbmap, nil)
+	if assert.NotNil(t, err) {
+		// every failed cookbook is listed, not only the first one
+		assert.Contains(t, err.Error(), "unable to download 2 cookbook(s)")
+		assert.Contains(t, err.Error(), "Failed to download cookbook bar v0.2.0: connection reset")
+		assert.Contains(t, err.Error(), "Failed to download cookbook foo v0.1.0: connection reset")
+	}
+	// the partial downloads are not left behind
+	entries, err := ioutil.ReadDir(filepath.Join(baseDir, "cookbooks"))
+	mustSucceed(err)
+	assert.Empty(t, entries)
+}
+
 func TestCapturer_CaptureAllDataBags(t *testing.T) {
 	dataBags := map[string]string{
 		"bag1": "https://url/data/bag1",
@@ -1016,7 +1343,7 @@ func TestCapturer_CaptureAllDataBags(t *testing.T) {
 		CBAMock{},
 		&writer,
 	)
-	err := nc.CaptureAllDataBagItems()
+	err := nc.CaptureAllDataBagItems(nil)
 	assert.Equal(t, dataBagItem, writer.ReceivedObject.(map[string]interface{}))
 	assert.Nil(t, err)
 }
@@ -1033,7 +1360,7 @@ func TestCapturer_CaptureAllDataBagsWithDBListError(t *testing.T) {
 		CBAMock{},
 		&ObjectWriterMock{},
 	)
-	err := nc.CaptureAllDataBagItems()
+	err := nc.CaptureAllDataBagItems(nil)
 	if assert.NotNil(t, err) {
 		assert.Contains(t, err.Error(), "unable to retrieve list of data bags")
 		assert.Contains(t, err.Error(), "i misplaced them")
@@ -1058,7 +1385,7 @@ func TestCapturer_CaptureAllDataBagsWithListItemsError(t *testing.T) {
 		CBAMock{},
 		&ObjectWriterMock{},
 	)
-	err := nc.CaptureAllDataBagItems()
+	err := nc.CaptureAllDataBagItems(nil)
 	if assert.NotNil(t, err) {
 		assert.Contains(t, err.Error(), "unable to retrieve data bag items for bag1")
 		assert.Contains(t, err.Error(), "empty bags")
@@ -1087,7 +1414,7 @@ func TestCapturer_CaptureAllDataBagsWithGetItemError(t *testing.T) {
 		CBAMock{},
 		&ObjectWriterMock{},
 	)
-	err := nc.CaptureAllDataBagItems()
+	err := nc.CaptureAllDataBagItems(nil)
 	if assert.NotNil(t, err) {
 		assert.Contains(t, err.Error(), "unable to retrieve data bag item bag1/item1")
 		assert.Contains(t, err.Error(), "i swear they were right here")
@@ -1123,7 +1450,7 @@ func TestCapturer_CaptureAllDataBagsWithWriteError(t *testing.T) {
 		CBAMock{},
 		&ObjectWriterMock{Error: errors.New("dropped the bag")},
 	)
-	err := nc.CaptureAllDataBagItems()
+	err := nc.CaptureAllDataBagItems(nil)
 	if assert.NotNil(t, err) {
 		assert.Contains(t, err.Error(), "unable to save data bag item bag1/item1")
 		assert.Contains(t, err.Error(), "dropped the bag")
@@ -1161,6 +1488,8 @@ provisioner:
 
 platforms:
   - name: ubuntu-18.04
+    driver:
+      box: bento/ubuntu-18.04
 
 suites:
   - name: node1
@@ -1186,12 +1515,14 @@ func TestCapturer_SaveKitchenYMLWithMissingAttrs(t *testing.T) {
 		&writerMock,
 	)
 
-	// Missing chef_packages['chef_packages']
+	// Missing chef_packages, the node never converged
 	node := defaultNode()
 	err := nc.SaveKitchenYML(node)
-	if assert.NotNil(t, err) {
-		// cast to string to keep it readable if it fails
-		assert.Contains(t, err.Error(), "missing automatic attribute 'chef_packages'")
+	if assert.Nil(t, err) {
+		support, err := subject.LoadSupportData("")
+		mustSucceed(err)
+		assert.Contains(t, string(writerMock.ReceivedObject.([]byte)),
+			fmt.Sprintf("  product_version: %s\n", support.RecommendedClient))
 	}
 
 	// Missing chef_packages['chef']
@@ -1218,3 +1549,77 @@ func TestCapturer_SaveKitchenYMLWithMissingAttrs(t *testing.T) {
 func TestCapturer_SaveKitchenYMLWithFailedWrite(t *testing.T) {
 
 }
+
+func TestCapturer_CaptureCookbooksRefresh(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	cookbookDir := fmt.Sprintf("%s/cookbooks", baseDir)
+	for _, dir := range []string{"local-checkout/foo", "cookbooks/bar", "cookbooks/baz"} {
+		if err := os.MkdirAll(fmt.Sprintf("%s/%s", baseDir, dir), 0755); err != nil {
+			panic(err)
+		}
+	}
+	if err := os.Symlink(fmt.Sprintf("%s/local-checkout/foo", baseDir), fmt.Sprintf("%s/foo", cookbookDir)); err != nil {
+		panic(err)
+	}
+
+	writer := ObjectWriterMock{}
+	nc := subject.NewNodeCapturer(
+		NodeMock{},
+		RoleMock{},
+		EnvMock{},
+		newMockCookbook(chef.CookbookListResult{}, nil, errors.New("unexpected download")),
+		DataBagMock{},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		&writer,
+	)
+	nc.SetPreviousCapture(&subject.CaptureManifest{
+		Cookbooks: map[string]string{"cookbooks/bar": "1.0.0", "cookbooks/baz": "1.0.0"},
+	})
+
+	// foo was sourced locally and bar didn't change, neither is downloaded
+	cbs, err := nc.CaptureCookbooks(baseDir, map[string]interface{}{
+		"foo": map[string]interface{}{"version": "2.0.0"},
+		"bar": map[string]interface{}{"version": "1.0.0"},
+	}, nil)
+	if assert.Nil(t, err) {
+		assert.ElementsMatch(t, []subject.NodeCookbook{{"foo", "2.0.0"}, {"bar", "1.0.0"}}, cbs)
+	}
+	_, err = os.Lstat(fmt.Sprintf("%s/foo", cookbookDir))
+	assert.Nil(t, err, "the symlink of a locally sourced cookbook must be kept")
+
+	// baz changed but the download fails, the previous capture is kept
+	mustSucceed(ioutil.WriteFile(fmt.Sprintf("%s/baz/metadata.rb", cookbookDir), []byte("version '1.0.0'"), 0644))
+	_, err = nc.CaptureCookbooks(baseDir, map[string]interface{}{
+		"baz": map[string]interface{}{"version": "1.1.0"},
+	}, nil)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unexpected download")
+	}
+	_, err = os.Stat(fmt.Sprintf("%s/baz/metadata.rb", cookbookDir))
+	assert.Nil(t, err, "a failed download must keep the previous capture")
+
+	// once the download succeeds the previous capture is replaced
+	cookbooks := newMockCookbook(chef.CookbookListResult{}, nil, nil)
+	cookbooks.downloadFiles = map[string]string{"metadata.json": "{}"}
+	nc = subject.NewNodeCapturer(NodeMock{}, RoleMock{}, EnvMock{}, cookbooks,
+		DataBagMock{}, PolicyGroupMock{}, PolicyMock{}, CBAMock{}, &writer)
+	_, err = nc.CaptureCookbooks(baseDir, map[string]interface{}{
+		"baz": map[string]interface{}{"version": "1.1.0"},
+	}, nil)
+	assert.Nil(t, err)
+	_, err = os.Stat(fmt.Sprintf("%s/baz/metadata.json", cookbookDir))
+	assert.Nil(t, err)
+	_, err = os.Stat(fmt.Sprintf("%s/baz/metadata.rb", cookbookDir))
+	assert.True(t, os.IsNotExist(err))
+	entries, err := ioutil.ReadDir(baseDir)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 2, len(entries), "the staging directory must be removed")
+	}
+}
@@ -25,6 +25,8 @@ import "github.com/go-chef/chef"
 // CookbookInterface for testing
 type CookbookInterface interface {
 	ListAvailableVersions(numVersions string) (chef.CookbookListResult, error)
+	GetAvailableVersions(name, numVersions string) (chef.CookbookListResult, error)
+	GetVersion(name, version string) (chef.Cookbook, error)
 	DownloadTo(name, version, localDir string) error
 }
 
@@ -19,6 +19,7 @@ package reporting_test
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -45,12 +46,35 @@ type CookbookMock struct {
 	desiredCookbookListError error
 	desiredDownloadError     error
 	createDirOnDownload      bool
+	// cookbook metadata returned by GetVersion, indexed by NAME-VERSION
+	desiredCookbooks       map[string]chef.Cookbook
+	desiredGetVersionError error
+	// files written in the downloaded cookbook, indexed by their relative path
+	downloadFiles map[string]string
 }
 
 func (cm CookbookMock) ListAvailableVersions(limit string) (chef.CookbookListResult, error) {
 	return cm.desiredCookbookList, cm.desiredCookbookListError
 }
 
+func (cm CookbookMock) GetAvailableVersions(name, limit string) (chef.CookbookListResult, error) {
+	if cm.desiredCookbookListError != nil {
+		return nil, cm.desiredCookbookListError
+	}
+	result := chef.CookbookListResult{}
+	if versions, ok := cm.desiredCookbookList[name]; ok {
+		result[name] = versions
+	}
+	return result, nil
+}
+
+func (cm CookbookMock) GetVersion(name, version string) (chef.Cookbook, error) {
+	if cm.desiredGetVersionError != nil {
+		return chef.Cookbook{}, cm.desiredGetVersionError
+	}
+	return cm.desiredCookbooks[fmt.Sprintf("%s-%s", name, version)], nil
+}
+
 func (cm CookbookMock) DownloadTo(name, version, localDir string) error {
 	if cm.createDirOnDownload {
 		dirToMock := filepath.Join(localDir, fmt.Sprintf("%s-%s", name, version))
@@ -58,6 +82,11 @@ func (cm CookbookMock) DownloadTo(name, version, localDir string) error {
 		if err != nil {
 			panic(err)
 		}
+		for file, content := range cm.downloadFiles {
+			path := filepath.Join(dirToMock, file)
+			mustSucceed(os.MkdirAll(filepath.Dir(path), os.ModePerm))
+			mustSucceed(ioutil.WriteFile(path, []byte(content), 0644))
+		}
 	}
 	return cm.desiredDownloadError
 }
@@ -205,12 +234,21 @@ func (nm NodeMock) Get(name string) (chef.Node, error) {
 type RoleMock struct {
 	Error error
 	Role  *chef.Role
+	// when set, roles are looked up by name instead of returning Role
+	Roles map[string]*chef.Role
 }
 
 func (rm RoleMock) Get(name string) (*chef.Role, error) {
 	if rm.Error != nil {
 		return nil, rm.Error
 	}
+	if rm.Roles != nil {
+		role, ok := rm.Roles[name]
+		if !ok {
+			return nil, fmt.Errorf("404 role %s not found", name)
+		}
+		return role, nil
+	}
 	return rm.Role, nil
 }
 
@@ -0,0 +1,58 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"io/ioutil"
+
+	"github.com/BurntSushi/toml"
+	"github.com/chef/go-libs/config"
+	"github.com/pkg/errors"
+)
+
+// ConfigFile holds the sections of the user's config.toml that chef-analyze reads
+type ConfigFile struct {
+	Reports struct {
+		Nodes NodesReportConfig `toml:"nodes"`
+	} `toml:"reports"`
+	Redaction RedactionRules `toml:"redaction"`
+}
+
+// LoadConfigFile loads the user's config.toml, returns an empty
+// ConfigFile when the file doesn't exist
+func LoadConfigFile() (ConfigFile, error) {
+	configToml, err := config.FindChefWSUserConfigFile()
+	if err != nil {
+		// the config.toml is optional
+		return ConfigFile{}, nil
+	}
+	return ReadConfigFile(configToml)
+}
+
+// ReadConfigFile reads the sections chef-analyze uses from the provided config file
+func ReadConfigFile(configToml string) (ConfigFile, error) {
+	configBytes, err := ioutil.ReadFile(configToml)
+	if err != nil {
+		return ConfigFile{}, errors.Wrapf(err, "unable to read config.toml from '%s'", configToml)
+	}
+
+	var cfg ConfigFile
+	if _, err := toml.Decode(string(configBytes), &cfg); err != nil {
+		return ConfigFile{}, errors.Wrapf(err, "unable to parse config.toml from '%s'", configToml)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,70 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configToml := filepath.Join(dir, "config.toml")
+	err = ioutil.WriteFile(configToml, []byte(`
+[reports]
+anonymize = true
+
+[reports.nodes]
+attributes = ["env=chef_environment", "provider=cloud.provider"]
+
+[redaction]
+keys = ["(?i)license"]
+`), 0644)
+	if err != nil {
+		panic(err)
+	}
+
+	cfg, err := subject.ReadConfigFile(configToml)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"env=chef_environment", "provider=cloud.provider"}, cfg.Reports.Nodes.Attributes)
+		assert.Equal(t, []string{"(?i)license"}, cfg.Redaction.Keys)
+	}
+
+	err = ioutil.WriteFile(configToml, []byte("[reports\n"), 0644)
+	if err != nil {
+		panic(err)
+	}
+	_, err = subject.ReadConfigFile(configToml)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to parse config.toml")
+	}
+
+	_, err = subject.ReadConfigFile(filepath.Join(dir, "missing.toml"))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to read config.toml")
+	}
+}
@@ -0,0 +1,525 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chef/go-libs/config"
+	"github.com/pkg/errors"
+)
+
+// the drift of a server cookbook version from its local checkout
+const (
+	// the server content is the content of the version in git
+	DriftInSync = "in sync"
+	// the server content is the working tree, it was uploaded before it was committed
+	DriftUncommitted = "uncommitted"
+	// the server content differs from the version in git and from the working tree
+	DriftModified = "modified"
+	// the version is not in git and the server content differs from the working tree
+	DriftUnknownSource = "unknown source"
+)
+
+// how a file of the server cookbook differs from its source
+const (
+	DriftFileModified   = "modified"
+	DriftFileServerOnly = "server only"
+	DriftFileSourceOnly = "source only"
+)
+
+// CookbookDriftReport compares the cookbook versions of the Chef Infra Server
+// with the local checkouts of the cookbooks of the same name
+type CookbookDriftReport struct {
+	Records        []*CookbookDriftRecord
+	recordsMutex   sync.Mutex
+	TotalCookbooks int
+	Progress       chan int
+	// hashes the names and the checkouts of the cookbooks
+	Anonymize    bool
+	cookbooks    CookbookInterface
+	cookbooksDir string
+	numWorkers   int
+	jobs         []cookbookDriftJob
+}
+
+// CookbookDriftRecord is the drift of a cookbook version from its checkout
+type CookbookDriftRecord struct {
+	Name    string
+	Version string
+	// the checkout the cookbook was compared with
+	SourcePath string
+	// the tag or commit of the checkout the version was found at, empty
+	// when the server cookbook was only compared with the working tree
+	Revision string
+	Status   string
+	// the files that differ from the source, the version in git when
+	// the Revision is known, the working tree otherwise
+	Files []CookbookDriftFile
+	Error error
+}
+
+// CookbookDriftFile is a file that differs between a server cookbook and its source
+type CookbookDriftFile struct {
+	Path   string
+	Change string
+}
+
+// Drifted returns true if the server cookbook was not uploaded from a committed source
+func (r *CookbookDriftRecord) Drifted() bool {
+	return r.Error == nil && r.Status != DriftInSync
+}
+
+// a cookbook version to compare with its checkout
+type cookbookDriftJob struct {
+	name       string
+	version    string
+	sourcePath string
+}
+
+// NewCookbookDriftReport creates the report of the cookbook versions of the Chef
+// Infra Server that have a checkout in one of the source paths, see cookbookCheckouts,
+// cookbooks without checkout are ignored
+func NewCookbookDriftReport(cookbooks CookbookInterface, sourcePaths []string, workers int, anonymize bool) (*CookbookDriftReport, error) {
+	wsDir, err := config.ChefWorkstationDir()
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := cookbooks.ListAvailableVersions("0")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to retrieve cookbooks")
+	}
+
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		checkouts = cookbookCheckouts(sourcePaths)
+		jobs      = make([]cookbookDriftJob, 0)
+	)
+	for _, name := range names {
+		sourcePath, ok := checkouts[name]
+		if !ok {
+			continue
+		}
+		for _, version := range results[name].Versions {
+			jobs = append(jobs, cookbookDriftJob{name: name, version: version.Version, sourcePath: sourcePath})
+		}
+	}
+
+	return &CookbookDriftReport{
+		Records:        make([]*CookbookDriftRecord, 0, len(jobs)),
+		Progress:       make(chan int, len(jobs)),
+		TotalCookbooks: len(jobs),
+		Anonymize:      anonymize,
+		cookbooks:      cookbooks,
+		cookbooksDir:   filepath.Join(wsDir, analyzeCacheDir, analyzeCookbooksDir),
+		numWorkers:     workers,
+		jobs:           jobs,
+	}, nil
+}
+
+// returns the checkouts of the cookbooks in the source paths indexed by the name in
+// their metadata, directories without metadata are not cookbooks and are ignored, the
+// first path with a cookbook is used and, within a path, the directory named after
+// the cookbook has precedence over other directories with the same metadata name
+func cookbookCheckouts(sourcePaths []string) map[string]string {
+	checkouts := make(map[string]string)
+	for _, sourcePath := range sourcePaths {
+		entries, err := ioutil.ReadDir(sourcePath)
+		if err != nil {
+			continue
+		}
+		found := make(map[string]string)
+		for _, entry := range entries {
+			checkout, err := filepath.Abs(filepath.Join(sourcePath, entry.Name()))
+			if err != nil {
+				continue
+			}
+			if info, err := os.Stat(checkout); err != nil || !info.IsDir() {
+				continue
+			}
+			item, err := localCookbookItem(checkout)
+			if err != nil {
+				continue
+			}
+			if _, ok := found[item.Name]; !ok || entry.Name() == item.Name {
+				found[item.Name] = checkout
+			}
+		}
+		for name, checkout := range found {
+			if _, ok := checkouts[name]; !ok {
+				checkouts[name] = checkout
+			}
+		}
+	}
+	return checkouts
+}
+
+// Generate compares every cookbook version with a pool of workers, the
+// Progress channel receives a message per cookbook version compared
+func (cdr *CookbookDriftReport) Generate() {
+	defer close(cdr.Progress)
+
+	jobsCh := make(chan cookbookDriftJob, len(cdr.jobs))
+	for _, job := range cdr.jobs {
+		jobsCh <- job
+	}
+	close(jobsCh)
+
+	// determine how many workers do we need, by default, the total number
+	// of cookbooks, but never more than the maximum allowed
+	numWorkers := cdr.TotalCookbooks
+	if cdr.TotalCookbooks > cdr.numWorkers {
+		numWorkers = cdr.numWorkers
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobsCh {
+				record := cdr.compare(job)
+				cdr.recordsMutex.Lock()
+				cdr.Records = append(cdr.Records, record)
+				cdr.recordsMutex.Unlock()
+				cdr.Progress <- 1
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// downloads the cookbook version and compares it with its checkout
+func (cdr *CookbookDriftReport) compare(job cookbookDriftJob) *CookbookDriftRecord {
+	record := &CookbookDriftRecord{Name: job.name, Version: job.version, SourcePath: job.sourcePath}
+	if cdr.Anonymize {
+		record.Name, record.SourcePath = hashString(job.name), hashString(job.sourcePath)
+	}
+
+	downloadDir := filepath.Join(cdr.cookbooksDir, fmt.Sprintf("%s-%s", job.name, job.version))
+	// a previous download could have files that were since removed from the cookbook
+	if err := os.RemoveAll(downloadDir); err != nil {
+		record.Error = errors.Wrapf(err, "unable to clean up cookbook %s", record.Name)
+		return record
+	}
+	if err := cdr.cookbooks.DownloadTo(job.name, job.version, cdr.cookbooksDir); err != nil {
+		record.Error = errors.Wrapf(err, "unable to download cookbook %s", record.Name)
+		return record
+	}
+
+	record.Revision, record.Status, record.Files, record.Error = CompareCookbookSource(downloadDir, job.sourcePath, job.name, job.version)
+	if cdr.Anonymize {
+		// tags can be qualified with the name of the cookbook, i.e. NAME-VERSION
+		record.Revision = strings.Replace(record.Revision, job.name, record.Name, 1)
+	}
+	return record
+}
+
+// CompareCookbookSource compares the content of a cookbook version with its source
+// checkout, the version is looked up in git by tag, or by the commit that set it in
+// the metadata.rb, otherwise the cookbook is compared with the working tree. Returns
+// the revision the version was found at, the drift status and the files that differ.
+func CompareCookbookSource(cookbookDir, sourcePath, name, version string) (string, string, []CookbookDriftFile, error) {
+	ignore, err := loadChefignore(sourcePath)
+	if err != nil {
+		return "", "", nil, err
+	}
+	serverFiles, err := workingTreeBlobs(cookbookDir, ignore)
+	if err != nil {
+		return "", "", nil, errors.Wrap(err, "unable to read the server cookbook")
+	}
+	sourceFiles, err := workingTreeBlobs(sourcePath, ignore)
+	if err != nil {
+		return "", "", nil, errors.Wrap(err, "unable to read the cookbook checkout")
+	}
+	workingTreeDiff := diffCookbookFiles(serverFiles, sourceFiles)
+
+	revision := ""
+	if isGitCheckout(sourcePath) {
+		revision, err = findVersionRevision(sourcePath, name, version, serverFiles, ignore)
+		if err != nil {
+			return "", "", nil, err
+		}
+	}
+
+	if revision == "" {
+		switch {
+		case len(workingTreeDiff) != 0:
+			return "", DriftUnknownSource, workingTreeDiff, nil
+		case isGitCheckout(sourcePath):
+			// the version is not in any commit
+			return "", DriftUncommitted, nil, nil
+		default:
+			return "", DriftInSync, nil, nil
+		}
+	}
+
+	revisionFiles, err := revisionBlobs(sourcePath, revision, ignore)
+	if err != nil {
+		return "", "", nil, err
+	}
+	revisionDiff := diffCookbookFiles(serverFiles, revisionFiles)
+	switch {
+	case len(revisionDiff) == 0:
+		return revision, DriftInSync, nil, nil
+	case len(workingTreeDiff) == 0:
+		return revision, DriftUncommitted, revisionDiff, nil
+	default:
+		return revision, DriftModified, revisionDiff, nil
+	}
+}
+
+// returns the files that differ between the server and the source, sorted by path
+func diffCookbookFiles(server, source map[string]string) []CookbookDriftFile {
+	files := make([]CookbookDriftFile, 0)
+	for path, blob := range server {
+		sourceBlob, ok := source[path]
+		switch {
+		case !ok:
+			files = append(files, CookbookDriftFile{path, DriftFileServerOnly})
+		case sourceBlob != blob:
+			files = append(files, CookbookDriftFile{path, DriftFileModified})
+		}
+	}
+	for path := range source {
+		if _, ok := server[path]; !ok {
+			files = append(files, CookbookDriftFile{path, DriftFileSourceOnly})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+// returns the git blob hashes of the files of a directory, indexed by their
+// path relative to the directory, so that they compare with the ones of a commit
+func workingTreeBlobs(dir string, ignore chefignore) (map[string]string, error) {
+	blobs := make(map[string]string)
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		path, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		path = filepath.ToSlash(path)
+		if info.IsDir() {
+			if path != "." && info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || ignore.ignored(path) {
+			return nil
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		blobs[path] = gitBlobHash(content)
+		return nil
+	})
+	return blobs, err
+}
+
+// returns the git blob hashes of the files of the checkout at a revision
+func revisionBlobs(checkout, revision string, ignore chefignore) (map[string]string, error) {
+	output, err := runGit(checkout, "ls-tree", "-r", "-z", revision, "--", ".")
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list the files of %s", revision)
+	}
+	blobs := make(map[string]string)
+	for _, entry := range strings.Split(output, "\x00") {
+		// <mode> SP <type> SP <object> TAB <file>
+		parts := strings.SplitN(entry, "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields := strings.Fields(parts[0])
+		if len(fields) != 3 || fields[0] != "100644" && fields[0] != "100755" || ignore.ignored(parts[1]) {
+			continue
+		}
+		blobs[parts[1]] = fields[2]
+	}
+	return blobs, nil
+}
+
+// the hash git gives to a file with the content
+func gitBlobHash(content []byte) string {
+	hash := sha1.New()
+	fmt.Fprintf(hash, "blob %d\x00", len(content))
+	hash.Write(content)
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+func isGitCheckout(dir string) bool {
+	_, err := runGit(dir, "rev-parse", "--is-inside-work-tree")
+	return err == nil
+}
+
+// returns the tag or the commit of the checkout the version is at, returns an
+// empty string if none is found. A tag named after the version has precedence,
+// the tags qualified with the name of the cookbook are tried first since a
+// repository with several cookbooks can only tag the version of one of them with
+// VERSION. Otherwise, since the version is usually bumped before the changes
+// that are released with it, the commits that changed the cookbook while its
+// metadata.rb held the version are searched for the newest one with the files
+// of the server, falling back to the newest of them
+func findVersionRevision(checkout, name, version string, serverFiles map[string]string, ignore chefignore) (string, error) {
+	for _, tag := range []string{name + "-" + version, name + "-v" + version, version, "v" + version} {
+		if _, err := runGit(checkout, "rev-parse", "--verify", "--quiet", "refs/tags/"+tag); err == nil {
+			return tag, nil
+		}
+	}
+
+	output, err := runGit(checkout, "log", "--format=%H", "--", ".")
+	if err != nil {
+		// i.e. a repository without commits
+		return "", nil
+	}
+	newest := ""
+	for _, commit := range strings.Fields(output) {
+		metadata, err := runGit(checkout, "show", commit+":./metadata.rb")
+		if err != nil {
+			continue
+		}
+		if match := metadataVersionPattern.FindStringSubmatch(metadata); match == nil || match[1] != version {
+			continue
+		}
+		if newest == "" {
+			newest = commit[0:12]
+		}
+		files, err := revisionBlobs(checkout, commit, ignore)
+		if err != nil {
+			return "", err
+		}
+		if len(diffCookbookFiles(serverFiles, files)) == 0 {
+			return commit[0:12], nil
+		}
+	}
+	return newest, nil
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok {
+			return "", errors.Wrap(exitError, strings.TrimSpace(string(exitError.Stderr)))
+		}
+		return "", errors.Wrap(err, "unable to run git")
+	}
+	return string(output), nil
+}
+
+// chefignore are the patterns of the files that are not uploaded
+// with a cookbook, matched as Ruby's File.fnmatch does
+type chefignore []*regexp.Regexp
+
+// reads the chefignore of the cookbook, or of the directory of cookbooks it is in
+func loadChefignore(cookbookDir string) (chefignore, error) {
+	var ignore chefignore
+	for _, file := range []string{
+		filepath.Join(cookbookDir, "chefignore"),
+		filepath.Join(filepath.Dir(cookbookDir), "chefignore"),
+	} {
+		f, err := os.Open(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read chefignore")
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			ignore = append(ignore, fnmatchPattern(line))
+		}
+		return ignore, errors.Wrap(scanner.Err(), "unable to read chefignore")
+	}
+	return ignore, nil
+}
+
+// returns true if the file at path, relative to the cookbook, is not compared
+// with the server, it is matched by a pattern of the chefignore or generated
+func (c chefignore) ignored(path string) bool {
+	// the metadata.json is generated when the cookbook is uploaded
+	if path == "metadata.json" {
+		return true
+	}
+	for _, pattern := range c {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// translates a glob to a regular expression, without FNM_PATHNAME a * matches slashes too
+func fnmatchPattern(glob string) *regexp.Regexp {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			pattern.WriteString(".*")
+		case '?':
+			pattern.WriteString(".")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				pattern.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			pattern.WriteString("[" + class + "]")
+			i += end
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	pattern.WriteString("$")
+	if re, err := regexp.Compile(pattern.String()); err == nil {
+		return re
+	}
+	// i.e. an invalid character class, the pattern matches itself only
+	return regexp.MustCompile("^" + regexp.QuoteMeta(glob) + "$")
+}
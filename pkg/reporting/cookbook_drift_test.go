@@ -0,0 +1,290 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chef/chef"
+	"github.com/stretchr/testify/assert"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+)
+
+// the content of version 1.0.0 of the foo cookbook
+var driftCookbookFiles = map[string]string{
+	"metadata.rb":        "name 'foo'\nversion '1.0.0'\n",
+	"recipes/default.rb": "package 'foo'\n",
+}
+
+func writeDriftFiles(dir string, files map[string]string) {
+	for file, content := range files {
+		path := filepath.Join(dir, file)
+		mustSucceed(os.MkdirAll(filepath.Dir(path), 0755))
+		mustSucceed(ioutil.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func git(dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		panic(string(output))
+	}
+}
+
+// creates a git checkout of the foo cookbook in a cookbooks directory with
+// version 1.0.0 committed, returns the base directory and the checkout
+func writeDriftCheckout() (string, string) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	mustSucceed(err)
+	checkout := filepath.Join(baseDir, "cookbooks", "foo")
+	writeDriftFiles(checkout, driftCookbookFiles)
+	writeDriftFiles(checkout, map[string]string{
+		"chefignore":             "spec/*\n.kitchen*\n",
+		"spec/default_spec.rb":   "describe 'foo'\n",
+		".kitchen/default.yml":   "state\n",
+		"test/integration/x.rb":  "describe package('foo')\n",
+		"files/default/conf.txt": "x\n",
+	})
+	git(checkout, "init", "-q")
+	git(checkout, "add", "-A")
+	git(checkout, "commit", "-q", "-m", "version 1.0.0")
+	return baseDir, checkout
+}
+
+// writes the server copy of the foo cookbook with the files of the checkout that are uploaded
+func writeDriftServerCookbook(baseDir string, files map[string]string) string {
+	serverDir := filepath.Join(baseDir, "server", "foo-1.0.0")
+	writeDriftFiles(serverDir, files)
+	writeDriftFiles(serverDir, map[string]string{
+		"metadata.json":          `{"name": "foo", "version": "1.0.0"}`,
+		"chefignore":             "spec/*\n.kitchen*\n",
+		"test/integration/x.rb":  "describe package('foo')\n",
+		"files/default/conf.txt": "x\n",
+	})
+	return serverDir
+}
+
+func TestCompareCookbookSource_InSyncWithCommit(t *testing.T) {
+	baseDir, checkout := writeDriftCheckout()
+	defer os.RemoveAll(baseDir)
+	serverDir := writeDriftServerCookbook(baseDir, driftCookbookFiles)
+
+	revision, status, files, err := subject.CompareCookbookSource(serverDir, checkout, "foo", "1.0.0")
+	if assert.Nil(t, err) {
+		assert.Len(t, revision, 12)
+		assert.Equal(t, subject.DriftInSync, status)
+		assert.Empty(t, files)
+	}
+}
+
+func TestCompareCookbookSource_TagHasPrecedence(t *testing.T) {
+	baseDir, checkout := writeDriftCheckout()
+	defer os.RemoveAll(baseDir)
+	git(checkout, "tag", "v1.0.0")
+	serverDir := writeDriftServerCookbook(baseDir, driftCookbookFiles)
+
+	revision, status, _, err := subject.CompareCookbookSource(serverDir, checkout, "foo", "1.0.0")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "v1.0.0", revision)
+		assert.Equal(t, subject.DriftInSync, status)
+	}
+}
+
+func TestCompareCookbookSource_NameTagHasPrecedence(t *testing.T) {
+	baseDir, checkout := writeDriftCheckout()
+	defer os.RemoveAll(baseDir)
+	git(checkout, "tag", "1.0.0")
+	git(checkout, "tag", "foo-v1.0.0")
+	serverDir := writeDriftServerCookbook(baseDir, driftCookbookFiles)
+
+	revision, status, _, err := subject.CompareCookbookSource(serverDir, checkout, "foo", "1.0.0")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "foo-v1.0.0", revision)
+		assert.Equal(t, subject.DriftInSync, status)
+	}
+}
+
+func TestCompareCookbookSource_GitHubDirectory(t *testing.T) {
+	baseDir, checkout := writeDriftCheckout()
+	defer os.RemoveAll(baseDir)
+	// only the .git directory is skipped, not the ones that start with .git
+	github := map[string]string{".github/workflows/ci.yml": "on: push\n"}
+	writeDriftFiles(checkout, github)
+	git(checkout, "add", "-A")
+	git(checkout, "commit", "-q", "-m", "ci")
+	git(checkout, "tag", "foo-1.0.0")
+	serverDir := writeDriftServerCookbook(baseDir, driftCookbookFiles)
+	writeDriftFiles(serverDir, github)
+
+	_, status, files, err := subject.CompareCookbookSource(serverDir, checkout, "foo", "1.0.0")
+	if assert.Nil(t, err) {
+		assert.Equal(t, subject.DriftInSync, status)
+		assert.Empty(t, files)
+	}
+}
+
+func TestCompareCookbookSource_CommitAfterVersionBump(t *testing.T) {
+	baseDir, checkout := writeDriftCheckout()
+	defer os.RemoveAll(baseDir)
+	// the version was bumped first, the fix uploaded with it was committed
+	// after it and the checkout moved on without bumping the version again
+	writeDriftFiles(checkout, map[string]string{"recipes/default.rb": "package 'foo-fixed'\n"})
+	git(checkout, "commit", "-q", "-a", "-m", "fix")
+	fix, err := exec.Command("git", "-C", checkout, "rev-parse", "--short=12", "HEAD").Output()
+	mustSucceed(err)
+	writeDriftFiles(checkout, map[string]string{"recipes/default.rb": "package 'foo-next'\n"})
+	git(checkout, "commit", "-q", "-a", "-m", "next")
+	serverDir := writeDriftServerCookbook(baseDir, map[string]string{
+		"metadata.rb":        driftCookbookFiles["metadata.rb"],
+		"recipes/default.rb": "package 'foo-fixed'\n",
+	})
+
+	revision, status, files, err := subject.CompareCookbookSource(serverDir, checkout, "foo", "1.0.0")
+	if assert.Nil(t, err) {
+		assert.Equal(t, strings.TrimSpace(string(fix)), revision)
+		assert.Equal(t, subject.DriftInSync, status)
+		assert.Empty(t, files)
+	}
+}
+
+func TestCompareCookbookSource_Uncommitted(t *testing.T) {
+	baseDir, checkout := writeDriftCheckout()
+	defer os.RemoveAll(baseDir)
+	// uploaded from the working tree before the change was committed
+	writeDriftFiles(checkout, map[string]string{"recipes/default.rb": "package 'foo-ng'\n"})
+	serverDir := writeDriftServerCookbook(baseDir, map[string]string{
+		"metadata.rb":        driftCookbookFiles["metadata.rb"],
+		"recipes/default.rb": "package 'foo-ng'\n",
+	})
+
+	revision, status, files, err := subject.CompareCookbookSource(serverDir, checkout, "foo", "1.0.0")
+	if assert.Nil(t, err) {
+		assert.NotEmpty(t, revision)
+		assert.Equal(t, subject.DriftUncommitted, status)
+		assert.Equal(t, []subject.CookbookDriftFile{
+			{Path: "recipes/default.rb", Change: subject.DriftFileModified},
+		}, files)
+	}
+}
+
+func TestCompareCookbookSource_Modified(t *testing.T) {
+	baseDir, checkout := writeDriftCheckout()
+	defer os.RemoveAll(baseDir)
+	serverDir := writeDriftServerCookbook(baseDir, map[string]string{
+		"metadata.rb":         driftCookbookFiles["metadata.rb"],
+		"recipes/default.rb":  "package 'hotfix'\n",
+		"recipes/hotfix.rb":   "log 'hotfix'\n",
+		"spec/hotfix_spec.rb": "ignored\n",
+	})
+	mustSucceed(os.Remove(filepath.Join(serverDir, "files", "default", "conf.txt")))
+
+	_, status, files, err := subject.CompareCookbookSource(serverDir, checkout, "foo", "1.0.0")
+	if assert.Nil(t, err) {
+		assert.Equal(t, subject.DriftModified, status)
+		assert.Equal(t, []subject.CookbookDriftFile{
+			{Path: "files/default/conf.txt", Change: subject.DriftFileSourceOnly},
+			{Path: "recipes/default.rb", Change: subject.DriftFileModified},
+			{Path: "recipes/hotfix.rb", Change: subject.DriftFileServerOnly},
+		}, files)
+	}
+}
+
+func TestCompareCookbookSource_UnknownSource(t *testing.T) {
+	baseDir, checkout := writeDriftCheckout()
+	defer os.RemoveAll(baseDir)
+	serverDir := writeDriftServerCookbook(baseDir, map[string]string{
+		"metadata.rb":        "name 'foo'\nversion '0.9.0'\n",
+		"recipes/default.rb": driftCookbookFiles["recipes/default.rb"],
+	})
+
+	revision, status, files, err := subject.CompareCookbookSource(serverDir, checkout, "foo", "0.9.0")
+	if assert.Nil(t, err) {
+		assert.Empty(t, revision)
+		assert.Equal(t, subject.DriftUnknownSource, status)
+		assert.Equal(t, []subject.CookbookDriftFile{
+			{Path: "metadata.rb", Change: subject.DriftFileModified},
+		}, files)
+	}
+}
+
+func TestCookbookDriftReport(t *testing.T) {
+	defer os.RemoveAll(createConfigToml(t))
+	baseDir, _ := writeDriftCheckout()
+	defer os.RemoveAll(baseDir)
+
+	cookbooks := newMockCookbook(chef.CookbookListResult{
+		"foo": chef.CookbookVersions{Versions: []chef.CookbookVersion{{Version: "1.0.0"}}},
+		// without checkout, not compared
+		"bar": chef.CookbookVersions{Versions: []chef.CookbookVersion{{Version: "2.0.0"}}},
+		// its checkout has another name in the metadata, not compared
+		"baz": chef.CookbookVersions{Versions: []chef.CookbookVersion{{Version: "3.0.0"}}},
+	}, nil, nil)
+	writeDriftFiles(filepath.Join(baseDir, "cookbooks", "baz"), map[string]string{"metadata.rb": "name 'baz_fork'\n"})
+	// a directory that is not a cookbook
+	mustSucceed(os.MkdirAll(filepath.Join(baseDir, "cookbooks", "bar"), 0755))
+	cookbooks.downloadFiles = map[string]string{
+		"metadata.rb":            driftCookbookFiles["metadata.rb"],
+		"recipes/default.rb":     driftCookbookFiles["recipes/default.rb"],
+		"test/integration/x.rb":  "describe package('foo')\n",
+		"files/default/conf.txt": "x\n",
+		"chefignore":             "spec/*\n.kitchen*\n",
+	}
+
+	report, err := subject.NewCookbookDriftReport(cookbooks, []string{"/does/not/exist", filepath.Join(baseDir, "cookbooks")}, Workers, false)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 1, report.TotalCookbooks)
+		report.Generate()
+		assert.Equal(t, 1, len(report.Progress))
+		if assert.Len(t, report.Records, 1) {
+			record := report.Records[0]
+			assert.Nil(t, record.Error)
+			assert.Equal(t, "foo", record.Name)
+			assert.Equal(t, subject.DriftInSync, record.Status)
+			assert.False(t, record.Drifted())
+		}
+	}
+}
+
+func TestCookbookDriftReport_Anonymize(t *testing.T) {
+	defer os.RemoveAll(createConfigToml(t))
+	baseDir, checkout := writeDriftCheckout()
+	defer os.RemoveAll(baseDir)
+	git(checkout, "tag", "foo-1.0.0")
+
+	cookbooks := newMockCookbook(chef.CookbookListResult{
+		"foo": chef.CookbookVersions{Versions: []chef.CookbookVersion{{Version: "1.0.0"}}},
+	}, nil, nil)
+	cookbooks.downloadFiles = driftCookbookFiles
+
+	report, err := subject.NewCookbookDriftReport(cookbooks, []string{filepath.Join(baseDir, "cookbooks")}, Workers, true)
+	if assert.Nil(t, err) {
+		report.Generate()
+		if assert.Len(t, report.Records, 1) {
+			record := report.Records[0]
+			assert.Nil(t, record.Error)
+			assert.Len(t, record.Name, 64)
+			assert.NotContains(t, record.SourcePath, baseDir)
+			assert.Equal(t, record.Name+"-1.0.0", record.Revision)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+)
+
+// matches the depends of a metadata.rb, with or without a version constraint
+var metadataDependsPattern = regexp.MustCompile(
+	`(?m)^\s*depends\s*\(?\s*['"]([^'"]+)['"](?:\s*,\s*['"]([^'"]+)['"])?`)
+
+// matches the keys of the dependencies of a policy solution, i.e. "apache2 (5.0.1)"
+var solutionDependencyPattern = regexp.MustCompile(`^(\S+) \(([^)]+)\)$`)
+
+// CookbookGraph is the dependency graph of the cookbooks of a report
+type CookbookGraph struct {
+	Cookbooks []*CookbookGraphNode `json:"cookbooks"`
+}
+
+// CookbookGraphNode is a cookbook version of the dependency graph, the cookbooks
+// of a policy only depend on the cookbooks locked by the same policy revision
+type CookbookGraphNode struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Version     string `json:"version,omitempty"`
+	Policy      string `json:"policy,omitempty"`
+	PolicyGroup string `json:"policy_group,omitempty"`
+	// the IDs of the cookbooks it depends on directly
+	Depends []string `json:"depends"`
+	// the IDs of the cookbooks that depend on it, directly or transitively
+	Dependents []string `json:"dependents"`
+	// the nodes that run the cookbook directly
+	Nodes []string `json:"nodes"`
+	// the nodes that run the cookbook or any of its dependents, see BuildCookbookGraph
+	BlastRadius []string `json:"blast_radius"`
+}
+
+// GraphID returns the ID of the cookbook in the dependency graph
+func (cr *CookbookRecord) GraphID() string {
+	if cr.Policy == "" {
+		return fmt.Sprintf("%s@%s", cr.Name, cr.Version)
+	}
+	revision := cr.PolicyVer
+	if len(revision) > 10 {
+		revision = revision[0:10]
+	}
+	if cr.PolicyGroup == "" {
+		return fmt.Sprintf("%s@%s/%s", cr.Policy, revision, cr.Name)
+	}
+	return fmt.Sprintf("%s/%s@%s/%s", cr.PolicyGroup, cr.Policy, revision, cr.Name)
+}
+
+// BuildCookbookGraph builds the dependency graph of the records from their
+// Depends, a classic cookbook depends on every version of the cookbooks of
+// the records that satisfies its constraint, the Dependents and BlastRadius
+// of every record are set from the graph
+//
+// The blast radius of a cookbook locked by a policy is the nodes of the policy
+// revision that run it. A node that runs a dependent of a classic cookbook version
+// may run another version of the cookbook though, so the blast radius of classic
+// cookbooks is computed per cookbook name: the nodes that run any of its versions
+// or any of the dependents of those versions, it is the same for every version.
+func BuildCookbookGraph(records []*CookbookRecord) *CookbookGraph {
+	var (
+		graph      = &CookbookGraph{Cookbooks: make([]*CookbookGraphNode, 0, len(records))}
+		byID       = make(map[string]*CookbookGraphNode, len(records))
+		byName     = make(map[string][]*CookbookRecord)
+		dependents = make(map[string][]string)
+		// the blast radius of the classic cookbooks, by cookbook name
+		classicRadius = make(map[string]map[string]bool)
+	)
+	for _, record := range records {
+		byName[record.Name] = append(byName[record.Name], record)
+	}
+
+	for _, record := range records {
+		node := &CookbookGraphNode{
+			ID:          record.GraphID(),
+			Name:        record.Name,
+			Version:     record.Version,
+			Policy:      record.Policy,
+			PolicyGroup: record.PolicyGroup,
+			Depends:     make([]string, 0),
+			Nodes:       append([]string{}, record.Nodes...),
+		}
+		sort.Strings(node.Nodes)
+		for name := range record.Depends {
+			for _, dependency := range byName[name] {
+				if record.dependsOn(dependency) {
+					node.Depends = append(node.Depends, dependency.GraphID())
+					dependents[dependency.GraphID()] = append(dependents[dependency.GraphID()], node.ID)
+				}
+			}
+		}
+		sort.Strings(node.Depends)
+		graph.Cookbooks = append(graph.Cookbooks, node)
+		byID[node.ID] = node
+	}
+
+	for _, node := range graph.Cookbooks {
+		// walks the dependents breadth-first, dependency cycles are possible
+		var (
+			visited = map[string]bool{node.ID: true}
+			queue   = append([]string{}, dependents[node.ID]...)
+			radius  = make(map[string]bool)
+		)
+		for _, name := range node.Nodes {
+			radius[name] = true
+		}
+		node.Dependents = make([]string, 0)
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+			node.Dependents = append(node.Dependents, id)
+			for _, name := range byID[id].Nodes {
+				radius[name] = true
+			}
+			queue = append(queue, dependents[id]...)
+		}
+		sort.Strings(node.Dependents)
+
+		if node.Policy == "" {
+			if classicRadius[node.Name] == nil {
+				classicRadius[node.Name] = make(map[string]bool)
+			}
+			for name := range radius {
+				classicRadius[node.Name][name] = true
+			}
+			continue
+		}
+		node.BlastRadius = sortedKeys(radius)
+	}
+	for _, node := range graph.Cookbooks {
+		if node.Policy == "" {
+			node.BlastRadius = sortedKeys(classicRadius[node.Name])
+		}
+	}
+
+	sort.Slice(graph.Cookbooks, func(i, j int) bool { return graph.Cookbooks[i].ID < graph.Cookbooks[j].ID })
+	for _, record := range records {
+		node := byID[record.GraphID()]
+		record.Dependents = node.Dependents
+		record.BlastRadius = node.BlastRadius
+	}
+	return graph
+}
+
+// returns the keys of the set, sorted
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// returns true if the record depends on the other one directly
+func (cr *CookbookRecord) dependsOn(other *CookbookRecord) bool {
+	constraint, ok := cr.Depends[other.Name]
+	if !ok || cr == other {
+		return false
+	}
+	if cr.Policy != "" {
+		return other.Policy == cr.Policy &&
+			other.PolicyGroup == cr.PolicyGroup &&
+			other.PolicyVer == cr.PolicyVer
+	}
+	if other.Policy != "" {
+		return false
+	}
+	vc, err := ParseVersionConstraint(constraint)
+	if err != nil {
+		// an unknown constraint could match any version
+		return true
+	}
+	return vc.Satisfies(other.Version)
+}
+
+// returns the dependencies of the cookbook in dir from its metadata, the
+// metadata.json generated on upload has precedence over the metadata.rb
+func readCookbookDepends(dir string) (map[string]string, error) {
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "metadata.json")); err == nil {
+		metadata, err := chef.NewMetaDataFromJson(content)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse metadata of cookbook %s", dir)
+		}
+		if metadata.Depends == nil {
+			return map[string]string{}, nil
+		}
+		return metadata.Depends, nil
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "metadata.rb"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read metadata of cookbook %s", dir)
+	}
+	depends := make(map[string]string)
+	for _, match := range metadataDependsPattern.FindAllSubmatch(content, -1) {
+		depends[string(match[1])] = string(match[2])
+	}
+	return depends, nil
+}
+
+// returns the dependencies of every cookbook of a policy revision, indexed by
+// cookbook name, from the dependencies of its solution
+func policySolutionDepends(policy *chef.RevisionDetailsResponse) map[string]map[string]string {
+	solution := make(map[string]map[string]string)
+	// decoded from JSON, an object of "NAME (VERSION)": [[DEPENDENCY, CONSTRAINT], ...]
+	dependencies, ok := policy.SolutionDependencies.Dependencies.(map[string]interface{})
+	if !ok {
+		return solution
+	}
+	for key, value := range dependencies {
+		match := solutionDependencyPattern.FindStringSubmatch(key)
+		if match == nil {
+			continue
+		}
+		depends := make(map[string]string)
+		entries, _ := value.([]interface{})
+		for _, entry := range entries {
+			pair, _ := entry.([]interface{})
+			if len(pair) == 0 {
+				continue
+			}
+			name, _ := pair[0].(string)
+			constraint := ""
+			if len(pair) > 1 {
+				constraint, _ = pair[1].(string)
+			}
+			if name != "" {
+				depends[name] = constraint
+			}
+		}
+		solution[match[1]] = depends
+	}
+	return solution
+}
+
+// hashes the names of the dependencies like the names of the records
+func hashDepends(depends map[string]string) map[string]string {
+	hashed := make(map[string]string, len(depends))
+	for name, constraint := range depends {
+		hashed[hashString(name)] = constraint
+	}
+	return hashed
+}
@@ -0,0 +1,223 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+)
+
+// returns the node of the graph with the provided ID, nil if not found
+func graphNode(graph *subject.CookbookGraph, id string) *subject.CookbookGraphNode {
+	for _, node := range graph.Cookbooks {
+		if node.ID == id {
+			return node
+		}
+	}
+	return nil
+}
+
+func TestBuildCookbookGraph(t *testing.T) {
+	records := []*subject.CookbookRecord{
+		{Name: "base", Version: "1.0.0", Nodes: []string{"node1"}},
+		{Name: "base", Version: "2.0.0", Nodes: []string{}},
+		{Name: "web", Version: "1.0.0", Nodes: []string{"node2"}, Depends: map[string]string{"base": "~> 1.0"}},
+		{Name: "app", Version: "1.0.0", Nodes: []string{"node3"}, Depends: map[string]string{"web": ">= 0.0.0"}},
+		// a dependency cycle
+		{Name: "ping", Version: "0.1.0", Nodes: []string{"node4"}, Depends: map[string]string{"pong": ""}},
+		{Name: "pong", Version: "0.1.0", Nodes: []string{"node5"}, Depends: map[string]string{"ping": ""}},
+		// the cookbooks of a policy only depend on the ones locked by the same revision
+		{Name: "base", Version: "2.0.0", Policy: "web", PolicyGroup: "prod", PolicyVer: "1a2b3c4d5e6f7a8b9c", Nodes: []string{"node6"}},
+		{Name: "web", Version: "3.0.0", Policy: "web", PolicyGroup: "prod", PolicyVer: "1a2b3c4d5e6f7a8b9c", Nodes: []string{"node6"},
+			Depends: map[string]string{"base": "= 2.0.0"}},
+	}
+
+	graph := subject.BuildCookbookGraph(records)
+	if assert.Len(t, graph.Cookbooks, 8) {
+		assert.Equal(t, "app@1.0.0", graph.Cookbooks[0].ID, "the cookbooks are sorted by ID")
+	}
+
+	base := graphNode(graph, "base@1.0.0")
+	if assert.NotNil(t, base) {
+		assert.Empty(t, base.Depends)
+		assert.Equal(t, []string{"app@1.0.0", "web@1.0.0"}, base.Dependents)
+		assert.Equal(t, []string{"node1"}, base.Nodes)
+		assert.Equal(t, []string{"node1", "node2", "node3"}, base.BlastRadius)
+	}
+	base2 := graphNode(graph, "base@2.0.0")
+	if assert.NotNil(t, base2) {
+		assert.Empty(t, base2.Dependents, "doesn't satisfy the constraint of web")
+		// the blast radius of classic cookbooks is computed per cookbook name
+		assert.Equal(t, []string{"node1", "node2", "node3"}, base2.BlastRadius)
+	}
+	web := graphNode(graph, "web@1.0.0")
+	if assert.NotNil(t, web) {
+		assert.Equal(t, []string{"base@1.0.0"}, web.Depends)
+		assert.Equal(t, []string{"app@1.0.0"}, web.Dependents)
+		assert.Equal(t, []string{"node2", "node3"}, web.BlastRadius)
+	}
+	ping := graphNode(graph, "ping@0.1.0")
+	if assert.NotNil(t, ping) {
+		assert.Equal(t, []string{"pong@0.1.0"}, ping.Dependents)
+		assert.Equal(t, []string{"node4", "node5"}, ping.BlastRadius)
+	}
+	policyBase := graphNode(graph, "prod/web@1a2b3c4d5e/base")
+	if assert.NotNil(t, policyBase) {
+		assert.Equal(t, "web", policyBase.Policy)
+		assert.Equal(t, []string{"prod/web@1a2b3c4d5e/web"}, policyBase.Dependents)
+		assert.Equal(t, []string{"node6"}, policyBase.BlastRadius)
+	}
+
+	// the records are updated with their dependents and blast radius
+	assert.Equal(t, []string{"app@1.0.0", "web@1.0.0"}, records[0].Dependents)
+	assert.Equal(t, []string{"node1", "node2", "node3"}, records[0].BlastRadius)
+}
+
+func TestBuildCookbookGraph_Empty(t *testing.T) {
+	graph := subject.BuildCookbookGraph([]*subject.CookbookRecord{})
+	assert.Empty(t, graph.Cookbooks)
+}
+
+func TestCookbooksReport_WithDependencies(t *testing.T) {
+	defer os.RemoveAll(createConfigToml(t))
+
+	cookbookList := chef.CookbookListResult{
+		"foo": chef.CookbookVersions{Versions: []chef.CookbookVersion{{Version: "0.1.0"}}},
+		"bar": chef.CookbookVersions{Versions: []chef.CookbookVersion{{Version: "0.1.0"}}},
+	}
+	cookbooks := newMockCookbook(cookbookList, nil, nil)
+	cookbooks.desiredCookbooks = map[string]chef.Cookbook{
+		"foo-0.1.0": {Metadata: chef.CookbookMeta{Depends: map[string]string{"bar": "~> 0.1"}}},
+		"bar-0.1.0": {Metadata: chef.CookbookMeta{}},
+	}
+
+	policyGroupList := chef.PolicyGroupGetResponse{
+		"my-policygroup": chef.PolicyGroup{
+			Policies: map[string]chef.Revision{
+				"my-policy": chef.Revision{"revision_id": "123xyz"},
+			},
+		},
+	}
+	var policyDetail chef.RevisionDetailsResponse
+	mustSucceed(json.Unmarshal([]byte(`{
+  "name": "my-policy",
+  "revision_id": "123xyz",
+  "cookbook_locks": {
+    "alpha": {"version": "1.0.0", "identifier": "123456789012345678901234567890xyz"},
+    "gama": {"version": "2.0.0", "identifier": "abc456789012345678901234567890xyz"}
+  },
+  "solution_dependencies": {
+    "Policyfile": [["alpha", ">= 0.0.0"]],
+    "dependencies": {
+      "alpha (1.0.0)": [["gama", "~> 2.0"]],
+      "gama (2.0.0)": []
+    }
+  }
+}`), &policyDetail))
+
+	chefAnalyzeClient := subject.ChefAnalyzeClient{
+		Cookbooks:         cookbooks,
+		CookbookArtifacts: newMockCookbookArtifact(chef.CBAGetResponse{}, nil, nil),
+		PolicyGroups:      newMockPolicyGroup(policyGroupList, nil),
+		Policies:          newMockPolicy(policyDetail, nil),
+		Search:            makeMockSearch(mockedNodesSearchRows(), nil),
+	}
+
+	c, err := subject.NewCookbooksReport(&chefAnalyzeClient, false, false, Workers, "", false)
+	if assert.Nil(t, err) {
+		c.WithDependencies = true
+		c.Generate()
+		assert.Equal(t, 4, len(c.Records))
+		if assert.NotNil(t, c.Graph) {
+			assert.Len(t, c.Graph.Cookbooks, 4)
+			bar := graphNode(c.Graph, "bar@0.1.0")
+			if assert.NotNil(t, bar) {
+				assert.Equal(t, []string{"foo@0.1.0"}, bar.Dependents)
+				assert.Equal(t, []string{"node1", "node2", "node3"}, bar.BlastRadius)
+			}
+			gama := graphNode(c.Graph, "my-policygroup/my-policy@123xyz/gama")
+			if assert.NotNil(t, gama) {
+				assert.Equal(t, []string{"my-policygroup/my-policy@123xyz/alpha"}, gama.Dependents)
+			}
+		}
+		for _, record := range c.Records {
+			assert.Empty(t, record.Errors())
+			assert.NotNil(t, record.Depends)
+		}
+	}
+}
+
+func TestCookbooksReport_WithDependenciesErrors(t *testing.T) {
+	defer os.RemoveAll(createConfigToml(t))
+
+	cookbookList := chef.CookbookListResult{
+		"foo": chef.CookbookVersions{Versions: []chef.CookbookVersion{{Version: "0.1.0"}}},
+	}
+	cookbooks := newMockCookbook(cookbookList, nil, nil)
+	cookbooks.desiredGetVersionError = errors.New("not found")
+
+	chefAnalyzeClient := subject.ChefAnalyzeClient{
+		Cookbooks:         cookbooks,
+		CookbookArtifacts: newMockCookbookArtifact(chef.CBAGetResponse{}, nil, nil),
+		PolicyGroups:      newMockPolicyGroup(chef.PolicyGroupGetResponse{}, nil),
+		Policies:          newMockPolicy(chef.RevisionDetailsResponse{}, nil),
+		Search:            makeMockSearch(mockedNodesSearchRows(), nil),
+	}
+
+	c, err := subject.NewCookbooksReport(&chefAnalyzeClient, false, false, Workers, "", false)
+	if assert.Nil(t, err) {
+		c.WithDependencies = true
+		c.Generate()
+		if assert.Len(t, c.Records, 1) && assert.Len(t, c.Records[0].Errors(), 1) {
+			assert.Equal(t,
+				"unable to read dependencies of cookbook foo: unable to retrieve cookbook metadata: not found",
+				c.Records[0].Errors()[0].Error())
+		}
+		if assert.NotNil(t, c.Graph) {
+			assert.Len(t, c.Graph.Cookbooks, 1)
+		}
+	}
+}
+
+func TestLocalCookbooksReport_WithDependencies(t *testing.T) {
+	repoDir := writeLocalChefRepo()
+	defer os.RemoveAll(repoDir)
+
+	c, err := subject.NewLocalCookbooksReport(repoDir, nil, false, false, Workers, false)
+	if assert.Nil(t, err) {
+		c.WithDependencies = true
+		c.Generate()
+		if assert.NotNil(t, c.Graph) {
+			base := graphNode(c.Graph, "base@2.0.0")
+			if assert.NotNil(t, base) {
+				assert.Equal(t, []string{"web@1.2.0"}, base.Dependents)
+			}
+			// without solution dependencies, read from the source of the lock
+			policyBase := graphNode(c.Graph, "web@1a2b3c/base")
+			if assert.NotNil(t, policyBase) {
+				assert.Equal(t, []string{"web@1a2b3c/web"}, policyBase.Dependents)
+			}
+		}
+	}
+}
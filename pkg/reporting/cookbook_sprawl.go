@@ -0,0 +1,147 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CookbookSprawl is the versions of a cookbook that are in use
+type CookbookSprawl struct {
+	Name string
+	// sorted from the oldest to the newest version
+	Versions []*CookbookSprawlVersion
+}
+
+// CookbookSprawlVersion is a version of a cookbook in use, with the
+// environments and policy groups that pin it
+type CookbookSprawlVersion struct {
+	Version string
+	Nodes   []string
+	// as ENVIRONMENT (CONSTRAINT)
+	EnvironmentPins []string
+	// as POLICY_GROUP (POLICY), or POLICY for the policies
+	// of the Policyfile.lock.json files, which have no group
+	PolicyGroups []string
+}
+
+// NumVersions returns the number of distinct versions in use
+func (cs *CookbookSprawl) NumVersions() int {
+	return len(cs.Versions)
+}
+
+// Oldest returns the oldest version in use
+func (cs *CookbookSprawl) Oldest() string {
+	if len(cs.Versions) == 0 {
+		return ""
+	}
+	return cs.Versions[0].Version
+}
+
+// Newest returns the newest version in use
+func (cs *CookbookSprawl) Newest() string {
+	if len(cs.Versions) == 0 {
+		return ""
+	}
+	return cs.Versions[len(cs.Versions)-1].Version
+}
+
+// NumNodes returns the number of distinct nodes that use any version
+func (cs *CookbookSprawl) NumNodes() int {
+	nodes := make(map[string]bool)
+	for _, version := range cs.Versions {
+		for _, node := range version.Nodes {
+			nodes[node] = true
+		}
+	}
+	return len(nodes)
+}
+
+// BuildCookbookSprawl aggregates the records of a report of the cookbooks in use by
+// cookbook name, the versions locked by policies are merged with the versions of the
+// same number, the cookbooks with the most versions in use come first
+func BuildCookbookSprawl(records []*CookbookRecord) []*CookbookSprawl {
+	var (
+		sprawl   = make([]*CookbookSprawl, 0)
+		byName   = make(map[string]*CookbookSprawl)
+		versions = make(map[string]*CookbookSprawlVersion)
+		nodes    = make(map[string]map[string]bool)
+	)
+
+	for _, record := range records {
+		cookbook, ok := byName[record.Name]
+		if !ok {
+			cookbook = &CookbookSprawl{Name: record.Name, Versions: make([]*CookbookSprawlVersion, 0)}
+			byName[record.Name] = cookbook
+			sprawl = append(sprawl, cookbook)
+		}
+
+		key := fmt.Sprintf("%s@%s", record.Name, record.Version)
+		version, ok := versions[key]
+		if !ok {
+			version = &CookbookSprawlVersion{
+				Version:         record.Version,
+				Nodes:           make([]string, 0),
+				EnvironmentPins: make([]string, 0),
+				PolicyGroups:    make([]string, 0),
+			}
+			versions[key] = version
+			nodes[key] = make(map[string]bool)
+			cookbook.Versions = append(cookbook.Versions, version)
+		}
+
+		// a node could use the same version from more than one record
+		for _, node := range record.Nodes {
+			if !nodes[key][node] {
+				nodes[key][node] = true
+				version.Nodes = append(version.Nodes, node)
+			}
+		}
+		for _, pin := range record.EnvironmentPins {
+			version.EnvironmentPins = appendUnique(version.EnvironmentPins, pin)
+		}
+		switch {
+		case record.PolicyGroup != "":
+			version.PolicyGroups = appendUnique(version.PolicyGroups,
+				fmt.Sprintf("%s (%s)", record.PolicyGroup, record.Policy))
+		case record.Policy != "":
+			version.PolicyGroups = appendUnique(version.PolicyGroups, record.Policy)
+		}
+	}
+
+	for _, cookbook := range sprawl {
+		for _, version := range cookbook.Versions {
+			sort.Strings(version.Nodes)
+			sort.Strings(version.EnvironmentPins)
+			sort.Strings(version.PolicyGroups)
+		}
+		sort.Slice(cookbook.Versions, func(i, j int) bool {
+			if cmp := CompareVersions(cookbook.Versions[i].Version, cookbook.Versions[j].Version); cmp != 0 {
+				return cmp < 0
+			}
+			return cookbook.Versions[i].Version < cookbook.Versions[j].Version
+		})
+	}
+	sort.Slice(sprawl, func(i, j int) bool {
+		if sprawl[i].NumVersions() != sprawl[j].NumVersions() {
+			return sprawl[i].NumVersions() > sprawl[j].NumVersions()
+		}
+		return sprawl[i].Name < sprawl[j].Name
+	})
+	return sprawl
+}
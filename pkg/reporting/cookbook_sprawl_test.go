@@ -0,0 +1,147 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+)
+
+func TestBuildCookbookSprawl(t *testing.T) {
+	records := []*subject.CookbookRecord{
+		{Name: "base", Version: "1.10.0", Nodes: []string{"node3"}},
+		{Name: "base", Version: "1.2.0", Nodes: []string{"node1", "node2"}, EnvironmentPins: []string{"staging (~> 1.2)"}},
+		{Name: "base", Version: "2.0.0", Nodes: []string{"node4"}, Policy: "web", PolicyGroup: "prod", PolicyVer: "abc"},
+		// the same version locked by another revision of the policy
+		{Name: "base", Version: "2.0.0", Nodes: []string{"node4", "node5"}, Policy: "web", PolicyGroup: "dev", PolicyVer: "def"},
+		{Name: "web", Version: "1.0.0", Nodes: []string{"node1"}},
+		// locked by a Policyfile.lock.json, without policy group
+		{Name: "web", Version: "1.0.0", Nodes: []string{"node6"}, Policy: "api", PolicyVer: "123"},
+	}
+
+	sprawl := subject.BuildCookbookSprawl(records)
+	if assert.Len(t, sprawl, 2) {
+		base := sprawl[0]
+		assert.Equal(t, "base", base.Name, "the cookbooks with the most versions come first")
+		assert.Equal(t, 3, base.NumVersions())
+		assert.Equal(t, "1.2.0", base.Oldest())
+		assert.Equal(t, "2.0.0", base.Newest())
+		assert.Equal(t, 5, base.NumNodes())
+		if assert.Len(t, base.Versions, 3) {
+			assert.Equal(t, "1.10.0", base.Versions[1].Version)
+			assert.Equal(t, []string{"staging (~> 1.2)"}, base.Versions[0].EnvironmentPins)
+			assert.Empty(t, base.Versions[0].PolicyGroups)
+			assert.Equal(t, []string{"node4", "node5"}, base.Versions[2].Nodes)
+			assert.Equal(t, []string{"dev (web)", "prod (web)"}, base.Versions[2].PolicyGroups)
+		}
+
+		web := sprawl[1]
+		assert.Equal(t, "web", web.Name)
+		assert.Equal(t, 1, web.NumVersions())
+		assert.Equal(t, web.Oldest(), web.Newest())
+		assert.Equal(t, []string{"api"}, web.Versions[0].PolicyGroups)
+	}
+}
+
+func TestBuildCookbookSprawl_Empty(t *testing.T) {
+	assert.Empty(t, subject.BuildCookbookSprawl([]*subject.CookbookRecord{}))
+	assert.Equal(t, "", (&subject.CookbookSprawl{}).Oldest())
+	assert.Equal(t, "", (&subject.CookbookSprawl{}).Newest())
+}
+
+const sprawlNodesSearchRows = `[
+  {"data": {"name": "node1", "chef_environment": "production"}},
+  {"data": {"name": "node2", "chef_environment": "production"}}
+]`
+
+func TestCookbooksReport_WithSprawl(t *testing.T) {
+	defer os.RemoveAll(createConfigToml(t))
+
+	cookbookList := chef.CookbookListResult{
+		"foo": chef.CookbookVersions{Versions: []chef.CookbookVersion{{Version: "0.1.0"}, {Version: "0.2.0"}}},
+		"bar": chef.CookbookVersions{Versions: []chef.CookbookVersion{{Version: "1.0.0"}}},
+	}
+
+	chefAnalyzeClient := subject.ChefAnalyzeClient{
+		Cookbooks:         newMockCookbook(cookbookList, nil, nil),
+		CookbookArtifacts: newMockCookbookArtifact(chef.CBAGetResponse{}, nil, nil),
+		PolicyGroups:      newMockPolicyGroup(chef.PolicyGroupGetResponse{}, nil),
+		Policies:          newMockPolicy(chef.RevisionDetailsResponse{}, nil),
+		Search:            makeMockSearch(sprawlNodesSearchRows, nil),
+		Environments: EnvMock{Env: &chef.Environment{
+			Name:             "production",
+			CookbookVersions: map[string]string{"foo": "~> 0.1"},
+		}},
+	}
+
+	c, err := subject.NewCookbooksReport(&chefAnalyzeClient, false, false, Workers, "", false)
+	if assert.Nil(t, err) {
+		c.WithSprawl = true
+		c.Generate()
+		if assert.Len(t, c.Sprawl, 2) {
+			foo := c.Sprawl[0]
+			assert.Equal(t, "foo", foo.Name)
+			assert.Equal(t, 2, foo.NumVersions())
+			assert.Equal(t, "0.1.0", foo.Oldest())
+			assert.Equal(t, "0.2.0", foo.Newest())
+			assert.Equal(t, 2, foo.NumNodes())
+			for _, version := range foo.Versions {
+				assert.Equal(t, []string{"node1", "node2"}, version.Nodes)
+				assert.Equal(t, []string{"production (~> 0.1)"}, version.EnvironmentPins)
+			}
+
+			bar := c.Sprawl[1]
+			assert.Equal(t, "bar", bar.Name)
+			if assert.Len(t, bar.Versions, 1) {
+				assert.Empty(t, bar.Versions[0].EnvironmentPins, "not pinned by the environment")
+			}
+		}
+	}
+}
+
+func TestCookbooksReport_WithSprawlEnvironmentError(t *testing.T) {
+	defer os.RemoveAll(createConfigToml(t))
+
+	cookbookList := chef.CookbookListResult{
+		"foo": chef.CookbookVersions{Versions: []chef.CookbookVersion{{Version: "0.1.0"}}},
+	}
+
+	chefAnalyzeClient := subject.ChefAnalyzeClient{
+		Cookbooks:         newMockCookbook(cookbookList, nil, nil),
+		CookbookArtifacts: newMockCookbookArtifact(chef.CBAGetResponse{}, nil, nil),
+		PolicyGroups:      newMockPolicyGroup(chef.PolicyGroupGetResponse{}, nil),
+		Policies:          newMockPolicy(chef.RevisionDetailsResponse{}, nil),
+		Search:            makeMockSearch(sprawlNodesSearchRows, nil),
+		Environments:      EnvMock{Error: errors.New("forbidden")},
+	}
+
+	c, err := subject.NewCookbooksReport(&chefAnalyzeClient, false, false, Workers, "", false)
+	if assert.Nil(t, err) {
+		c.WithSprawl = true
+		c.Generate()
+		if assert.Len(t, c.Records, 1) && assert.Len(t, c.Records[0].Errors(), 1) {
+			assert.Equal(t, "unable to get environment production: forbidden", c.Records[0].Errors()[0].Error())
+		}
+		assert.Len(t, c.Sprawl, 1)
+	}
+}
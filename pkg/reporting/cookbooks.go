@@ -20,6 +20,7 @@ package reporting
 import (
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
@@ -53,6 +54,19 @@ type CookbooksReport struct {
 	policyGroups          PolicyGroupInterface
 	Policies              PolicyInterface
 	Anonymize             bool
+	// builds the dependency graph of the cookbooks, see BuildCookbookGraph
+	WithDependencies bool
+	Graph            *CookbookGraph
+	// aggregates the versions in use of every cookbook, see BuildCookbookSprawl
+	WithSprawl bool
+	Sprawl     []*CookbookSprawl
+	// the environments of the nodes, retrieved once per report
+	environments      EnvironmentInterface
+	environmentsCache map[string]*chef.Environment
+	environmentsMutex sync.Mutex
+	// the cookbooks of a local report, see NewLocalCookbooksReport
+	localItems   []cookbookItem
+	nodeSnapshot *NodeSnapshot
 }
 
 // CookbookRecord is a single cookbook that we want to download and analyze
@@ -69,6 +83,15 @@ type CookbookRecord struct {
 	Policy           string
 	PolicyVer        string
 	PolicyGroup      string
+	// the cookbooks it depends on, with their version constraint,
+	// only set when the report is generated with its dependencies
+	Depends         map[string]string
+	Dependents      []string
+	BlastRadius     []string
+	DependencyError error
+	// the cookbook_versions constraints of the environments of its nodes for the
+	// cookbook, as ENVIRONMENT (CONSTRAINT), only set when the report has its sprawl
+	EnvironmentPins []string
 }
 
 // Errors collates all known errors
@@ -83,6 +106,9 @@ func (cr CookbookRecord) Errors() []error {
 	if cr.CookstyleError != nil {
 		errs = append(errs, cr.CookstyleError)
 	}
+	if cr.DependencyError != nil {
+		errs = append(errs, cr.DependencyError)
+	}
 	return errs
 }
 
@@ -154,6 +180,10 @@ type cookbookItem struct {
 	Policy        string
 	PolicyGroup   string
 	PolicyRev     string
+	// the local directory of the cookbook, only set for local reports
+	Path string
+	// the dependencies locked by the policy, only set for policy cookbooks
+	Depends map[string]string
 }
 
 func NewCookbooksReport(
@@ -207,6 +237,7 @@ func NewCookbooksReport(
 		CBASearchResults:      resultsCBA,
 		policyGroups:          chefClient.PolicyGroups,
 		Policies:              chefClient.Policies,
+		environments:          chefClient.Environments,
 		Anonymize:             anonymize,
 	}, nil
 }
@@ -258,6 +289,9 @@ func (cbr *CookbooksReport) triggerJobs(inCh chan<- cookbookItem) {
 	for _, cbItem := range cbr.CBASearchResults {
 		inCh <- cbItem
 	}
+	for _, cbItem := range cbr.localItems {
+		inCh <- cbItem
+	}
 	close(inCh)
 }
 
@@ -267,7 +301,9 @@ func (cbr *CookbooksReport) createDownloadWorkerPool(nWorkers int, downloadCh <-
 		wg.Add(1)
 		go func(inCh <-chan cookbookItem, outCh chan<- *CookbookRecord, wg *sync.WaitGroup) {
 			for item := range inCh {
-				if item.Policy != "" {
+				if cbr.localItems != nil {
+					analyzeCh <- cbr.localCookbook(item)
+				} else if item.Policy != "" {
 					cbState := cbr.downloadCookbookArtifact(item)
 					analyzeCh <- cbState
 				} else {
@@ -307,14 +343,20 @@ func (cbr *CookbooksReport) createAnalyzeWorkerPool(nWorkers int, analyzeCh <-ch
 	}
 
 	wg.Wait()
+	if cbr.WithDependencies {
+		cbr.Graph = BuildCookbookGraph(cbr.Records)
+	}
+	if cbr.WithSprawl {
+		cbr.Sprawl = BuildCookbookSprawl(cbr.Records)
+	}
 	doneCh <- true
 }
 
 func (cbr *CookbooksReport) downloadCookbook(cookbookName, version string) *CookbookRecord {
 	var (
-		nodes, err       = cbr.nodesUsingCookbookVersion(cookbookName, version)
-		cookbookLongName = fmt.Sprintf("%v-%v", cookbookName, version)
-		cbState          = &CookbookRecord{
+		nodes, environments, err = cbr.nodesUsingCookbookVersion(cookbookName, version)
+		cookbookLongName         = fmt.Sprintf("%v-%v", cookbookName, version)
+		cbState                  = &CookbookRecord{
 			path:    filepath.Join(cbr.cookbooksDir, cookbookLongName),
 			Version: version,
 		}
@@ -356,15 +398,56 @@ func (cbr *CookbooksReport) downloadCookbook(cookbookName, version string) *Cook
 		}
 	}
 
+	if cbr.WithDependencies {
+		depends, err := cbr.cookbookDepends(cookbookName, version, cbState)
+		cbr.setDepends(cbState, depends, err)
+	}
+
+	if cbr.WithSprawl {
+		cbState.EnvironmentPins, err = cbr.environmentPins(cookbookName, environments)
+		if err != nil && cbState.UsageLookupError == nil {
+			cbState.UsageLookupError = err
+		}
+	}
+
 	return cbState
 }
 
+// returns the dependencies of a server cookbook from its downloaded
+// metadata, or from the metadata of the server if it was not downloaded
+func (cbr *CookbooksReport) cookbookDepends(cookbookName, version string, cbState *CookbookRecord) (map[string]string, error) {
+	if cbr.RunCookstyle && cbState.DownloadError == nil {
+		return readCookbookDepends(cbState.path)
+	}
+	cookbook, err := cbr.cookbooks.GetVersion(cookbookName, version)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to retrieve cookbook metadata")
+	}
+	return cookbook.Metadata.Depends, nil
+}
+
+// sets the dependencies of the record, hashed like its name when anonymized
+func (cbr *CookbooksReport) setDepends(cbState *CookbookRecord, depends map[string]string, err error) {
+	if err != nil {
+		cbState.DependencyError = errors.Wrapf(err, "unable to read dependencies of cookbook %s", cbState.Name)
+		return
+	}
+	if depends == nil {
+		depends = map[string]string{}
+	}
+	if cbr.Anonymize {
+		depends = hashDepends(depends)
+	}
+	cbState.Depends = depends
+}
+
 func (cbr *CookbooksReport) downloadCookbookArtifact(item cookbookItem) *CookbookRecord {
 	var (
 		nodes, err       = cbr.nodesUsingPolicy(item.PolicyGroup, item.Policy, item.PolicyRev)
 		cookbookLongName = fmt.Sprintf("%v-%v", item.Name, item.CBAIdentifier[0:20])
 		cbState          = &CookbookRecord{
 			path:       filepath.Join(cbr.cookbooksDir, cookbookLongName),
+			Version:    item.Version,
 			Identifier: item.CBAIdentifier,
 			PolicyVer:  item.PolicyRev,
 		}
@@ -408,13 +491,23 @@ func (cbr *CookbooksReport) downloadCookbookArtifact(item cookbookItem) *Cookboo
 		}
 	}
 
+	if cbr.WithDependencies {
+		// the dependencies locked by the policy, not the ones of the metadata
+		cbr.setDepends(cbState, item.Depends, nil)
+	}
+
 	return cbState
 }
 
-func (cbr *CookbooksReport) nodesUsingCookbookVersion(cookbook string, version string) ([]string, error) {
+// returns the nodes that use the version of the cookbook and, for sprawl
+// reports, the distinct environments of those nodes
+func (cbr *CookbooksReport) nodesUsingCookbookVersion(cookbook string, version string) ([]string, []string, error) {
 	query := map[string]interface{}{
 		"name": []string{"name"},
 	}
+	if cbr.WithSprawl {
+		query["chef_environment"] = []string{"chef_environment"}
+	}
 
 	// TODO add pagination
 	nodeFilter := fmt.Sprintf("cookbooks_%s_version:%s", cookbook, version)
@@ -424,11 +517,15 @@ func (cbr *CookbooksReport) nodesUsingCookbookVersion(cookbook string, version s
 
 	pres, err := cbr.searcher.PartialExec("node", nodeFilter, query)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to get cookbook usage information")
+		return nil, nil, errors.Wrap(err, "unable to get cookbook usage information")
 	}
 
 	// If the error is unrelated to returning any results we want to parse them and return them.
-	results := make([]string, 0, len(pres.Rows))
+	var (
+		results      = make([]string, 0, len(pres.Rows))
+		environments = make([]string, 0)
+		seen         = make(map[string]bool)
+	)
 	for _, element := range pres.Rows {
 		v := element.(map[string]interface{})["data"].(map[string]interface{})
 		if v != nil {
@@ -437,10 +534,56 @@ func (cbr *CookbooksReport) nodesUsingCookbookVersion(cookbook string, version s
 				nodeName = hashString(nodeName)
 			}
 			results = append(results, nodeName)
+
+			if environment := safeStringFromMap(v, "chef_environment"); environment != "" && !seen[environment] {
+				seen[environment] = true
+				environments = append(environments, environment)
+			}
 		}
 	}
 
-	return results, nil
+	return results, environments, nil
+}
+
+// returns the cookbook_versions constraints of the environments for the cookbook,
+// as ENVIRONMENT (CONSTRAINT), the environments without one don't pin the cookbook
+func (cbr *CookbooksReport) environmentPins(cookbook string, environments []string) ([]string, error) {
+	pins := make([]string, 0)
+	for _, name := range environments {
+		environment, err := cbr.environment(name)
+		if err != nil {
+			return pins, err
+		}
+		constraint, ok := environment.CookbookVersions[cookbook]
+		if !ok {
+			continue
+		}
+		if cbr.Anonymize {
+			name = hashString(name)
+		}
+		pins = append(pins, fmt.Sprintf("%s (%s)", name, constraint))
+	}
+	sort.Strings(pins)
+	return pins, nil
+}
+
+// returns the environment from the Chef Infra Server, or from the cache of the report
+func (cbr *CookbooksReport) environment(name string) (*chef.Environment, error) {
+	cbr.environmentsMutex.Lock()
+	defer cbr.environmentsMutex.Unlock()
+
+	if environment, ok := cbr.environmentsCache[name]; ok {
+		return environment, nil
+	}
+	environment, err := cbr.environments.Get(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get environment %s", name)
+	}
+	if cbr.environmentsCache == nil {
+		cbr.environmentsCache = make(map[string]*chef.Environment)
+	}
+	cbr.environmentsCache[name] = environment
+	return environment, nil
 }
 
 func (cbr *CookbooksReport) runCookstyleFor(cb *CookbookRecord) {
@@ -502,12 +645,15 @@ func getCookbookArtifacts(policyGroups PolicyGroupInterface, Policies PolicyInte
 				if err != nil {
 					return nil, errors.Wrap(err, "unable to retrieve cookbook artifacts for policy revisions")
 				}
+				solution := policySolutionDepends(&rvDetail)
 				for ck, cv := range rvDetail.CookbookLocks {
 					cbaResults = append(cbaResults, cookbookItem{Name: ck,
+						Version:       cv.Version,
 						CBAIdentifier: cv.Identifier,
 						Policy:        p,
 						PolicyGroup:   pg,
-						PolicyRev:     rv})
+						PolicyRev:     rv,
+						Depends:       solution[ck]})
 				}
 			}
 		}
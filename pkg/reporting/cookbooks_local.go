@@ -0,0 +1,369 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+)
+
+// the version of a cookbook whose metadata doesn't have one, as chef-client does
+const defaultCookbookVersion = "0.0.0"
+
+var (
+	metadataNamePattern    = regexp.MustCompile(`(?m)^\s*name\s*\(?\s*['"]([^'"]+)['"]`)
+	metadataVersionPattern = regexp.MustCompile(`(?m)^\s*version\s*\(?\s*['"]([^'"]+)['"]`)
+)
+
+// directories that are not searched for Policyfile.lock.json files
+var localScanSkippedDirs = map[string]bool{".git": true, "cookbook_artifacts": true}
+
+// NewLocalCookbooksReport creates a report of the cookbooks found in a local path
+// instead of a Chef Infra Server: a chef-repo, a directory of cookbooks or a single
+// cookbook, and the cookbooks locked by the Policyfile.lock.json files found under
+// it. Nothing is downloaded, the cookbooks are analyzed in place. The nodes that
+// use the cookbooks are matched from the nodes of the snapshot, when it is nil
+// the usage of the cookbooks is unknown and every cookbook is reported.
+func NewLocalCookbooksReport(
+	path string, snapshot *NodeSnapshot,
+	runCookstyle bool, onlyUnused bool, workers int, anonymize bool,
+) (*CookbooksReport, error) {
+	items, err := scanLocalCookbooks(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CookbooksReport{
+		Records:        make([]*CookbookRecord, 0, len(items)),
+		Progress:       make(chan int, len(items)),
+		TotalCookbooks: len(items),
+		RunCookstyle:   runCookstyle,
+		onlyUnused:     onlyUnused,
+		cookstyle:      NewCookstyleRunner(),
+		numWorkers:     workers,
+		localItems:     items,
+		nodeSnapshot:   snapshot,
+		Anonymize:      anonymize,
+	}, nil
+}
+
+// returns the record of a cookbook found locally, or nil if it must not
+// be reported because of its usage, see NewLocalCookbooksReport
+func (cbr *CookbooksReport) localCookbook(item cookbookItem) *CookbookRecord {
+	cbState := &CookbookRecord{
+		Name:       item.Name,
+		Version:    item.Version,
+		Identifier: item.CBAIdentifier,
+		Policy:     item.Policy,
+		PolicyVer:  item.PolicyRev,
+		path:       item.Path,
+	}
+
+	if cbr.nodeSnapshot != nil {
+		if item.Policy != "" {
+			cbState.Nodes = cbr.nodeSnapshot.nodesUsingPolicy(item.Policy, item.PolicyRev)
+		} else {
+			cbState.Nodes = cbr.nodeSnapshot.nodesUsingCookbookVersion(item.Name, item.Version)
+		}
+		// same as with a Chef Infra Server, by default we report only
+		// the cookbooks that are used, or only the unused ones
+		if cbr.onlyUnused {
+			if len(cbState.Nodes) > 0 {
+				return nil
+			}
+		} else {
+			if len(cbState.Nodes) == 0 {
+				return nil
+			}
+		}
+	}
+
+	if cbr.Anonymize {
+		cbState.Name = hashString(item.Name)
+		cbState.Policy = hashString(item.Policy)
+		for i, node := range cbState.Nodes {
+			cbState.Nodes[i] = hashString(node)
+		}
+	}
+
+	if item.Path == "" {
+		cbState.DownloadError = errors.Errorf("unable to find the source of cookbook %s locally", cbState.Name)
+	}
+
+	if cbr.WithDependencies {
+		if item.Depends != nil || item.Path == "" {
+			// the dependencies locked by the policy, not the ones of the metadata
+			cbr.setDepends(cbState, item.Depends, nil)
+		} else {
+			depends, err := readCookbookDepends(item.Path)
+			cbr.setDepends(cbState, depends, err)
+		}
+	}
+
+	return cbState
+}
+
+// returns the cookbooks of the path, see NewLocalCookbooksReport
+func scanLocalCookbooks(path string) ([]cookbookItem, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, errors.Wrap(err, "unable to read local cookbooks")
+	}
+
+	items, err := scanLocalCookbookDirs(path)
+	if err != nil {
+		return nil, err
+	}
+	locks, err := scanPolicyfileLocks(path, items)
+	if err != nil {
+		return nil, err
+	}
+	return append(items, locks...), nil
+}
+
+// returns the cookbooks of a chef-repo (in its cookbooks directory), of a
+// directory of cookbooks or the cookbook itself, sorted by name
+func scanLocalCookbookDirs(path string) ([]cookbookItem, error) {
+	if isCookbookDir(path) {
+		item, err := localCookbookItem(path)
+		if err != nil {
+			return nil, err
+		}
+		return []cookbookItem{item}, nil
+	}
+
+	cookbooksDir := filepath.Join(path, "cookbooks")
+	if info, err := os.Stat(cookbooksDir); err != nil || !info.IsDir() {
+		cookbooksDir = path
+	}
+	entries, err := ioutil.ReadDir(cookbooksDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read local cookbooks")
+	}
+
+	items := make([]cookbookItem, 0, len(entries))
+	for _, entry := range entries {
+		dir := filepath.Join(cookbooksDir, entry.Name())
+		if !isCookbookDir(dir) {
+			continue
+		}
+		item, err := localCookbookItem(dir)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items, nil
+}
+
+// returns true if the directory has the metadata of a cookbook
+func isCookbookDir(dir string) bool {
+	for _, metadata := range []string{"metadata.json", "metadata.rb"} {
+		if info, err := os.Stat(filepath.Join(dir, metadata)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// reads the name and version of the cookbook in dir from its metadata, the
+// metadata.json generated on upload has precedence over the metadata.rb
+func localCookbookItem(dir string) (cookbookItem, error) {
+	item := cookbookItem{Name: filepath.Base(dir), Version: defaultCookbookVersion, Path: dir}
+
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "metadata.json")); err == nil {
+		var metadata struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		}
+		if err := json.Unmarshal(content, &metadata); err != nil {
+			return item, errors.Wrapf(err, "unable to parse metadata of cookbook %s", dir)
+		}
+		if metadata.Name != "" {
+			item.Name = metadata.Name
+		}
+		if metadata.Version != "" {
+			item.Version = metadata.Version
+		}
+		return item, nil
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "metadata.rb"))
+	if err != nil {
+		return item, errors.Wrapf(err, "unable to read metadata of cookbook %s", dir)
+	}
+	if match := metadataNamePattern.FindSubmatch(content); match != nil {
+		item.Name = string(match[1])
+	}
+	if match := metadataVersionPattern.FindSubmatch(content); match != nil {
+		item.Version = string(match[1])
+	}
+	return item, nil
+}
+
+// returns the cookbooks locked by the Policyfile.lock.json files found under path,
+// the source of each cookbook is looked up in the path of its lock, in the
+// cookbook_artifacts of the path and in the cookbooks already found locally
+func scanPolicyfileLocks(path string, cookbooks []cookbookItem) ([]cookbookItem, error) {
+	items := make([]cookbookItem, 0)
+	err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && localScanSkippedDirs[info.Name()] {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".lock.json") {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var lock chef.RevisionDetailsResponse
+		if err := json.Unmarshal(content, &lock); err != nil {
+			return errors.Wrapf(err, "unable to parse Policyfile lock %s", file)
+		}
+
+		solution := policySolutionDepends(&lock)
+		names := make([]string, 0, len(lock.CookbookLocks))
+		for name := range lock.CookbookLocks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			cookbook := lock.CookbookLocks[name]
+			items = append(items, cookbookItem{
+				Name:          name,
+				Version:       cookbook.Version,
+				CBAIdentifier: cookbook.Identifier,
+				Policy:        lock.Name,
+				PolicyRev:     lock.RevisionID,
+				Path:          lockedCookbookDir(path, filepath.Dir(file), name, cookbook, cookbooks),
+				Depends:       solution[name],
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read Policyfile locks")
+	}
+	return items, nil
+}
+
+// returns the local directory of a cookbook locked by a policy, empty if not found
+func lockedCookbookDir(path, lockDir, name string, lock chef.CookbookLock, cookbooks []cookbookItem) string {
+	candidates := make([]string, 0, 2)
+	if source := lock.SourceOptions["path"]; source != "" {
+		if !filepath.IsAbs(source) {
+			source = filepath.Join(lockDir, source)
+		}
+		candidates = append(candidates, source)
+	}
+	if lock.Identifier != "" {
+		candidates = append(candidates, filepath.Join(path, "cookbook_artifacts", fmt.Sprintf("%s-%s", name, lock.Identifier)))
+	}
+	for _, dir := range candidates {
+		if isCookbookDir(dir) {
+			return dir
+		}
+	}
+
+	for _, cookbook := range cookbooks {
+		if cookbook.Name == name && cookbook.Version == lock.Version {
+			return cookbook.Path
+		}
+	}
+	return ""
+}
+
+// NodeSnapshot is a set of node objects saved locally, i.e. the nodes directory
+// of a captured repository, used to find the nodes that use local cookbooks
+type NodeSnapshot struct {
+	Nodes []chef.Node
+}
+
+// LoadNodeSnapshot reads the node JSON objects of a directory, or of the
+// nodes directory of a chef-repo
+func LoadNodeSnapshot(dir string) (*NodeSnapshot, error) {
+	if info, err := os.Stat(filepath.Join(dir, "nodes")); err == nil && info.IsDir() {
+		dir = filepath.Join(dir, "nodes")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read node snapshot")
+	}
+	if len(files) == 0 {
+		return nil, errors.Errorf("no node objects found in %s", dir)
+	}
+
+	snapshot := &NodeSnapshot{Nodes: make([]chef.Node, 0, len(files))}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read node snapshot")
+		}
+		var node chef.Node
+		if err := json.Unmarshal(content, &node); err != nil {
+			return nil, errors.Wrapf(err, "unable to parse node %s", file)
+		}
+		snapshot.Nodes = append(snapshot.Nodes, node)
+	}
+	return snapshot, nil
+}
+
+// returns the nodes that ran the version of the cookbook in their last chef-client run
+func (ns *NodeSnapshot) nodesUsingCookbookVersion(cookbook, version string) []string {
+	nodes := make([]string, 0)
+	for _, node := range ns.Nodes {
+		cookbooks, ok := node.AutomaticAttributes["cookbooks"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		details, ok := cookbooks[cookbook].(map[string]interface{})
+		if ok && safeStringFromMap(details, "version") == version {
+			nodes = append(nodes, node.Name)
+		}
+	}
+	return nodes
+}
+
+// returns the nodes of the policy, the revision is compared only
+// for the nodes that recorded the revision they run
+func (ns *NodeSnapshot) nodesUsingPolicy(policy, revision string) []string {
+	nodes := make([]string, 0)
+	for _, node := range ns.Nodes {
+		if node.PolicyName != policy {
+			continue
+		}
+		if nodeRevision := safeStringFromMap(node.AutomaticAttributes, "policy_revision"); nodeRevision != "" && nodeRevision != revision {
+			continue
+		}
+		nodes = append(nodes, node.Name)
+	}
+	return nodes
+}
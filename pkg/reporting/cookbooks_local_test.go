@@ -0,0 +1,180 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+)
+
+const localPolicyfileLock = `{
+  "revision_id": "1a2b3c",
+  "name": "web",
+  "cookbook_locks": {
+    "web": {
+      "version": "1.2.0",
+      "identifier": "6f2bd5f0e6a4a8d2d7d1f6b4d1e2c3b4a5968778",
+      "source_options": {"path": "../cookbooks/web"}
+    },
+    "base": {
+      "version": "2.0.0",
+      "identifier": "0a1b2c3d4e5f60718293a4b5c6d7e8f901234567",
+      "source_options": {"artifactserver": "https://supermarket.chef.io/api/v1/cookbooks/base/versions/2.0.0/download"}
+    },
+    "missing": {
+      "version": "0.1.0",
+      "identifier": "ffffffffffffffffffffffffffffffffffffffff",
+      "source_options": {"artifactserver": "https://supermarket.chef.io/api/v1/cookbooks/missing/versions/0.1.0/download"}
+    }
+  }
+}`
+
+// writes a chef-repo with two cookbooks, a Policyfile lock and a
+// snapshot of two nodes, returns the directory of the repository
+func writeLocalChefRepo() string {
+	repoDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	mustSucceed(err)
+	files := map[string]string{
+		"cookbooks/web/metadata.rb":        "name 'web'\nversion '1.2.0'\ndepends 'base'\n",
+		"cookbooks/base/metadata.json":     `{"name": "base", "version": "2.0.0"}`,
+		"cookbooks/base/metadata.rb":       "name 'base'\nversion '1.0.0'\n",
+		"cookbooks/README.md":              "not a cookbook",
+		"policyfiles/Policyfile.lock.json": localPolicyfileLock,
+		"snapshot/nodes/node1.json":        `{"name": "node1", "automatic": {"cookbooks": {"web": {"version": "1.2.0"}, "base": {"version": "2.0.0"}}}}`,
+		"snapshot/nodes/node2.json":        `{"name": "node2", "policy_name": "web", "automatic": {"policy_revision": "1a2b3c"}}`,
+		"cookbooks/web/recipes/default.rb": "include_recipe 'base'\n",
+	}
+	for file, content := range files {
+		path := filepath.Join(repoDir, file)
+		mustSucceed(os.MkdirAll(filepath.Dir(path), 0755))
+		mustSucceed(ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	return repoDir
+}
+
+// returns the records of the report as NAME VERSION POLICY, sorted
+func localRecords(c *subject.CookbooksReport) []string {
+	records := make([]string, 0, len(c.Records))
+	for _, record := range c.Records {
+		records = append(records, record.Name+" "+record.Version+" "+record.Policy)
+	}
+	sort.Strings(records)
+	return records
+}
+
+func TestLocalCookbooksReport(t *testing.T) {
+	repoDir := writeLocalChefRepo()
+	defer os.RemoveAll(repoDir)
+
+	c, err := subject.NewLocalCookbooksReport(repoDir, nil, false, false, Workers, false)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 5, c.TotalCookbooks)
+		c.Generate()
+		assert.Equal(t, 5, len(c.Progress))
+		// without a snapshot the usage is unknown and every cookbook is reported
+		assert.Equal(t, []string{
+			"base 2.0.0 ",
+			"base 2.0.0 web",
+			"missing 0.1.0 web",
+			"web 1.2.0 ",
+			"web 1.2.0 web",
+		}, localRecords(c))
+		for _, record := range c.Records {
+			assert.Empty(t, record.Nodes)
+			if record.Name == "missing" {
+				if assert.Len(t, record.Errors(), 1) {
+					assert.Contains(t, record.Errors()[0].Error(), "unable to find the source of cookbook missing locally")
+				}
+			} else {
+				assert.Empty(t, record.Errors())
+			}
+		}
+	}
+}
+
+func TestLocalCookbooksReport_WithNodeSnapshot(t *testing.T) {
+	repoDir := writeLocalChefRepo()
+	defer os.RemoveAll(repoDir)
+
+	snapshot, err := subject.LoadNodeSnapshot(filepath.Join(repoDir, "snapshot"))
+	mustSucceed(err)
+
+	c, err := subject.NewLocalCookbooksReport(repoDir, snapshot, false, false, Workers, false)
+	if assert.Nil(t, err) {
+		c.Generate()
+		assert.Equal(t, []string{
+			"base 2.0.0 ",
+			"base 2.0.0 web",
+			"missing 0.1.0 web",
+			"web 1.2.0 ",
+			"web 1.2.0 web",
+		}, localRecords(c))
+		for _, record := range c.Records {
+			if record.Policy == "web" {
+				assert.Equal(t, []string{"node2"}, record.Nodes)
+			} else {
+				assert.Equal(t, []string{"node1"}, record.Nodes)
+			}
+		}
+	}
+
+	c, err = subject.NewLocalCookbooksReport(repoDir, snapshot, false, true, Workers, false)
+	if assert.Nil(t, err) {
+		c.Generate()
+		assert.Empty(t, c.Records)
+	}
+}
+
+func TestLocalCookbooksReport_SingleCookbook(t *testing.T) {
+	repoDir := writeLocalChefRepo()
+	defer os.RemoveAll(repoDir)
+
+	c, err := subject.NewLocalCookbooksReport(filepath.Join(repoDir, "cookbooks", "web"), nil, false, false, Workers, true)
+	if assert.Nil(t, err) {
+		c.Generate()
+		if assert.Len(t, c.Records, 1) {
+			assert.Equal(t, "1.2.0", c.Records[0].Version)
+			// anonymized
+			assert.Len(t, c.Records[0].Name, 64)
+		}
+	}
+}
+
+func TestLocalCookbooksReport_MissingPath(t *testing.T) {
+	_, err := subject.NewLocalCookbooksReport("/does/not/exist", nil, false, false, Workers, false)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to read local cookbooks")
+	}
+}
+
+func TestLoadNodeSnapshot_Empty(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	mustSucceed(err)
+	defer os.RemoveAll(dir)
+
+	_, err = subject.LoadNodeSnapshot(dir)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "no node objects found")
+	}
+}
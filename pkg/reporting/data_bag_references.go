@@ -0,0 +1,154 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// the data bags that can be captured
+const (
+	// every data bag of the Chef Infra Server
+	AllDataBags = "all"
+	// the data bags referenced by the cookbooks of the nodes
+	ReferencedDataBags = "referenced"
+)
+
+// DataBagModes are the valid values of --with-data-bags
+var DataBagModes = []string{AllDataBags, ReferencedDataBags}
+
+// matches the calls that reference a data bag by a literal name, with or without
+// parentheses: data_bag('users'), data_bag_item("users", id), search(:users, query)
+// and Chef::DataBagItem.load('users', id), names built at runtime are not matched
+var dataBagReferencePattern = regexp.MustCompile(
+	`(?:\b(?:data_bag|data_bag_item|search)|Chef::(?:Encrypted)?DataBagItem\.load)\s*\(?\s*` +
+		`(?::([A-Za-z0-9_-]+)|'([A-Za-z0-9_-]+)'|"([A-Za-z0-9_-]+)")`)
+
+// the indexes that search can query besides data bags
+var builtinSearchIndexes = map[string]bool{
+	"client": true, "environment": true, "node": true, "role": true,
+}
+
+// directories of a cookbook that chef-client doesn't load
+var dataBagScanSkippedDirs = map[string]bool{".git": true, "spec": true, "test": true}
+
+// ScanDataBagReferences statically scans the Ruby files of the provided cookbook
+// directories for the data bags they reference with a literal name, the data bags
+// referenced by a name built at runtime must be provided separately, missing
+// directories are ignored, returns the sorted names of the data bags
+func ScanDataBagReferences(cookbookDirs []string) ([]string, error) {
+	bags := make(map[string]bool)
+	for _, dir := range cookbookDirs {
+		// cookbooks sourced locally are links to their checkout
+		root, err := filepath.EvalSymlinks(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to scan cookbook '%s'", dir)
+		}
+
+		err = filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && dataBagScanSkippedDirs[info.Name()] {
+				return filepath.SkipDir
+			}
+			if info.IsDir() || filepath.Ext(file) != ".rb" {
+				return nil
+			}
+			return scanDataBagReferences(file, bags)
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to scan cookbook '%s'", dir)
+		}
+	}
+
+	names := make([]string, 0, len(bags))
+	for name := range bags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func scanDataBagReferences(file string, bags map[string]bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, match := range dataBagReferencePattern.FindAllStringSubmatch(line, -1) {
+			name := match[1] + match[2] + match[3]
+			if strings.HasPrefix(match[0], "search") && builtinSearchIndexes[name] {
+				continue
+			}
+			bags[name] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// returns the directories of the cookbooks, or the cookbook artifacts for
+// policy-managed nodes, captured for the node
+func (nc *NodeCapture) cookbookDirs() []string {
+	dirs := make([]string, 0)
+	if nc.Policy != nil {
+		for name, lock := range nc.Policy.CookbookLocks {
+			dirs = append(dirs, filepath.Join(nc.repositoryDir, "cookbook_artifacts", fmt.Sprintf("%s-%s", name, lock.Identifier)))
+		}
+		return dirs
+	}
+	for _, cookbook := range nc.Cookbooks {
+		dirs = append(dirs, filepath.Join(nc.repositoryDir, "cookbooks", cookbook.Name))
+	}
+	return dirs
+}
+
+// captures the data bags referenced by the cookbooks of the node
+// and the ones provided in the options
+func (nc *NodeCapture) captureReferencedDataBags() error {
+	names := append([]string{}, nc.opts.DataBags...)
+	if nc.opts.ReferencedDataBags {
+		referenced, err := ScanDataBagReferences(nc.cookbookDirs())
+		if err != nil {
+			return err
+		}
+		for _, name := range referenced {
+			if !containsString(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return nc.capturer.CaptureDataBags(names, nc.objectProgress(FetchingDataBags))
+}
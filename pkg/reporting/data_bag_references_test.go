@@ -0,0 +1,146 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chef/chef"
+	"github.com/stretchr/testify/assert"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+)
+
+const dataBagReferencesRecipe = `
+users = data_bag('users')
+admin = data_bag_item("users", 'admin')
+creds = data_bag_item :credentials, node['app']['env']
+search(:apps, 'type:web').each { |app| log app['id'] }
+search(:node, 'role:web')
+search("role", 'name:base')
+secrets = Chef::EncryptedDataBagItem.load('secrets', 'db', key)
+dynamic = data_bag_item(node['app']['bag'], 'item')
+interpolated = data_bag("#{node['app']['prefix']}_keys")
+# data_bag('commented_out')
+`
+
+// writes the cookbooks of the scan tests, returns their directories
+func writeDataBagReferencesCookbooks(baseDir string) []string {
+	var (
+		web      = filepath.Join(baseDir, "cookbooks", "web")
+		base     = filepath.Join(baseDir, "checkouts", "base")
+		baseLink = filepath.Join(baseDir, "cookbooks", "base")
+	)
+	files := map[string]string{
+		filepath.Join(web, "recipes", "default.rb"):                   dataBagReferencesRecipe,
+		filepath.Join(web, "libraries", "helpers.rb"):                 "Chef::DataBagItem.load(:certificates, 'web')\n",
+		filepath.Join(web, "templates", "default", "config.erb"):      "<%= data_bag_item('templates', 'x') %>\n",
+		filepath.Join(web, "spec", "unit", "default_spec.rb"):         "stub_data_bag_item('spec_only', 'x')\ndata_bag('spec_only')\n",
+		filepath.Join(base, "recipes", "default.rb"):                  "data_bag_item('base_config', 'default')\n",
+		filepath.Join(base, "test", "integration", "default_test.rb"): "data_bag('test_only')\n",
+	}
+	for file, content := range files {
+		mustSucceed(os.MkdirAll(filepath.Dir(file), 0755))
+		mustSucceed(ioutil.WriteFile(file, []byte(content), 0644))
+	}
+	// cookbooks sourced locally are links to their checkout
+	mustSucceed(os.Symlink(base, baseLink))
+	return []string{web, baseLink, filepath.Join(baseDir, "cookbooks", "missing")}
+}
+
+func TestScanDataBagReferences(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	mustSucceed(err)
+	defer os.RemoveAll(baseDir)
+
+	bags, err := subject.ScanDataBagReferences(writeDataBagReferencesCookbooks(baseDir))
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"apps", "base_config", "certificates", "credentials", "secrets", "users"}, bags)
+	}
+}
+
+func TestScanDataBagReferences_NoCookbooks(t *testing.T) {
+	bags, err := subject.ScanDataBagReferences([]string{})
+	if assert.Nil(t, err) {
+		assert.Empty(t, bags)
+	}
+}
+
+func TestCapture_RunWithReferencedDataBags(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	mustSucceed(err)
+	defer os.RemoveAll(baseDir)
+	writeDataBagReferencesCookbooks(baseDir)
+
+	capturer := &CapturerMock{
+		NodeReturn:     defaultNode(),
+		CookbookReturn: []subject.NodeCookbook{{"web", "1.0.0"}, {"base", "2.0.0"}},
+	}
+	opts := subject.CaptureOpts{ReferencedDataBags: true, DataBags: []string{"dynamic"}}
+	nc := subject.NewNodeCapture("node1", baseDir, opts, capturer)
+	events := captureStages(nc)
+	assert.Nil(t, nc.Error)
+	// the data bags are captured once the cookbooks that reference them are
+	assert.Equal(t, []subject.CaptureStage{
+		subject.FetchingNode,
+		subject.FetchingCookbooks,
+		subject.FetchingEnvironment,
+		subject.FetchingRoles,
+		subject.FetchingDataBags,
+		subject.WritingKitchenConfig,
+		subject.CaptureComplete,
+	}, events)
+	assert.Equal(t,
+		[]string{"dynamic", "apps", "base_config", "certificates", "credentials", "secrets", "users"},
+		capturer.DataBagsCaptured)
+}
+
+func TestCapture_RunWithDataBagAllowList(t *testing.T) {
+	capturer := &CapturerMock{NodeReturn: defaultNode()}
+	nc := subject.NewNodeCapture("node1", "", subject.CaptureOpts{DataBags: []string{"users"}}, capturer)
+	captureStages(nc)
+	assert.Nil(t, nc.Error)
+	assert.Equal(t, []string{"users"}, capturer.DataBagsCaptured)
+}
+
+func TestCapturer_CaptureDataBags(t *testing.T) {
+	writer := &ObjectWriterMock{}
+	nc := subject.NewNodeCapturer(
+		NodeMock{},
+		RoleMock{},
+		EnvMock{},
+		CookbookMock{},
+		DataBagMock{
+			desiredDataBagList:     map[string]string{"users": "https://url/data/users", "other": "https://url/data/other"},
+			desiredDataBagItem:     map[string]interface{}{"id": "alice"},
+			desiredDataBagItemList: map[string]string{"alice": "https://url/data/users/alice"},
+		},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		writer,
+	)
+
+	err := nc.CaptureDataBags([]string{"users", "missing", "missing"}, nil)
+	if assert.Nil(t, err) {
+		assert.Equal(t, chef.DataBagItem(map[string]interface{}{"id": "alice"}), writer.ReceivedObject)
+		assert.Equal(t, []string{"missing"}, nc.MissingDataBags())
+	}
+}
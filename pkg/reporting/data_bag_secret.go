@@ -0,0 +1,226 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DataBagSecretFile is the path, relative to a captured repository, of the
+// secret of the encrypted data bag items, the repository ignores it
+const DataBagSecretFile = ".chef/encrypted_data_bag_secret"
+
+// ReadDataBagSecret reads the secret of encrypted data bag items from a file,
+// the trailing whitespace is not part of the secret
+func ReadDataBagSecret(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read data bag secret from '%s'", path)
+	}
+	secret := bytes.TrimRight(content, " \t\r\n")
+	if len(secret) == 0 {
+		return nil, errors.Errorf("data bag secret '%s' is empty", path)
+	}
+	return secret, nil
+}
+
+// WriteDataBagSecret writes the secret of the encrypted data bag items to the
+// DataBagSecretFile of a repository, readable only by the current user
+func WriteDataBagSecret(repositoryDir string, secret []byte) error {
+	path := filepath.Join(repositoryDir, filepath.FromSlash(DataBagSecretFile))
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrapf(err, "unable to create directory '%s'", filepath.Dir(path))
+	}
+	if err := ioutil.WriteFile(path, secret, 0600); err != nil {
+		return errors.Wrapf(err, "unable to write data bag secret '%s'", path)
+	}
+	return nil
+}
+
+// SetDataBagSecret sets the secret that the encrypted data bag items are verified
+// with, the kitchen.yml passes it to the local converges
+func (nc *NodeCapturer) SetDataBagSecret(secret []byte) {
+	nc.dataBagSecret = secret
+}
+
+// EncryptedDataBagItems returns the encrypted data bag items captured
+// so far, as BAG/ITEM
+func (nc *NodeCapturer) EncryptedDataBagItems() []string {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+	return append([]string{}, nc.encryptedItems...)
+}
+
+func (nc *NodeCapturer) recordEncryptedItem(item string) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+	nc.encryptedItems = append(nc.encryptedItems, item)
+}
+
+// IsEncryptedDataBagItem returns true if any of the values of the data bag item
+// was encrypted, encrypted values have the encrypted_data, iv and version fields
+func IsEncryptedDataBagItem(item interface{}) bool {
+	values, ok := item.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for key, value := range values {
+		if key != "id" && isEncryptedDataBagValue(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyDataBagItemSecret returns an error if any of the encrypted values of
+// the data bag item can't be decrypted with the secret, the decrypted values
+// are discarded
+func VerifyDataBagItemSecret(item interface{}, secret []byte) error {
+	values, ok := item.(map[string]interface{})
+	if !ok {
+		return errors.New("the data bag item is not an object")
+	}
+	for key, value := range values {
+		if key == "id" || !isEncryptedDataBagValue(value) {
+			continue
+		}
+		if err := decryptDataBagValue(value.(map[string]interface{}), secret); err != nil {
+			return errors.Wrapf(err, "unable to decrypt '%s'", key)
+		}
+	}
+	return nil
+}
+
+func isEncryptedDataBagValue(value interface{}) bool {
+	v, ok := value.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, data := v["encrypted_data"]
+	_, iv := v["iv"]
+	_, version := v["version"]
+	return data && iv && version
+}
+
+// decrypts a value encrypted with any of the versions of the encrypted data bag
+// item format, the key is the sha256 of the secret, version 1 and 2 use
+// aes-256-cbc (version 2 adds an hmac of the encrypted data) and version 3
+// uses aes-256-gcm
+func decryptDataBagValue(value map[string]interface{}, secret []byte) error {
+	encrypted, err := base64Field(value, "encrypted_data")
+	if err != nil {
+		return err
+	}
+	iv, err := base64Field(value, "iv")
+	if err != nil {
+		return err
+	}
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+
+	var plaintext []byte
+	switch version, _ := value["version"].(float64); version {
+	case 1, 2:
+		if version == 2 {
+			expected, err := base64Field(value, "hmac")
+			if err != nil {
+				return err
+			}
+			mac := hmac.New(sha256.New, secret)
+			mac.Write([]byte(value["encrypted_data"].(string)))
+			if !hmac.Equal(mac.Sum(nil), expected) {
+				return errors.New("the secret does not match the hmac of the value")
+			}
+		}
+		if len(iv) != aes.BlockSize || len(encrypted) == 0 || len(encrypted)%aes.BlockSize != 0 {
+			return errors.New("invalid encrypted data")
+		}
+		plaintext = make([]byte, len(encrypted))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, encrypted)
+		plaintext, err = unpadPKCS7(plaintext)
+		if err != nil {
+			return err
+		}
+	case 3:
+		tag, err := base64Field(value, "auth_tag")
+		if err != nil {
+			return err
+		}
+		gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+		if err != nil {
+			return err
+		}
+		plaintext, err = gcm.Open(nil, iv, append(encrypted, tag...), nil)
+		if err != nil {
+			return errors.New("the secret does not authenticate the value")
+		}
+	default:
+		return errors.Errorf("unsupported encrypted data bag item version %v", value["version"])
+	}
+
+	// a wrong secret can still produce a valid padding, the
+	// value is only decrypted if it is the expected JSON wrapper
+	var wrapper map[string]interface{}
+	if err := json.Unmarshal(plaintext, &wrapper); err != nil {
+		return errors.New("the secret does not decrypt the value")
+	}
+	if _, ok := wrapper["json_wrapper"]; !ok {
+		return errors.New("the secret does not decrypt the value")
+	}
+	return nil
+}
+
+func base64Field(value map[string]interface{}, field string) ([]byte, error) {
+	encoded, ok := value[field].(string)
+	if !ok {
+		return nil, errors.Errorf("missing field '%s'", field)
+	}
+	// the encrypted data of version 1 and 2 is wrapped every 60 characters
+	decoded, err := base64.StdEncoding.DecodeString(strings.Replace(encoded, "\n", "", -1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid field '%s'", field)
+	}
+	return decoded, nil
+}
+
+func unpadPKCS7(data []byte) ([]byte, error) {
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(data) {
+		return nil, errors.New("the secret does not decrypt the value")
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, errors.New("the secret does not decrypt the value")
+		}
+	}
+	return data[:len(data)-padding], nil
+}
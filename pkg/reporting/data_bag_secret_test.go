@@ -0,0 +1,241 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chef/chef"
+	"github.com/stretchr/testify/assert"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+)
+
+// encrypts a value like Chef does for the provided encrypted data bag item version
+func encryptDataBagValue(version int, secret string, value interface{}) map[string]interface{} {
+	plaintext, err := json.Marshal(map[string]interface{}{"json_wrapper": value})
+	if err != nil {
+		panic(err)
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+
+	if version == 3 {
+		iv := bytes.Repeat([]byte{7}, 12)
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			panic(err)
+		}
+		sealed := gcm.Seal(nil, iv, plaintext, nil)
+		tagStart := len(sealed) - gcm.Overhead()
+		return map[string]interface{}{
+			"encrypted_data": base64.StdEncoding.EncodeToString(sealed[:tagStart]),
+			"iv":             base64.StdEncoding.EncodeToString(iv),
+			"auth_tag":       base64.StdEncoding.EncodeToString(sealed[tagStart:]),
+			"version":        float64(3),
+			"cipher":         "aes-256-gcm",
+		}
+	}
+
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	plaintext = append(plaintext, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	iv := bytes.Repeat([]byte{3}, aes.BlockSize)
+	encrypted := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, plaintext)
+	value64 := base64.StdEncoding.EncodeToString(encrypted)
+	encryptedValue := map[string]interface{}{
+		"encrypted_data": value64,
+		"iv":             base64.StdEncoding.EncodeToString(iv),
+		"version":        float64(version),
+		"cipher":         "aes-256-cbc",
+	}
+	if version == 2 {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(value64))
+		encryptedValue["hmac"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	return encryptedValue
+}
+
+func encryptedDataBagItem(version int, secret string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       "item1",
+		"password": encryptDataBagValue(version, secret, "hunter2"),
+	}
+}
+
+func TestIsEncryptedDataBagItem(t *testing.T) {
+	assert.True(t, subject.IsEncryptedDataBagItem(encryptedDataBagItem(1, "secret")))
+	assert.True(t, subject.IsEncryptedDataBagItem(encryptedDataBagItem(3, "secret")))
+	assert.False(t, subject.IsEncryptedDataBagItem(map[string]interface{}{
+		"id":       "item1",
+		"password": "hunter2",
+		"nested":   map[string]interface{}{"iv": "not encrypted"},
+	}))
+	assert.False(t, subject.IsEncryptedDataBagItem("item1"))
+}
+
+func TestVerifyDataBagItemSecret(t *testing.T) {
+	for _, version := range []int{1, 2, 3} {
+		item := encryptedDataBagItem(version, "secret")
+		assert.Nil(t, subject.VerifyDataBagItemSecret(item, []byte("secret")), "version %d", version)
+
+		err := subject.VerifyDataBagItemSecret(item, []byte("not the secret"))
+		if assert.NotNil(t, err, "version %d", version) {
+			assert.Contains(t, err.Error(), "unable to decrypt 'password'")
+			// the decrypted value is never part of the error
+			assert.NotContains(t, err.Error(), "hunter2")
+		}
+	}
+}
+
+func TestVerifyDataBagItemSecret_UnsupportedVersion(t *testing.T) {
+	item := encryptedDataBagItem(1, "secret")
+	item["password"].(map[string]interface{})["version"] = float64(4)
+	err := subject.VerifyDataBagItemSecret(item, []byte("secret"))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unsupported encrypted data bag item version 4")
+	}
+}
+
+func TestReadDataBagSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(path, []byte("s3cr3t\n"), 0600); err != nil {
+		panic(err)
+	}
+	secret, err := subject.ReadDataBagSecret(path)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []byte("s3cr3t"), secret)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(" \n"), 0600); err != nil {
+		panic(err)
+	}
+	_, err = subject.ReadDataBagSecret(path)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "is empty")
+	}
+
+	_, err = subject.ReadDataBagSecret(filepath.Join(dir, "missing"))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to read data bag secret")
+	}
+}
+
+func TestWriteDataBagSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = subject.WriteDataBagSecret(dir, []byte("s3cr3t"))
+	if assert.Nil(t, err) {
+		path := filepath.Join(dir, filepath.FromSlash(subject.DataBagSecretFile))
+		content, err := ioutil.ReadFile(path)
+		if assert.Nil(t, err) {
+			assert.Equal(t, "s3cr3t", string(content))
+		}
+		info, err := os.Stat(path)
+		if assert.Nil(t, err) {
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		}
+	}
+}
+
+func encryptedDataBagCapturer(item map[string]interface{}, writer *ObjectWriterMock) *subject.NodeCapturer {
+	return subject.NewNodeCapturer(
+		NodeMock{},
+		RoleMock{},
+		EnvMock{},
+		CookbookMock{},
+		DataBagMock{
+			desiredDataBagList:     map[string]string{"bag1": "https://url/data/bag1"},
+			desiredDataBagItem:     item,
+			desiredDataBagItemList: map[string]string{"item1": "https://url/data/bag1/item1"},
+		},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		writer,
+	)
+}
+
+func TestCapturer_CaptureAllDataBagItemsEncrypted(t *testing.T) {
+	item := encryptedDataBagItem(2, "secret")
+	writer := &ObjectWriterMock{}
+	nc := encryptedDataBagCapturer(item, writer)
+	nc.SetDataBagSecret([]byte("secret"))
+
+	err := nc.CaptureAllDataBagItems(nil)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"bag1/item1"}, nc.EncryptedDataBagItems())
+		// the item is written encrypted
+		assert.Equal(t, chef.DataBagItem(item), writer.ReceivedObject)
+	}
+}
+
+func TestCapturer_CaptureAllDataBagItemsEncryptedWithoutSecret(t *testing.T) {
+	nc := encryptedDataBagCapturer(encryptedDataBagItem(3, "secret"), &ObjectWriterMock{})
+
+	err := nc.CaptureAllDataBagItems(nil)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"bag1/item1"}, nc.EncryptedDataBagItems())
+	}
+}
+
+func TestCapturer_CaptureAllDataBagItemsEncryptedWithWrongSecret(t *testing.T) {
+	nc := encryptedDataBagCapturer(encryptedDataBagItem(1, "secret"), &ObjectWriterMock{})
+	nc.SetDataBagSecret([]byte("not the secret"))
+
+	err := nc.CaptureAllDataBagItems(nil)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "the data bag secret does not decrypt data bag item bag1/item1")
+	}
+}
+
+func TestCapturer_SaveKitchenYMLWithDataBagSecret(t *testing.T) {
+	writer := &ObjectWriterMock{}
+	nc := encryptedDataBagCapturer(nil, writer)
+	nc.SetDataBagSecret([]byte("secret"))
+
+	err := nc.SaveKitchenYML(nodeWithChefInstall())
+	if assert.Nil(t, err) {
+		kitchenYML := string(writer.ReceivedObject.([]byte))
+		assert.Contains(t, kitchenYML,
+			"  json_attributes: false\n  encrypted_data_bag_secret_key_path: .chef/encrypted_data_bag_secret\n")
+	}
+}
@@ -0,0 +1,180 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"fmt"
+
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+)
+
+// DefaultHabitatOrigin is the Habitat origin of the Effortless packages
+// when none is provided
+const DefaultHabitatOrigin = "chef-analyze"
+
+// EffortlessOpts are the options used to write the Effortless package of a policy
+type EffortlessOpts struct {
+	// Habitat origin of the package, defaults to DefaultHabitatOrigin
+	Origin string
+}
+
+const effortlessPolicyfileTmpl = `# Policyfile of the policy {{quote .Policy.Name}} revision {{quote .Policy.RevisionID}}
+# of the policy group {{quote .Group}}, reconstructed by chef-analyze capture
+# from the policy on the Chef Infra Server. The cookbooks are sourced from
+# the captured cookbook artifacts.
+{{- range .Policy.IncludedPolicyLocks}}
+#
+# The included policy {{quote .Name}} revision {{quote .RevisionID}} could not be
+# reconstructed, add it with include_policy once it is available locally.
+{{- end}}
+name {{quote .Policy.Name}}
+
+run_list {{join .Policy.RunList}}
+{{- range $name, $runList := .Policy.NamedRunList}}
+named_run_list :{{quote $name}}, {{join $runList}}
+{{- end}}
+{{range $name, $lock := .Policy.CookbookLocks}}
+cookbook {{quote $name}}, path: {{quote ($.ArtifactPath $name $lock)}}
+{{- end}}
+{{- range $key, $value := .Policy.DefaultAttributes}}
+default[{{quote $key}}] = {{ruby $value}}
+{{- end}}
+{{- range $key, $value := .Policy.OverrideAttributes}}
+override[{{quote $key}}] = {{ruby $value}}
+{{- end}}
+`
+
+const effortlessPlanShTmpl = `pkg_name={{.Policy.Name}}
+pkg_origin={{.Origin}}
+pkg_version="0.1.0"
+pkg_maintainer="The {{.Origin}} Maintainers"
+pkg_description="Effortless package of the policy {{.Policy.Name}} revision {{.Policy.RevisionID}}"
+pkg_license=("Apache-2.0")
+pkg_scaffolding="chef/scaffolding-chef-infra"
+pkg_svc_user=("root")
+scaffold_policy_name="{{.Policy.Name}}"
+scaffold_policyfile_path="$PLAN_CONTEXT/.."
+`
+
+const effortlessPlanPs1Tmpl = `$pkg_name="{{.Policy.Name}}"
+$pkg_origin="{{.Origin}}"
+$pkg_version="0.1.0"
+$pkg_maintainer="The {{.Origin}} Maintainers"
+$pkg_description="Effortless package of the policy {{.Policy.Name}} revision {{.Policy.RevisionID}}"
+$pkg_license=@("Apache-2.0")
+$pkg_scaffolding="chef/scaffolding-chef-infra"
+$scaffold_policy_name="{{.Policy.Name}}"
+$scaffold_policyfile_path="$PLAN_CONTEXT/.."
+`
+
+const effortlessDefaultToml = `interval = 1800
+splay = 1800
+splay_first_run = 0
+run_lock_timeout = 1800
+log_level = "warn"
+chef_license = "accept-no-persist"
+
+[automate]
+enable = false
+server_url = "https://<automate_url>"
+token = "<automate_token>"
+`
+
+type effortlessArgs struct {
+	Policy *chef.RevisionDetailsResponse
+	Group  string
+	Origin string
+}
+
+// ArtifactPath returns the path of a captured cookbook artifact relative to
+// the directory of the Effortless package
+func (ea effortlessArgs) ArtifactPath(name string, lock chef.CookbookLock) string {
+	return fmt.Sprintf("../../cookbook_artifacts/%s-%s", name, lock.Identifier)
+}
+
+// SetEffortlessOpts sets the options used to write Effortless packages
+func (nc *NodeCapturer) SetEffortlessOpts(opts EffortlessOpts) {
+	nc.effortless = opts
+}
+
+// EffortlessDir returns the directory, relative to the repository, of the
+// Effortless package of a policy in a policy group
+func EffortlessDir(policyName, group string) string {
+	return fmt.Sprintf("effortless/%s-%s", policyName, group)
+}
+
+// SaveEffortlessPackage writes an Effortless package of the policy revision of a
+// policy group to effortless/POLICY-GROUP: a Policyfile whose cookbooks are the
+// captured cookbook artifacts, its Policyfile.lock.json reconstructed from the
+// revision and the Habitat plan.sh, plan.ps1 and default.toml to build it
+func (nc *NodeCapturer) SaveEffortlessPackage(group string, policy *chef.RevisionDetailsResponse) error {
+	args := effortlessArgs{Policy: policy, Group: group, Origin: nc.effortless.Origin}
+	if args.Origin == "" {
+		args.Origin = DefaultHabitatOrigin
+	}
+	dir := EffortlessDir(policy.Name, group)
+
+	// the lock is redacted by the writer
+	err := nc.writer.WriteJSON(dir, policy.Name+".lock", effortlessPolicyLock(args))
+	if err != nil {
+		return errors.Wrapf(err, "unable to save lock of policy '%s'", policy.Name)
+	}
+
+	// the attributes the Policyfile writes as ruby are redacted like the ones of the nodes
+	policyfile := fmt.Sprintf("%s/%s.rb", dir, policy.Name)
+	redacted := *policy
+	redacted.DefaultAttributes = nc.redactAttributes(policyfile, policy.DefaultAttributes)
+	redacted.OverrideAttributes = nc.redactAttributes(policyfile, policy.OverrideAttributes)
+	args.Policy = &redacted
+
+	for _, file := range []struct {
+		name string
+		tmpl string
+	}{
+		{policyfile, effortlessPolicyfileTmpl},
+		{dir + "/habitat/plan.sh", effortlessPlanShTmpl},
+		{dir + "/habitat/plan.ps1", effortlessPlanPs1Tmpl},
+		{dir + "/habitat/default.toml", effortlessDefaultToml},
+	} {
+		content, err := renderRubyTemplate(file.name, file.tmpl, args)
+		if err != nil {
+			return err
+		}
+		if err := nc.writer.WriteContent(file.name, content); err != nil {
+			return errors.Wrapf(err, "unable to save %s", file.name)
+		}
+	}
+	return nil
+}
+
+// returns the Policyfile.lock.json of the policy revision, the policy on the
+// Chef Infra Server is the lock itself except for the cookbook sources, which
+// are replaced by the captured cookbook artifacts
+func effortlessPolicyLock(args effortlessArgs) *chef.RevisionDetailsResponse {
+	lock := *args.Policy
+	lock.CookbookLocks = make(map[string]chef.CookbookLock, len(args.Policy.CookbookLocks))
+	for name, cookbook := range args.Policy.CookbookLocks {
+		path := args.ArtifactPath(name, cookbook)
+		cookbook.Source = path
+		cookbook.SourceOptions = map[string]string{"path": path}
+		cookbook.Origin = ""
+		cookbook.CacheKey = ""
+		lock.CookbookLocks[name] = cookbook
+	}
+	return &lock
+}
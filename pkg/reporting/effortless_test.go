@@ -0,0 +1,147 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func effortlessPolicy() *chef.RevisionDetailsResponse {
+	return &chef.RevisionDetailsResponse{
+		Name:         "web",
+		RevisionID:   "1234abcd",
+		RunList:      []string{"recipe[base::default]", "recipe[web::server]"},
+		NamedRunList: map[string][]string{"deploy": []string{"recipe[web::deploy]"}},
+		CookbookLocks: map[string]chef.CookbookLock{
+			"base": chef.CookbookLock{
+				Version:       "1.2.0",
+				Identifier:    "abcdef0123456789abcdef0123456789",
+				Origin:        "https://supermarket.chef.io:443/api/v1/cookbooks/base/versions/1.2.0/download",
+				CacheKey:      "base-1.2.0-supermarket.chef.io",
+				SourceOptions: map[string]string{"artifactserver": "https://supermarket.chef.io"},
+			},
+			"web": chef.CookbookLock{
+				Version:       "0.3.1",
+				Identifier:    "0123456789abcdef0123456789abcdef",
+				Source:        "cookbooks/web",
+				SourceOptions: map[string]string{"path": "cookbooks/web"},
+			},
+		},
+		DefaultAttributes:  map[string]interface{}{"web": map[string]interface{}{"port": 8080.0}},
+		OverrideAttributes: map[string]interface{}{},
+	}
+}
+
+func TestCapturer_SaveEffortlessPackage(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	nc := subject.NewNodeCapturer(
+		NodeMock{},
+		RoleMock{},
+		EnvMock{},
+		CookbookMock{},
+		DataBagMock{},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		&subject.ObjectWriter{RootDir: baseDir},
+	)
+	nc.SetEffortlessOpts(subject.EffortlessOpts{Origin: "acme"})
+	policy := effortlessPolicy()
+	err = nc.SaveEffortlessPackage("prod", policy)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	packageDir := filepath.Join(baseDir, subject.EffortlessDir("web", "prod"))
+	policyfile, err := ioutil.ReadFile(filepath.Join(packageDir, "web.rb"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, `# Policyfile of the policy 'web' revision '1234abcd'
+# of the policy group 'prod', reconstructed by chef-analyze capture
+# from the policy on the Chef Infra Server. The cookbooks are sourced from
+# the captured cookbook artifacts.
+name 'web'
+
+run_list 'recipe[base::default]', 'recipe[web::server]'
+named_run_list :'deploy', 'recipe[web::deploy]'
+
+cookbook 'base', path: '../../cookbook_artifacts/base-abcdef0123456789abcdef0123456789'
+cookbook 'web', path: '../../cookbook_artifacts/web-0123456789abcdef0123456789abcdef'
+default['web'] = {
+  'port' => 8080
+}
+`, string(policyfile))
+	}
+
+	lock := readJSONFile(t, filepath.Join(packageDir, "web.lock.json"))
+	assert.Equal(t, "1234abcd", lock["revision_id"])
+	assert.Equal(t, map[string]interface{}{
+		"version":        "1.2.0",
+		"identifier":     "abcdef0123456789abcdef0123456789",
+		"source":         "../../cookbook_artifacts/base-abcdef0123456789abcdef0123456789",
+		"source_options": map[string]interface{}{"path": "../../cookbook_artifacts/base-abcdef0123456789abcdef0123456789"},
+		"scm_info":       map[string]interface{}{},
+	}, lock["cookbook_locks"].(map[string]interface{})["base"])
+
+	plan, err := ioutil.ReadFile(filepath.Join(packageDir, "habitat", "plan.sh"))
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(plan), "pkg_name=web\npkg_origin=acme\n")
+		assert.Contains(t, string(plan), `scaffold_policy_name="web"`)
+		assert.Contains(t, string(plan), `scaffold_policyfile_path="$PLAN_CONTEXT/.."`)
+	}
+	plan, err = ioutil.ReadFile(filepath.Join(packageDir, "habitat", "plan.ps1"))
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(plan), `$pkg_origin="acme"`)
+		assert.Contains(t, string(plan), `$scaffold_policy_name="web"`)
+	}
+	_, err = os.Stat(filepath.Join(packageDir, "habitat", "default.toml"))
+	assert.Nil(t, err)
+
+	// the captured policy is not modified
+	assert.Equal(t, "cookbooks/web", policy.CookbookLocks["web"].Source)
+}
+
+func TestCapturer_SaveEffortlessPackageWithFailedWrite(t *testing.T) {
+	nc := subject.NewNodeCapturer(
+		NodeMock{},
+		RoleMock{},
+		EnvMock{},
+		CookbookMock{},
+		DataBagMock{},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		&ObjectWriterMock{Error: errors.New("disk full")},
+	)
+	err := nc.SaveEffortlessPackage("prod", effortlessPolicy())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to save lock of policy 'web'")
+		assert.Contains(t, err.Error(), "disk full")
+	}
+}
@@ -0,0 +1,389 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	_ "embed"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+)
+
+// the embedded kitchen images, see data/kitchen_images.toml to update them
+//
+//go:embed data/kitchen_images.toml
+var embeddedKitchenImages string
+
+// Test Kitchen drivers that the kitchen.yml of a capture can be written for
+const (
+	KitchenDriverVagrant = "vagrant"
+	KitchenDriverDokken  = "dokken"
+	KitchenDriverDocker  = "docker"
+	KitchenDriverEC2     = "ec2"
+)
+
+// KitchenDrivers are the valid drivers of the kitchen.yml of a capture
+var KitchenDrivers = []string{KitchenDriverVagrant, KitchenDriverDokken, KitchenDriverDocker, KitchenDriverEC2}
+
+// the driver section of the kitchen.yml of every driver
+var kitchenDriverTemplates = map[string]string{
+	KitchenDriverVagrant: `driver:
+  name: vagrant
+`,
+	KitchenDriverDokken: `driver:
+  name: dokken
+  privileged: true
+
+transport:
+  name: dokken
+`,
+	KitchenDriverDocker: `driver:
+  name: docker
+`,
+	KitchenDriverEC2: `driver:
+  name: ec2
+`,
+}
+
+// the platform driver setting that holds the image of every driver
+var kitchenImageSettings = map[string]string{
+	KitchenDriverVagrant: "box",
+	KitchenDriverDokken:  "image",
+	KitchenDriverDocker:  "image",
+	KitchenDriverEC2:     "image_id",
+}
+
+// the driver, transport and provisioner settings of a platform of the kitchen.yml
+const kitchenPlatformSettingsTmpl = `
+{{- range $section := .Sections}}
+{{- if $section.Settings}}
+    {{$section.Name}}:
+{{- range $section.Settings}}
+      {{.Key}}: {{.Value}}
+{{- end}}
+{{- end}}
+{{- end}}`
+
+// the releases of Windows Server by the build of their platform version
+var windowsReleases = []struct {
+	version string
+	release string
+}{
+	{"6.1.7600", "2008r2"},
+	{"6.1.7601", "2008r2"},
+	{"6.2.9200", "2012"},
+	{"6.3.9600", "2012r2"},
+	{"10.0.14393", "2016"},
+	{"10.0.17763", "2019"},
+	{"10.0.20348", "2022"},
+	{"10.0.26100", "2025"},
+}
+
+// the provisioner settings of Windows guests, the client is retried once
+// after a reboot is requested (exit code 35) or a reboot is pending (213)
+var windowsProvisionerSettings = []kitchenSetting{
+	{"retry_on_exit_code", "[35, 213]"},
+	{"max_retries", "1"},
+	{"wait_for_retry", "90"},
+}
+
+// KitchenOpts are the options used to write the kitchen.yml of a capture
+type KitchenOpts struct {
+	// one of KitchenDrivers, defaults to vagrant
+	Driver string
+	// defaults to the embedded kitchen images
+	Images *KitchenImages
+}
+
+// KitchenImages maps platforms to the boxes and images of the kitchen drivers
+type KitchenImages struct {
+	Images []KitchenImage `toml:"images"`
+}
+
+// KitchenImage is the box or image of a driver for a platform (or platform family)
+// and version, see data/kitchen_images.toml for a description of every field
+type KitchenImage struct {
+	Driver   string `toml:"driver"`
+	Platform string `toml:"platform"`
+	Family   string `toml:"family"`
+	Version  string `toml:"version"`
+	Image    string `toml:"image"`
+}
+
+// the platform of a node in the kitchen.yml
+type kitchenPlatform struct {
+	Name        string
+	Settings    []kitchenSetting // driver settings
+	Transport   []kitchenSetting
+	Provisioner []kitchenSetting
+}
+
+type kitchenSection struct {
+	Name     string
+	Settings []kitchenSetting
+}
+
+type kitchenSetting struct {
+	Key   string
+	Value string
+}
+
+var (
+	defaultKitchenImages     *KitchenImages
+	defaultKitchenImagesOnce sync.Once
+)
+
+// LoadKitchenImages loads the embedded kitchen images and, when an override
+// file is provided, replaces its entries with the ones from that file
+func LoadKitchenImages(overrideFile string) (*KitchenImages, error) {
+	images, err := parseKitchenImages(embeddedKitchenImages)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse embedded kitchen images")
+	}
+
+	if overrideFile == "" {
+		return images, nil
+	}
+
+	overrideBytes, err := ioutil.ReadFile(overrideFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read kitchen images from '%s'", overrideFile)
+	}
+	override, err := parseKitchenImages(string(overrideBytes))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse kitchen images from '%s'", overrideFile)
+	}
+
+	images.Merge(override)
+	return images, nil
+}
+
+func parseKitchenImages(content string) (*KitchenImages, error) {
+	var images KitchenImages
+	if _, err := toml.Decode(content, &images); err != nil {
+		return nil, err
+	}
+
+	for _, image := range images.Images {
+		if _, ok := kitchenDriverTemplates[image.Driver]; !ok {
+			return nil, errors.Errorf("invalid driver '%s' of image '%s', valid drivers are %s",
+				image.Driver, image.Image, strings.Join(KitchenDrivers, ", "))
+		}
+		if image.Platform == "" && image.Family == "" {
+			return nil, errors.Errorf("image '%s' requires a platform or a family", image.Image)
+		}
+		if image.Image == "" {
+			return nil, errors.New("every kitchen image requires an image")
+		}
+	}
+	return &images, nil
+}
+
+// Merge replaces the images with the ones of the override that have the
+// same driver, platform, family and version, images that don't exist are added
+func (ki *KitchenImages) Merge(override *KitchenImages) {
+	for _, image := range override.Images {
+		replaced := false
+		for i, existing := range ki.Images {
+			if existing.Driver == image.Driver &&
+				strings.EqualFold(existing.Platform, image.Platform) &&
+				strings.EqualFold(existing.Family, image.Family) &&
+				existing.Version == image.Version {
+				ki.Images[i] = image
+				replaced = true
+			}
+		}
+		if !replaced {
+			ki.Images = append(ki.Images, image)
+		}
+	}
+}
+
+// Lookup returns the box or image of the driver for the provided platform,
+// platform family and version, returns false if no image matches them
+func (ki *KitchenImages) Lookup(driver, platform, family, version string) (string, bool) {
+	var (
+		found *KitchenImage
+		score int
+	)
+	for i, image := range ki.Images {
+		if image.Driver != driver {
+			continue
+		}
+		if image.Version != "" && !versionHasPrefix(version, image.Version) {
+			continue
+		}
+
+		// platform entries take precedence over family entries,
+		// then longer versions over shorter ones
+		var s int
+		switch {
+		case image.Platform != "" && strings.EqualFold(image.Platform, platform):
+			s = 2000
+		case image.Platform == "" && strings.EqualFold(image.Family, family):
+			s = 1000
+		default:
+			continue
+		}
+		s += len(image.Version)
+
+		if found == nil || s > score {
+			found = &ki.Images[i]
+			score = s
+		}
+	}
+	if found == nil {
+		return "", false
+	}
+
+	major := strings.SplitN(version, ".", 2)[0]
+	return strings.NewReplacer("{version}", version, "{major}", major).Replace(found.Image), true
+}
+
+// SetKitchenOpts sets the options used to write the kitchen.yml
+func (nc *NodeCapturer) SetKitchenOpts(opts KitchenOpts) {
+	nc.kitchen = opts
+}
+
+func (nc *NodeCapturer) kitchenDriver() string {
+	if nc.kitchen.Driver == "" {
+		return KitchenDriverVagrant
+	}
+	return nc.kitchen.Driver
+}
+
+func (nc *NodeCapturer) kitchenImages() *KitchenImages {
+	if nc.kitchen.Images != nil {
+		return nc.kitchen.Images
+	}
+
+	defaultKitchenImagesOnce.Do(func() {
+		images, err := LoadKitchenImages("")
+		if err != nil {
+			// the embedded images are verified by the unit tests
+			images = &KitchenImages{}
+		}
+		defaultKitchenImages = images
+	})
+	return defaultKitchenImages
+}
+
+// returns the platform of the node in the kitchen.yml, nodes that run on EC2
+// use their AMI and instance type when writing the config of the ec2 driver,
+// Windows nodes use a WinRM transport (or the docker transport with docker)
+func (nc *NodeCapturer) kitchenPlatform(node *chef.Node) (kitchenPlatform, error) {
+	var (
+		driver   = nc.kitchenDriver()
+		platform = extractPlatformFromNode(node)
+		kp       = kitchenPlatform{Name: determineKitchenImage(node)}
+	)
+
+	if platform.isWindows() {
+		switch driver {
+		case KitchenDriverDokken:
+			return kp, errors.Errorf(
+				"unable to write kitchen config for '%s', Windows nodes are not supported by the dokken driver, use %s, %s or %s",
+				node.Name, KitchenDriverVagrant, KitchenDriverDocker, KitchenDriverEC2,
+			)
+		case KitchenDriverDocker:
+			kp.Settings = append(kp.Settings, kitchenSetting{"platform", "windows"})
+			kp.Transport = []kitchenSetting{{"name", "docker"}, {"socket", "npipe:////./pipe/docker_engine"}}
+		default:
+			kp.Transport = []kitchenSetting{{"name", "winrm"}, {"elevated", "true"}}
+		}
+		kp.Provisioner = windowsProvisionerSettings
+	}
+
+	if driver == KitchenDriverEC2 {
+		if ec2, ok := node.AutomaticAttributes["ec2"].(map[string]interface{}); ok {
+			if ami := safeStringFromMap(ec2, "ami_id"); ami != "" {
+				kp.Settings = append(kp.Settings, kitchenSetting{"image_id", ami})
+				if instanceType := safeStringFromMap(ec2, "instance_type"); instanceType != "" {
+					kp.Settings = append(kp.Settings, kitchenSetting{"instance_type", instanceType})
+				}
+				if region := ec2Region(ec2); region != "" {
+					kp.Settings = append(kp.Settings, kitchenSetting{"region", region})
+				}
+				return kp, nil
+			}
+		}
+	}
+
+	name, family := platform.Name, platform.Family
+	if platform.isWindows() {
+		// ohai reports windows as both the platform and the family
+		name, family = "windows", "windows"
+	}
+	image, ok := nc.kitchenImages().Lookup(driver, name, family, platform.Version)
+	if ok {
+		// the image setting goes first
+		kp.Settings = append([]kitchenSetting{{kitchenImageSettings[driver], image}}, kp.Settings...)
+	}
+	return kp, nil
+}
+
+// Sections returns the driver, transport and provisioner settings of the platform
+func (kp kitchenPlatform) Sections() []kitchenSection {
+	return []kitchenSection{
+		{"driver", kp.Settings},
+		{"transport", kp.Transport},
+		{"provisioner", kp.Provisioner},
+	}
+}
+
+func (pd nodePlatformData) isWindows() bool {
+	return strings.EqualFold(pd.OS, "windows") || strings.EqualFold(pd.Family, "windows")
+}
+
+// returns the release of Windows Server of a platform version (e.g. 2019 for
+// 10.0.17763), or the version itself when the release is unknown
+func windowsRelease(version string) string {
+	for _, r := range windowsReleases {
+		if versionHasPrefix(version, r.version) {
+			return r.release
+		}
+	}
+	return version
+}
+
+// returns the region of an EC2 instance, older versions of ohai
+// only report the availability zone (e.g. us-west-2a)
+func ec2Region(ec2 map[string]interface{}) string {
+	if region := safeStringFromMap(ec2, "region"); region != "" {
+		return region
+	}
+	zone := safeStringFromMap(ec2, "placement_availability_zone")
+	if len(zone) < 2 {
+		return ""
+	}
+	return zone[:len(zone)-1]
+}
+
+// the key that identifies the settings of a platform
+func (kp kitchenPlatform) settingsKey() string {
+	parts := make([]string, 0)
+	for _, section := range kp.Sections() {
+		for _, s := range section.Settings {
+			parts = append(parts, section.Name+"."+s.Key+"="+s.Value)
+		}
+	}
+	return strings.Join(parts, ",")
+}
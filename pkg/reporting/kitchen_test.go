@@ -0,0 +1,437 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/go-chef/chef"
+	"github.com/stretchr/testify/assert"
+)
+
+func newKitchenCapturer(writer *ObjectWriterMock, opts subject.KitchenOpts) *subject.NodeCapturer {
+	nc := subject.NewNodeCapturer(
+		NodeMock{},
+		RoleMock{},
+		EnvMock{},
+		CookbookMock{},
+		DataBagMock{},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		writer,
+	)
+	nc.SetKitchenOpts(opts)
+	return nc
+}
+
+func TestLoadKitchenImages(t *testing.T) {
+	images, err := subject.LoadKitchenImages("")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	cases := []struct {
+		driver, platform, family, version string
+		expected                          string
+		found                             bool
+	}{
+		{"vagrant", "ubuntu", "debian", "18.04", "bento/ubuntu-18.04", true},
+		{"vagrant", "centos", "rhel", "7.8.2003", "bento/centos-7", true},
+		{"vagrant", "centos", "rhel", "8.2.2004", "bento/centos-stream-8", true},
+		{"dokken", "ubuntu", "debian", "20.04", "dokken/ubuntu-20.04", true},
+		{"docker", "amazon", "amazon", "2", "amazonlinux:2", true},
+		// platforms without an entry fall back to their family
+		{"vagrant", "scientific", "rhel", "7.4", "bento/centos-7", true},
+		{"vagrant", "arch", "arch", "", "", false},
+		// kitchen-ec2 looks up the AMI on its own
+		{"ec2", "ubuntu", "debian", "18.04", "", false},
+	}
+	for _, c := range cases {
+		image, found := images.Lookup(c.driver, c.platform, c.family, c.version)
+		assert.Equal(t, c.found, found, "%s %s %s", c.driver, c.platform, c.version)
+		assert.Equal(t, c.expected, image, "%s %s %s", c.driver, c.platform, c.version)
+	}
+}
+
+func TestLoadKitchenImages_Override(t *testing.T) {
+	override, err := ioutil.TempFile(os.TempDir(), "kitchen-images*.toml")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(override.Name())
+	override.WriteString(`
+[[images]]
+driver = "vagrant"
+platform = "ubuntu"
+image = "mycorp/ubuntu-{version}"
+
+[[images]]
+driver = "ec2"
+platform = "ubuntu"
+version = "18.04"
+image = "ami-0123456789"
+`)
+	override.Close()
+
+	images, err := subject.LoadKitchenImages(override.Name())
+	if !assert.Nil(t, err) {
+		return
+	}
+	image, _ := images.Lookup("vagrant", "ubuntu", "debian", "18.04")
+	assert.Equal(t, "mycorp/ubuntu-18.04", image)
+	image, _ = images.Lookup("ec2", "ubuntu", "debian", "18.04.4")
+	assert.Equal(t, "ami-0123456789", image)
+	image, _ = images.Lookup("vagrant", "centos", "rhel", "7.8")
+	assert.Equal(t, "bento/centos-7", image)
+
+	_, err = subject.LoadKitchenImages("/does/not/exist.toml")
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to read kitchen images")
+	}
+
+	invalid, err := ioutil.TempFile(os.TempDir(), "kitchen-images*.toml")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(invalid.Name())
+	invalid.WriteString("[[images]]\ndriver = \"lxd\"\nplatform = \"ubuntu\"\nimage = \"ubuntu\"\n")
+	invalid.Close()
+	_, err = subject.LoadKitchenImages(invalid.Name())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to parse kitchen images")
+		assert.Contains(t, err.Error(), "invalid driver 'lxd'")
+	}
+}
+
+func TestCapturer_SaveKitchenYMLDokken(t *testing.T) {
+	writerMock := ObjectWriterMock{}
+	nc := newKitchenCapturer(&writerMock, subject.KitchenOpts{Driver: subject.KitchenDriverDokken})
+
+	err := nc.SaveKitchenYML(nodeWithChefInstall())
+	expectedYML := `
+---
+driver:
+  name: dokken
+  privileged: true
+
+transport:
+  name: dokken
+
+provisioner:
+  name: chef_zero_capture
+  product_name: chef
+  product_version: 99.0
+  json_attributes: false
+  client_rb:
+    node_name: node1
+
+platforms:
+  - name: ubuntu-18.04
+    driver:
+      image: dokken/ubuntu-18.04
+
+suites:
+  - name: node1
+    driver:
+      chef_version: 99.0
+`
+	if assert.Nil(t, err) {
+		assert.Equal(t, expectedYML, string(writerMock.ReceivedObject.([]byte)))
+	}
+}
+
+func ec2Node(name, ami string) *chef.Node {
+	node := nodeWithChefInstall()
+	node.Name = name
+	node.AutomaticAttributes["ec2"] = map[string]interface{}{
+		"ami_id":                      ami,
+		"instance_type":               "t3.medium",
+		"placement_availability_zone": "us-west-2a",
+	}
+	return node
+}
+
+func TestCapturer_SaveKitchenYMLEC2(t *testing.T) {
+	writerMock := ObjectWriterMock{}
+	nc := newKitchenCapturer(&writerMock, subject.KitchenOpts{Driver: subject.KitchenDriverEC2})
+
+	err := nc.SaveKitchenYML(ec2Node("node1", "ami-0a1b2c"))
+	expectedYML := `
+---
+driver:
+  name: ec2
+
+provisioner:
+  name: chef_zero_capture
+  product_name: chef
+  product_version: 99.0
+  json_attributes: false
+  client_rb:
+    node_name: node1
+
+platforms:
+  - name: ubuntu-18.04
+    driver:
+      image_id: ami-0a1b2c
+      instance_type: t3.medium
+      region: us-west-2
+
+suites:
+  - name: node1
+`
+	if assert.Nil(t, err) {
+		assert.Equal(t, expectedYML, string(writerMock.ReceivedObject.([]byte)))
+	}
+
+	// nodes that don't run on EC2 let kitchen-ec2 find the AMI
+	err = nc.SaveKitchenYML(nodeWithChefInstall())
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(writerMock.ReceivedObject.([]byte)), "platforms:\n  - name: ubuntu-18.04\n\nsuites:")
+	}
+}
+
+func TestCapturer_SaveMultiNodeKitchenYMLEC2(t *testing.T) {
+	writerMock := ObjectWriterMock{}
+	nc := newKitchenCapturer(&writerMock, subject.KitchenOpts{Driver: subject.KitchenDriverEC2})
+
+	err := nc.SaveMultiNodeKitchenYML([]*chef.Node{
+		ec2Node("node1", "ami-1"),
+		ec2Node("node2", "ami-2"),
+		ec2Node("node3", "ami-1"),
+	})
+	expectedYML := `
+---
+driver:
+  name: ec2
+
+provisioner:
+  name: chef_zero_capture
+  product_name: chef
+  json_attributes: false
+
+platforms:
+  - name: ubuntu-18.04
+    driver:
+      image_id: ami-1
+      instance_type: t3.medium
+      region: us-west-2
+  - name: ubuntu-18.04-2
+    driver:
+      image_id: ami-2
+      instance_type: t3.medium
+      region: us-west-2
+
+suites:
+  - name: node1
+    provisioner:
+      product_version: 99.0
+      client_rb:
+        node_name: node1
+    includes:
+      - ubuntu-18.04
+  - name: node2
+    provisioner:
+      product_version: 99.0
+      client_rb:
+        node_name: node2
+    includes:
+      - ubuntu-18.04-2
+  - name: node3
+    provisioner:
+      product_version: 99.0
+      client_rb:
+        node_name: node3
+    includes:
+      - ubuntu-18.04
+`
+	if assert.Nil(t, err) {
+		assert.Equal(t, expectedYML, string(writerMock.ReceivedObject.([]byte)))
+	}
+}
+
+// a Windows Server 2019 node as captured from a Chef Infra Server
+func windowsNode() *chef.Node {
+	return &chef.Node{
+		Name:        "win1",
+		Environment: "_default",
+		RunList:     []string{"recipe[iis]"},
+		AutomaticAttributes: map[string]interface{}{
+			"chef_packages": map[string]interface{}{
+				"chef": map[string]interface{}{
+					"version": "16.5.77",
+				},
+			},
+			"os":               "windows",
+			"os_version":       "10.0.17763",
+			"platform":         "windows",
+			"platform_version": "10.0.17763",
+			"platform_family":  "windows",
+			"kernel": map[string]interface{}{
+				"name": "Microsoft Windows Server 2019 Datacenter",
+			},
+		},
+	}
+}
+
+func TestCapturer_SaveKitchenYMLWindows(t *testing.T) {
+	writerMock := ObjectWriterMock{}
+	nc := newKitchenCapturer(&writerMock, subject.KitchenOpts{})
+
+	err := nc.SaveKitchenYML(windowsNode())
+	expectedYML := `
+---
+driver:
+  name: vagrant
+
+provisioner:
+  name: chef_zero_capture
+  product_name: chef
+  product_version: 16.5.77
+  json_attributes: false
+  client_rb:
+    node_name: win1
+
+platforms:
+  - name: windows-2019
+    driver:
+      box: gusztavvargadr/windows-server-2019-standard
+    transport:
+      name: winrm
+      elevated: true
+    provisioner:
+      retry_on_exit_code: [35, 213]
+      max_retries: 1
+      wait_for_retry: 90
+
+suites:
+  - name: win1
+`
+	if assert.Nil(t, err) {
+		assert.Equal(t, expectedYML, string(writerMock.ReceivedObject.([]byte)))
+	}
+
+	// unknown releases are named after their version and have no box
+	node := windowsNode()
+	node.AutomaticAttributes["platform_version"] = "10.0.99999"
+	err = nc.SaveKitchenYML(node)
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(writerMock.ReceivedObject.([]byte)), `
+  - name: windows-10.0.99999
+    transport:
+      name: winrm`)
+	}
+}
+
+func TestCapturer_SaveKitchenYMLWindowsDrivers(t *testing.T) {
+	writerMock := ObjectWriterMock{}
+
+	nc := newKitchenCapturer(&writerMock, subject.KitchenOpts{Driver: subject.KitchenDriverDocker})
+	err := nc.SaveKitchenYML(windowsNode())
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(writerMock.ReceivedObject.([]byte)), `
+  - name: windows-2019
+    driver:
+      image: mcr.microsoft.com/windows/servercore:ltsc2019
+      platform: windows
+    transport:
+      name: docker
+      socket: npipe:////./pipe/docker_engine
+`)
+	}
+
+	node := windowsNode()
+	node.AutomaticAttributes["ec2"] = map[string]interface{}{
+		"ami_id":        "ami-0win",
+		"instance_type": "m5.large",
+		"region":        "eu-west-1",
+	}
+	nc = newKitchenCapturer(&writerMock, subject.KitchenOpts{Driver: subject.KitchenDriverEC2})
+	err = nc.SaveKitchenYML(node)
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(writerMock.ReceivedObject.([]byte)), `
+  - name: windows-2019
+    driver:
+      image_id: ami-0win
+      instance_type: m5.large
+      region: eu-west-1
+    transport:
+      name: winrm
+      elevated: true
+`)
+	}
+
+	nc = newKitchenCapturer(&writerMock, subject.KitchenOpts{Driver: subject.KitchenDriverDokken})
+	err = nc.SaveKitchenYML(windowsNode())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "Windows nodes are not supported by the dokken driver")
+	}
+}
+
+func TestCapturer_SaveMultiNodeKitchenYMLWindows(t *testing.T) {
+	writerMock := ObjectWriterMock{}
+	nc := newKitchenCapturer(&writerMock, subject.KitchenOpts{})
+
+	err := nc.SaveMultiNodeKitchenYML([]*chef.Node{nodeWithChefInstall(), windowsNode()})
+	expectedYML := `
+---
+driver:
+  name: vagrant
+
+provisioner:
+  name: chef_zero_capture
+  product_name: chef
+  json_attributes: false
+
+platforms:
+  - name: ubuntu-18.04
+    driver:
+      box: bento/ubuntu-18.04
+  - name: windows-2019
+    driver:
+      box: gusztavvargadr/windows-server-2019-standard
+    transport:
+      name: winrm
+      elevated: true
+    provisioner:
+      retry_on_exit_code: [35, 213]
+      max_retries: 1
+      wait_for_retry: 90
+
+suites:
+  - name: node1
+    provisioner:
+      product_version: 99.0
+      client_rb:
+        node_name: node1
+    includes:
+      - ubuntu-18.04
+  - name: win1
+    provisioner:
+      product_version: 16.5.77
+      client_rb:
+        node_name: win1
+    includes:
+      - windows-2019
+`
+	if assert.Nil(t, err) {
+		assert.Equal(t, expectedYML, string(writerMock.ReceivedObject.([]byte)))
+	}
+}
@@ -0,0 +1,168 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// partial search keys of custom attributes are prefixed to
+// avoid clashing with the keys the nodes report already requests
+const nodeAttributeKeyPrefix = "attribute:"
+
+// NodeAttribute is a custom node attribute to add as a column to the nodes report
+type NodeAttribute struct {
+	Name string
+	Path []string
+}
+
+// NodeAttributeValue is the serialized value of a custom attribute of a node
+type NodeAttributeValue struct {
+	Name  string
+	Value string
+}
+
+// NodesReportConfig holds the settings of the nodes report that
+// can be configured in the config.toml file:
+//
+//	[reports.nodes]
+//	attributes = ["environment=chef_environment", "provider=cloud.provider"]
+//	support_data = "/path/to/support.toml"
+type NodesReportConfig struct {
+	Attributes  []string `toml:"attributes"`
+	SupportData string   `toml:"support_data"`
+}
+
+// LoadNodesReportConfig loads the nodes report settings from the user's config.toml,
+// returns an empty config when the file doesn't exist
+func LoadNodesReportConfig() (NodesReportConfig, error) {
+	cfg, err := LoadConfigFile()
+	return cfg.Reports.Nodes, err
+}
+
+// ParseNodeAttribute parses an attribute in the form NAME=PATH where the path is
+// a dot separated list of keys (e.g. "provider=cloud.provider"), the name is optional
+// and when missing the path is used as the name of the attribute
+func ParseNodeAttribute(spec string) (NodeAttribute, error) {
+	name, path := spec, spec
+	if i := strings.Index(spec, "="); i != -1 {
+		name, path = spec[0:i], spec[i+1:]
+	}
+	name, path = strings.TrimSpace(name), strings.TrimSpace(path)
+
+	if name == "" || path == "" {
+		return NodeAttribute{}, errors.Errorf("invalid attribute '%s', use the form NAME=PATH (e.g. provider=cloud.provider)", spec)
+	}
+
+	keys := strings.Split(path, ".")
+	for _, key := range keys {
+		if key == "" {
+			return NodeAttribute{}, errors.Errorf("invalid attribute '%s', path '%s' has an empty key", spec, path)
+		}
+	}
+
+	return NodeAttribute{Name: name, Path: keys}, nil
+}
+
+// ParseNodeAttributes parses a list of attributes, an attribute that is specified
+// more than once keeps its first position but uses the last definition, this
+// allows the attributes in the config.toml to be overridden from the command line
+func ParseNodeAttributes(specs []string) ([]NodeAttribute, error) {
+	var (
+		attributes = make([]NodeAttribute, 0, len(specs))
+		positions  = make(map[string]int)
+	)
+	for _, spec := range specs {
+		attr, err := ParseNodeAttribute(spec)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := positions[attr.Name]; ok {
+			attributes[i] = attr
+			continue
+		}
+		positions[attr.Name] = len(attributes)
+		attributes = append(attributes, attr)
+	}
+	return attributes, nil
+}
+
+func (na NodeAttribute) searchKey() string {
+	return nodeAttributeKeyPrefix + na.Name
+}
+
+// serializes an attribute value into a string, nested values produce the same
+// string no matter the order in which the keys were received
+//
+//	nil                  => ""
+//	"foo"                => "foo"
+//	1.0                  => "1"
+//	["a", "b"]           => "a, b"
+//	{"b": 1, "a": [1,2]} => {"a":[1,2],"b":1}
+func serializeAttributeValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			if _, nested := item.([]interface{}); nested {
+				items = append(items, jsonAttributeValue(item))
+				continue
+			}
+			items = append(items, serializeAttributeValue(item))
+		}
+		return strings.Join(items, ", ")
+	case map[string]interface{}:
+		return jsonAttributeValue(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// encoding/json sorts the keys of maps, which is what makes this deterministic
+func jsonAttributeValue(value interface{}) string {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(bytes)
+}
+
+// NodeAttributeNames returns the names of the custom attributes of the provided
+// records, all records of a report share the same attributes
+func NodeAttributeNames(records []*NodeReportItem) []string {
+	if len(records) == 0 {
+		return []string{}
+	}
+	names := make([]string, 0, len(records[0].Attributes))
+	for _, attr := range records[0].Attributes {
+		names = append(names, attr.Name)
+	}
+	return names
+}
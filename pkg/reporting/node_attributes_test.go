@@ -0,0 +1,182 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNodeAttribute(t *testing.T) {
+	cases := map[string]subject.NodeAttribute{
+		"provider=cloud.provider":    subject.NodeAttribute{Name: "provider", Path: []string{"cloud", "provider"}},
+		" env = chef_environment ":   subject.NodeAttribute{Name: "env", Path: []string{"chef_environment"}},
+		"fqdn":                       subject.NodeAttribute{Name: "fqdn", Path: []string{"fqdn"}},
+		"kernel.release":             subject.NodeAttribute{Name: "kernel.release", Path: []string{"kernel", "release"}},
+		"a=b=c":                      subject.NodeAttribute{Name: "a", Path: []string{"b=c"}},
+		"Public IP=cloud.public_ips": subject.NodeAttribute{Name: "Public IP", Path: []string{"cloud", "public_ips"}},
+	}
+	for spec, expected := range cases {
+		attr, err := subject.ParseNodeAttribute(spec)
+		if assert.Nil(t, err, spec) {
+			assert.Equal(t, expected, attr, spec)
+		}
+	}
+
+	for _, spec := range []string{"", "=fqdn", "fqdn=", "ip=cloud..public_ips", "ip=.fqdn"} {
+		_, err := subject.ParseNodeAttribute(spec)
+		if assert.NotNil(t, err, spec) {
+			assert.Contains(t, err.Error(), "invalid attribute")
+		}
+	}
+}
+
+func TestParseNodeAttributes(t *testing.T) {
+	attributes, err := subject.ParseNodeAttributes([]string{
+		"env=chef_environment",
+		"fqdn",
+		"env=environment",
+	})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []subject.NodeAttribute{
+			subject.NodeAttribute{Name: "env", Path: []string{"environment"}},
+			subject.NodeAttribute{Name: "fqdn", Path: []string{"fqdn"}},
+		}, attributes)
+	}
+
+	_, err = subject.ParseNodeAttributes([]string{"fqdn", "=bad"})
+	assert.NotNil(t, err)
+}
+
+func TestLoadNodesReportConfig(t *testing.T) {
+	// the config.toml is optional
+	cfg, err := subject.LoadNodesReportConfig()
+	if assert.Nil(t, err) {
+		assert.Equal(t, subject.NodesReportConfig{}, cfg)
+	}
+
+	defer os.RemoveAll(createConfigToml(t))
+	configToml := filepath.Join(os.Getenv("HOME"), ".chef-workstation", "config.toml")
+	f, err := os.OpenFile(configToml, os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		panic(err)
+	}
+	_, err = f.WriteString(`
+[reports.nodes]
+attributes = ["env=chef_environment"]
+support_data = "/path/to/support.toml"
+`)
+	if err != nil {
+		panic(err)
+	}
+	f.Close()
+
+	cfg, err = subject.LoadNodesReportConfig()
+	if assert.Nil(t, err) {
+		assert.Equal(t, subject.NodesReportConfig{
+			Attributes:  []string{"env=chef_environment"},
+			SupportData: "/path/to/support.toml",
+		}, cfg)
+	}
+}
+
+func TestNodes_WithAttributes(t *testing.T) {
+	attributes, err := subject.ParseNodeAttributes([]string{
+		"env=chef_environment", "tags", "ips=cloud.public_ips", "cloud", "uptime=uptime_seconds", "virtual=virtualization.enabled",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	mocksearch := makeMockSearch(`[
+  {
+    "data" : {
+      "name" : "node1",
+      "attribute:env" : "production",
+      "attribute:tags" : [ "web", "frontend" ],
+      "attribute:ips" : [ "10.0.0.1", [ "10.0.0.2", "10.0.0.3" ] ],
+      "attribute:cloud" : { "provider" : "ec2", "local_ipv4" : "10.0.0.1", "zones" : [ "a", "b" ] },
+      "attribute:uptime" : 1234567,
+      "attribute:virtual" : true
+    }
+  },
+  {
+    "data" : {
+      "name" : "node2",
+      "attribute:env" : null
+    }
+  }
+]`, nil)
+
+	results, err := subject.GenerateNodesReport(&subject.ChefAnalyzeClient{Search: mocksearch}, "", 0, attributes, false, nil)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(results)) {
+		assert.Equal(t, []subject.NodeAttributeValue{
+			subject.NodeAttributeValue{Name: "env", Value: "production"},
+			subject.NodeAttributeValue{Name: "tags", Value: "web, frontend"},
+			subject.NodeAttributeValue{Name: "ips", Value: `10.0.0.1, ["10.0.0.2","10.0.0.3"]`},
+			subject.NodeAttributeValue{Name: "cloud", Value: `{"local_ipv4":"10.0.0.1","provider":"ec2","zones":["a","b"]}`},
+			subject.NodeAttributeValue{Name: "uptime", Value: "1234567"},
+			subject.NodeAttributeValue{Name: "virtual", Value: "true"},
+		}, results[0].Attributes)
+
+		// missing attributes are still reported, with an empty value
+		assert.Equal(t, []subject.NodeAttributeValue{
+			subject.NodeAttributeValue{Name: "env", Value: ""},
+			subject.NodeAttributeValue{Name: "tags", Value: ""},
+			subject.NodeAttributeValue{Name: "ips", Value: ""},
+			subject.NodeAttributeValue{Name: "cloud", Value: ""},
+			subject.NodeAttributeValue{Name: "uptime", Value: ""},
+			subject.NodeAttributeValue{Name: "virtual", Value: ""},
+		}, results[1].Attributes)
+
+		assert.Equal(t,
+			[]string{"env", "tags", "ips", "cloud", "uptime", "virtual"},
+			subject.NodeAttributeNames(results))
+	}
+
+	results, err = subject.GenerateNodesReport(&subject.ChefAnalyzeClient{Search: mocksearch}, "", 0, attributes, true, nil)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(results)) {
+		// values are hashed, empty values are kept empty
+		assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("production"))), results[0].Attributes[0].Value)
+		assert.Equal(t, "", results[1].Attributes[0].Value)
+	}
+
+	redactor, err := subject.NewRedactor(subject.RedactionRules{Keys: []string{"^chef_environment$"}, Values: []string{`^10\.`}})
+	assert.Nil(t, err)
+	results, err = subject.GenerateNodesReport(&subject.ChefAnalyzeClient{Search: mocksearch}, "", 0, attributes, false, redactor)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(results)) {
+		assert.Equal(t, subject.NodeAttributeValue{Name: "env", Value: subject.RedactedValue}, results[0].Attributes[0])
+		assert.Equal(t, subject.NodeAttributeValue{Name: "ips", Value: `**REDACTED**, ["**REDACTED**","**REDACTED**"]`}, results[0].Attributes[2])
+		assert.Equal(t,
+			subject.NodeAttributeValue{Name: "cloud", Value: `{"local_ipv4":"**REDACTED**","provider":"ec2","zones":["a","b"]}`},
+			results[0].Attributes[3])
+	}
+}
+
+func TestNodeAttributeNames_Empty(t *testing.T) {
+	assert.Equal(t, []string{}, subject.NodeAttributeNames(nil))
+	assert.Equal(t, []string{}, subject.NodeAttributeNames([]*subject.NodeReportItem{&subject.NodeReportItem{}}))
+}
@@ -0,0 +1,131 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NodeGroupFields are the node fields that nodes can be grouped by, the
+// name of any custom attribute added to the report is also accepted
+var NodeGroupFields = []string{"chef_version", "platform", "platform_version", "policy_group", "policy", "environment"}
+
+// NodeGroup is a set of nodes that share the same value of a field,
+// nested groups split the nodes of this group by the next field
+type NodeGroup struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+	Count int    `json:"count"`
+	// percentage (rounded to two decimals) of the nodes of the parent
+	// group, or the whole report for top level groups, in this group
+	Percentage float64      `json:"percentage"`
+	Groups     []*NodeGroup `json:"groups,omitempty"`
+}
+
+// ParseNodeGroupFields parses a comma separated list of fields, e.g. "platform,chef_version"
+func ParseNodeGroupFields(groupBy string) []string {
+	fields := make([]string, 0)
+	for _, field := range strings.Split(groupBy, ",") {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// GroupNodes aggregates the provided nodes by the list of fields, the first field
+// produces the top level groups and every following field splits those groups further.
+// Groups are sorted by the number of nodes (biggest first) and then by value.
+func GroupNodes(records []*NodeReportItem, fields []string) ([]*NodeGroup, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("at least one field is required to group nodes")
+	}
+
+	attributes := NodeAttributeNames(records)
+	for _, field := range fields {
+		if !containsString(NodeGroupFields, field) && !containsString(attributes, field) {
+			return nil, errors.Errorf(
+				"unable to group nodes by '%s', valid fields are %s or the name of a custom attribute",
+				field, strings.Join(NodeGroupFields, ", "),
+			)
+		}
+	}
+
+	return groupNodes(records, fields), nil
+}
+
+func groupNodes(records []*NodeReportItem, fields []string) []*NodeGroup {
+	var (
+		field   = fields[0]
+		byValue = make(map[string][]*NodeReportItem)
+	)
+	for _, record := range records {
+		value := nodeGroupValue(record, field)
+		byValue[value] = append(byValue[value], record)
+	}
+
+	groups := make([]*NodeGroup, 0, len(byValue))
+	for value, members := range byValue {
+		group := &NodeGroup{
+			Field:      field,
+			Value:      value,
+			Count:      len(members),
+			Percentage: math.Round(float64(len(members))*10000/float64(len(records))) / 100,
+		}
+		if len(fields) > 1 {
+			group.Groups = groupNodes(members, fields[1:])
+		}
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Value < groups[j].Value
+	})
+	return groups
+}
+
+func nodeGroupValue(record *NodeReportItem, field string) string {
+	switch field {
+	case "chef_version":
+		return record.ChefVersion
+	case "platform":
+		return record.OS
+	case "platform_version":
+		return record.OSVersionPretty()
+	case "policy_group":
+		return record.PolicyGroup
+	case "policy":
+		return record.Policy
+	case "environment":
+		return record.Environment
+	}
+
+	for _, attr := range record.Attributes {
+		if attr.Name == field {
+			return attr.Value
+		}
+	}
+	return ""
+}
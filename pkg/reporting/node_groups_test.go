@@ -0,0 +1,130 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"testing"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockedGroupNodes() []*subject.NodeReportItem {
+	nodes := []*subject.NodeReportItem{
+		&subject.NodeReportItem{Name: "n1", ChefVersion: "15.8", OS: "ubuntu", OSVersion: "18.04", Environment: "prod"},
+		&subject.NodeReportItem{Name: "n2", ChefVersion: "15.8", OS: "ubuntu", OSVersion: "16.04", Environment: "prod"},
+		&subject.NodeReportItem{Name: "n3", ChefVersion: "12.22", OS: "ubuntu", OSVersion: "16.04", Environment: "dev"},
+		&subject.NodeReportItem{Name: "n4", ChefVersion: "12.22", OS: "windows", OSVersion: "10", PolicyGroup: "prod", Policy: "web"},
+		&subject.NodeReportItem{Name: "n5", ChefVersion: "", OS: "", OSVersion: ""},
+	}
+	for _, node := range nodes {
+		node.Attributes = []subject.NodeAttributeValue{subject.NodeAttributeValue{Name: "provider"}}
+	}
+	nodes[4].Attributes[0].Value = "ec2"
+	return nodes
+}
+
+func TestParseNodeGroupFields(t *testing.T) {
+	assert.Equal(t, []string{"platform", "chef_version"}, subject.ParseNodeGroupFields("platform, chef_version,"))
+	assert.Equal(t, []string{"policy"}, subject.ParseNodeGroupFields("policy"))
+	assert.Equal(t, []string{}, subject.ParseNodeGroupFields(""))
+}
+
+func TestGroupNodes(t *testing.T) {
+	groups, err := subject.GroupNodes(mockedGroupNodes(), []string{"chef_version"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []*subject.NodeGroup{
+			&subject.NodeGroup{Field: "chef_version", Value: "12.22", Count: 2, Percentage: 40},
+			&subject.NodeGroup{Field: "chef_version", Value: "15.8", Count: 2, Percentage: 40},
+			&subject.NodeGroup{Field: "chef_version", Value: "", Count: 1, Percentage: 20},
+		}, groups)
+	}
+}
+
+func TestGroupNodes_Nested(t *testing.T) {
+	groups, err := subject.GroupNodes(mockedGroupNodes(), []string{"platform", "chef_version"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []*subject.NodeGroup{
+			&subject.NodeGroup{Field: "platform", Value: "ubuntu", Count: 3, Percentage: 60,
+				Groups: []*subject.NodeGroup{
+					&subject.NodeGroup{Field: "chef_version", Value: "15.8", Count: 2, Percentage: 66.67},
+					&subject.NodeGroup{Field: "chef_version", Value: "12.22", Count: 1, Percentage: 33.33},
+				},
+			},
+			&subject.NodeGroup{Field: "platform", Value: "", Count: 1, Percentage: 20,
+				Groups: []*subject.NodeGroup{
+					&subject.NodeGroup{Field: "chef_version", Value: "", Count: 1, Percentage: 100},
+				},
+			},
+			&subject.NodeGroup{Field: "platform", Value: "windows", Count: 1, Percentage: 20,
+				Groups: []*subject.NodeGroup{
+					&subject.NodeGroup{Field: "chef_version", Value: "12.22", Count: 1, Percentage: 100},
+				},
+			},
+		}, groups)
+	}
+}
+
+func TestGroupNodes_OtherFields(t *testing.T) {
+	groups, err := subject.GroupNodes(mockedGroupNodes(), []string{"platform_version"})
+	if assert.Nil(t, err) && assert.Equal(t, 4, len(groups)) {
+		assert.Equal(t, "ubuntu v16.04", groups[0].Value)
+		assert.Equal(t, 2, groups[0].Count)
+	}
+
+	groups, err = subject.GroupNodes(mockedGroupNodes(), []string{"environment"})
+	if assert.Nil(t, err) && assert.Equal(t, 3, len(groups)) {
+		// groups with the same number of nodes are sorted by value
+		assert.Equal(t, "", groups[0].Value)
+		assert.Equal(t, "prod", groups[1].Value)
+		assert.Equal(t, 2, groups[1].Count)
+	}
+
+	groups, err = subject.GroupNodes(mockedGroupNodes(), []string{"policy_group", "policy"})
+	if assert.Nil(t, err) && assert.Equal(t, 2, len(groups)) {
+		assert.Equal(t, "prod", groups[1].Value)
+		assert.Equal(t, "web", groups[1].Groups[0].Value)
+	}
+
+	// custom attributes can be used as well
+	groups, err = subject.GroupNodes(mockedGroupNodes(), []string{"provider"})
+	if assert.Nil(t, err) && assert.Equal(t, 2, len(groups)) {
+		assert.Equal(t, "", groups[0].Value)
+		assert.Equal(t, 4, groups[0].Count)
+		assert.Equal(t, "ec2", groups[1].Value)
+	}
+}
+
+func TestGroupNodes_Errors(t *testing.T) {
+	_, err := subject.GroupNodes(mockedGroupNodes(), []string{})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "at least one field is required to group nodes", err.Error())
+	}
+
+	_, err = subject.GroupNodes(mockedGroupNodes(), []string{"platform", "foo"})
+	if assert.NotNil(t, err) {
+		assert.Equal(t,
+			"unable to group nodes by 'foo', valid fields are chef_version, platform, platform_version, policy_group, policy, environment or the name of a custom attribute",
+			err.Error())
+	}
+}
+
+func TestGroupNodes_Empty(t *testing.T) {
+	groups, err := subject.GroupNodes([]*subject.NodeReportItem{}, []string{"platform"})
+	assert.Nil(t, err)
+	assert.Equal(t, []*subject.NodeGroup{}, groups)
+}
@@ -18,7 +18,9 @@ package reporting
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -69,11 +71,49 @@ type NodeReportItem struct {
 	ChefVersion      string
 	OS               string
 	OSVersion        string
+	Environment      string
 	CookbookVersions []CookbookVersion
 	PolicyGroup      string
 	Policy           string
 	PolicyRev        string
+	RunList          []string
+	LastCheckIn      time.Time // zero when the node has never converged
+	DaysSinceLastRun int
+	Stale            bool // last check-in is older than the --stale-after age
+	Attributes       []NodeAttributeValue
+	Support          NodeSupport
 	Anonymize        bool
+	// the node never converged and its CookbookVersions were resolved
+	// from its run list instead, ExpandError is set if that failed
+	CookbooksExpanded bool
+	ExpandError       error
+}
+
+// NeverConverged returns true if the node has never completed a chef-client run
+func (nri *NodeReportItem) NeverConverged() bool {
+	return nri.LastCheckIn.IsZero()
+}
+
+// LastCheckInPretty returns the last check-in as a human readable string
+func (nri *NodeReportItem) LastCheckInPretty() string {
+	if nri.NeverConverged() {
+		return "never converged"
+	}
+
+	var ago string
+	switch nri.DaysSinceLastRun {
+	case 0:
+		ago = "today"
+	case 1:
+		ago = "1 day ago"
+	default:
+		ago = fmt.Sprintf("%d days ago", nri.DaysSinceLastRun)
+	}
+
+	if nri.Stale {
+		return fmt.Sprintf("%s (%s, stale)", nri.LastCheckIn.UTC().Format(time.RFC3339), ago)
+	}
+	return fmt.Sprintf("%s (%s)", nri.LastCheckIn.UTC().Format(time.RFC3339), ago)
 }
 
 // OSVersionPretty looks nice
@@ -138,20 +178,38 @@ func (nri *NodeReportItem) CookbooksList() []string {
 	return cookbooks
 }
 
-// GenerateNodesReport generate a nodes report
-func GenerateNodesReport(client *ChefAnalyzeClient, filter string, anonymize bool) ([]*NodeReportItem, error) {
+// GenerateNodesReport generate a nodes report, nodes that haven't checked in
+// within staleAfter are flagged as stale (a zero staleAfter disables it) and
+// the provided custom attributes are added to every node of the report, masked
+// by the redactor when one is provided. The cookbooks of the nodes that never
+// converged, and don't have a policy, are resolved from their run list.
+func GenerateNodesReport(
+	client *ChefAnalyzeClient,
+	filter string,
+	staleAfter time.Duration,
+	attributes []NodeAttribute,
+	anonymize bool,
+	redactor *Redactor,
+) ([]*NodeReportItem, error) {
 	var (
+		now   = time.Now()
 		query = map[string]interface{}{
 			"name":            []string{"name"},
 			"chef_version":    []string{"chef_packages", "chef", "version"},
 			"os":              []string{"platform"},
 			"os_version":      []string{"platform_version"},
+			"environment":     []string{"chef_environment"},
 			"cookbooks":       []string{"cookbooks"},
 			"policy_group":    []string{"policy_group"},
 			"policy_name":     []string{"policy_name"},
 			"policy_revision": []string{"policy_revision"},
+			"ohai_time":       []string{"ohai_time"},
+			"run_list":        []string{"run_list"},
 		}
 	)
+	for _, attr := range attributes {
+		query[attr.searchKey()] = attr.Path
+	}
 	if filter == "" {
 		filter = "*:*"
 	}
@@ -164,7 +222,10 @@ func GenerateNodesReport(client *ChefAnalyzeClient, filter string, anonymize boo
 	// 	view all nodes in the result set, the actual returned number will be lower than
 	// 	the value of Rows.
 
-	results := make([]*NodeReportItem, 0, len(pres.Rows))
+	var (
+		results  = make([]*NodeReportItem, 0, len(pres.Rows))
+		expander = NewRunListExpander(client.Roles, client.Environments, client.Cookbooks)
+	)
 	for _, element := range pres.Rows {
 
 		// cookbook version arrives as [ NAME : { version: VERSION } - we extract that here.
@@ -175,10 +236,30 @@ func GenerateNodesReport(client *ChefAnalyzeClient, filter string, anonymize boo
 				Name:        safeStringFromMap(v, "name"),
 				OS:          safeStringFromMap(v, "os"),
 				OSVersion:   safeStringFromMap(v, "os_version"),
+				Environment: safeStringFromMap(v, "environment"),
 				ChefVersion: safeStringFromMap(v, "chef_version"),
 				PolicyGroup: safeStringFromMap(v, "policy_group"),
 				Policy:      safeStringFromMap(v, "policy_name"),
 				PolicyRev:   safeStringFromMap(v, "policy_revision"),
+				RunList:     safeStringSliceFromMap(v, "run_list"),
+			}
+
+			item.Attributes = make([]NodeAttributeValue, 0, len(attributes))
+			for _, attr := range attributes {
+				item.Attributes = append(item.Attributes, NodeAttributeValue{
+					Name:  attr.Name,
+					Value: serializeAttributeValue(redactor.RedactValue("nodes report", attr.Path, v[attr.searchKey()])),
+				})
+			}
+
+			// ohai_time is a float with the epoch of the last chef-client run
+			if ohaiTime, ok := v["ohai_time"].(float64); ok && ohaiTime > 0 {
+				sec, frac := int64(ohaiTime), ohaiTime-float64(int64(ohaiTime))
+				item.LastCheckIn = time.Unix(sec, int64(frac*float64(time.Second)))
+				item.DaysSinceLastRun = int(now.Sub(item.LastCheckIn).Hours() / 24)
+				if staleAfter > 0 && now.Sub(item.LastCheckIn) > staleAfter {
+					item.Stale = true
+				}
 			}
 
 			if anonymize {
@@ -186,10 +267,31 @@ func GenerateNodesReport(client *ChefAnalyzeClient, filter string, anonymize boo
 				item.Name = hashString(item.Name)
 				item.PolicyGroup = hashString(item.PolicyGroup)
 				item.Policy = hashString(item.Policy)
+				item.Environment = hashString(item.Environment)
+				for i, entry := range item.RunList {
+					item.RunList[i] = hashRunListItem(entry)
+				}
+				for i := range item.Attributes {
+					item.Attributes[i].Value = hashString(item.Attributes[i].Value)
+				}
 			}
 
-			if v["cookbooks"] != nil {
-				cookbooks := v["cookbooks"].(map[string]interface{})
+			cookbooks, _ := v["cookbooks"].(map[string]interface{})
+			if cookbooks == nil && item.NeverConverged() && item.Policy == "" && len(item.RunList) != 0 {
+				// the run list and environment of the item could be anonymized
+				expanded, err := expander.Expand(safeStringSliceFromMap(v, "run_list"), safeStringFromMap(v, "environment"))
+				switch {
+				case err != nil && anonymize:
+					// the error names the roles and cookbooks of the run list
+					item.ExpandError = errors.New("unable to resolve the cookbooks of the run list")
+				case err != nil:
+					item.ExpandError = errors.Wrap(err, "unable to resolve the cookbooks of the run list")
+				default:
+					cookbooks = expanded.AsCookbooksAttribute()
+					item.CookbooksExpanded = true
+				}
+			}
+			if cookbooks != nil {
 				item.CookbookVersions = make([]CookbookVersion, 0, len(cookbooks))
 				for k, v := range cookbooks {
 					cbv := CookbookVersion{Name: k, Version: safeStringFromMap(v.(map[string]interface{}), "version")}
@@ -207,6 +309,50 @@ func GenerateNodesReport(client *ChefAnalyzeClient, filter string, anonymize boo
 	return results, nil
 }
 
+// StaleNodes returns only the nodes that are stale or have never converged
+func StaleNodes(records []*NodeReportItem) []*NodeReportItem {
+	stale := make([]*NodeReportItem, 0)
+	for _, record := range records {
+		if record.Stale || record.NeverConverged() {
+			stale = append(stale, record)
+		}
+	}
+	return stale
+}
+
+// ParseAge parses an age like "7d", "2w" or any duration understood by
+// time.ParseDuration ("36h", "90m", etc.)
+func ParseAge(age string) (time.Duration, error) {
+	var unit time.Duration
+	switch {
+	case strings.HasSuffix(age, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(age, "w"):
+		unit = 7 * 24 * time.Hour
+	default:
+		d, err := time.ParseDuration(age)
+		if err != nil || d < 0 {
+			return 0, errors.Errorf("invalid age '%s', use a value like 7d, 2w or 36h", age)
+		}
+		return d, nil
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(age[0 : len(age)-1]))
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("invalid age '%s', use a value like 7d, 2w or 36h", age)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// hashes the name of a run list item but keeps its type, "recipe[foo]" => "recipe[HASH]"
+func hashRunListItem(entry string) string {
+	start, end := strings.Index(entry, "["), strings.LastIndex(entry, "]")
+	if start == -1 || end < start {
+		return hashString(entry)
+	}
+	return fmt.Sprintf("%s[%s]", entry[0:start], hashString(entry[start+1:end]))
+}
+
 // This returns the value referenced by `key` in `values`. If value is nil,
 // it returns an empty string; otherwise it returns the original string.
 // Will probably be more generally useful exposed as public in the reporting package.
@@ -216,3 +362,18 @@ func safeStringFromMap(values map[string]interface{}, key string) string {
 	}
 	return values[key].(string)
 }
+
+// returns the list of strings referenced by `key` in `values`, non-string items are ignored
+func safeStringSliceFromMap(values map[string]interface{}, key string) []string {
+	list, ok := values[key].([]interface{})
+	if !ok {
+		return nil
+	}
+	result := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -17,10 +17,13 @@
 package reporting_test
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"testing"
+	"time"
 
 	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/go-chef/chef"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -28,7 +31,7 @@ func TestNodes(t *testing.T) {
 	// It's a little less verbose and a little more readable to format
 	// this as JSON then convert it where we need it than to create it as a golang map.
 	mocksearch := makeMockSearch(mockedNodesSearchRows(), nil)
-	results, err := subject.GenerateNodesReport(&subject.ChefAnalyzeClient{Search: mocksearch}, "", false)
+	results, err := subject.GenerateNodesReport(&subject.ChefAnalyzeClient{Search: mocksearch}, "", 0, nil, false, nil)
 	// valid results don't mock an error.
 	assert.Nil(t, err)
 
@@ -70,7 +73,7 @@ func TestNodes(t *testing.T) {
 func TestNodes_Anon(t *testing.T) {
 
 	mocksearch := makeMockSearch(mockedNodesSearchRows(), nil)
-	results, err := subject.GenerateNodesReport(&subject.ChefAnalyzeClient{Search: mocksearch}, "", true) //anonymize
+	results, err := subject.GenerateNodesReport(&subject.ChefAnalyzeClient{Search: mocksearch}, "", 0, nil, true, nil) //anonymize
 
 	// valid results don't mock an error.
 	assert.Nil(t, err)
@@ -82,10 +85,153 @@ func TestNodes_Anon(t *testing.T) {
 
 }
 
+func TestNodes_LastCheckIn(t *testing.T) {
+	var (
+		now        = time.Now()
+		recentRun  = now.Add(-36 * time.Hour)
+		oldRun     = now.Add(-10 * 24 * time.Hour)
+		mocksearch = makeMockSearch(fmt.Sprintf(`[
+  { "data" : { "name" : "recent", "ohai_time" : %d.25, "run_list" : [ "role[base]", "recipe[web]" ] } },
+  { "data" : { "name" : "old", "ohai_time" : %d, "run_list" : [ "recipe[web]" ] } },
+  { "data" : { "name" : "never", "ohai_time" : null, "run_list" : [] } }
+]`, recentRun.Unix(), oldRun.Unix()), nil)
+	)
+
+	results, err := subject.GenerateNodesReport(&subject.ChefAnalyzeClient{Search: mocksearch}, "", 7*24*time.Hour, nil, false, nil)
+	assert.Nil(t, err)
+	if assert.Equal(t, 3, len(results)) {
+		recent, old, never := results[0], results[1], results[2]
+
+		assert.Equal(t, recentRun.Unix(), recent.LastCheckIn.Unix())
+		assert.Equal(t, 1, recent.DaysSinceLastRun)
+		assert.False(t, recent.Stale)
+		assert.False(t, recent.NeverConverged())
+		assert.Equal(t, []string{"role[base]", "recipe[web]"}, recent.RunList)
+
+		assert.Equal(t, 10, old.DaysSinceLastRun)
+		assert.True(t, old.Stale)
+		assert.False(t, old.NeverConverged())
+
+		assert.True(t, never.NeverConverged())
+		assert.False(t, never.Stale)
+		assert.Equal(t, []string{}, never.RunList)
+
+		assert.Equal(t, []*subject.NodeReportItem{old, never}, subject.StaleNodes(results))
+	}
+
+	// without a stale age no node is flagged as stale
+	results, err = subject.GenerateNodesReport(&subject.ChefAnalyzeClient{Search: mocksearch}, "", 0, nil, true, nil)
+	assert.Nil(t, err)
+	if assert.Equal(t, 3, len(results)) {
+		assert.False(t, results[1].Stale)
+		// the run list item names are anonymized but we keep their type
+		assert.Equal(t,
+			[]string{fmt.Sprintf("recipe[%x]", sha256.Sum256([]byte("web")))},
+			results[1].RunList)
+		assert.Equal(t, []*subject.NodeReportItem{results[2]}, subject.StaleNodes(results))
+	}
+}
+
+func TestNodes_NeverConverged(t *testing.T) {
+	var (
+		mocksearch = makeMockSearch(`[
+  { "data" : { "name" : "new", "ohai_time" : null, "environment" : "_default", "run_list" : [ "role[base]", "recipe[web]" ] } },
+  { "data" : { "name" : "broken", "ohai_time" : null, "environment" : "_default", "run_list" : [ "role[missing]" ] } },
+  { "data" : { "name" : "policy", "ohai_time" : null, "policy_name" : "p", "policy_group" : "g", "run_list" : [ "recipe[web]" ] } },
+  { "data" : { "name" : "empty", "ohai_time" : null, "run_list" : [] } }
+]`, nil)
+		client = &subject.ChefAnalyzeClient{
+			Search:       mocksearch,
+			Roles:        mockedExpanderRoles(),
+			Environments: &EnvMock{Env: &chef.Environment{Name: "_default"}},
+			Cookbooks:    mockedExpanderCookbooks(),
+		}
+	)
+
+	results, err := subject.GenerateNodesReport(client, "", 0, nil, false, nil)
+	assert.Nil(t, err)
+	if assert.Equal(t, 4, len(results)) {
+		expanded, broken, policy, empty := results[0], results[1], results[2], results[3]
+
+		assert.True(t, expanded.CookbooksExpanded)
+		assert.Nil(t, expanded.ExpandError)
+		equalsCookbookVersionsArray(t, []subject.CookbookVersion{
+			subject.CookbookVersion{Name: "apache2", Version: "4.1.0"},
+			subject.CookbookVersion{Name: "base", Version: "1.1.0"},
+			subject.CookbookVersion{Name: "web", Version: "0.2.0"},
+		}, expanded.CookbookVersions)
+
+		assert.False(t, broken.CookbooksExpanded)
+		if assert.NotNil(t, broken.ExpandError) {
+			assert.Contains(t, broken.ExpandError.Error(), "unable to resolve the cookbooks of the run list")
+		}
+		assert.Nil(t, broken.CookbookVersions)
+
+		// policies don't use the run list to pick their cookbooks
+		assert.False(t, policy.CookbooksExpanded)
+		assert.Nil(t, policy.ExpandError)
+		assert.Nil(t, policy.CookbookVersions)
+
+		assert.False(t, empty.CookbooksExpanded)
+		assert.Nil(t, empty.CookbookVersions)
+	}
+
+	// the names of the run list are kept out of the anonymized errors
+	results, err = subject.GenerateNodesReport(client, "", 0, nil, true, nil)
+	assert.Nil(t, err)
+	if assert.Equal(t, 4, len(results)) && assert.NotNil(t, results[1].ExpandError) {
+		assert.Equal(t, "unable to resolve the cookbooks of the run list", results[1].ExpandError.Error())
+		assert.True(t, results[0].CookbooksExpanded)
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	cases := map[string]time.Duration{
+		"7d":  7 * 24 * time.Hour,
+		"0d":  0,
+		"2w":  14 * 24 * time.Hour,
+		"36h": 36 * time.Hour,
+		"90m": 90 * time.Minute,
+	}
+	for age, expected := range cases {
+		d, err := subject.ParseAge(age)
+		if assert.Nil(t, err, age) {
+			assert.Equal(t, expected, d, age)
+		}
+	}
+
+	for _, age := range []string{"", "d", "seven days", "-1d", "-5h", "7x"} {
+		_, err := subject.ParseAge(age)
+		if assert.NotNil(t, err, age) {
+			assert.Contains(t, err.Error(), "invalid age")
+		}
+	}
+}
+
+func TestNodeReportItemLastCheckInPretty(t *testing.T) {
+	lastRun := time.Date(2020, 4, 1, 10, 30, 0, 0, time.UTC)
+	cases := []struct {
+		expected string
+		report   subject.NodeReportItem
+	}{
+		{expected: "never converged",
+			report: subject.NodeReportItem{}},
+		{expected: "2020-04-01T10:30:00Z (today)",
+			report: subject.NodeReportItem{LastCheckIn: lastRun}},
+		{expected: "2020-04-01T10:30:00Z (1 day ago)",
+			report: subject.NodeReportItem{LastCheckIn: lastRun, DaysSinceLastRun: 1}},
+		{expected: "2020-04-01T10:30:00Z (30 days ago, stale)",
+			report: subject.NodeReportItem{LastCheckIn: lastRun, DaysSinceLastRun: 30, Stale: true}},
+	}
+	for _, kase := range cases {
+		assert.Equal(t, kase.expected, kase.report.LastCheckInPretty())
+	}
+}
+
 func TestErrorResult(t *testing.T) {
 	expectedError := fmt.Errorf("error here")
 	mocksearch := makeMockSearch("", expectedError)
-	report, err := subject.GenerateNodesReport(&subject.ChefAnalyzeClient{Search: mocksearch}, "", false)
+	report, err := subject.GenerateNodesReport(&subject.ChefAnalyzeClient{Search: mocksearch}, "", 0, nil, false, nil)
 	if assert.NotNil(t, err) {
 		assert.Equal(t, "unable to get node(s) information: error here", err.Error())
 		assert.Nil(t, report)
@@ -18,9 +18,13 @@
 package reporting
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 
 	"github.com/go-chef/chef"
 	"github.com/pkg/errors"
@@ -28,6 +32,13 @@ import (
 
 type ObjectWriter struct {
 	RootDir string
+	// files relative to RootDir that must not be overwritten
+	Protected map[string]bool
+	// masks the secrets of the objects and files before they are written
+	Redactor *Redactor
+
+	mutex   sync.Mutex
+	written map[string]string
 }
 
 type ObjectWriterInterface interface {
@@ -41,6 +52,11 @@ type ObjectWriterInterface interface {
 	WriteDataBagItem(bagName string, itemName string, item interface{}) error
 
 	WriteContent(string, []byte) error
+	WriteJSON(objGroupingName string, objName string, object interface{}) error
+
+	// masks a value, decoded from JSON, that is written as part of a file that
+	// is not JSON, where only the value rules of the Redactor apply otherwise
+	RedactValue(file string, path []string, value interface{}) interface{}
 }
 
 // Takes a chef.Role and saves it as json in RootDir/roles
@@ -76,30 +92,95 @@ func (ow *ObjectWriter) WriteDataBagItem(bagName string, itemName string, item i
 	return ow.WriteJSON(fmt.Sprintf("data_bags/%s", bagName), itemName, item)
 }
 
+// Written returns the files written so far, relative to RootDir,
+// and the sha256 of their content
+func (ow *ObjectWriter) Written() map[string]string {
+	ow.mutex.Lock()
+	defer ow.mutex.Unlock()
+
+	written := make(map[string]string, len(ow.written))
+	for file, sum := range ow.written {
+		written[file] = sum
+	}
+	return written
+}
+
+func (ow *ObjectWriter) recordWritten(fileName string, content []byte) {
+	ow.mutex.Lock()
+	defer ow.mutex.Unlock()
+
+	if ow.written == nil {
+		ow.written = make(map[string]string)
+	}
+	ow.written[fileName] = fmt.Sprintf("%x", sha256.Sum256(content))
+}
+
 // Writes "content" to RootDir/"fileName"
 func (ow *ObjectWriter) WriteContent(fileName string, content []byte) error {
+	if ow.Protected[fileName] {
+		return nil
+	}
+	return ow.writeFile(fileName, ow.Redactor.RedactText(fileName, content))
+}
+
+// RedactValue masks the value with the Redactor, see ObjectWriterInterface
+func (ow *ObjectWriter) RedactValue(file string, path []string, value interface{}) interface{} {
+	return ow.Redactor.RedactValue(file, path, value)
+}
+
+// WriteRedactionLog writes the values masked by the Redactor to RootDir/redaction-log.json,
+// nothing is written when there is no Redactor
+func (ow *ObjectWriter) WriteRedactionLog() error {
+	if ow.Redactor == nil {
+		return nil
+	}
+	content, err := json.MarshalIndent(ow.Redactor.Redactions(), "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "unable to generate redaction log")
+	}
+	return ow.writeFile(RedactionLogFile, append(content, '\n'))
+}
+
+func (ow *ObjectWriter) writeFile(fileName string, content []byte) error {
+	// files in subdirectories of the repository create them as needed
+	if dir := filepath.Dir(fileName); dir != "." {
+		dirName := fmt.Sprintf("%s/%s", ow.RootDir, dir)
+		if err := os.MkdirAll(dirName, 0700); err != nil {
+			return errors.Wrapf(err, "Failed to create directory %s", dirName)
+		}
+	}
 	path := fmt.Sprintf("%s/%s", ow.RootDir, fileName)
 	f, err := os.Create(path)
 	if err != nil {
 		return errors.Wrapf(err, "failed to create %s", path)
 	}
+	defer f.Close()
 	_, err = f.Write(content)
 	if err != nil {
 		return errors.Wrapf(err, "failed to write to %s", path)
 	}
+	ow.recordWritten(fileName, content)
 	return nil
 }
 
 func (ow *ObjectWriter) WriteJSON(objGroupingName string, objName string, object interface{}) error {
 	var err error
 	dirName := fmt.Sprintf("%s/%s", ow.RootDir, objGroupingName)
-	path := fmt.Sprintf("%s/%s/%s.json", ow.RootDir, objGroupingName, objName)
+	fileName := fmt.Sprintf("%s/%s.json", objGroupingName, objName)
+	if ow.Protected[fileName] {
+		return nil
+	}
+	path := fmt.Sprintf("%s/%s", ow.RootDir, fileName)
 	if _, err := os.Stat(dirName); os.IsNotExist(err) {
 		err = os.MkdirAll(dirName, 0700)
 	}
 	if err != nil {
 		return errors.Wrapf(err, "Failed to create directory %s", dirName)
 	}
+	object, err = ow.Redactor.RedactObject(fileName, object)
+	if err != nil {
+		return err
+	}
 	body, err := chef.JSONReader(object)
 	if err != nil {
 		return errors.Wrapf(err, "Failed to convert %s to json. If you see this, it's a bug.  Please report it.", objName)
@@ -114,5 +195,6 @@ func (ow *ObjectWriter) WriteJSON(objGroupingName string, objName string, object
 	if err != nil {
 		return errors.Wrapf(err, "Failed to save file %s", path)
 	}
+	ow.recordWritten(fileName, content)
 	return nil
 }
@@ -0,0 +1,284 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+)
+
+// PolicyfileMigration is the translation of a node managed by roles and
+// environments into a Policyfile, along with what could not be translated
+type PolicyfileMigration struct {
+	Name        string
+	Environment string
+	Roles       []string
+	RunList     []string
+	Cookbooks   []NodeCookbook
+	// role and environment attributes flattened by precedence
+	Default  map[string]interface{}
+	Override map[string]interface{}
+	// anything that could not be translated and needs to be reviewed
+	Notes []string
+}
+
+const policyfileTmpl = `# Policyfile generated by chef-analyze capture from node {{quote .Name}}
+# (environment {{quote .Environment}}{{if .Roles}}, roles {{join .Roles}}{{end}}), see
+# {{.Name}}-migration.md for what could not be translated.
+#
+# The cookbooks are sourced from the captured repository, replace the
+# source with your Supermarket or Chef Infra Server once verified.
+name {{quote .Name}}
+
+default_source :chef_repo, '..'
+
+run_list {{join .RunList}}
+{{range .Cookbooks}}
+cookbook {{quote .Name}}, {{quote (print "= " .Version)}}
+{{- end}}
+{{- range $key, $value := .Default}}
+default[{{quote $key}}] = {{ruby $value}}
+{{- end}}
+{{- range $key, $value := .Override}}
+override[{{quote $key}}] = {{ruby $value}}
+{{- end}}
+`
+
+const policyfileReportTmpl = `# Policyfile migration of node '{{.Name}}'
+
+policyfiles/{{.Name}}.rb was generated from the run list, roles and environment
+of the node. It pins the {{len .Cookbooks}} cookbook(s) the node ran and flattens the
+attributes of {{len .Roles}} role(s) and the environment '{{.Environment}}' into
+{{len .Default}} default and {{len .Override}} override top level attribute(s).
+
+## Could not be translated
+{{if .Notes}}{{range .Notes}}
+- {{.}}
+{{- end}}{{else}}
+Everything was translated.
+{{- end}}
+
+## Review
+
+- Cookbooks that search nodes by role or environment (e.g. ` + "`search(:node, 'role:web')`" + `),
+  or that use ` + "`node.roles`" + `, ` + "`node.role?`" + ` or ` + "`node.chef_environment`" + `, must use
+  ` + "`policy_name`" + ` and ` + "`policy_group`" + ` instead.
+- Push the policy to a policy group with ` + "`chef push GROUP policyfiles/{{.Name}}.rb`" + `
+  and set ` + "`policy_name`" + ` and ` + "`policy_group`" + ` on the node.
+`
+
+// NewPolicyfileMigration translates a node, its expanded run list, the cookbooks
+// it ran and its roles and environment into a Policyfile migration. Role attributes
+// are merged in run list order, then the environment default attributes go below
+// the role ones and the environment override attributes above, as the client does.
+func NewPolicyfileMigration(node *chef.Node, expanded *ExpandedRunList, cookbooks []NodeCookbook,
+	roles []*chef.Role, env *chef.Environment) *PolicyfileMigration {
+	migration := &PolicyfileMigration{
+		Name:        node.Name,
+		Environment: expanded.Environment,
+		Roles:       expanded.Roles,
+		RunList:     make([]string, 0, len(expanded.Recipes)),
+		Cookbooks:   append([]NodeCookbook{}, cookbooks...),
+		Default:     make(map[string]interface{}),
+		Override:    make(map[string]interface{}),
+		Notes:       make([]string, 0),
+	}
+	for _, recipe := range expanded.Recipes {
+		migration.RunList = append(migration.RunList, qualifiedRecipe(recipe))
+	}
+	sort.Slice(migration.Cookbooks, func(i, j int) bool {
+		return migration.Cookbooks[i].Name < migration.Cookbooks[j].Name
+	})
+
+	if env != nil {
+		mergeAttributes(migration.Default, attributesMap(env.DefaultAttributes))
+	}
+	for _, role := range roles {
+		mergeAttributes(migration.Default, attributesMap(role.DefaultAttributes))
+		mergeAttributes(migration.Override, attributesMap(role.OverrideAttributes))
+
+		others := make([]string, 0)
+		for environment := range role.EnvRunList {
+			if environment != expanded.Environment {
+				others = append(others, environment)
+			}
+		}
+		if len(others) > 0 {
+			sort.Strings(others)
+			migration.Notes = append(migration.Notes, fmt.Sprintf(
+				"role '%s' has run lists for the environments %s, only the run list of '%s' was translated, "+
+					"nodes in other environments need a Policyfile of their own",
+				role.Name, strings.Join(others, ", "), expanded.Environment,
+			))
+		}
+	}
+	if env != nil {
+		mergeAttributes(migration.Override, attributesMap(env.OverrideAttributes))
+
+		if len(env.CookbookVersions) > 0 {
+			constraints := make([]string, 0, len(env.CookbookVersions))
+			for name, constraint := range env.CookbookVersions {
+				constraints = append(constraints, fmt.Sprintf("%s %s", name, constraint))
+			}
+			sort.Strings(constraints)
+			migration.Notes = append(migration.Notes, fmt.Sprintf(
+				"environment '%s' constrains the cookbooks %s, the Policyfile pins the versions the node ran instead",
+				env.Name, strings.Join(constraints, ", "),
+			))
+		}
+	}
+
+	if len(node.NormalAttributes) > 0 {
+		names := make([]string, 0, len(node.NormalAttributes))
+		for name := range node.NormalAttributes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		migration.Notes = append(migration.Notes, fmt.Sprintf(
+			"the node has normal attributes (%s), Policyfiles can't set them, move them "+
+				"to the Policyfile attributes or to a cookbook",
+			strings.Join(names, ", "),
+		))
+	}
+	if len(migration.RunList) == 0 {
+		migration.Notes = append(migration.Notes, "the run list of the node is empty, the Policyfile has no run list")
+	}
+
+	return migration
+}
+
+// SavePolicyfile translates a node managed by roles and environments into a
+// Policyfile, writes it to policyfiles/NODE.rb and what could not be
+// translated to policyfiles/NODE-migration.md
+func (nc *NodeCapturer) SavePolicyfile(node *chef.Node, expanded *ExpandedRunList, cookbooks []NodeCookbook) error {
+	roles := make([]*chef.Role, 0, len(expanded.Roles))
+	for _, name := range expanded.Roles {
+		role, err := nc.roles.Get(name)
+		if err != nil {
+			return errors.Wrapf(err, "unable to retrieve role '%s'", name)
+		}
+		if role != nil {
+			roles = append(roles, role)
+		}
+	}
+	env, err := nc.env.Get(expanded.Environment)
+	if err != nil {
+		return errors.Wrapf(err, "unable to retrieve environment '%s'", expanded.Environment)
+	}
+
+	migration := NewPolicyfileMigration(node, expanded, cookbooks, roles, env)
+	policyfile := fmt.Sprintf("policyfiles/%s.rb", node.Name)
+	migration.Default = nc.redactAttributes(policyfile, migration.Default)
+	migration.Override = nc.redactAttributes(policyfile, migration.Override)
+	for _, file := range []struct {
+		name string
+		tmpl string
+	}{
+		{policyfile, policyfileTmpl},
+		{fmt.Sprintf("policyfiles/%s-migration.md", node.Name), policyfileReportTmpl},
+	} {
+		content, err := renderRubyTemplate(file.name, file.tmpl, migration)
+		if err != nil {
+			return err
+		}
+		if err := nc.writer.WriteContent(file.name, content); err != nil {
+			return errors.Wrapf(err, "unable to save %s", file.name)
+		}
+	}
+	return nil
+}
+
+// renders a template that writes ruby, quote writes a string as a ruby string,
+// ruby any value decoded from JSON as a ruby literal and join a list of strings
+// as comma separated ruby strings
+func renderRubyTemplate(name, text string, data interface{}) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(template.FuncMap{
+		"quote": rubyQuote,
+		"ruby":  func(value interface{}) string { return rubyLiteral(value, "") },
+		"join": func(items []string) string {
+			quoted := make([]string, 0, len(items))
+			for _, item := range items {
+				quoted = append(quoted, rubyQuote(item))
+			}
+			return strings.Join(quoted, ", ")
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create new template")
+	}
+
+	var content bytes.Buffer
+	if err := tmpl.Execute(&content, data); err != nil {
+		return nil, errors.Wrap(err, "failed to execute template")
+	}
+	return content.Bytes(), nil
+}
+
+// returns the attributes of a role or environment as a map, they are
+// decoded from JSON so anything else means there are no attributes
+func attributesMap(attributes interface{}) map[string]interface{} {
+	if m, ok := attributes.(map[string]interface{}); ok {
+		return m
+	}
+	return map[string]interface{}{}
+}
+
+// returns the value, decoded from JSON, as a ruby literal, hashes
+// are written one key per line with the provided indentation
+func rubyLiteral(value interface{}, indent string) string {
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case string:
+		return rubyQuote(v)
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, rubyLiteral(item, indent))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return "{}"
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		lines := make([]string, 0, len(v))
+		for _, key := range keys {
+			lines = append(lines, fmt.Sprintf("%s  %s => %s", indent, rubyQuote(key), rubyLiteral(v[key], indent+"  ")))
+		}
+		return "{\n" + strings.Join(lines, ",\n") + "\n" + indent + "}"
+	}
+	return rubyQuote(fmt.Sprintf("%v", value))
+}
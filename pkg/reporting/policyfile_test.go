@@ -0,0 +1,184 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func migrationRoles() map[string]*chef.Role {
+	return map[string]*chef.Role{
+		"base": &chef.Role{
+			Name:              "base",
+			RunList:           []string{"recipe[ntp]"},
+			DefaultAttributes: map[string]interface{}{"web": map[string]interface{}{"port": 80.0, "user": "www"}},
+		},
+		"web": &chef.Role{
+			Name:               "web",
+			RunList:            []string{"recipe[web]"},
+			DefaultAttributes:  map[string]interface{}{"web": map[string]interface{}{"port": 8080.0}},
+			OverrideAttributes: map[string]interface{}{"ntp": map[string]interface{}{"servers": []interface{}{"a", "b"}}},
+			EnvRunList:         chef.EnvRunList{"staging": []string{"recipe[web::staging]"}},
+		},
+	}
+}
+
+func migrationEnvironment() *chef.Environment {
+	return &chef.Environment{
+		Name:               "prod",
+		DefaultAttributes:  map[string]interface{}{"web": map[string]interface{}{"port": 443.0, "tls": true}},
+		OverrideAttributes: map[string]interface{}{"ntp": map[string]interface{}{"servers": []interface{}{"prod"}}},
+		CookbookVersions:   map[string]string{"web": "~> 2.0"},
+	}
+}
+
+func TestNewPolicyfileMigration(t *testing.T) {
+	roles := migrationRoles()
+	expanded := &subject.ExpandedRunList{
+		Environment: "prod",
+		Roles:       []string{"base", "web"},
+		Recipes:     []string{"ntp", "web::server"},
+	}
+	migration := subject.NewPolicyfileMigration(
+		nodeWithAttributes(), expanded,
+		[]subject.NodeCookbook{{"web", "2.1.0"}, {"ntp", "1.0.0"}},
+		[]*chef.Role{roles["base"], roles["web"]}, migrationEnvironment(),
+	)
+
+	assert.Equal(t, "node1", migration.Name)
+	assert.Equal(t, []string{"ntp::default", "web::server"}, migration.RunList)
+	assert.Equal(t, []subject.NodeCookbook{{"ntp", "1.0.0"}, {"web", "2.1.0"}}, migration.Cookbooks)
+	// role default attributes win over the environment ones, the
+	// environment override attributes win over the role ones
+	assert.Equal(t, map[string]interface{}{
+		"web": map[string]interface{}{"port": 8080.0, "user": "www", "tls": true},
+	}, migration.Default)
+	assert.Equal(t, map[string]interface{}{
+		"ntp": map[string]interface{}{"servers": []interface{}{"prod"}},
+	}, migration.Override)
+
+	if assert.Len(t, migration.Notes, 3) {
+		assert.Contains(t, migration.Notes[0], "role 'web' has run lists for the environments staging")
+		assert.Contains(t, migration.Notes[1], "environment 'prod' constrains the cookbooks web ~> 2.0")
+		assert.Contains(t, migration.Notes[2], "the node has normal attributes (db, web)")
+	}
+}
+
+func TestCapturer_SavePolicyfile(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	nc := subject.NewNodeCapturer(
+		NodeMock{},
+		RoleMock{Roles: migrationRoles()},
+		EnvMock{Env: migrationEnvironment()},
+		CookbookMock{},
+		DataBagMock{},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		&subject.ObjectWriter{RootDir: baseDir},
+	)
+	expanded := &subject.ExpandedRunList{
+		Environment: "prod",
+		Roles:       []string{"base", "web"},
+		Recipes:     []string{"ntp", "web::server"},
+	}
+	err = nc.SavePolicyfile(nodeWithAttributes(), expanded, []subject.NodeCookbook{{"web", "2.1.0"}, {"ntp", "1.0.0"}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	policyfile, err := ioutil.ReadFile(filepath.Join(baseDir, "policyfiles", "node1.rb"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, `# Policyfile generated by chef-analyze capture from node 'node1'
+# (environment 'prod', roles 'base', 'web'), see
+# node1-migration.md for what could not be translated.
+#
+# The cookbooks are sourced from the captured repository, replace the
+# source with your Supermarket or Chef Infra Server once verified.
+name 'node1'
+
+default_source :chef_repo, '..'
+
+run_list 'ntp::default', 'web::server'
+
+cookbook 'ntp', '= 1.0.0'
+cookbook 'web', '= 2.1.0'
+default['web'] = {
+  'port' => 8080,
+  'tls' => true,
+  'user' => 'www'
+}
+override['ntp'] = {
+  'servers' => ['prod']
+}
+`, string(policyfile))
+	}
+
+	report, err := ioutil.ReadFile(filepath.Join(baseDir, "policyfiles", "node1-migration.md"))
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(report), "It pins the 2 cookbook(s) the node ran")
+		assert.Contains(t, string(report), "\n- role 'web' has run lists for the environments staging")
+		assert.Contains(t, string(report), "chef push GROUP policyfiles/node1.rb")
+	}
+}
+
+func TestCapturer_SavePolicyfileWithMissingRole(t *testing.T) {
+	nc := subject.NewNodeCapturer(
+		NodeMock{},
+		RoleMock{Roles: map[string]*chef.Role{}},
+		EnvMock{},
+		CookbookMock{},
+		DataBagMock{},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		&ObjectWriterMock{},
+	)
+	err := nc.SavePolicyfile(nodeWithAttributes(), &subject.ExpandedRunList{Roles: []string{"web"}}, nil)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to retrieve role 'web'")
+	}
+
+	nc = subject.NewNodeCapturer(
+		NodeMock{},
+		RoleMock{},
+		EnvMock{},
+		CookbookMock{},
+		DataBagMock{},
+		PolicyGroupMock{},
+		PolicyMock{},
+		CBAMock{},
+		&ObjectWriterMock{Error: errors.New("disk full")},
+	)
+	err = nc.SavePolicyfile(nodeWithAttributes(), &subject.ExpandedRunList{}, nil)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to save policyfiles/node1.rb")
+	}
+}
@@ -0,0 +1,237 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// RedactionLogFile is the name of the file, at the root of a captured
+// repository, that lists the values that were redacted
+const RedactionLogFile = "redaction-log.json"
+
+// RedactionRules are the regular expressions of a Redactor, values whose key matches
+// one of the Keys and string values that match one of the Values are redacted, they
+// can be extended in the config.toml:
+//
+//	[redaction]
+//	keys = ["(?i)license"]
+//	values = ["^sk_live_"]
+type RedactionRules struct {
+	Keys   []string `toml:"keys"`
+	Values []string `toml:"values"`
+}
+
+// RedactedValue replaces the values masked by a Redactor
+const RedactedValue = "**REDACTED**"
+
+// the names of the keys that look like they hold a secret
+var secretAttributeName = regexp.MustCompile(`(?i)(password|passwd|passphrase|secret|token|private_?key|api_?key|credential)`)
+
+// DefaultRedactionRules redact the keys that look like they hold a secret
+// and values that look like private keys or well known access tokens
+var DefaultRedactionRules = RedactionRules{
+	Keys: []string{secretAttributeName.String()},
+	Values: []string{
+		`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+		`\bAKIA[0-9A-Z]{16}\b`,              // AWS access key IDs
+		`\bgh[pousr]_[A-Za-z0-9]{36,}\b`,    // GitHub tokens
+		`\bxox[aboprs]-[A-Za-z0-9-]{10,}\b`, // Slack tokens
+	},
+}
+
+// Redaction is a value that a Redactor masked
+type Redaction struct {
+	// file or report the value was masked in
+	File string `json:"file"`
+	// dot separated path of the value, empty for text files
+	Path string `json:"path,omitempty"`
+	// the key or value rule that matched
+	Rule string `json:"rule"`
+}
+
+// Redactor masks the values of captured objects and reports that match its rules
+// and keeps a log of what it masked, a nil Redactor doesn't mask anything
+type Redactor struct {
+	keys   []*regexp.Regexp
+	values []*regexp.Regexp
+
+	mutex      sync.Mutex
+	redactions []Redaction
+}
+
+// LoadRedactionRules returns the DefaultRedactionRules extended with the
+// rules of the [redaction] section of the user's config.toml
+func LoadRedactionRules() (RedactionRules, error) {
+	rules := RedactionRules{
+		Keys:   append([]string{}, DefaultRedactionRules.Keys...),
+		Values: append([]string{}, DefaultRedactionRules.Values...),
+	}
+
+	cfg, err := LoadConfigFile()
+	if err != nil {
+		return rules, err
+	}
+	rules.Keys = append(rules.Keys, cfg.Redaction.Keys...)
+	rules.Values = append(rules.Values, cfg.Redaction.Values...)
+	return rules, nil
+}
+
+// NewRedactor creates a Redactor with the provided rules
+func NewRedactor(rules RedactionRules) (*Redactor, error) {
+	compile := func(kind string, patterns []string) ([]*regexp.Regexp, error) {
+		compiled := make([]*regexp.Regexp, 0, len(patterns))
+		for _, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid redaction %s rule '%s'", kind, pattern)
+			}
+			compiled = append(compiled, re)
+		}
+		return compiled, nil
+	}
+
+	keys, err := compile("key", rules.Keys)
+	if err != nil {
+		return nil, err
+	}
+	values, err := compile("value", rules.Values)
+	if err != nil {
+		return nil, err
+	}
+	return &Redactor{keys: keys, values: values}, nil
+}
+
+// RedactObject returns the object, as decoded from its JSON representation,
+// with the values that match the rules masked, encrypted values (like the ones
+// of encrypted data bag items) are kept as they are
+func (r *Redactor) RedactObject(file string, object interface{}) (interface{}, error) {
+	if r == nil {
+		return object, nil
+	}
+
+	content, err := json.Marshal(object)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to redact %s", file)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	// numbers are kept as they were
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, errors.Wrapf(err, "unable to redact %s", file)
+	}
+	return r.RedactValue(file, []string{}, decoded), nil
+}
+
+// RedactValue returns the value, decoded from JSON, found at the provided
+// path with the values that match the rules masked
+func (r *Redactor) RedactValue(file string, path []string, value interface{}) interface{} {
+	if r == nil || isEncryptedValue(value) {
+		return value
+	}
+
+	if len(path) > 0 {
+		for _, re := range r.keys {
+			if re.MatchString(path[len(path)-1]) {
+				r.record(file, path, re)
+				return RedactedValue
+			}
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		for _, re := range r.values {
+			if re.MatchString(v) {
+				r.record(file, path, re)
+				return RedactedValue
+			}
+		}
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			redacted[key] = r.RedactValue(file, append(path[:len(path):len(path)], key), item)
+		}
+		return redacted
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = r.RedactValue(file, path, item)
+		}
+		return items
+	}
+	return value
+}
+
+// RedactText masks the parts of a text file that match the value rules, the
+// keys of text files are unknown so the key rules don't apply to them
+func (r *Redactor) RedactText(file string, content []byte) []byte {
+	if r == nil {
+		return content
+	}
+	for _, re := range r.values {
+		if re.Match(content) {
+			r.record(file, nil, re)
+			content = re.ReplaceAll(content, []byte(RedactedValue))
+		}
+	}
+	return content
+}
+
+// Redactions returns the values masked so far, sorted by file and path
+func (r *Redactor) Redactions() []Redaction {
+	if r == nil {
+		return []Redaction{}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	redactions := append([]Redaction{}, r.redactions...)
+	sort.SliceStable(redactions, func(i, j int) bool {
+		if redactions[i].File != redactions[j].File {
+			return redactions[i].File < redactions[j].File
+		}
+		return redactions[i].Path < redactions[j].Path
+	})
+	return redactions
+}
+
+func (r *Redactor) record(file string, path []string, rule *regexp.Regexp) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.redactions = append(r.redactions, Redaction{File: file, Path: strings.Join(path, "."), Rule: rule.String()})
+}
+
+// returns true if the value was encrypted by Chef, the values of encrypted data bag
+// items are objects with the encrypted data and the cipher used to encrypt it
+func isEncryptedValue(value interface{}) bool {
+	v, ok := value.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, data := v["encrypted_data"]
+	_, cipher := v["cipher"]
+	return data && cipher
+}
@@ -0,0 +1,263 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// generators that can create the skeleton of a captured repository
+const (
+	// generates the repository without any external tool
+	NativeRepositoryGenerator = "native"
+	// generates the repository with `chef generate repo`, requires Chef Workstation
+	ChefRepositoryGenerator = "chef"
+)
+
+// RepositoryGenerators are the valid generators of a captured repository
+var RepositoryGenerators = []string{NativeRepositoryGenerator, ChefRepositoryGenerator}
+
+// the directories of a captured repository, the ones that are not
+// needed by a capture are created anyway so the layout is always the same
+var repositoryDirs = []string{"cookbooks", "data_bags", "environments", "nodes", "roles"}
+
+// RepositoryInfo describes how a repository was captured, it is
+// written to the README of the repository, the Chef Infra Server
+// and the command are left out when they are empty
+type RepositoryInfo struct {
+	Nodes         []string
+	ChefServerURL string
+	Command       string
+	CapturedAt    time.Time
+}
+
+const repositoryGitignore = `.kitchen/
+.rake_test_cache
+
+# Chef key files and secrets
+.chef/*.pem
+.chef/encrypted_data_bag_secret
+
+# copies of the captured cookbooks that were replaced by local checkouts
+cookbooks/*.server
+cookbook_artifacts/*.server
+`
+
+const repositoryChefignore = `# Put files/directories that should be ignored in this file when uploading
+# to a Chef Infra Server or Supermarket.
+# Lines that start with '# ' are comments.
+
+# OS generated files #
+######################
+.DS_Store
+ehthumbs.db
+Icon?
+nohup.out
+Thumbs.db
+.envrc
+
+# EDITORS #
+###########
+.#*
+.project
+.settings
+*_flymake
+*_flymake.*
+*.bak
+*.sw[a-z]
+*.tmproj
+*~
+\#*
+REVISION
+TAGS*
+tmtags
+.vscode
+.editorconfig
+
+## COMPILED ##
+##############
+*.class
+*.com
+*.dll
+*.exe
+*.o
+*.pyc
+*.so
+*/rdoc/
+a.out
+mkmf.log
+
+# Testing #
+###########
+.circleci/*
+.codeclimate.yml
+.delivery/*
+.foodcritic
+.kitchen*
+.mdlrc
+.overcommit.yml
+.rspec
+.rubocop.yml
+.travis.yml
+.watchr
+.yamllint
+azure-pipelines.yml
+Dangerfile
+examples/*
+features/*
+Guardfile
+kitchen.yml*
+mlc_config.json
+Procfile
+Rakefile
+spec/*
+test/*
+
+# SCM #
+#######
+.git
+.gitattributes
+.gitconfig
+.github/*
+.gitignore
+.gitkeep
+.gitmodules
+.svn
+*/.bzr/*
+*/.git
+*/.hg/*
+*/.svn/*
+
+# Berkshelf #
+#############
+Berksfile
+Berksfile.lock
+cookbooks/*
+tmp
+
+# Bundler #
+###########
+vendor/*
+Gemfile
+Gemfile.lock
+
+# Policyfile #
+##############
+Policyfile.rb
+Policyfile.lock.json
+
+# Documentation #
+#################
+CODE_OF_CONDUCT*
+CONTRIBUTING*
+documentation/*
+TESTING*
+UPGRADING*
+
+# Vagrant #
+###########
+.vagrant
+Vagrantfile
+`
+
+const repositoryReadmeTmpl = `# Captured Chef Infra Repository
+
+This repository was generated by ` + "`chef-analyze capture`" + ` on {{.CapturedAt.Format "2006-01-02 15:04:05 MST"}}
+{{- if .ChefServerURL}} from the
+Chef Infra Server ` + "`{{.ChefServerURL}}`" + `{{end}}. It contains the state of the following nodes:
+{{range .Nodes}}
+- {{.}}
+{{- end}}
+{{- if .Command}}
+
+It was produced by running:
+
+    {{.Command}}
+{{- end}}
+
+## Layout
+
+- ` + "`nodes/`" + `: the captured node objects
+- ` + "`dna/`" + `: the attributes of the nodes, to converge with ` + "`chef-client -j`" + `
+- ` + "`ohai/`" + `: the automatic attributes of the nodes, as ohai fixtures
+- ` + "`roles/`" + `: the roles in the run lists of the nodes
+- ` + "`environments/`" + `: the environments of the nodes
+- ` + "`data_bags/`" + `: the captured data bags, if any
+- ` + "`cookbooks/`" + `: the cookbooks of the nodes, as downloaded from the Chef Infra Server
+  or linked to local checkouts
+- ` + "`cookbook_artifacts/`, `policies/`, `policy_groups/`" + `: the policy data of
+  nodes managed by Policyfiles
+- ` + "`kitchen.yml`" + `: a Test Kitchen configuration to converge the nodes locally
+- ` + "`spec/`" + `: ChefSpec scaffolds and fauxhai fixtures of the nodes, when captured
+  with ` + "`--chefspec`" + `
+- ` + "`policyfiles/`" + `: Policyfiles translated from the roles and environments of the
+  nodes, when captured with ` + "`--to-policyfile`" + `
+- ` + "`effortless/`" + `: Effortless packages of the policies of the nodes, when captured
+  with ` + "`--effortless`" + `
+- ` + "`redaction-log.json`" + `: the values that were masked because they looked like secrets
+- ` + "`.chef/encrypted_data_bag_secret`" + `: the secret of the encrypted data bag items, when
+  captured with ` + "`--secret-file`" + `, it is ignored by git
+
+## Converging the nodes locally
+
+    kitchen converge
+
+Cookbooks that were linked to local checkouts can be modified and
+converged again to test changes before uploading them.
+`
+
+// GenerateRepository creates the skeleton of a captured repository: its directories,
+// a .gitignore, a chefignore and a README that describes how it was captured
+func GenerateRepository(repositoryDir string, info RepositoryInfo) error {
+	for _, dir := range repositoryDirs {
+		path := filepath.Join(repositoryDir, dir)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return errors.Wrapf(err, "unable to create directory '%s'", path)
+		}
+	}
+
+	tmpl, err := template.New("README.md").Parse(repositoryReadmeTmpl)
+	if err != nil {
+		return errors.Wrap(err, "could not create new template")
+	}
+	var readme bytes.Buffer
+	if err := tmpl.Execute(&readme, info); err != nil {
+		return errors.Wrap(err, "failed to execute template")
+	}
+
+	for _, file := range []struct {
+		name    string
+		content []byte
+	}{
+		{".gitignore", []byte(repositoryGitignore)},
+		{"chefignore", []byte(repositoryChefignore)},
+		{"README.md", readme.Bytes()},
+	} {
+		path := filepath.Join(repositoryDir, file.name)
+		if err := ioutil.WriteFile(path, file.content, 0644); err != nil {
+			return errors.Wrapf(err, "unable to write '%s'", path)
+		}
+	}
+	return nil
+}
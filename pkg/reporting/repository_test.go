@@ -0,0 +1,113 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateRepository(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	repoDir := filepath.Join(baseDir, "node-node1-repo")
+	err = subject.GenerateRepository(repoDir, subject.RepositoryInfo{
+		Nodes:         []string{"node1", "node2"},
+		ChefServerURL: "https://chef.example.com/organizations/demo",
+		Command:       "chef-analyze capture node1 node2",
+		CapturedAt:    time.Date(2020, 5, 4, 10, 30, 0, 0, time.UTC),
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for _, dir := range []string{"cookbooks", "data_bags", "environments", "nodes", "roles"} {
+		info, err := os.Stat(filepath.Join(repoDir, dir))
+		if assert.Nil(t, err, dir) {
+			assert.True(t, info.IsDir(), dir)
+		}
+	}
+
+	gitignore, err := ioutil.ReadFile(filepath.Join(repoDir, ".gitignore"))
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(gitignore), "cookbooks/*.server")
+	}
+	chefignore, err := ioutil.ReadFile(filepath.Join(repoDir, "chefignore"))
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(chefignore), "kitchen.yml*")
+	}
+
+	readme, err := ioutil.ReadFile(filepath.Join(repoDir, "README.md"))
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(readme), "on 2020-05-04 10:30:00 UTC from the\nChef Infra Server `https://chef.example.com/organizations/demo`.")
+		assert.Contains(t, string(readme), "\n- node1\n- node2\n")
+		assert.Contains(t, string(readme), "    chef-analyze capture node1 node2\n")
+	}
+}
+
+func TestGenerateRepository_WithoutServerAndCommand(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	// archived repositories leave the server and the command out
+	repoDir := filepath.Join(baseDir, "repo")
+	err = subject.GenerateRepository(repoDir, subject.RepositoryInfo{
+		Nodes:      []string{"node1"},
+		CapturedAt: time.Date(2020, 5, 4, 10, 30, 0, 0, time.UTC),
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	readme, err := ioutil.ReadFile(filepath.Join(repoDir, "README.md"))
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(readme), "on 2020-05-04 10:30:00 UTC. It contains the state of the following nodes:")
+		assert.NotContains(t, string(readme), "Chef Infra Server `")
+		assert.NotContains(t, string(readme), "It was produced by running")
+	}
+}
+
+func TestGenerateRepository_InvalidPath(t *testing.T) {
+	baseDir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	// a file where the repository directory should be
+	repoDir := filepath.Join(baseDir, "repo")
+	if err := ioutil.WriteFile(repoDir, []byte{}, 0600); err != nil {
+		panic(err)
+	}
+	err = subject.GenerateRepository(repoDir, subject.RepositoryInfo{Nodes: []string{"node1"}})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to create directory")
+	}
+}
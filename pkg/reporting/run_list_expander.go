@@ -0,0 +1,362 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-chef/chef"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultEnvironment = "_default"
+	// upper bound on the passes we make while settling cookbook versions,
+	// protects us against constraint sets that keep flip-flopping
+	maxResolvePasses = 25
+)
+
+// RunListExpander resolves a node's run list locally, without relying on the
+// 'cookbooks' automatic attribute that is only available after a successful
+// chef-client run. Roles are expanded (honoring environment specific run lists)
+// into recipes, and those recipes are resolved into cookbook versions using the
+// environment constraints and the `depends` entries of each cookbook.
+//
+// The resolution is a simplified version of the one the Chef Infra Server
+// performs: it always picks the newest version that satisfies every known
+// constraint and does not backtrack.
+type RunListExpander struct {
+	roles        RolesInterface
+	env          EnvironmentInterface
+	cookbooks    CookbookInterface
+	roleCache    map[string]*chef.Role
+	roleErrors   map[string]error
+	versionCache map[string][]string
+	dependsCache map[string]map[string]string
+}
+
+// ExpandedRunList is the result of expanding a run list
+type ExpandedRunList struct {
+	Environment string
+	Roles       []string
+	Recipes     []string
+	Cookbooks   []NodeCookbook
+}
+
+func NewRunListExpander(roles RolesInterface, env EnvironmentInterface, cookbooks CookbookInterface) *RunListExpander {
+	return &RunListExpander{
+		roles:        roles,
+		env:          env,
+		cookbooks:    cookbooks,
+		roleCache:    make(map[string]*chef.Role),
+		roleErrors:   make(map[string]error),
+		versionCache: make(map[string][]string),
+		dependsCache: make(map[string]map[string]string),
+	}
+}
+
+// ExpandNode expands the run list of the provided node within its environment
+func (e *RunListExpander) ExpandNode(node *chef.Node) (*ExpandedRunList, error) {
+	return e.Expand(node.RunList, node.Environment)
+}
+
+// Expand resolves the run list into roles, recipes and cookbook versions
+// for the given environment.
+func (e *RunListExpander) Expand(runList []string, environment string) (*ExpandedRunList, error) {
+	if environment == "" {
+		environment = defaultEnvironment
+	}
+	expanded := &ExpandedRunList{
+		Environment: environment,
+		Roles:       make([]string, 0),
+		Recipes:     make([]string, 0),
+		Cookbooks:   make([]NodeCookbook, 0),
+	}
+
+	pins := make(map[string]string)
+	err := e.expandItems(runList, environment, expanded, pins, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := e.env.Get(environment)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to retrieve environment '%s'", environment)
+	}
+
+	constraints := make(map[string][]VersionConstraint)
+	if env != nil {
+		for name, c := range env.CookbookVersions {
+			vc, err := ParseVersionConstraint(c)
+			if err != nil {
+				return nil, errors.Wrapf(err, "environment '%s' cookbook '%s'", environment, name)
+			}
+			constraints[name] = append(constraints[name], vc)
+		}
+	}
+	for name, version := range pins {
+		constraints[name] = append(constraints[name], VersionConstraint{Operator: "=", Version: version})
+	}
+
+	roots := make([]string, 0, len(expanded.Recipes))
+	for _, recipe := range expanded.Recipes {
+		roots = append(roots, recipeCookbook(recipe))
+	}
+
+	expanded.Cookbooks, err = e.resolveCookbooks(roots, constraints)
+	if err != nil {
+		return nil, err
+	}
+	return expanded, nil
+}
+
+// AsCookbooksAttribute returns the resolved cookbooks in the same form that
+// chef-client stores them in the node's 'cookbooks' automatic attribute:
+//
+//	{ NAME: { "version": VERSION } }
+func (erl *ExpandedRunList) AsCookbooksAttribute() map[string]interface{} {
+	attr := make(map[string]interface{}, len(erl.Cookbooks))
+	for _, cb := range erl.Cookbooks {
+		attr[cb.Name] = map[string]interface{}{"version": cb.Version}
+	}
+	return attr
+}
+
+func (e *RunListExpander) expandItems(items []string, environment string,
+	expanded *ExpandedRunList, pins map[string]string, visited map[string]bool) error {
+	for _, entry := range items {
+		item, err := chef.NewRunListItem(entry)
+		if err != nil {
+			return err
+		}
+
+		if item.IsRole() {
+			// a role can be included more than once, even in a loop, but it
+			// only contributes to the run list the first time we see it
+			if visited[item.Name] {
+				continue
+			}
+			visited[item.Name] = true
+			expanded.Roles = append(expanded.Roles, item.Name)
+
+			role, err := e.getRole(item.Name)
+			if err != nil {
+				return err
+			}
+			err = e.expandItems(roleRunList(role, environment), environment, expanded, pins, visited)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if item.Version != "" {
+			pins[recipeCookbook(item.Name)] = item.Version
+		}
+		if !containsString(expanded.Recipes, item.Name) {
+			expanded.Recipes = append(expanded.Recipes, item.Name)
+		}
+	}
+	return nil
+}
+
+// resolveCookbooks settles on a version for every cookbook reachable from the
+// roots. Every pass picks the newest version of each required cookbook that
+// satisfies the constraints collected from the previous pass; we are done once
+// a pass no longer changes the selection.
+func (e *RunListExpander) resolveCookbooks(roots []string, baseConstraints map[string][]VersionConstraint) ([]NodeCookbook, error) {
+	selected := make(map[string]string)
+
+	for pass := 0; pass < maxResolvePasses; pass++ {
+		var (
+			required    = make([]string, 0, len(roots))
+			constraints = make(map[string][]VersionConstraint)
+		)
+		for name, c := range baseConstraints {
+			constraints[name] = append(constraints[name], c...)
+		}
+		for _, name := range roots {
+			required = appendUnique(required, name)
+		}
+		for name, version := range selected {
+			depends, err := e.getDepends(name, version)
+			if err != nil {
+				return nil, err
+			}
+			for dep, c := range depends {
+				vc, err := ParseVersionConstraint(c)
+				if err != nil {
+					return nil, errors.Wrapf(err, "cookbook %s v%s depends on '%s'", name, version, dep)
+				}
+				constraints[dep] = append(constraints[dep], vc)
+				required = appendUnique(required, dep)
+			}
+		}
+
+		next := make(map[string]string, len(required))
+		for _, name := range required {
+			version, err := e.pickVersion(name, constraints[name])
+			if err != nil {
+				return nil, err
+			}
+			next[name] = version
+		}
+
+		if sameSelection(selected, next) {
+			cookbooks := make([]NodeCookbook, 0, len(selected))
+			for name, version := range selected {
+				cookbooks = append(cookbooks, NodeCookbook{Name: name, Version: version})
+			}
+			sort.Slice(cookbooks, func(i, j int) bool { return cookbooks[i].Name < cookbooks[j].Name })
+			return cookbooks, nil
+		}
+		selected = next
+	}
+
+	return nil, errors.New("unable to settle on a set of cookbook versions, the dependency constraints are too complex to resolve locally")
+}
+
+func (e *RunListExpander) pickVersion(name string, constraints []VersionConstraint) (string, error) {
+	versions, err := e.getVersions(name)
+	if err != nil {
+		return "", err
+	}
+
+	best := ""
+	for _, v := range versions {
+		if !satisfiesAll(v, constraints) {
+			continue
+		}
+		if best == "" || CompareVersions(v, best) > 0 {
+			best = v
+		}
+	}
+	if best == "" {
+		cs := make([]string, 0, len(constraints))
+		for _, c := range constraints {
+			cs = append(cs, c.String())
+		}
+		return "", fmt.Errorf("no version of cookbook '%s' satisfies constraints [%s]", name, strings.Join(cs, ", "))
+	}
+	return best, nil
+}
+
+func (e *RunListExpander) getRole(name string) (*chef.Role, error) {
+	if role, ok := e.roleCache[name]; ok {
+		return role, nil
+	}
+	// a missing role would otherwise be requested again by every run list that includes it
+	if err, ok := e.roleErrors[name]; ok {
+		return nil, err
+	}
+	role, err := e.roles.Get(name)
+	if err != nil {
+		e.roleErrors[name] = errors.Wrapf(err, "unable to retrieve role '%s'", name)
+		return nil, e.roleErrors[name]
+	}
+	e.roleCache[name] = role
+	return role, nil
+}
+
+func (e *RunListExpander) getVersions(name string) ([]string, error) {
+	if versions, ok := e.versionCache[name]; ok {
+		return versions, nil
+	}
+	results, err := e.cookbooks.GetAvailableVersions(name, "0")
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to retrieve versions of cookbook '%s'", name)
+	}
+	versions := make([]string, 0)
+	for _, v := range results[name].Versions {
+		versions = append(versions, v.Version)
+	}
+	e.versionCache[name] = versions
+	return versions, nil
+}
+
+func (e *RunListExpander) getDepends(name, version string) (map[string]string, error) {
+	key := fmt.Sprintf("%s-%s", name, version)
+	if depends, ok := e.dependsCache[key]; ok {
+		return depends, nil
+	}
+	cookbook, err := e.cookbooks.GetVersion(name, version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to retrieve metadata for cookbook %s v%s", name, version)
+	}
+	depends := cookbook.Metadata.Depends
+	if depends == nil {
+		depends = map[string]string{}
+	}
+	e.dependsCache[key] = depends
+	return depends, nil
+}
+
+// returns the run list of a role for the given environment, roles can
+// define environment specific run lists that replace the default one
+func roleRunList(role *chef.Role, environment string) []string {
+	if role == nil {
+		return nil
+	}
+	if runList, ok := role.EnvRunList[environment]; ok {
+		return runList
+	}
+	return role.RunList
+}
+
+// returns the cookbook name of a recipe, "apache2::mod_ssl" => "apache2"
+func recipeCookbook(recipe string) string {
+	return strings.SplitN(recipe, "::", 2)[0]
+}
+
+func satisfiesAll(version string, constraints []VersionConstraint) bool {
+	for _, c := range constraints {
+		if !c.Satisfies(version) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameSelection(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, version := range a {
+		if b[name] != version {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(list []string, s string) []string {
+	if containsString(list, s) {
+		return list
+	}
+	return append(list, s)
+}
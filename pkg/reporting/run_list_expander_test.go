@@ -0,0 +1,165 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"errors"
+	"testing"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/go-chef/chef"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockCookbookVersions(versions ...string) chef.CookbookVersions {
+	cv := chef.CookbookVersions{Versions: []chef.CookbookVersion{}}
+	for _, v := range versions {
+		cv.Versions = append(cv.Versions, chef.CookbookVersion{Version: v})
+	}
+	return cv
+}
+
+func mockCookbookDepends(depends map[string]string) chef.Cookbook {
+	return chef.Cookbook{Metadata: chef.CookbookMeta{Depends: depends}}
+}
+
+func mockedExpanderCookbooks() *CookbookMock {
+	return &CookbookMock{
+		desiredCookbookList: chef.CookbookListResult{
+			"base":    mockCookbookVersions("1.0.0", "1.1.0", "2.0.0"),
+			"web":     mockCookbookVersions("0.1.0", "0.2.0"),
+			"apache2": mockCookbookVersions("3.0.0", "4.1.0", "5.0.0"),
+			"db":      mockCookbookVersions("1.0.0"),
+		},
+		desiredCookbooks: map[string]chef.Cookbook{
+			"web-0.2.0":     mockCookbookDepends(map[string]string{"apache2": "~> 4.0"}),
+			"web-0.1.0":     mockCookbookDepends(map[string]string{"apache2": ">= 3.0.0"}),
+			"apache2-4.1.0": mockCookbookDepends(map[string]string{"base": "< 2.0"}),
+			"apache2-5.0.0": mockCookbookDepends(map[string]string{}),
+		},
+	}
+}
+
+func mockedExpanderRoles() *RoleMock {
+	return &RoleMock{Roles: map[string]*chef.Role{
+		"base": &chef.Role{Name: "base", RunList: chef.RunList{"recipe[base]"}},
+		"web": &chef.Role{
+			Name:    "web",
+			RunList: chef.RunList{"role[base]", "recipe[web::default]"},
+			EnvRunList: chef.EnvRunList{
+				"staging": chef.RunList{"role[base]", "recipe[web]", "recipe[db]"},
+			},
+		},
+		// loops back to itself through 'web'
+		"loop": &chef.Role{Name: "loop", RunList: chef.RunList{"role[web]", "role[loop]"}},
+	}}
+}
+
+func TestRunListExpander_Expand(t *testing.T) {
+	expander := subject.NewRunListExpander(
+		mockedExpanderRoles(),
+		&EnvMock{Env: &chef.Environment{Name: "_default"}},
+		mockedExpanderCookbooks(),
+	)
+
+	expanded, err := expander.Expand([]string{"role[loop]", "recipe[web]"}, "")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "_default", expanded.Environment)
+		assert.Equal(t, []string{"loop", "web", "base"}, expanded.Roles)
+		assert.Equal(t, []string{"base", "web::default", "web"}, expanded.Recipes)
+		// web 0.2.0 requires apache2 ~> 4.0 which in turn requires base < 2.0
+		assert.Equal(t, []subject.NodeCookbook{
+			subject.NodeCookbook{Name: "apache2", Version: "4.1.0"},
+			subject.NodeCookbook{Name: "base", Version: "1.1.0"},
+			subject.NodeCookbook{Name: "web", Version: "0.2.0"},
+		}, expanded.Cookbooks)
+		assert.Equal(t, map[string]interface{}{
+			"apache2": map[string]interface{}{"version": "4.1.0"},
+			"base":    map[string]interface{}{"version": "1.1.0"},
+			"web":     map[string]interface{}{"version": "0.2.0"},
+		}, expanded.AsCookbooksAttribute())
+	}
+}
+
+func TestRunListExpander_ExpandWithEnvironment(t *testing.T) {
+	env := &chef.Environment{
+		Name:             "staging",
+		CookbookVersions: map[string]string{"web": "= 0.1.0"},
+	}
+	expander := subject.NewRunListExpander(mockedExpanderRoles(), &EnvMock{Env: env}, mockedExpanderCookbooks())
+
+	expanded, err := expander.ExpandNode(&chef.Node{Environment: "staging", RunList: []string{"role[web]", "recipe[base@1.0.0]"}})
+	if assert.Nil(t, err) {
+		assert.Equal(t, "staging", expanded.Environment)
+		assert.Equal(t, []string{"web", "base"}, expanded.Roles)
+		// the environment specific run list replaces the default one
+		assert.Equal(t, []string{"base", "web", "db"}, expanded.Recipes)
+		assert.Equal(t, []subject.NodeCookbook{
+			subject.NodeCookbook{Name: "apache2", Version: "5.0.0"},
+			subject.NodeCookbook{Name: "base", Version: "1.0.0"},
+			subject.NodeCookbook{Name: "db", Version: "1.0.0"},
+			subject.NodeCookbook{Name: "web", Version: "0.1.0"},
+		}, expanded.Cookbooks)
+	}
+}
+
+func TestRunListExpander_ExpandErrors(t *testing.T) {
+	cookbooks := mockedExpanderCookbooks()
+	env := &EnvMock{Env: &chef.Environment{Name: "_default"}}
+
+	expander := subject.NewRunListExpander(mockedExpanderRoles(), env, cookbooks)
+	_, err := expander.Expand([]string{"role[missing]"}, "")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "unable to retrieve role 'missing': 404 role missing not found", err.Error())
+	}
+
+	_, err = expander.Expand([]string{"recipe[web]", "recipe[apache2@3.0.0]"}, "")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "no version of cookbook 'apache2' satisfies constraints [= 3.0.0, ~> 4.0]", err.Error())
+	}
+
+	expander = subject.NewRunListExpander(mockedExpanderRoles(), &EnvMock{Error: errors.New("500 boom")}, cookbooks)
+	_, err = expander.Expand([]string{"recipe[db]"}, "prod")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "unable to retrieve environment 'prod': 500 boom", err.Error())
+	}
+}
+
+// counts the roles requested from the RoleMock
+type countingRoleMock struct {
+	*RoleMock
+	requests map[string]int
+}
+
+func (cr countingRoleMock) Get(name string) (*chef.Role, error) {
+	cr.requests[name]++
+	return cr.RoleMock.Get(name)
+}
+
+func TestRunListExpander_ExpandCachesMissingRoles(t *testing.T) {
+	var (
+		roles    = countingRoleMock{mockedExpanderRoles(), make(map[string]int)}
+		expander = subject.NewRunListExpander(roles, &EnvMock{Env: &chef.Environment{Name: "_default"}}, mockedExpanderCookbooks())
+	)
+	for i := 0; i < 3; i++ {
+		_, err := expander.Expand([]string{"role[base]", "role[missing]"}, "")
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "unable to retrieve role 'missing': 404 role missing not found", err.Error())
+		}
+	}
+	assert.Equal(t, map[string]int{"base": 1, "missing": 1}, roles.requests)
+}
@@ -0,0 +1,255 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	_ "embed"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+)
+
+// the embedded support data, see data/support.toml to update it
+//
+//go:embed data/support.toml
+var embeddedSupportData string
+
+// the format of the EOL dates of the support data
+const supportDateFormat = "2006-01-02"
+
+// possible values of NodeSupport.Platform
+const (
+	PlatformSupportUnknown = ""
+	PlatformSupported      = "yes"
+	PlatformUnsupported    = "no"
+)
+
+// SupportData holds the Chef Infra Client releases and the platforms that
+// are used to annotate the nodes report with support information
+type SupportData struct {
+	Updated           string            `toml:"updated"`
+	RecommendedClient string            `toml:"recommended_client"`
+	Clients           []ClientRelease   `toml:"clients"`
+	Platforms         []PlatformSupport `toml:"platforms"`
+}
+
+// ClientRelease is a Chef Infra Client release and its end-of-life date (if any)
+type ClientRelease struct {
+	Version string `toml:"version"`
+	EOL     string `toml:"eol"`
+}
+
+// PlatformSupport is a platform version and whether it is supported or not,
+// unsupported platforms can set the last client release that runs on them
+type PlatformSupport struct {
+	Name              string `toml:"name"`
+	Version           string `toml:"version"`
+	Supported         bool   `toml:"supported"`
+	RecommendedClient string `toml:"recommended_client"`
+}
+
+// NodeSupport is the support status of the Chef Infra Client and platform of a node
+type NodeSupport struct {
+	ClientKnown       bool      // the client release of the node was found in the support data
+	ClientEOL         time.Time // zero when no end-of-life has been announced
+	ClientEOLReached  bool
+	Platform          string // one of PlatformSupported, PlatformUnsupported or PlatformSupportUnknown
+	RecommendedClient string
+}
+
+// ClientEOLPretty returns the client end-of-life as a human readable string
+func (ns *NodeSupport) ClientEOLPretty() string {
+	switch {
+	case !ns.ClientKnown:
+		return "unknown"
+	case ns.ClientEOL.IsZero():
+		return "not announced"
+	case ns.ClientEOLReached:
+		return fmt.Sprintf("%s (reached)", ns.ClientEOL.Format(supportDateFormat))
+	default:
+		return ns.ClientEOL.Format(supportDateFormat)
+	}
+}
+
+// PlatformSupportedPretty returns yes, no or unknown
+func (ns *NodeSupport) PlatformSupportedPretty() string {
+	if ns.Platform == PlatformSupportUnknown {
+		return "unknown"
+	}
+	return ns.Platform
+}
+
+// LoadSupportData loads the embedded support data and, when an override
+// file is provided, replaces its entries with the ones from that file
+func LoadSupportData(overrideFile string) (*SupportData, error) {
+	data, err := parseSupportData(embeddedSupportData)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse embedded support data")
+	}
+
+	if overrideFile == "" {
+		return data, nil
+	}
+
+	overrideBytes, err := ioutil.ReadFile(overrideFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read support data from '%s'", overrideFile)
+	}
+	override, err := parseSupportData(string(overrideBytes))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse support data from '%s'", overrideFile)
+	}
+
+	data.Merge(override)
+	return data, nil
+}
+
+func parseSupportData(content string) (*SupportData, error) {
+	var data SupportData
+	if _, err := toml.Decode(content, &data); err != nil {
+		return nil, err
+	}
+
+	for _, client := range data.Clients {
+		if client.Version == "" {
+			return nil, errors.New("every client release requires a version")
+		}
+		if client.EOL == "" {
+			continue
+		}
+		if _, err := time.Parse(supportDateFormat, client.EOL); err != nil {
+			return nil, errors.Errorf("invalid eol '%s' of client release %s, use the format YYYY-MM-DD", client.EOL, client.Version)
+		}
+	}
+	for _, platform := range data.Platforms {
+		if platform.Name == "" || platform.Version == "" {
+			return nil, errors.New("every platform requires a name and a version")
+		}
+	}
+	return &data, nil
+}
+
+// Merge replaces the clients and platforms of the support data with the ones of the
+// override that have the same version (clients) or name and version (platforms),
+// entries that don't exist are added
+func (sd *SupportData) Merge(override *SupportData) {
+	if override.Updated != "" {
+		sd.Updated = override.Updated
+	}
+	if override.RecommendedClient != "" {
+		sd.RecommendedClient = override.RecommendedClient
+	}
+
+	for _, client := range override.Clients {
+		replaced := false
+		for i := range sd.Clients {
+			if sd.Clients[i].Version == client.Version {
+				sd.Clients[i] = client
+				replaced = true
+			}
+		}
+		if !replaced {
+			sd.Clients = append(sd.Clients, client)
+		}
+	}
+
+	for _, platform := range override.Platforms {
+		replaced := false
+		for i := range sd.Platforms {
+			if strings.EqualFold(sd.Platforms[i].Name, platform.Name) && sd.Platforms[i].Version == platform.Version {
+				sd.Platforms[i] = platform
+				replaced = true
+			}
+		}
+		if !replaced {
+			sd.Platforms = append(sd.Platforms, platform)
+		}
+	}
+}
+
+// Lookup returns the support status of a node that runs the provided
+// client version on the provided platform and platform version
+func (sd *SupportData) Lookup(chefVersion, platform, platformVersion string, now time.Time) NodeSupport {
+	var support NodeSupport
+
+	if client := sd.findClient(chefVersion); client != nil {
+		support.ClientKnown = true
+		if client.EOL != "" {
+			// already validated when the support data was loaded
+			support.ClientEOL, _ = time.Parse(supportDateFormat, client.EOL)
+			support.ClientEOLReached = !now.Before(support.ClientEOL)
+		}
+	}
+
+	if p := sd.findPlatform(platform, platformVersion); p != nil {
+		if p.Supported {
+			support.Platform = PlatformSupported
+			support.RecommendedClient = sd.RecommendedClient
+		} else {
+			support.Platform = PlatformUnsupported
+		}
+		if p.RecommendedClient != "" {
+			support.RecommendedClient = p.RecommendedClient
+		}
+	}
+
+	return support
+}
+
+func (sd *SupportData) findClient(version string) *ClientRelease {
+	var found *ClientRelease
+	for i, client := range sd.Clients {
+		if versionHasPrefix(version, client.Version) &&
+			(found == nil || len(client.Version) > len(found.Version)) {
+			found = &sd.Clients[i]
+		}
+	}
+	return found
+}
+
+func (sd *SupportData) findPlatform(name, version string) *PlatformSupport {
+	var found *PlatformSupport
+	for i, platform := range sd.Platforms {
+		if strings.EqualFold(platform.Name, name) &&
+			versionHasPrefix(version, platform.Version) &&
+			(found == nil || len(platform.Version) > len(found.Version)) {
+			found = &sd.Platforms[i]
+		}
+	}
+	return found
+}
+
+// AnnotateNodesSupport sets the support status of every node of the report
+func AnnotateNodesSupport(records []*NodeReportItem, data *SupportData) {
+	now := time.Now()
+	for _, record := range records {
+		record.Support = data.Lookup(record.ChefVersion, record.OS, record.OSVersion, now)
+	}
+}
+
+// returns true if the version is the prefix or any dot separated
+// prefix of it, e.g. "7.8.2003" has the prefixes "7" and "7.8"
+func versionHasPrefix(version, prefix string) bool {
+	if version == "" || prefix == "" {
+		return false
+	}
+	return version == prefix || strings.HasPrefix(version, prefix+".")
+}
@@ -0,0 +1,180 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSupportData_Embedded(t *testing.T) {
+	data, err := subject.LoadSupportData("")
+	if assert.Nil(t, err) {
+		assert.NotEmpty(t, data.RecommendedClient)
+		assert.NotEmpty(t, data.Clients)
+		assert.NotEmpty(t, data.Platforms)
+	}
+}
+
+func TestSupportData_Lookup(t *testing.T) {
+	var (
+		now  = time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+		data = &subject.SupportData{
+			RecommendedClient: "17",
+			Clients: []subject.ClientRelease{
+				subject.ClientRelease{Version: "15", EOL: "2021-04-30"},
+				subject.ClientRelease{Version: "16", EOL: "2022-11-30"},
+				subject.ClientRelease{Version: "16.1", EOL: "2020-01-01"},
+				subject.ClientRelease{Version: "17"},
+			},
+			Platforms: []subject.PlatformSupport{
+				subject.PlatformSupport{Name: "centos", Version: "7", Supported: true},
+				subject.PlatformSupport{Name: "centos", Version: "6", Supported: false, RecommendedClient: "15"},
+				subject.PlatformSupport{Name: "windows", Version: "10.0", Supported: true},
+				subject.PlatformSupport{Name: "windows", Version: "10.0.14393", Supported: false},
+			},
+		}
+	)
+
+	support := data.Lookup("15.8.23", "centos", "7.8.2003", now)
+	assert.True(t, support.ClientKnown)
+	assert.True(t, support.ClientEOLReached)
+	assert.Equal(t, "2021-04-30 (reached)", support.ClientEOLPretty())
+	assert.Equal(t, subject.PlatformSupported, support.Platform)
+	assert.Equal(t, "yes", support.PlatformSupportedPretty())
+	assert.Equal(t, "17", support.RecommendedClient)
+
+	// the longest matching version wins
+	support = data.Lookup("16.1.0", "windows", "10.0.14393", now)
+	assert.Equal(t, "2020-01-01 (reached)", support.ClientEOLPretty())
+	assert.Equal(t, "no", support.PlatformSupportedPretty())
+	assert.Equal(t, "", support.RecommendedClient)
+
+	support = data.Lookup("16.10.8", "Windows", "10.0.17763", now)
+	assert.False(t, support.ClientEOLReached)
+	assert.Equal(t, "2022-11-30", support.ClientEOLPretty())
+	assert.Equal(t, "yes", support.PlatformSupportedPretty())
+
+	// unsupported platforms recommend the last client that runs on them
+	support = data.Lookup("17.0.242", "centos", "6.10", now)
+	assert.Equal(t, "not announced", support.ClientEOLPretty())
+	assert.Equal(t, "no", support.PlatformSupportedPretty())
+	assert.Equal(t, "15", support.RecommendedClient)
+
+	// "1" must not match "15" nor "7" match "70"
+	support = data.Lookup("1.0", "centos", "70", now)
+	assert.Equal(t, "unknown", support.ClientEOLPretty())
+	assert.Equal(t, "unknown", support.PlatformSupportedPretty())
+	assert.Equal(t, "", support.RecommendedClient)
+
+	support = data.Lookup("", "", "", now)
+	assert.Equal(t, subject.NodeSupport{}, support)
+}
+
+func TestLoadSupportData_Override(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "chefanalyze-unit*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	overrideToml := filepath.Join(dir, "support.toml")
+	err = ioutil.WriteFile(overrideToml, []byte(`
+recommended_client = "99"
+
+[[clients]]
+version = "15"
+eol = "2099-01-01"
+
+[[clients]]
+version = "99"
+
+[[platforms]]
+name = "ubuntu"
+version = "18.04"
+supported = false
+recommended_client = "98"
+
+[[platforms]]
+name = "plan9"
+version = "4"
+supported = true
+`), 0644)
+	if err != nil {
+		panic(err)
+	}
+
+	data, err := subject.LoadSupportData(overrideToml)
+	if !assert.Nil(t, err) {
+		return
+	}
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	support := data.Lookup("15.8.23", "ubuntu", "18.04", now)
+	assert.Equal(t, "2099-01-01", support.ClientEOLPretty())
+	assert.Equal(t, "no", support.PlatformSupportedPretty())
+	assert.Equal(t, "98", support.RecommendedClient)
+
+	support = data.Lookup("99.0.1", "plan9", "4", now)
+	assert.Equal(t, "not announced", support.ClientEOLPretty())
+	assert.Equal(t, "yes", support.PlatformSupportedPretty())
+	assert.Equal(t, "99", support.RecommendedClient)
+
+	// entries that were not overridden are kept
+	support = data.Lookup("14.15.6", "ubuntu", "22.04", now)
+	assert.Equal(t, "2020-04-30 (reached)", support.ClientEOLPretty())
+	assert.Equal(t, "yes", support.PlatformSupportedPretty())
+
+	err = ioutil.WriteFile(overrideToml, []byte("[[clients]]\nversion = \"15\"\neol = \"April 2021\"\n"), 0644)
+	if err != nil {
+		panic(err)
+	}
+	_, err = subject.LoadSupportData(overrideToml)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to parse support data")
+		assert.Contains(t, err.Error(), "invalid eol 'April 2021'")
+	}
+
+	_, err = subject.LoadSupportData(filepath.Join(dir, "missing.toml"))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unable to read support data")
+	}
+}
+
+func TestAnnotateNodesSupport(t *testing.T) {
+	data := &subject.SupportData{
+		RecommendedClient: "17",
+		Clients:           []subject.ClientRelease{subject.ClientRelease{Version: "12", EOL: "2019-04-30"}},
+		Platforms:         []subject.PlatformSupport{subject.PlatformSupport{Name: "ubuntu", Version: "18.04", Supported: true}},
+	}
+	records := []*subject.NodeReportItem{
+		&subject.NodeReportItem{Name: "node1", ChefVersion: "12.22.5", OS: "ubuntu", OSVersion: "18.04"},
+		&subject.NodeReportItem{Name: "node2"},
+	}
+
+	subject.AnnotateNodesSupport(records, data)
+	assert.True(t, records[0].Support.ClientEOLReached)
+	assert.Equal(t, subject.PlatformSupported, records[0].Support.Platform)
+	assert.Equal(t, "17", records[0].Support.RecommendedClient)
+	assert.Equal(t, subject.NodeSupport{}, records[1].Support)
+}
@@ -0,0 +1,123 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VersionConstraint is a single cookbook version constraint, as found
+// in environment cookbook_versions and in metadata `depends` entries.
+// (e.g. "= 1.2.3", "~> 2.0", ">= 0.0.0")
+type VersionConstraint struct {
+	Operator string
+	Version  string
+}
+
+// ParseVersionConstraint parses a constraint in the form "OP VERSION". A bare
+// version is treated as an exact match and an empty string matches anything.
+func ParseVersionConstraint(constraint string) (VersionConstraint, error) {
+	fields := strings.Fields(constraint)
+	switch len(fields) {
+	case 0:
+		return VersionConstraint{Operator: ">=", Version: "0.0.0"}, nil
+	case 1:
+		return VersionConstraint{Operator: "=", Version: fields[0]}, nil
+	case 2:
+		switch fields[0] {
+		case "=", "!=", ">", "<", ">=", "<=", "~>":
+			return VersionConstraint{Operator: fields[0], Version: fields[1]}, nil
+		}
+	}
+	return VersionConstraint{}, fmt.Errorf("invalid version constraint '%s'", constraint)
+}
+
+func (vc VersionConstraint) String() string {
+	return fmt.Sprintf("%s %s", vc.Operator, vc.Version)
+}
+
+// Satisfies returns true if the provided version matches the constraint
+func (vc VersionConstraint) Satisfies(version string) bool {
+	cmp := CompareVersions(version, vc.Version)
+	switch vc.Operator {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case "~>":
+		// pessimistic operator: "~> 1.2" means ">= 1.2, < 2.0" and
+		// "~> 1.2.3" means ">= 1.2.3, < 1.3.0"
+		if cmp < 0 {
+			return false
+		}
+		parts := strings.Split(vc.Version, ".")
+		if len(parts) == 1 {
+			return true
+		}
+		upper := versionParts(strings.Join(parts[0:len(parts)-1], "."))
+		upper[len(upper)-1]++
+		return compareVersionParts(versionParts(version), upper) < 0
+	}
+	return false
+}
+
+// CompareVersions compares two dotted versions numerically. Returns a negative
+// number when a < b, zero when they are equal and a positive number when a > b.
+// Missing segments count as zero, so "1.2" is equal to "1.2.0".
+func CompareVersions(a, b string) int {
+	return compareVersionParts(versionParts(a), versionParts(b))
+}
+
+func versionParts(version string) []int {
+	var parts []int
+	for _, p := range strings.Split(strings.TrimSpace(version), ".") {
+		// use any leading digits ("15rc1" => 15) and ignore the rest
+		end := strings.IndexFunc(p, func(r rune) bool { return r < '0' || r > '9' })
+		if end != -1 {
+			p = p[0:end]
+		}
+		n, _ := strconv.Atoi(p)
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+func compareVersionParts(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+//
+// Copyright 2020 Chef Software, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package reporting_test
+
+import (
+	"testing"
+
+	subject "github.com/chef/chef-analyze/pkg/reporting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersionConstraint(t *testing.T) {
+	cases := map[string]subject.VersionConstraint{
+		"":          subject.VersionConstraint{Operator: ">=", Version: "0.0.0"},
+		"1.2.3":     subject.VersionConstraint{Operator: "=", Version: "1.2.3"},
+		"= 1.2.3":   subject.VersionConstraint{Operator: "=", Version: "1.2.3"},
+		"~> 2.0":    subject.VersionConstraint{Operator: "~>", Version: "2.0"},
+		" >=  0.1 ": subject.VersionConstraint{Operator: ">=", Version: "0.1"},
+	}
+	for input, expected := range cases {
+		vc, err := subject.ParseVersionConstraint(input)
+		if assert.Nil(t, err, input) {
+			assert.Equal(t, expected, vc, input)
+		}
+	}
+
+	for _, input := range []string{"=~ 1.0", "> 1.0 2.0"} {
+		_, err := subject.ParseVersionConstraint(input)
+		if assert.NotNil(t, err, input) {
+			assert.Contains(t, err.Error(), "invalid version constraint")
+		}
+	}
+}
+
+func TestVersionConstraint_Satisfies(t *testing.T) {
+	cases := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"= 1.2.3", "1.2.3", true},
+		{"= 1.2", "1.2.0", true},
+		{"= 1.2.3", "1.2.4", false},
+		{"!= 1.2.3", "1.2.4", true},
+		{"> 1.2.3", "1.10.0", true},
+		{"< 1.2.3", "1.10.0", false},
+		{">= 1.2.3", "1.2.3", true},
+		{"<= 1.2.3", "1.2.2", true},
+		{"~> 1.2", "1.9.9", true},
+		{"~> 1.2", "2.0.0", false},
+		{"~> 1.2", "1.1.0", false},
+		{"~> 1.2.3", "1.2.9", true},
+		{"~> 1.2.3", "1.3.0", false},
+		{"~> 2", "9.0.0", true},
+	}
+	for _, c := range cases {
+		vc, err := subject.ParseVersionConstraint(c.constraint)
+		assert.Nil(t, err)
+		assert.Equalf(t, c.expected, vc.Satisfies(c.version),
+			"'%s' satisfies '%s'", c.version, c.constraint)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, 0, subject.CompareVersions("1.2", "1.2.0"))
+	assert.True(t, subject.CompareVersions("1.10.0", "1.9.0") > 0)
+	assert.True(t, subject.CompareVersions("0.9", "1.0") < 0)
+	assert.True(t, subject.CompareVersions("15rc1.0", "14.3") > 0)
+}